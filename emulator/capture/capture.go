@@ -0,0 +1,201 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+// Package capture encodes the emulated screen and audio to video and sound files.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+)
+
+var ErrNoFrames = errors.New("no frames recorded")
+
+// VideoEncoder receives frames at a fixed frame rate.
+type VideoEncoder interface {
+	WriteFrame(img *image.RGBA) error
+	Close() error
+}
+
+type gifEncoder struct {
+	w       io.Writer
+	fps     int
+	palette color.Palette
+	lookup  map[color.RGBA]uint8
+
+	anim   gif.GIF
+	size   image.Rectangle
+	prev   *image.Paletted
+	start  int
+	frames int
+}
+
+// NewGIF creates an encoder for animated GIF images. Colors are mapped exactly to
+// the palette, which can hold up to 256 colors. Identical frames are merged so a
+// still screen does not grow the image, but all frames are kept in memory until
+// the encoder is closed.
+func NewGIF(w io.Writer, palette color.Palette, fps int) VideoEncoder {
+	e := &gifEncoder{
+		w:       w,
+		fps:     fps,
+		palette: palette,
+		lookup:  make(map[color.RGBA]uint8, len(palette)),
+	}
+	for i, c := range palette {
+		e.lookup[color.RGBAModel.Convert(c).(color.RGBA)] = uint8(i)
+	}
+	return e
+}
+
+func (e *gifEncoder) WriteFrame(img *image.RGBA) error {
+	b := img.Bounds()
+	if e.size.Empty() {
+		e.size = image.Rect(0, 0, b.Dx(), b.Dy())
+	} else if b.Dx() != e.size.Dx() || b.Dy() != e.size.Dy() {
+		return fmt.Errorf("frame size changed from %dx%d to %dx%d", e.size.Dx(), e.size.Dy(), b.Dx(), b.Dy())
+	}
+	dst := image.NewPaletted(e.size, e.palette)
+
+	for y := 0; y < b.Dy(); y++ {
+		src := img.Pix[y*img.Stride:]
+		for x := 0; x < b.Dx(); x++ {
+			c := color.RGBA{src[x*4], src[x*4+1], src[x*4+2], src[x*4+3]}
+			idx, ok := e.lookup[c]
+			if !ok {
+				idx = uint8(e.palette.Index(c))
+				e.lookup[c] = idx
+			}
+			dst.Pix[y*dst.Stride+x] = idx
+		}
+	}
+
+	if e.prev != nil && e.prev.Rect == dst.Rect && bytes.Equal(e.prev.Pix, dst.Pix) {
+		e.frames++
+		return nil
+	}
+
+	e.flush()
+	e.prev = dst
+	e.start = e.frames
+	e.frames++
+	return nil
+}
+
+// flush adds the previous frame to the animation. GIF delays are in 100ths of a second.
+func (e *gifEncoder) flush() {
+	if e.prev != nil {
+		delay := e.frames*100/e.fps - e.start*100/e.fps
+		e.anim.Image = append(e.anim.Image, e.prev)
+		e.anim.Delay = append(e.anim.Delay, delay)
+	}
+}
+
+func (e *gifEncoder) Close() error {
+	e.flush()
+	e.prev = nil
+	if len(e.anim.Image) == 0 {
+		return ErrNoFrames
+	}
+	return gif.EncodeAll(e.w, &e.anim)
+}
+
+// Scale resizes the image with nearest neighbor sampling. Recordings use it to keep
+// the frame size fixed when the video mode changes, the same way a monitor fills
+// the screen in every mode. The image is returned as is if it already has the size.
+func Scale(img *image.RGBA, width, height int) *image.RGBA {
+	b := img.Bounds()
+	if b.Dx() == width && b.Dy() == height {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		src := img.Pix[(y*b.Dy()/height)*img.Stride:]
+		row := dst.Pix[y*dst.Stride:]
+		for x := 0; x < width; x++ {
+			offset := (x * b.Dx() / width) * 4
+			copy(row[x*4:x*4+4], src[offset:offset+4])
+		}
+	}
+	return dst
+}
+
+type y4mEncoder struct {
+	w      *bufio.Writer
+	fps    int
+	width  int
+	height int
+	planes [3][]byte
+}
+
+// NewY4M creates an encoder for uncompressed YUV4MPEG2 streams. The frames are
+// stored with full chroma resolution (4:4:4) using BT.601 video levels.
+func NewY4M(w io.Writer, fps int) VideoEncoder {
+	return &y4mEncoder{w: bufio.NewWriter(w), fps: fps}
+}
+
+func (e *y4mEncoder) WriteFrame(img *image.RGBA) error {
+	b := img.Bounds()
+	if e.width == 0 {
+		e.width, e.height = b.Dx(), b.Dy()
+		for i := range e.planes {
+			e.planes[i] = make([]byte, e.width*e.height)
+		}
+		if _, err := fmt.Fprintf(e.w, "YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 C444\n", e.width, e.height, e.fps); err != nil {
+			return err
+		}
+	} else if b.Dx() != e.width || b.Dy() != e.height {
+		return fmt.Errorf("frame size changed from %dx%d to %dx%d", e.width, e.height, b.Dx(), b.Dy())
+	}
+
+	for y := 0; y < e.height; y++ {
+		src := img.Pix[y*img.Stride:]
+		for x := 0; x < e.width; x++ {
+			r, g, b := int(src[x*4]), int(src[x*4+1]), int(src[x*4+2])
+			i := y*e.width + x
+			e.planes[0][i] = byte(((66*r + 129*g + 25*b + 128) >> 8) + 16)
+			e.planes[1][i] = byte(((-38*r - 74*g + 112*b + 128) >> 8) + 128)
+			e.planes[2][i] = byte(((112*r - 94*g - 18*b + 128) >> 8) + 128)
+		}
+	}
+
+	if _, err := e.w.WriteString("FRAME\n"); err != nil {
+		return err
+	}
+	for _, p := range e.planes {
+		if _, err := e.w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *y4mEncoder) Close() error {
+	if e.width == 0 {
+		return ErrNoFrames
+	}
+	return e.w.Flush()
+}
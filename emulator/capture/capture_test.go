@@ -0,0 +1,194 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"testing"
+)
+
+var testPalette = color.Palette{
+	color.RGBA{0, 0, 0, 0xFF},
+	color.RGBA{0xFF, 0xFF, 0xFF, 0xFF},
+}
+
+func testFrame(c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+type memFile struct {
+	bytes.Buffer
+	pos int
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	data := f.Bytes()
+	n := copy(data[f.pos:], p)
+	f.Buffer.Write(p[n:])
+	f.pos += len(p)
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		f.pos = int(offset)
+	case io.SeekCurrent:
+		f.pos += int(offset)
+	case io.SeekEnd:
+		f.pos = f.Len() + int(offset)
+	}
+	return int64(f.pos), nil
+}
+
+func TestGIF(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewGIF(&buf, testPalette, 30)
+
+	frames := []color.RGBA{
+		{0, 0, 0, 0xFF},
+		{0, 0, 0, 0xFF},
+		{0, 0, 0, 0xFF},
+		{0xFF, 0xFF, 0xFF, 0xFF},
+	}
+	for _, c := range frames {
+		if err := enc.WriteFrame(testFrame(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.WriteFrame(image.NewRGBA(image.Rect(0, 0, 2, 2))); err == nil {
+		t.Error("expected error when frame size changes")
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	anim, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != 2 {
+		t.Fatalf("expected 2 frames, got %d", len(anim.Image))
+	}
+	if anim.Delay[0] != 10 || anim.Delay[1] != 3 {
+		t.Errorf("unexpected delays: %v", anim.Delay)
+	}
+	if anim.Image[1].Pix[0] != 1 {
+		t.Errorf("unexpected palette index: %d", anim.Image[1].Pix[0])
+	}
+
+	if err := NewGIF(&buf, testPalette, 30).Close(); !errors.Is(err, ErrNoFrames) {
+		t.Error("expected ErrNoFrames")
+	}
+}
+
+func TestScale(t *testing.T) {
+	img := testFrame(color.RGBA{0, 0, 0, 0xFF})
+	if Scale(img, 4, 2) != img {
+		t.Error("expected the same image")
+	}
+
+	img.Pix[4*4] = 0xFF // Red at 0,1
+	dst := Scale(img, 8, 6)
+	if b := dst.Bounds(); b.Dx() != 8 || b.Dy() != 6 {
+		t.Fatalf("unexpected size: %v", b)
+	}
+	for _, pos := range []image.Point{{0, 3}, {1, 5}} {
+		if c := dst.RGBAAt(pos.X, pos.Y); c.R != 0xFF {
+			t.Errorf("expected red at %v, got %v", pos, c)
+		}
+	}
+	if c := dst.RGBAAt(2, 3); c.R != 0 {
+		t.Errorf("expected black at (2,3), got %v", c)
+	}
+}
+
+func TestY4M(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewY4M(&buf, 30)
+
+	for _, c := range testPalette {
+		if err := enc.WriteFrame(testFrame(c.(color.RGBA))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.WriteFrame(image.NewRGBA(image.Rect(0, 0, 2, 2))); err == nil {
+		t.Error("expected error when frame size changes")
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	header := "YUV4MPEG2 W4 H2 F30:1 Ip A1:1 C444\n"
+	frameSize := len("FRAME\n") + 4*2*3
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte(header)) {
+		t.Fatalf("invalid header: %q", data)
+	}
+	if len(data) != len(header)+2*frameSize {
+		t.Fatalf("unexpected stream size: %d", len(data))
+	}
+
+	black := data[len(header)+len("FRAME\n"):]
+	white := data[len(header)+frameSize+len("FRAME\n"):]
+	if black[0] != 16 || black[8] != 128 || white[0] != 235 || white[16] != 128 {
+		t.Errorf("unexpected levels: %d %d %d %d", black[0], black[8], white[0], white[16])
+	}
+}
+
+func TestWAV(t *testing.T) {
+	var f memFile
+	wav, err := NewWAV(&f, 44100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wav.Write([]byte{0x80, 0x80, 0x80})
+	if err := wav.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := f.Bytes()
+	if len(data) != wavHeaderSize+3 {
+		t.Fatalf("unexpected file size: %d", len(data))
+	}
+	if string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		t.Error("invalid header")
+	}
+	if n := binary.LittleEndian.Uint32(data[4:]); n != wavHeaderSize-8+3 {
+		t.Errorf("unexpected RIFF size: %d", n)
+	}
+	if n := binary.LittleEndian.Uint32(data[40:]); n != 3 {
+		t.Errorf("unexpected data size: %d", n)
+	}
+	if n := binary.LittleEndian.Uint32(data[24:]); n != 44100 {
+		t.Errorf("unexpected frequency: %d", n)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const wavHeaderSize = 44
+
+// WAV writes unsigned 8-bit PCM audio to a RIFF WAVE file.
+type WAV struct {
+	w    io.WriteSeeker
+	size uint32
+}
+
+// NewWAV writes the file header. The data size is filled in when the file is closed.
+func NewWAV(w io.WriteSeeker, freq, channels int) (*WAV, error) {
+	wav := &WAV{w: w}
+	if err := wav.writeHeader(freq, channels); err != nil {
+		return nil, err
+	}
+	return wav, nil
+}
+
+func (wav *WAV) writeHeader(freq, channels int) error {
+	header := struct {
+		Riff          [4]byte
+		RiffSize      uint32
+		Wave          [4]byte
+		Fmt           [4]byte
+		FmtSize       uint32
+		Format        uint16
+		Channels      uint16
+		Freq          uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		Data          [4]byte
+		DataSize      uint32
+	}{
+		Riff:          [4]byte{'R', 'I', 'F', 'F'},
+		RiffSize:      wavHeaderSize - 8 + wav.size,
+		Wave:          [4]byte{'W', 'A', 'V', 'E'},
+		Fmt:           [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		Format:        1, // PCM
+		Channels:      uint16(channels),
+		Freq:          uint32(freq),
+		ByteRate:      uint32(freq * channels),
+		BlockAlign:    uint16(channels),
+		BitsPerSample: 8,
+		Data:          [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      wav.size,
+	}
+	return binary.Write(wav.w, binary.LittleEndian, &header)
+}
+
+func (wav *WAV) Write(p []byte) (int, error) {
+	n, err := wav.w.Write(p)
+	wav.size += uint32(n)
+	return n, err
+}
+
+// Close updates the sizes in the header. It does not close the underlying writer.
+func (wav *WAV) Close() error {
+	if _, err := wav.w.Seek(4, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Write(wav.w, binary.LittleEndian, wavHeaderSize-8+wav.size); err != nil {
+		return err
+	}
+	if _, err := wav.w.Seek(wavHeaderSize-4, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Write(wav.w, binary.LittleEndian, wav.size); err != nil {
+		return err
+	}
+	_, err := wav.w.Seek(0, io.SeekEnd)
+	return err
+}
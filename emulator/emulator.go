@@ -21,49 +21,77 @@ freely, subject to the following restrictions:
 package emulator
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strings"
 	"time"
 
-	"github.com/andreas-jonsson/virtualxt/emulator/memory"
-	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
-	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/cga"
+	"github.com/andreas-jonsson/virtualxt/emulator/capture"
+	"github.com/andreas-jonsson/virtualxt/emulator/input"
+	"github.com/andreas-jonsson/virtualxt/emulator/machine"
 	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/debug"
-	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/disk"
-	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/dma"
-	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/joystick"
-	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/keyboard"
-	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/network"
-	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/pic"
-	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/pit"
-	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/ram"
-	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/rom"
-	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/smouse"
-	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/speaker"
-	"github.com/andreas-jonsson/virtualxt/emulator/processor"
 	"github.com/andreas-jonsson/virtualxt/emulator/processor/cpu"
 	"github.com/andreas-jonsson/virtualxt/emulator/processor/validator"
+	"github.com/andreas-jonsson/virtualxt/emulator/script"
 	"github.com/andreas-jonsson/virtualxt/platform"
 	"github.com/andreas-jonsson/virtualxt/platform/dialog"
 )
 
+// requestPollInterval is how often requests from the host, like snapshots, screenshots,
+// paste and signals, are checked while the machine is running.
+const requestPollInterval = int64(10 * time.Millisecond)
+
 var (
 	biosImage  = "bios/vxtbios.bin"
 	vxtxImage  = "bios/vxtx.bin"
 	vbiosImage = ""
 )
 
+var (
+	defaultFloppyImage = ""
+	defaultHdImage     = "boot/freedos_hd.img"
+	driveImages        [0x100]string
+)
+
 var (
 	validatorOutput,
 	cpuProfile string
+
+	stateFile = "virtualxt.state"
+	recordFile,
+	replayFile string
+	loadState,
+	saveState bool
+
+	screenshotDir    = "."
+	screenshotFile   string
+	videoFile        string
+	screenDumpFile   string
+	scriptFile       string
+	aspectCorrection bool
+
+	cpuModel      = "8088"
+	videoAdapters = "CGA"
+	displayIndex  int
 )
 
 var (
 	limitMIPS float64
-	v20cpu    bool
+	v20cpu,
+	prefetch,
+	mathCoprocessor,
+	deterministic,
+	enableDebug bool
+
+	instructionCache = true
 )
 
 func init() {
@@ -79,13 +107,45 @@ func init() {
 		vbiosImage = p
 	}
 
-	flag.BoolVar(&v20cpu, "v20", false, "Emulate NEC V20 CPU")
+	if p, ok := os.LookupEnv("VXT_DEFAULT_FLOPPY_IMAGE"); ok {
+		defaultFloppyImage = p
+	}
+	if p, ok := os.LookupEnv("VXT_DEFAULT_HD_IMAGE"); ok {
+		defaultHdImage = p
+	}
+
+	flag.StringVar(&driveImages[0x0], "a", defaultFloppyImage, "Mount image as floppy A")
+	flag.StringVar(&driveImages[0x1], "b", "", "Mount image as floppy B")
+	flag.StringVar(&driveImages[0x80], "c", defaultHdImage, "Mount image as haddrive C")
+	flag.StringVar(&driveImages[0x81], "d", "", "Mount image as haddrive D")
 
-	flag.Float64Var(&limitMIPS, "mips", 3, "Limit CPU speed (0 for no limit)")
+	flag.StringVar(&cpuModel, "cpu", cpuModel, "CPU model (8088, 8086, V20, V30, 80188 or 80186)")
+	flag.BoolVar(&v20cpu, "v20", false, "Emulate NEC V20 CPU (same as -cpu=V20)")
+	flag.BoolVar(&prefetch, "prefetch", false, "Emulate the CPU prefetch queue")
+	flag.BoolVar(&instructionCache, "cache", instructionCache, "Cache decoded instructions")
+	flag.BoolVar(&mathCoprocessor, "fpu", false, "Emulate Intel 8087 math coprocessor")
+	flag.BoolVar(&deterministic, "deterministic", false, "Use a fixed seed for memory initialization")
+
+	flag.Float64Var(&limitMIPS, "mips", 4.77, "Limit CPU clock in MHz (0 for no limit)")
 	flag.StringVar(&biosImage, "bios", biosImage, "Path to BIOS image")
 	flag.StringVar(&vxtxImage, "vxtx", vxtxImage, "Path to VirtualXT BIOS extension image")
 	flag.StringVar(&vbiosImage, "vbios", vbiosImage, "Path to EGA/VGA BIOS image")
-
+	flag.StringVar(&videoAdapters, "adapter", videoAdapters, "Comma separated list of video adapters (CGA, HGC, EGA and VGA)")
+	flag.IntVar(&displayIndex, "display", displayIndex, "Display used for screenshots and in text or headless mode (index in the -adapter list)")
+
+	flag.StringVar(&stateFile, "state", stateFile, "Snapshot file used by save state (Shift+F11) and load state (Shift+F12)")
+	flag.BoolVar(&loadState, "load-state", false, "Restore the snapshot file at startup")
+	flag.BoolVar(&saveState, "save-state", false, "Save a snapshot file at shutdown")
+	flag.StringVar(&screenshotDir, "screenshot-dir", screenshotDir, "Directory for screenshots taken with Ctrl+F11")
+	flag.StringVar(&screenshotFile, "screenshot", screenshotFile, "Save a PNG screenshot to file at shutdown")
+	flag.BoolVar(&aspectCorrection, "aspect", false, "Stretch screenshots to a 4:3 aspect ratio")
+	flag.StringVar(&videoFile, "video", videoFile, "Record video to a .gif or .y4m file and audio to a .wav file next to it")
+	flag.StringVar(&screenDumpFile, "dump-screen", screenDumpFile, "Write the text screen to file at shutdown or on SIGUSR1 (- for stdout)")
+	flag.StringVar(&scriptFile, "script", scriptFile, "Run an input script")
+	flag.StringVar(&recordFile, "record", recordFile, "Record all input to file (implies -deterministic)")
+	flag.StringVar(&replayFile, "replay", replayFile, "Replay input from a recording (implies -deterministic)")
+
+	flag.BoolVar(&enableDebug, "debug", false, "Enable debugger")
 	flag.StringVar(&validatorOutput, "validator", validatorOutput, "Set CPU validator output")
 	flag.StringVar(&cpuProfile, "cpu-profile", cpuProfile, "Set CPU profile output")
 }
@@ -98,35 +158,40 @@ func Start(s platform.Platform) {
 	}
 	defer bios.Close()
 
-	dc := &disk.Device{BootDrive: 0xFF}
-	dialog.FloppyController = dc
+	drives := &dialog.Drives{}
+	images := make(map[byte]io.ReadWriteSeeker)
+	bootDrive := -1
 
-	for i, v := range dialog.DriveImages {
-		if v.Name != "" {
-			name := v.Name
+	for i, name := range driveImages {
+		if name != "" {
 			bootable := false
 			if name[0] == '*' {
 				bootable = true
 				name = name[1:]
 			}
 
+			img := &drives.Images[i]
+
 			var err error
-			if dialog.DriveImages[i].Fp, err = s.OpenFile(name, os.O_RDWR, 0644); err != nil {
-				dialog.ShowErrorMessage(err.Error())
-			} else if err = dc.Insert(byte(i), dialog.DriveImages[i].Fp); err != nil {
+			if img.Fp, err = s.OpenFile(name, os.O_RDWR, 0644); err != nil {
 				dialog.ShowErrorMessage(err.Error())
-			} else if bootable || dc.BootDrive == 0xFF {
-				dc.BootDrive = byte(i)
+				continue
+			}
+			img.Name = name
+
+			images[byte(i)] = img.Fp
+			if bootable || bootDrive < 0 {
+				bootDrive = i
 			}
 		}
 	}
 
-	if dc.BootDrive == 0xFF {
+	if bootDrive < 0 {
 		dialog.ShowErrorMessage("No boot device selected!")
 		return
 	}
 
-	if !checkBootsector(dc) {
+	if !checkBootsector(drives.Images[bootDrive].Fp) {
 		dialog.ShowErrorMessage("The selected disk is not bootable!")
 		return
 	}
@@ -135,30 +200,73 @@ func Start(s platform.Platform) {
 		debug.MuteLogging(true)
 	}
 
-	spkr := &speaker.Device{}
-	peripherals := []peripheral.Peripheral{
-		&ram.Device{ // RAM (needs to go first since it maps the full memory range)
-			Clear: runtime.GOOS == "js", // A bug in the JS backend does not allow us to scramble that memory.
-		},
-		&rom.Device{
-			RomName: "BIOS",
-			Base:    memory.NewPointer(0xFE00, 0),
-			Reader:  bios,
-		},
-		&pic.Device{},      // Programmable Interrupt Controller
-		&pit.Device{},      // Programmable Interval Timer
-		&dma.Device{},      // DMA Controller
-		dc,                 // Disk Controller
-		&cga.Device{},      // Video Device
-		spkr,               // PC Speaker
-		&keyboard.Device{}, // Keyboard Controller
-		&joystick.Device{}, // Game Port Joysticks
-		&network.Device{},  // Network Adapter
-		&smouse.Device{ // Microsoft Serial Mouse (COM1)
-			BasePort: 0x3F8,
-			IRQ:      4,
-		},
+	var journal *input.Journal
+	if recordFile != "" && replayFile != "" {
+		dialog.ShowErrorMessage("Can't record and replay input at the same time!")
+		return
+	} else if recordFile != "" {
+		fp, err := s.Create(recordFile)
+		if err != nil {
+			dialog.ShowErrorMessage(err.Error())
+			return
+		}
+		defer fp.Close()
+
+		w := bufio.NewWriter(fp)
+		defer w.Flush()
+
+		if journal, err = input.NewRecorder(w); err != nil {
+			dialog.ShowErrorMessage(err.Error())
+			return
+		}
+		defer func() {
+			if err := journal.Err(); err != nil {
+				log.Print("Input recording failed: ", err)
+			}
+		}()
+	} else if replayFile != "" {
+		fp, err := s.Open(replayFile)
+		if err != nil {
+			dialog.ShowErrorMessage(err.Error())
+			return
+		}
+
+		journal, err = input.NewPlayer(bufio.NewReader(fp))
+		fp.Close()
+		if err != nil {
+			dialog.ShowErrorMessage(err.Error())
+			return
+		}
+	}
+
+	// Recordings can only be replayed if the machine starts from a known state.
+	if journal != nil {
+		deterministic = true
 	}
+
+	var seed int64
+	if deterministic {
+		seed = 1
+	}
+
+	v := validator.New(validatorOutput, validator.DefulatQueueSize, validator.DefaultBufferSize)
+	defer v.Shutdown()
+
+	cfg := machine.Config{
+		Platform:         s,
+		BIOS:             bios,
+		PrefetchQueue:    prefetch,
+		InstructionCache: instructionCache,
+		MathCoprocessor:  mathCoprocessor,
+		Drives:           images,
+		BootDrive:        byte(bootDrive),
+		Seed:             seed,
+		ClearMemory:      runtime.GOOS == "js", // A bug in the JS backend does not allow us to scramble that memory.
+		Journal:          journal,
+		Debug:            enableDebug,
+		Validator:        v,
+	}
+
 	if vxtxImage != "" {
 		vxtxBios, err := s.Open(vxtxImage)
 		if err != nil {
@@ -166,12 +274,7 @@ func Start(s platform.Platform) {
 			return
 		}
 		defer vxtxBios.Close()
-
-		peripherals = append(peripherals, &rom.Device{
-			RomName: "VirtualXT BIOS Extension",
-			Base:    memory.NewPointer(0xE000, 0),
-			Reader:  vxtxBios,
-		})
+		cfg.BIOSExtension = vxtxBios
 	}
 	if vbiosImage != "" {
 		videoBios, err := s.Open(vbiosImage)
@@ -180,15 +283,7 @@ func Start(s platform.Platform) {
 			return
 		}
 		defer videoBios.Close()
-
-		peripherals = append(peripherals, &rom.Device{
-			RomName: "Video BIOS",
-			Base:    memory.NewPointer(0xC000, 0),
-			Reader:  videoBios,
-		})
-	}
-	if debug.EnableDebug { // Add this last so it can find other devices.
-		peripherals = append(peripherals, &debug.Device{})
+		cfg.VideoBIOS = videoBios
 	}
 
 	if cpuProfile != "" {
@@ -201,49 +296,126 @@ func Start(s platform.Platform) {
 		}
 	}
 
+	// With the turbo switch off we run at the original 4.77MHz.
 	var doLimit float64 = limitMIPS
 	if doLimit == 0 {
-		doLimit = 0.33
+		doLimit = 4.77
 	}
 	limitSpeed := 1000000000 / int64(1000000*doLimit)
 
-	validator.Initialize(validatorOutput, validator.DefulatQueueSize, validator.DefaultBufferSize)
-	defer validator.Shutdown()
-
-	p, errs := cpu.NewCPU(peripherals)
-	defer p.Close()
+	if cfg.Model, err = cpu.ParseModel(cpuModel); err != nil {
+		dialog.ShowErrorMessage(err.Error())
+		return
+	}
+	for _, name := range strings.Split(videoAdapters, ",") {
+		adapter, err := machine.ParseVideoAdapter(strings.TrimSpace(name))
+		if err != nil {
+			dialog.ShowErrorMessage(err.Error())
+			return
+		}
+		cfg.Video = append(cfg.Video, adapter)
+	}
+	if v20cpu {
+		cfg.Model = cpu.NECV20
+	}
 
+	m, errs := machine.New(cfg)
 	for _, err := range errs {
 		dialog.ShowErrorMessage(err.Error())
 	}
+	if m == nil {
+		return
+	}
+	defer m.Close()
+	if err := m.SelectDisplay(displayIndex); err != nil {
+		dialog.ShowErrorMessage(err.Error())
+		return
+	}
+	drives.Controller = m
+	s.SetDrives(drives)
+	defer s.SetDrives(nil)
 
-	p.SetV20Support(v20cpu)
-	p.Reset()
+	if loadState {
+		restoreSnapshot(s, m)
+	}
+	if saveState {
+		defer saveSnapshot(s, m)
+	}
+	if screenshotFile != "" {
+		defer saveScreenshot(s, m, screenshotFile)
+	}
+	if videoFile != "" {
+		stop, err := startRecording(s, m, videoFile)
+		if err != nil {
+			dialog.ShowErrorMessage(err.Error())
+			return
+		}
+		defer stop()
+	}
+
+	if screenDumpFile != "" {
+		defer dumpScreen(s, m, screenDumpFile)
+	}
+
+	var runner *script.Runner
+	if scriptFile != "" {
+		if runner, err = loadScript(s, m, scriptFile); err != nil {
+			dialog.ShowErrorMessage(err.Error())
+			return
+		}
+	}
 
+	signals := append([]os.Signal{}, dumpSignals...)
+	for _, sig := range quitSignals {
+		// The debugger breaks on interrupt.
+		if !enableDebug || sig != os.Interrupt {
+			signals = append(signals, sig)
+		}
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, signals...)
+	defer signal.Stop(sigChan)
+
+	var nextPoll int64
 	for !dialog.ShutdownRequested() {
 		var cycles int64
 		t := time.Now().UnixNano()
 
 		if dialog.RestartRequested() {
-			p.Reset()
+			m.Reset()
+		}
+		if t >= nextPoll {
+			nextPoll = t + requestPollInterval
+			handleRequests(s, m, sigChan)
 		}
 
 	step:
-		c, err := p.Step()
-		if err != nil && err != processor.ErrCPUHalt {
+		c, err := m.Step(1)
+		if err != nil {
 			log.Print(err)
 			return
 		}
-		cycles += int64(c)
+		cycles += c
+
+		if runner != nil && runner.Step() {
+			if err := runner.Err(); err != nil {
+				log.Print("Script failed: ", err)
+			}
+			if code, ok := runner.Exited(); ok {
+				dialog.Exit(code)
+			}
+			runner = nil
+		}
 
 		if runtime.GOOS == "js" {
 			// This is to prevent the JS backend from deadlocking.
-			if cycles > 1000 {
+			if cycles > 10000 {
 				time.Sleep(time.Nanosecond)
 				continue
 			}
 			goto step
-		} else if limitMIPS == 0 && spkr.TurboSwitch() {
+		} else if limitMIPS == 0 && m.TurboSwitch() {
 			continue
 		}
 
@@ -257,8 +429,207 @@ func Start(s platform.Platform) {
 	}
 }
 
-func checkBootsector(dc *disk.Device) bool {
-	fp := dialog.DriveImages[dc.BootDrive].Fp
+func handleRequests(s platform.Platform, m *machine.Machine, sigChan <-chan os.Signal) {
+	if dialog.SaveStateRequested() {
+		saveSnapshot(s, m)
+	}
+	if dialog.LoadStateRequested() {
+		restoreSnapshot(s, m)
+	}
+	if dialog.ScreenshotRequested() {
+		saveScreenshot(s, m, nextScreenshotName())
+	}
+	if text, ok := dialog.PasteRequested(); ok {
+		if err := m.Paste(text); err != nil {
+			log.Print("Could not paste: ", err)
+		}
+	}
+
+	select {
+	case sig := <-sigChan:
+		if isDumpSignal(sig) {
+			dumpScreen(s, m, screenDumpFile)
+		} else {
+			log.Print("Shutdown on signal: ", sig)
+			dialog.Quit()
+		}
+	default:
+	}
+}
+
+func saveSnapshot(s platform.Platform, m *machine.Machine) {
+	fp, err := s.Create(stateFile)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer fp.Close()
+
+	w := bufio.NewWriter(fp)
+	if err := m.Snapshot(w); err != nil {
+		log.Print("Could not save snapshot: ", err)
+		return
+	}
+	if err := w.Flush(); err != nil {
+		log.Print("Could not save snapshot: ", err)
+		return
+	}
+	log.Print("Saved snapshot: ", stateFile)
+}
+
+func restoreSnapshot(s platform.Platform, m *machine.Machine) {
+	fp, err := s.Open(stateFile)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer fp.Close()
+
+	if err := m.Restore(bufio.NewReader(fp)); err != nil {
+		log.Print("Could not restore snapshot: ", err)
+		return
+	}
+	log.Print("Restored snapshot: ", stateFile)
+}
+
+func saveScreenshot(s platform.Platform, m *machine.Machine, name string) {
+	fp, err := s.Create(name)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer fp.Close()
+
+	if err := m.Screenshot(fp, aspectCorrection); err != nil {
+		log.Print("Could not save screenshot: ", err)
+		return
+	}
+	log.Print("Saved screenshot: ", name)
+}
+
+// startRecording records video to the file and audio to a WAV file with the same name.
+func startRecording(s platform.Platform, m *machine.Machine, name string) (func(), error) {
+	var newEncoder func(io.Writer) capture.VideoEncoder
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".gif":
+		newEncoder = func(w io.Writer) capture.VideoEncoder {
+			return capture.NewGIF(w, m.Palette(), machine.RecordingFPS)
+		}
+	case ".y4m":
+		newEncoder = func(w io.Writer) capture.VideoEncoder {
+			return capture.NewY4M(w, machine.RecordingFPS)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported video format: %s", ext)
+	}
+
+	videoFp, err := s.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	audioName := strings.TrimSuffix(name, filepath.Ext(name)) + ".wav"
+	audioFp, err := s.Create(audioName)
+	if err != nil {
+		videoFp.Close()
+		return nil, err
+	}
+
+	spec := m.AudioSpec()
+	wav, err := capture.NewWAV(audioFp, spec.Freq, spec.Channels)
+	if err != nil {
+		videoFp.Close()
+		audioFp.Close()
+		return nil, err
+	}
+
+	video := newEncoder(videoFp)
+	m.StartRecording(video, wav, aspectCorrection)
+
+	return func() {
+		ok := true
+		if err := m.StopRecording(); err != nil {
+			log.Print("Recording failed: ", err)
+			ok = false
+		}
+		if err := video.Close(); err != nil {
+			log.Print("Could not save video: ", err)
+			ok = false
+		}
+		if err := wav.Close(); err != nil {
+			log.Print("Could not save audio: ", err)
+			ok = false
+		}
+		videoFp.Close()
+		audioFp.Close()
+		if ok {
+			log.Printf("Saved recording: %s, %s", name, audioName)
+		}
+	}, nil
+}
+
+func loadScript(s platform.Platform, m *machine.Machine, name string) (*script.Runner, error) {
+	fp, err := s.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	sc, err := script.Parse(fp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	r := script.NewRunner(sc, m)
+	r.Create = func(name string) (io.WriteCloser, error) { return s.Create(name) }
+	r.CorrectAspect = aspectCorrection
+	return r, nil
+}
+
+func isDumpSignal(sig os.Signal) bool {
+	for _, s := range dumpSignals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
+
+// dumpScreen writes the text screen to the file, or to stdout if name is "-" or empty.
+func dumpScreen(s platform.Platform, m *machine.Machine, name string) {
+	t := m.Text()
+	if t == nil {
+		log.Print("Can't dump screen in graphics mode")
+		return
+	}
+
+	var w io.Writer = os.Stdout
+	if name != "" && name != "-" {
+		fp, err := s.Create(name)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		defer fp.Close()
+		w = fp
+	}
+
+	if _, err := fmt.Fprintln(w, t); err != nil {
+		log.Print("Could not dump screen: ", err)
+	}
+}
+
+// nextScreenshotName returns the first unused screenshot file name.
+func nextScreenshotName() string {
+	for i := 0; ; i++ {
+		name := filepath.Join(screenshotDir, fmt.Sprintf("screenshot%04d.png", i))
+		if _, err := os.Stat(name); err != nil {
+			return name
+		}
+	}
+}
+
+func checkBootsector(fp dialog.File) bool {
 	var sector [512]byte
 	fp.ReadAt(sector[:], 0)
 	fp.Seek(0, os.SEEK_SET)
@@ -0,0 +1,146 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package input
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Version is the version of the recording format.
+const Version = 1
+
+var magic = [4]byte{'V', 'X', 'T', 'I'}
+
+var (
+	ErrInvalidRecording = errors.New("invalid input recording")
+	ErrVersion          = errors.New("unsupported input recording version")
+)
+
+// Source is the device that consumed an input event.
+type Source byte
+
+const (
+	Keyboard Source = iota
+	Mouse
+	numSources
+)
+
+// Event is an input event and the CPU cycle it was delivered to the emulated device.
+type Event struct {
+	Cycle  int64
+	Source Source
+	Data   [3]byte
+}
+
+// Journal records the input events consumed by the devices, or replays the
+// events from an earlier recording at the same cycles. Devices only consume
+// input from their scheduled events so, given the same starting state, a
+// replay reproduces the recorded session exactly.
+//
+// All methods can be called on a nil journal, which does nothing.
+type Journal struct {
+	w      io.Writer
+	err    error
+	events [numSources][]Event
+}
+
+// NewRecorder creates a journal that writes all consumed events to w.
+func NewRecorder(w io.Writer) (*Journal, error) {
+	if err := binary.Write(w, binary.LittleEndian, magic); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint16(Version)); err != nil {
+		return nil, err
+	}
+	return &Journal{w: w}, nil
+}
+
+// NewPlayer creates a journal that replays the events read from r.
+func NewPlayer(r io.Reader) (*Journal, error) {
+	var (
+		m [4]byte
+		v uint16
+	)
+
+	if err := binary.Read(r, binary.LittleEndian, &m); err != nil {
+		return nil, err
+	}
+	if m != magic {
+		return nil, ErrInvalidRecording
+	}
+	if err := binary.Read(r, binary.LittleEndian, &v); err != nil {
+		return nil, err
+	}
+	if v != Version {
+		return nil, ErrVersion
+	}
+
+	j := &Journal{}
+	for {
+		var ev Event
+		if err := binary.Read(r, binary.LittleEndian, &ev); err == io.EOF {
+			return j, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if ev.Source >= numSources {
+			return nil, ErrInvalidRecording
+		}
+		j.events[ev.Source] = append(j.events[ev.Source], ev)
+	}
+}
+
+// Replaying reports if input from the host should be ignored.
+func (j *Journal) Replaying() bool {
+	return j != nil && j.w == nil
+}
+
+// Record writes the event if the journal is recording.
+func (j *Journal) Record(src Source, cycle int64, data [3]byte) {
+	if j == nil || j.w == nil || j.err != nil {
+		return
+	}
+	j.err = binary.Write(j.w, binary.LittleEndian, &Event{Cycle: cycle, Source: src, Data: data})
+}
+
+// Next returns the next recorded event for the device if it is due.
+func (j *Journal) Next(src Source, cycle int64) ([3]byte, bool) {
+	if !j.Replaying() {
+		return [3]byte{}, false
+	}
+
+	q := j.events[src]
+	if len(q) == 0 || q[0].Cycle > cycle {
+		return [3]byte{}, false
+	}
+	j.events[src] = q[1:]
+	return q[0].Data, true
+}
+
+// Err returns the first error that occurred while recording.
+func (j *Journal) Err() error {
+	if j == nil {
+		return nil
+	}
+	return j.err
+}
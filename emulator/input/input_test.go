@@ -0,0 +1,78 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package input
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Replaying() {
+		t.Fatal("recorder is replaying")
+	}
+
+	rec.Record(Keyboard, 100, [3]byte{0x1E})
+	rec.Record(Mouse, 150, [3]byte{1, 2, 3})
+	rec.Record(Keyboard, 200, [3]byte{0x9E})
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	play, err := NewPlayer(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !play.Replaying() {
+		t.Fatal("player is not replaying")
+	}
+
+	if _, ok := play.Next(Keyboard, 99); ok {
+		t.Error("event delivered too early")
+	}
+	if data, ok := play.Next(Keyboard, 100); !ok || data[0] != 0x1E {
+		t.Errorf("expected key press, got %v %v", data, ok)
+	}
+	if _, ok := play.Next(Keyboard, 100); ok {
+		t.Error("event delivered twice")
+	}
+	if data, ok := play.Next(Mouse, 150); !ok || data != [3]byte{1, 2, 3} {
+		t.Errorf("expected mouse event, got %v %v", data, ok)
+	}
+	if data, ok := play.Next(Keyboard, 200); !ok || data[0] != 0x9E {
+		t.Errorf("expected key release, got %v %v", data, ok)
+	}
+
+	var j *Journal
+	j.Record(Keyboard, 0, [3]byte{})
+	if _, ok := j.Next(Keyboard, 0); ok || j.Replaying() {
+		t.Error("nil journal is not a no-op")
+	}
+
+	if _, err := NewPlayer(bytes.NewReader([]byte("VXTS\x01\x00"))); err != ErrInvalidRecording {
+		t.Errorf("expected invalid recording error, got %v", err)
+	}
+}
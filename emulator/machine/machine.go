@@ -0,0 +1,502 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+// Package machine lets Go programs create and drive emulated IBM PC/XT machines.
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"sync/atomic"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/capture"
+	"github.com/andreas-jonsson/virtualxt/emulator/input"
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/cga"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/debug"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/disk"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/dma"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/ega"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/fpu"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/joystick"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/keyboard"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/mda"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/network"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/pic"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/pit"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/ram"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/rom"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/smouse"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/speaker"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/vga"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor/cpu"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor/validator"
+	"github.com/andreas-jonsson/virtualxt/emulator/screen"
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+// Config describes the hardware of a machine.
+type Config struct {
+	// Platform is required and is used for video, audio and host input.
+	// Machines that run side by side need a platform each.
+	Platform platform.Platform
+
+	// BIOS is required. BIOSExtension and VideoBIOS are optional, but EGA and VGA need VideoBIOS.
+	BIOS,
+	BIOSExtension,
+	VideoBIOS io.Reader
+
+	// Video lists the display adapters. The n:th adapter is shown on display n
+	// of the platform. A single CGA is installed if the list is empty.
+	Video []VideoAdapter
+
+	Model cpu.Model
+	PrefetchQueue,
+	InstructionCache,
+	MathCoprocessor bool
+
+	// Drives are inserted in the disk controller before the machine is reset.
+	// Floppy drives are numbered from 0x0 and harddrives from 0x80.
+	Drives    map[byte]io.ReadWriteSeeker
+	BootDrive byte
+
+	// Seed is used to scramble RAM and video memory. A zero seed gives different memory each run.
+	Seed        int64
+	ClearMemory bool
+
+	// Journal records or replays keyboard and mouse input. A snapshot can only be restored
+	// before the machine starts running, and a replay must restore the same snapshot as
+	// the recording.
+	Journal *input.Journal
+
+	// Debug adds the interactive debugger.
+	Debug bool
+
+	// Validator records every executed instruction. It can be nil.
+	Validator *validator.Validator
+}
+
+// videoDevice is implemented by the display adapters.
+type videoDevice interface {
+	peripheral.Peripheral
+
+	Framebuffer() ([]byte, int, int)
+	Image(correctAspect bool) *image.RGBA
+	Text() *screen.Text
+	TextScreen() ([]byte, int, int)
+}
+
+// RecordingFPS is the frame rate of recorded video. It is the same rate the screen is rendered at.
+const RecordingFPS = 30
+
+// ErrJournalStarted is returned by Restore if the machine has run with a journal. The
+// recorded input would no longer match the state of the machine.
+var ErrJournalStarted = errors.New("can't restore a snapshot while input is recorded or replayed")
+
+// Machine is an emulated computer. A machine is not safe for concurrent use,
+// except for Pause, Resume, KeyEvent and MouseEvent.
+type Machine struct {
+	cpu      *cpu.CPU
+	disk     *disk.Device
+	video    videoDevice
+	palette  color.Palette
+	displays []videoDevice
+	palettes []color.Palette
+	speaker  *speaker.Device
+	keyboard *keyboard.Device
+	mouse    *smouse.Device
+
+	paused int32
+
+	journal *input.Journal
+	stepped bool
+
+	recording     *processor.Event
+	recordErr     error
+	recordAspect  bool
+	recordSize    image.Point
+	recordVideo   capture.VideoEncoder
+	recordedAudio *errWriter
+}
+
+// errWriter keeps the first write error and drops all writes after it.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	var n int
+	n, w.err = w.w.Write(p)
+	return n, w.err
+}
+
+// New creates a machine from the configuration and resets it. Errors from
+// peripherals that fail to install are returned but the machine is still usable.
+func New(cfg Config) (*Machine, []error) {
+	if cfg.Platform == nil {
+		return nil, []error{errors.New("no platform")}
+	}
+	if cfg.BIOS == nil {
+		return nil, []error{errors.New("no BIOS image")}
+	}
+
+	m := &Machine{
+		journal:  cfg.Journal,
+		disk:     &disk.Device{BootDrive: cfg.BootDrive},
+		speaker:  &speaker.Device{Platform: cfg.Platform},
+		keyboard: &keyboard.Device{Platform: cfg.Platform, Journal: cfg.Journal},
+		mouse: &smouse.Device{ // COM1
+			BasePort: 0x3F8,
+			IRQ:      4,
+			Platform: cfg.Platform,
+			Journal:  cfg.Journal,
+		},
+	}
+
+	adapters := cfg.Video
+	if len(adapters) == 0 {
+		adapters = []VideoAdapter{CGA}
+	}
+	for i, adapter := range adapters {
+		for _, other := range adapters[:i] {
+			if adapter == other {
+				return nil, []error{fmt.Errorf("%v is installed twice", adapter)}
+			}
+			if adapter.colorPorts() && other.colorPorts() {
+				return nil, []error{fmt.Errorf("%v and %v use the same I/O ports", other, adapter)}
+			}
+		}
+		if (adapter == EGA || adapter == VGA) && cfg.VideoBIOS == nil {
+			return nil, []error{fmt.Errorf("%v needs a video BIOS image", adapter)}
+		}
+
+		display := cfg.Platform.GetDisplay(i)
+		switch adapter {
+		case EGA:
+			m.displays = append(m.displays, &ega.Device{Display: display, Seed: cfg.Seed})
+			m.palettes = append(m.palettes, ega.Palette())
+		case VGA:
+			m.displays = append(m.displays, &vga.Device{Display: display, Seed: cfg.Seed})
+			m.palettes = append(m.palettes, vga.Palette())
+		case CGA:
+			m.displays = append(m.displays, &cga.Device{Display: display, Seed: cfg.Seed})
+			m.palettes = append(m.palettes, cga.Palette())
+		case Hercules:
+			m.displays = append(m.displays, &mda.Device{Display: display, Seed: cfg.Seed})
+			m.palettes = append(m.palettes, mda.Palette())
+		default:
+			return nil, []error{fmt.Errorf("unknown video adapter: %v", adapter)}
+		}
+	}
+	m.video, m.palette = m.displays[0], m.palettes[0]
+
+	var errs []error
+	for drive, rws := range cfg.Drives {
+		if err := m.disk.Insert(drive, rws); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	peripherals := []peripheral.Peripheral{
+		&ram.Device{ // RAM (needs to go first since it maps the full memory range)
+			Clear: cfg.ClearMemory,
+			Seed:  cfg.Seed,
+		},
+		&rom.Device{
+			RomName: "BIOS",
+			Base:    memory.NewPointer(0xFE00, 0),
+			Reader:  cfg.BIOS,
+		},
+		&pic.Device{}, // Programmable Interrupt Controller
+		&pit.Device{}, // Programmable Interval Timer
+		&dma.Device{}, // DMA Controller
+		m.disk,        // Disk Controller
+	}
+	for _, video := range m.displays { // Video Devices
+		peripherals = append(peripherals, video)
+	}
+	peripherals = append(peripherals,
+		m.speaker,          // PC Speaker
+		m.keyboard,         // Keyboard Controller
+		&joystick.Device{}, // Game Port Joysticks
+		&network.Device{},  // Network Adapter
+		m.mouse,            // Serial Mouse
+	)
+	if cfg.MathCoprocessor {
+		peripherals = append(peripherals, &fpu.Device{})
+	}
+	if cfg.BIOSExtension != nil {
+		peripherals = append(peripherals, &rom.Device{
+			RomName: "VirtualXT BIOS Extension",
+			Base:    memory.NewPointer(0xE000, 0),
+			Reader:  cfg.BIOSExtension,
+		})
+	}
+	if cfg.VideoBIOS != nil {
+		peripherals = append(peripherals, &rom.Device{
+			RomName: "Video BIOS",
+			Base:    memory.NewPointer(0xC000, 0),
+			Reader:  cfg.VideoBIOS,
+		})
+	}
+	if cfg.Debug { // Add this last so it can find other devices.
+		peripherals = append(peripherals, &debug.Device{})
+	}
+
+	var installErrs []error
+	m.cpu, installErrs = cpu.NewCPU(peripherals)
+	errs = append(errs, installErrs...)
+
+	m.cpu.SetValidator(cfg.Validator)
+	m.cpu.SetModel(cfg.Model)
+	m.cpu.SetPrefetchQueue(cfg.PrefetchQueue)
+	m.cpu.SetInstructionCache(cfg.InstructionCache)
+	m.cpu.Reset()
+	return m, errs
+}
+
+// Close shuts down all peripherals. The machine can not be used after it is closed.
+func (m *Machine) Close() {
+	m.cpu.Close()
+}
+
+// CPU gives direct access to the processor, for example to inspect registers.
+func (m *Machine) CPU() *cpu.CPU {
+	return m.cpu
+}
+
+func (m *Machine) Reset() {
+	m.cpu.Reset()
+}
+
+// Step executes instructions until at least the given number of cycles has passed.
+// It returns the number of executed cycles, which is zero if the machine is paused.
+func (m *Machine) Step(cycles int64) (int64, error) {
+	m.stepped = true
+
+	var n int64
+	for n < cycles && !m.Paused() {
+		c, err := m.cpu.Step()
+		n += int64(c)
+		if err != nil && err != processor.ErrCPUHalt {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Cycles returns the total number of cycles the machine has executed.
+func (m *Machine) Cycles() int64 {
+	return m.cpu.GetScheduler().Cycles()
+}
+
+func (m *Machine) Pause() {
+	atomic.StoreInt32(&m.paused, 1)
+}
+
+func (m *Machine) Resume() {
+	atomic.StoreInt32(&m.paused, 0)
+}
+
+func (m *Machine) Paused() bool {
+	return atomic.LoadInt32(&m.paused) != 0
+}
+
+// TurboSwitch reports if the guest has asked to run at full speed.
+func (m *Machine) TurboSwitch() bool {
+	return m.speaker.TurboSwitch()
+}
+
+// Displays returns the number of video adapters.
+func (m *Machine) Displays() int {
+	return len(m.displays)
+}
+
+// SelectDisplay chooses the video adapter used by Framebuffer, Screenshot,
+// Palette, Text, TextScreen and recordings. Display 0 is selected by default.
+func (m *Machine) SelectDisplay(n int) error {
+	if n < 0 || n >= len(m.displays) {
+		return fmt.Errorf("no display %d", n)
+	}
+	m.video, m.palette = m.displays[n], m.palettes[n]
+	return nil
+}
+
+// Framebuffer renders the screen as RGBA pixels.
+func (m *Machine) Framebuffer() (pixels []byte, width, height int) {
+	return m.video.Framebuffer()
+}
+
+// Screenshot writes the screen as a PNG image, optionally corrected to a 4:3 aspect ratio.
+func (m *Machine) Screenshot(w io.Writer, correctAspect bool) error {
+	return png.Encode(w, m.video.Image(correctAspect))
+}
+
+// Palette returns the colors of the video adapter.
+func (m *Machine) Palette() color.Palette {
+	return m.palette
+}
+
+// AudioSpec returns the format of the audio sent to recordings.
+func (m *Machine) AudioSpec() platform.AudioSpec {
+	return m.speaker.AudioSpec()
+}
+
+// StartRecording captures the screen with RecordingFPS frames per second of emulated
+// time and the speaker output in the format given by AudioSpec. Both video and audio
+// are optional. Recording starts from a clean slate if it was already running.
+// All frames have the size of the screen when recording starts. Frames from
+// video modes with another size are scaled.
+func (m *Machine) StartRecording(video capture.VideoEncoder, audio io.Writer, correctAspect bool) {
+	m.StopRecording()
+
+	m.recordErr = nil
+	m.recordAspect = correctAspect
+	if video != nil {
+		m.recordVideo = video
+		m.recordSize = m.video.Image(correctAspect).Bounds().Size()
+		m.recording = m.cpu.GetScheduler().HostEvery(processor.ClockFrequency/RecordingFPS, m.recordFrame)
+		m.recordFrame()
+	}
+	if audio != nil {
+		m.recordedAudio = &errWriter{w: audio}
+		m.speaker.SetRecorder(m.recordedAudio)
+	}
+}
+
+func (m *Machine) recordFrame() error {
+	if m.recordErr == nil {
+		img := m.video.Image(m.recordAspect)
+		m.recordErr = m.recordVideo.WriteFrame(capture.Scale(img, m.recordSize.X, m.recordSize.Y))
+	}
+	return nil
+}
+
+// StopRecording stops capturing and returns the first error from the encoders.
+// The encoders are not closed.
+func (m *Machine) StopRecording() error {
+	if m.recording != nil {
+		m.cpu.GetScheduler().Cancel(m.recording)
+		m.recording = nil
+		m.recordVideo = nil
+	}
+	if m.recordedAudio != nil {
+		m.speaker.SetRecorder(nil)
+		if m.recordErr == nil {
+			m.recordErr = m.recordedAudio.err
+		}
+		m.recordedAudio = nil
+	}
+	return m.recordErr
+}
+
+// Text returns the visible text page with Unicode rows, attributes and cursor.
+// It returns nil if the video adapter is in a graphics mode.
+func (m *Machine) Text() *screen.Text {
+	return m.video.Text()
+}
+
+// TextScreen returns the character and attribute pairs of the visible text page.
+// Nothing is returned if the video adapter is in a graphics mode.
+func (m *Machine) TextScreen() (mem []byte, columns, rows int) {
+	return m.video.TextScreen()
+}
+
+// KeyEvent queues a scancode for the keyboard controller.
+func (m *Machine) KeyEvent(sc platform.Scancode) {
+	m.keyboard.KeyEvent(sc)
+}
+
+// Paste types the text on the keyboard. The keystrokes are delivered at the
+// pace the guest reads them. Text that can't be typed is rejected as a whole.
+func (m *Machine) Paste(text string) error {
+	keys, err := platform.Keystrokes(text)
+	if err != nil {
+		return err
+	}
+	m.keyboard.Type(keys)
+	return nil
+}
+
+// TypeKeys queues scancodes to be delivered the same way as pasted text.
+func (m *Machine) TypeKeys(keys ...platform.Scancode) {
+	m.keyboard.Type(keys)
+}
+
+// Typing reports if there are pasted keystrokes left to deliver.
+func (m *Machine) Typing() bool {
+	return m.keyboard.Typing()
+}
+
+// MouseEvent queues a relative mouse movement and the button state for the serial mouse.
+func (m *Machine) MouseEvent(buttons byte, xrel, yrel int8) {
+	m.mouse.MouseEvent(buttons, xrel, yrel)
+}
+
+// MoveMouse queues a relative mouse movement and the button state. Unlike MouseEvent
+// the movement can be of any length and packets are delivered at the pace the guest
+// reads them. Nothing is delivered if there is no mouse driver.
+func (m *Machine) MoveMouse(buttons byte, xrel, yrel int) {
+	m.mouse.Move(buttons, xrel, yrel)
+}
+
+// MouseMoving reports if there are mouse movements from MoveMouse left to deliver.
+func (m *Machine) MouseMoving() bool {
+	return m.mouse.Moving()
+}
+
+// Insert mounts a disk image in the drive.
+func (m *Machine) Insert(drive byte, disk io.ReadWriteSeeker) error {
+	return m.disk.Insert(drive, disk)
+}
+
+// Eject removes the disk image from the drive and returns it.
+func (m *Machine) Eject(drive byte) (io.ReadWriteSeeker, error) {
+	return m.disk.Eject(drive)
+}
+
+// Replace swaps the disk image in the drive.
+func (m *Machine) Replace(drive byte, disk io.ReadWriteSeeker) error {
+	return m.disk.Replace(drive, disk)
+}
+
+// Snapshot writes the state of the machine. See cpu.CPU.Snapshot.
+func (m *Machine) Snapshot(w io.Writer) error {
+	return m.cpu.Snapshot(w)
+}
+
+// Restore loads a snapshot written by a machine with the same configuration.
+// The machine is left unchanged if the snapshot can not be loaded.
+func (m *Machine) Restore(r io.Reader) error {
+	if m.journal != nil && m.stepped {
+		return ErrJournalStarted
+	}
+	return m.cpu.Restore(r)
+}
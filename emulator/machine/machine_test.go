@@ -0,0 +1,588 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package machine
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/capture"
+	"github.com/andreas-jonsson/virtualxt/emulator/input"
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor"
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+type memDisk struct {
+	data []byte
+	pos  int64
+}
+
+func (d *memDisk) Read(p []byte) (int, error) {
+	if d.pos >= int64(len(d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, d.data[d.pos:])
+	d.pos += int64(n)
+	return n, nil
+}
+
+func (d *memDisk) Write(p []byte) (int, error) {
+	n := copy(d.data[d.pos:], p)
+	d.pos += int64(n)
+	return n, nil
+}
+
+func (d *memDisk) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		d.pos = offset
+	case io.SeekCurrent:
+		d.pos += offset
+	case io.SeekEnd:
+		d.pos = int64(len(d.data)) + offset
+	}
+	return d.pos, nil
+}
+
+// newTestMachine creates a machine that boots a floppy which prints ch in the top left corner.
+func newTestMachine(t *testing.T, ch byte) (*Machine, func()) {
+	return newBootMachine(t, []byte{
+		0xB8, 0x00, 0xB8, // MOV AX,B800h
+		0x8E, 0xC0, // MOV ES,AX
+		0x26, 0xC7, 0x06, 0x00, 0x00, ch, 0x07, // MOV WORD [ES:0],07XXh
+		0xF4,       // HLT
+		0xEB, 0xFD, // JMP -3
+	})
+}
+
+// newBootMachine creates a machine that boots a floppy with the code in the boot sector.
+func newBootMachine(t *testing.T, code []byte, video ...VideoAdapter) (*Machine, func()) {
+	return newConfigMachine(t, code, Config{Video: video})
+}
+
+// newConfigMachine is like newBootMachine but takes the rest of the configuration from cfg.
+func newConfigMachine(t *testing.T, code []byte, cfg Config) (*Machine, func()) {
+	bios, err := os.Open("../../bios/vxtbios.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vxtx, err := os.Open("../../bios/vxtx.bin")
+	if err != nil {
+		bios.Close()
+		t.Fatal(err)
+	}
+
+	floppy := &memDisk{data: make([]byte, 0x168000)}
+	copy(floppy.data, code)
+	floppy.data[510], floppy.data[511] = 0x55, 0xAA
+
+	cfg.Platform = platform.NewHeadless()
+	cfg.BIOS, cfg.BIOSExtension = bios, vxtx
+	cfg.Drives = map[byte]io.ReadWriteSeeker{0: floppy}
+	cfg.ClearMemory = true
+
+	m, errs := New(cfg)
+	bios.Close()
+	vxtx.Close()
+
+	for _, err := range errs {
+		t.Fatal(err)
+	}
+	return m, m.Close
+}
+
+type countingEncoder struct {
+	frames int
+}
+
+func (e *countingEncoder) WriteFrame(*image.RGBA) error {
+	e.frames++
+	return nil
+}
+
+func (e *countingEncoder) Close() error {
+	return nil
+}
+
+func printed(m *Machine, ch byte) bool {
+	mem, _, _ := m.TextScreen()
+	return len(mem) > 1 && mem[0] == ch && mem[1] == 0x07
+}
+
+func boot(m *Machine, ch byte) error {
+	for i := 0; i < 100 && !printed(m, ch); i++ {
+		if _, err := m.Step(1000000); err != nil {
+			return err
+		}
+	}
+	if !printed(m, ch) {
+		return fmt.Errorf("boot sector did not print '%c'", ch)
+	}
+	return nil
+}
+
+func TestMachine(t *testing.T) {
+	m, closeMachine := newTestMachine(t, 'A')
+	defer closeMachine()
+
+	if err := boot(m, 'A'); err != nil {
+		t.Fatal(err)
+	}
+
+	if txt := m.Text(); txt == nil || txt.Row(0) != "A" || txt.Page != 0 {
+		t.Errorf("unexpected text screen: %v", txt)
+	}
+
+	pixels, w, h := m.Framebuffer()
+	if len(pixels) != w*h*4 {
+		t.Errorf("invalid framebuffer size: %d", len(pixels))
+	}
+
+	for _, aspect := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := m.Screenshot(&buf, aspect); err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 640 || (aspect && b.Dy() != 480) || (!aspect && b.Dy() != 200) {
+			t.Errorf("invalid screenshot size: %v", b)
+		}
+	}
+
+	var video countingEncoder
+	var audio bytes.Buffer
+	m.StartRecording(&video, &audio, false)
+	if _, err := m.Step(processor.ClockFrequency / 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	if video.frames < 3 || video.frames > 5 {
+		t.Errorf("expected 4 frames, got %d", video.frames)
+	}
+	if spec := m.AudioSpec(); audio.Len() < spec.Freq/20 {
+		t.Errorf("expected at least %d audio samples, got %d", spec.Freq/20, audio.Len())
+	}
+
+	m.Pause()
+	if n, _ := m.Step(1000); n != 0 {
+		t.Error("paused machine executed instructions")
+	}
+	m.Resume()
+	if n, _ := m.Step(1000); n < 1000 {
+		t.Errorf("expected at least 1000 cycles, got %d", n)
+	}
+}
+
+func TestRecordModeSwitch(t *testing.T) {
+	m, closeMachine := newTestMachine(t, 'A')
+	defer closeMachine()
+
+	if err := boot(m, 'A'); err != nil {
+		t.Fatal(err)
+	}
+
+	var gifData, y4mData bytes.Buffer
+	encoders := []capture.VideoEncoder{
+		capture.NewGIF(&gifData, m.Palette(), RecordingFPS),
+		capture.NewY4M(&y4mData, RecordingFPS),
+	}
+
+	for _, enc := range encoders {
+		m.StartRecording(enc, nil, false)
+		if _, err := m.Step(processor.ClockFrequency / 10); err != nil {
+			t.Fatal(err)
+		}
+
+		// Program the CRTC for 90 columns, which makes the screen 720 pixels wide.
+		m.cpu.OutByte(0x3D4, 1)
+		m.cpu.OutByte(0x3D5, 90)
+		if _, w, _ := m.Framebuffer(); w != 720 {
+			t.Fatalf("expected the screen to be 720 pixels wide, got %d", w)
+		}
+		if _, err := m.Step(processor.ClockFrequency / 10); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.StopRecording(); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		m.cpu.OutByte(0x3D5, 80)
+	}
+
+	anim, err := gif.DecodeAll(&gifData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range anim.Image {
+		if b := img.Bounds(); b.Dx() != 640 || b.Dy() != 200 {
+			t.Errorf("unexpected GIF frame size: %v", b)
+		}
+	}
+
+	// Each frame is 640x200 with three planes.
+	header := "YUV4MPEG2 W640 H200 F30:1 Ip A1:1 C444\n"
+	frameSize := len("FRAME\n") + 640*200*3
+	if !bytes.HasPrefix(y4mData.Bytes(), []byte(header)) {
+		t.Fatal("invalid Y4M header")
+	}
+	if n := (y4mData.Len() - len(header)) / frameSize; n < 5 {
+		t.Errorf("expected at least 5 frames, got %d", n)
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	code := []byte{
+		0xB8, 0x00, 0xB8, // MOV AX,B800h
+		0x8E, 0xC0, // MOV ES,AX
+		0xB8, 'J', 0x07, // MOV AX,07XXh
+		0x31, 0xFF, // XOR DI,DI
+		0xAB,       // STOSW
+		0xB4, 0x00, // MOV AH,0
+		0xCD, 0x16, // INT 16h
+		0xAB,       // STOSW
+		0xEB, 0xF9, // JMP -7
+	}
+
+	// Start the session from a snapshot of a booted machine.
+	m, closeMachine := newConfigMachine(t, code, Config{})
+	defer closeMachine()
+	if err := boot(m, 'J'); err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshot bytes.Buffer
+	if err := m.Snapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(journal *input.Journal) (*Machine, func()) {
+		m, closeMachine := newConfigMachine(t, code, Config{Journal: journal})
+		if err := m.Restore(bytes.NewReader(snapshot.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 50; i++ {
+			switch i {
+			case 5:
+				if err := m.Paste("vxt"); err != nil {
+					t.Fatal(err)
+				}
+			case 30:
+				m.TypeKeys(0x1C, 0x9C) // Enter
+			}
+			if _, err := m.Step(processor.ClockFrequency / 100); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return m, closeMachine
+	}
+
+	var recording bytes.Buffer
+	recorder, err := input.NewRecorder(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, closeRec := run(recorder)
+	defer closeRec()
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if txt := rec.Text(); !strings.HasPrefix(txt.Row(0), "Jvxt") {
+		t.Fatalf("input was not delivered: %q", txt.Row(0))
+	}
+	if err := rec.Restore(bytes.NewReader(snapshot.Bytes())); err != ErrJournalStarted {
+		t.Errorf("expected restore to be refused, got %v", err)
+	}
+
+	player, err := input.NewPlayer(bytes.NewReader(recording.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	play, closePlay := run(player)
+	defer closePlay()
+
+	if rec.Cycles() != play.Cycles() {
+		t.Errorf("cycles differ: %d != %d", play.Cycles(), rec.Cycles())
+	}
+	if rec.CPU().Registers != play.CPU().Registers {
+		t.Errorf("registers differ: %+v != %+v", play.CPU().Registers, rec.CPU().Registers)
+	}
+
+	// Compare conventional memory and the CGA framebuffer.
+	for addr := memory.Pointer(0); addr < 0xBC000; addr++ {
+		if addr == 0xA0000 {
+			addr = 0xB8000
+		}
+		if a, b := rec.CPU().ReadByte(addr), play.CPU().ReadByte(addr); a != b {
+			t.Fatalf("memory differs at 0x%X: 0x%X != 0x%X", addr, b, a)
+		}
+	}
+}
+
+func TestConcurrentMachines(t *testing.T) {
+	chars := []byte{'A', 'B'}
+	machines := make([]*Machine, len(chars))
+	for i, ch := range chars {
+		m, closeMachine := newTestMachine(t, ch)
+		defer closeMachine()
+		machines[i] = m
+	}
+
+	var wg sync.WaitGroup
+	for i, m := range machines {
+		wg.Add(1)
+		go func(m *Machine, ch byte) {
+			defer wg.Done()
+			if err := boot(m, ch); err != nil {
+				t.Error(err)
+			}
+		}(m, chars[i])
+	}
+	wg.Wait()
+
+	for i, m := range machines {
+		if !printed(m, chars[i]) {
+			t.Errorf("machine %d was overwritten", i)
+		}
+	}
+}
+
+func TestHercules(t *testing.T) {
+	if v, err := ParseVideoAdapter("mda"); err != nil || v != Hercules {
+		t.Fatalf("unexpected video adapter: %v, %v", v, err)
+	}
+
+	bios, err := os.Open("../../bios/vxtbios.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bios.Close()
+
+	m, errs := New(Config{
+		Platform:    platform.NewHeadless(),
+		BIOS:        bios,
+		Video:       []VideoAdapter{Hercules},
+		ClearMemory: true,
+	})
+	for _, err := range errs {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// The BIOS finds the adapter through the configuration switches.
+	for i := 0; i < 100; i++ {
+		if txt := m.Text(); txt != nil && txt.Contains("Mono/Hercules") {
+			break
+		}
+		if _, err := m.Step(1000000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if txt := m.Text(); txt == nil || !txt.Contains("Mono/Hercules") {
+		t.Fatalf("BIOS did not detect the adapter: %v", txt)
+	}
+
+	var buf bytes.Buffer
+	if err := m.Screenshot(&buf, true); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 720 || b.Dy() != 540 {
+		t.Errorf("invalid screenshot size: %v", b)
+	}
+}
+
+func TestDualMonitors(t *testing.T) {
+	// Switch to the monochrome display like a debugger would and print 'M'.
+	m, closeMachine := newBootMachine(t, []byte{
+		0xB8, 0x40, 0x00, // MOV AX,0040h
+		0x8E, 0xD8, // MOV DS,AX
+		0x80, 0x0E, 0x10, 0x00, 0x30, // OR BYTE [0010h],30h
+		0xB8, 0x07, 0x00, // MOV AX,0007h
+		0xCD, 0x10, // INT 10h
+		0xB8, 0x4D, 0x0E, // MOV AX,0E4Dh
+		0xCD, 0x10, // INT 10h
+		0xF4,       // HLT
+		0xEB, 0xFD, // JMP -3
+	}, CGA, Hercules)
+	defer closeMachine()
+
+	if m.Displays() != 2 {
+		t.Fatalf("expected 2 displays, got %d", m.Displays())
+	}
+	if err := m.SelectDisplay(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := boot(m, 'M'); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.SelectDisplay(0); err != nil {
+		t.Fatal(err)
+	}
+	if printed(m, 'M') {
+		t.Error("the color display shows the text of the monochrome display")
+	}
+
+	if err := m.SelectDisplay(2); err == nil {
+		t.Error("expected an error for a missing display")
+	}
+	if _, errs := New(Config{Platform: platform.NewHeadless(), BIOS: &bytes.Buffer{}, Video: []VideoAdapter{CGA, CGA}}); errs == nil {
+		t.Error("expected an error for two color adapters")
+	}
+}
+
+func TestVideoBIOS(t *testing.T) {
+	// A video BIOS that sets up 640x350 graphics, fills the screen with blue and
+	// replaces INT 10h, so the system BIOS can't change the mode. The DAC is
+	// only used by VGA.
+	vbios := make([]byte, 2048)
+	copy(vbios, []byte{
+		0x55, 0xAA, 0x04, // Signature and size
+		0x50, 0x52, 0x57, 0x06, 0x51, // PUSH AX,DX,DI,ES,CX
+		0x31, 0xC0, // XOR AX,AX
+		0x8E, 0xC0, // MOV ES,AX
+		0x26, 0xC7, 0x06, 0x40, 0x00, 0x7F, 0x00, // MOV WORD [ES:0040h],007Fh
+		0x26, 0x8C, 0x0E, 0x42, 0x00, // MOV [ES:0042h],CS
+		0xBA, 0xC2, 0x03, // MOV DX,03C2h
+		0xB0, 0xA7, // MOV AL,A7h
+		0xEE,             // OUT DX,AL
+		0xBA, 0xC4, 0x03, // MOV DX,03C4h
+		0xB8, 0x02, 0x0F, // MOV AX,0F02h
+		0xEF,             // OUT DX,AX
+		0xB8, 0x04, 0x06, // MOV AX,0604h
+		0xEF,             // OUT DX,AX
+		0xBA, 0xD4, 0x03, // MOV DX,03D4h
+		0xB8, 0x01, 0x4F, // MOV AX,4F01h
+		0xEF,             // OUT DX,AX
+		0xB8, 0x07, 0x1F, // MOV AX,1F07h
+		0xEF,             // OUT DX,AX
+		0xB8, 0x12, 0x5D, // MOV AX,5D12h
+		0xEF,             // OUT DX,AX
+		0xB8, 0x13, 0x28, // MOV AX,2813h
+		0xEF,             // OUT DX,AX
+		0xB8, 0x17, 0xE3, // MOV AX,E317h
+		0xEF,             // OUT DX,AX
+		0xBA, 0xC0, 0x03, // MOV DX,03C0h
+		0xB0, 0x01, // MOV AL,01h
+		0xEE,       // OUT DX,AL
+		0xEE,       // OUT DX,AL
+		0xB0, 0x10, // MOV AL,10h
+		0xEE,       // OUT DX,AL
+		0xB0, 0x01, // MOV AL,01h
+		0xEE,       // OUT DX,AL
+		0xB0, 0x20, // MOV AL,20h
+		0xEE,             // OUT DX,AL
+		0xBA, 0xC8, 0x03, // MOV DX,03C8h
+		0xB0, 0x01, // MOV AL,01h
+		0xEE,       // OUT DX,AL
+		0x42,       // INC DX
+		0x30, 0xC0, // XOR AL,AL
+		0xEE,       // OUT DX,AL
+		0xEE,       // OUT DX,AL
+		0xB0, 0x2A, // MOV AL,2Ah
+		0xEE,             // OUT DX,AL
+		0xBA, 0xCE, 0x03, // MOV DX,03CEh
+		0xB8, 0x06, 0x05, // MOV AX,0506h
+		0xEF,             // OUT DX,AX
+		0xB8, 0x01, 0x0F, // MOV AX,0F01h
+		0xEF,             // OUT DX,AX
+		0xB8, 0x00, 0x01, // MOV AX,0100h
+		0xEF,             // OUT DX,AX
+		0xB8, 0x00, 0xA0, // MOV AX,A000h
+		0x8E, 0xC0, // MOV ES,AX
+		0x31, 0xFF, // XOR DI,DI
+		0xB9, 0x60, 0x6D, // MOV CX,28000
+		0xF3, 0xAA, // REP STOSB
+		0x59, 0x07, 0x5F, 0x5A, 0x58, // POP CX,ES,DI,DX,AX
+		0xCB, // RETF
+		0xCF, // IRET
+	})
+	var sum byte
+	for _, v := range vbios {
+		sum += v
+	}
+	vbios[len(vbios)-1] = -sum
+
+	bios, err := ioutil.ReadFile("../../bios/vxtbios.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, adapter := range []VideoAdapter{EGA, VGA} {
+		t.Run(adapter.String(), func(t *testing.T) {
+			if _, errs := New(Config{Platform: platform.NewHeadless(), BIOS: bytes.NewReader(bios), Video: []VideoAdapter{adapter}}); errs == nil {
+				t.Error("expected an error without a video BIOS")
+			}
+			if _, errs := New(Config{Platform: platform.NewHeadless(), BIOS: bytes.NewReader(bios), VideoBIOS: bytes.NewReader(vbios), Video: []VideoAdapter{CGA, adapter}}); errs == nil {
+				t.Error("expected an error together with CGA")
+			}
+
+			m, errs := New(Config{
+				Platform:    platform.NewHeadless(),
+				BIOS:        bytes.NewReader(bios),
+				VideoBIOS:   bytes.NewReader(vbios),
+				Video:       []VideoAdapter{adapter, Hercules},
+				ClearMemory: true,
+			})
+			for _, err := range errs {
+				t.Fatal(err)
+			}
+			defer m.Close()
+
+			blue := func() bool {
+				pixels, w, h := m.Framebuffer()
+				return w == 640 && h == 350 && bytes.Equal(pixels[len(pixels)-4:], []byte{0, 0, 0xAA, 0xFF})
+			}
+			for i := 0; i < 100 && !blue(); i++ {
+				if _, err := m.Step(1000000); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !blue() {
+				t.Fatal("the video BIOS was not run")
+			}
+		})
+	}
+}
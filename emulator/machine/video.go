@@ -0,0 +1,71 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package machine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VideoAdapter selects the display adapter of a machine.
+type VideoAdapter int
+
+const (
+	// CGA is an IBM Color Graphics Adapter.
+	CGA VideoAdapter = iota
+
+	// Hercules is a Hercules Graphics Card. It also works as an IBM Monochrome Display Adapter.
+	Hercules
+
+	// EGA is an IBM Enhanced Graphics Adapter. It needs the video BIOS of the card.
+	EGA
+
+	// VGA is an IBM Video Graphics Array on an 8-bit card. It needs the video BIOS of the card.
+	VGA
+)
+
+var videoAdapterNames = [...]string{"CGA", "HGC", "EGA", "VGA"}
+
+func (v VideoAdapter) String() string {
+	if int(v) < len(videoAdapterNames) {
+		return videoAdapterNames[v]
+	}
+	return fmt.Sprintf("VideoAdapter(%d)", int(v))
+}
+
+// colorPorts reports if the adapter uses the I/O ports of a color display.
+func (v VideoAdapter) colorPorts() bool {
+	return v != Hercules
+}
+
+// ParseVideoAdapter returns the video adapter matching the name. Case is ignored
+// and MDA is accepted as a name for the Hercules card.
+func ParseVideoAdapter(name string) (VideoAdapter, error) {
+	if strings.EqualFold(name, "MDA") {
+		return Hercules, nil
+	}
+	for i, n := range videoAdapterNames {
+		if strings.EqualFold(n, name) {
+			return VideoAdapter(i), nil
+		}
+	}
+	return CGA, fmt.Errorf("unknown video adapter: %s", name)
+}
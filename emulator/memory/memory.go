@@ -61,16 +61,39 @@ func (p Pointer) String() string {
 	return fmt.Sprintf("0x%X", uint32(p))
 }
 
+const (
+	PageShift = 11
+	PageSize  = 1 << PageShift
+	NumPages  = 0x100000 >> PageShift
+)
+
 type Memory interface {
 	ReadByte(addr Pointer) byte
 	WriteByte(addr Pointer, data byte)
 }
 
+// Direct is implemented by memory devices that are backed by plain byte slices.
+// DirectPage returns the memory of the page starting at addr, or nil if the
+// page can't be accessed directly. The bus will read and write that memory
+// without calling the device.
+type Direct interface {
+	Memory
+	DirectPage(addr Pointer) (mem []byte, readOnly bool)
+}
+
 type IO interface {
 	In(port uint16) byte
 	Out(port uint16, data byte)
 }
 
+// IO16 is implemented by IO devices that handle word access natively. It is
+// only used when the same device is mapped to both ports.
+type IO16 interface {
+	IO
+	InWord(port uint16) uint16
+	OutWord(port uint16, data uint16)
+}
+
 type DummyIO struct{}
 
 func (m *DummyIO) In(port uint16) byte {
@@ -22,25 +22,36 @@ package cga
 
 import (
 	"flag"
-	"fmt"
+	"image"
+	"image/color"
+	"io"
 	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
 	"github.com/andreas-jonsson/virtualxt/emulator/processor"
+	"github.com/andreas-jonsson/virtualxt/emulator/screen"
 	"github.com/andreas-jonsson/virtualxt/platform"
-	"github.com/andreas-jonsson/virtualxt/platform/dialog"
 )
 
 const (
 	memorySize     = 0x4000
 	memoryBase     = 0xB8000
-	scanlineTiming = 31469
+	scanlineCycles = 150 // 31.469us at 4.77MHz.
+
+	maxWidth  = 720
+	maxHeight = 240
+
+	// The surface is blank and this size when the CRTC is not programmed.
+	blankWidth  = 640
+	blankHeight = 200
 )
 
-var applicationStart = time.Now()
+// textModeCRTC is what the BIOS programs for 80x25 text mode.
+var textModeCRTC = []byte{0x71, 0x50, 0x5A, 0x0A, 0x1F, 0x06, 0x19, 0x1C, 0x02, 0x07, 0x06, 0x07}
 
 var cgaColor = []uint32{
 	0x000000,
@@ -62,6 +73,12 @@ var cgaColor = []uint32{
 }
 
 type Device struct {
+	// Display shows the rendered screen.
+	Display platform.Display
+
+	// Seed is used to scramble the video memory. A zero seed gives different memory each run.
+	Seed int64
+
 	lock     sync.RWMutex
 	quitChan chan struct{}
 
@@ -72,7 +89,6 @@ type Device struct {
 	crtAddr, modeCtrlReg,
 	colorCtrlReg, statusReg byte
 
-	lastScanline    int64
 	currentScanline int
 
 	cursorVisible,
@@ -80,29 +96,33 @@ type Device struct {
 	cursorPosition uint16
 	surface        []byte
 
-	windowTitleTicker  *time.Ticker
-	atomicCycleCounter int32
+	title       *screen.Title
+	atomicBlink int32
 
 	p processor.Processor
 }
 
 func (m *Device) Install(p processor.Processor) error {
 	m.p = p
-	m.windowTitleTicker = time.NewTicker(time.Second)
+	m.title = screen.NewTitle()
 	m.quitChan = make(chan struct{})
 
 	// Scramble memory.
-	rand.Read(m.mem[:])
+	if m.Seed != 0 {
+		rand.New(rand.NewSource(m.Seed)).Read(m.mem[:])
+	} else {
+		rand.Read(m.mem[:])
+	}
 
 	// 16k of RAM at address 0B8000h for its frame buffer. The address is incompletely decoded; the frame buffer is repeated at 0BC000h.
-	if err := p.InstallMemoryDevice(m, memoryBase, memoryBase+memorySize*2); err != nil {
+	if err := p.InstallMemoryDevice(m, memoryBase, memoryBase+memorySize*2-1); err != nil {
 		return err
 	}
 	if err := p.InstallIODevice(m, 0x3D0, 0x3DF); err != nil {
 		return err
 	}
+	p.GetScheduler().Every(scanlineCycles, m.update)
 
-	m.surface = make([]byte, 640*200*4)
 	go m.renderLoop()
 	return nil
 }
@@ -113,34 +133,68 @@ func (m *Device) Name() string {
 
 func (m *Device) Reset() {
 	m.lock.Lock()
-	m.lastScanline = time.Now().UnixNano()
 	m.currentScanline = 0
 	m.colorCtrlReg = 0x20
 	m.modeCtrlReg = 1
 	m.statusReg = 0
 	m.cursorVisible = true
 	m.cursorPosition = 0
+	copy(m.crtReg[:], textModeCRTC)
 	m.lock.Unlock()
 }
 
-func (m *Device) Step(cycles int) error {
-	atomic.AddInt32(&m.atomicCycleCounter, int32(cycles))
+func (m *Device) EventDriven() bool {
+	return true
+}
+
+func (m *Device) Step(int) error {
+	return nil
+}
 
-	t := time.Now().UnixNano()
-	d := t - m.lastScanline
-	scanlines := d / scanlineTiming
+func (m *Device) SaveState(w io.Writer) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 
-	if scanlines > 0 {
-		offset := d % scanlineTiming
-		m.lastScanline = t - offset
+	return peripheral.WriteState(w,
+		m.mem[:], &m.crtReg,
+		m.crtAddr, m.modeCtrlReg, m.colorCtrlReg, m.statusReg,
+		int32(m.currentScanline), m.cursorVisible, m.cursorPosition,
+	)
+}
 
-		if m.currentScanline = (m.currentScanline + int(scanlines)) % 525; m.currentScanline > 479 {
-			m.statusReg = 8
-		} else {
-			m.statusReg = 0
+func (m *Device) LoadState(r io.Reader) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var scanline int32
+	err := peripheral.ReadState(r,
+		m.mem[:], &m.crtReg,
+		&m.crtAddr, &m.modeCtrlReg, &m.colorCtrlReg, &m.statusReg,
+		&scanline, &m.cursorVisible, &m.cursorPosition,
+	)
+	m.currentScanline = int(scanline)
+	atomic.StoreInt32(&m.dirtyMemory, 1)
+	return err
+}
+
+func (m *Device) update() error {
+	m.title.AddCycles(scanlineCycles)
+
+	if m.currentScanline = (m.currentScanline + 1) % 525; m.currentScanline == 0 {
+		// Blink is toggled every 500ms of emulated time.
+		var blink int32
+		if (m.p.GetScheduler().Time()/(time.Millisecond*500))%2 == 0 {
+			blink = 1
 		}
-		m.statusReg |= 1
+		atomic.StoreInt32(&m.atomicBlink, blink)
 	}
+
+	if m.currentScanline > 479 {
+		m.statusReg = 8
+	} else {
+		m.statusReg = 0
+	}
+	m.statusReg |= 1
 	return nil
 }
 
@@ -157,58 +211,72 @@ func blit32(pixels []byte, offset int, color uint32) {
 	pixels[offset+3] = 0xFF
 }
 
-func blinkTick() bool {
-	return ((time.Since(applicationStart)/time.Millisecond)/500)%2 == 0
+func (m *Device) blinkTick() bool {
+	return atomic.LoadInt32(&m.atomicBlink) != 0
 }
 
-func (m *Device) blitChar(ch, attrib byte, x, y int) {
-	pixels := m.surface
-	bgColorIndex := (attrib & 0x70) >> 4
-	fgColorIndex := attrib & 0xF
+// geometry is the screen layout programmed in the CRTC.
+type geometry struct {
+	// columns is the number of character clocks on a row and rowHeight the number of scanlines in a row.
+	columns, rows, rowHeight int
 
-	if attrib&0x80 != 0 {
-		if m.modeCtrlReg&0x20 != 0 {
-			if blinkTick() {
-				fgColorIndex = bgColorIndex
-			}
-		} else {
-			// High intensity!
-			bgColorIndex += 8
-		}
+	// charWidth is the number of pixels in a character clock.
+	charWidth int
+
+	// start is the memory address of the first character, counted in words.
+	start int
+}
+
+func (g geometry) size() (int, int) {
+	return g.columns * g.charWidth, g.rows * g.rowHeight
+}
+
+// blank reports if the CRTC is not programmed to show anything.
+func (g geometry) blank() bool {
+	return g.columns == 0 || g.rows == 0
+}
+
+// geometry returns the current screen layout. The lock must be held.
+func (m *Device) geometry() geometry {
+	g := geometry{
+		columns:   int(m.crtReg[1]),
+		rows:      int(m.crtReg[6] & 0x7F),
+		rowHeight: int(m.crtReg[9]&0x1F) + 1,
+		charWidth: 16,
+		start:     int(m.crtReg[0xC]&0x3F)<<8 | int(m.crtReg[0xD]),
 	}
 
-	bgColor := cgaColor[bgColorIndex]
-	fgColor := cgaColor[fgColorIndex]
+	// Characters in 80 column text mode are 8 pixels wide. Everything else
+	// reads two bytes per character clock, which gives 16 pixels.
+	if m.modeCtrlReg&3 == 1 {
+		g.charWidth = 8
+	}
 
-	charWidth := 1
-	if m.modeCtrlReg&1 == 0 {
-		charWidth = 2
+	if width, _ := g.size(); width > maxWidth {
+		g.columns = maxWidth / g.charWidth
+	}
+	if _, height := g.size(); height > maxHeight {
+		g.rows = maxHeight / g.rowHeight
 	}
+	return g
+}
 
-	for i := 0; i < 8; i++ {
-		glyphLine := cgaFont[int(ch)*8+i]
-		for j := 0; j < 8; j++ {
-			mask := byte(0x80 >> j)
-			col := fgColor
-			if glyphLine&mask == 0 {
-				col = bgColor
-			}
-			offset := (640*(y+i) + x*charWidth + j*charWidth) * 4
-			blit32(pixels, offset, col)
-			if charWidth == 2 { // 40 columns?
-				blit32(pixels, offset+4, col)
-			}
-		}
+// surfaceSize returns the size of the rendered surface.
+func (g geometry) surfaceSize() (int, int) {
+	if g.blank() {
+		return blankWidth, blankHeight
 	}
+	return g.size()
 }
 
 func (m *Device) renderLoop() {
-	p := platform.Instance
+	p := m.Display
 	textFlag := flag.Lookup("text")
 	cliMode := textFlag != nil && textFlag.Value.(flag.Getter).Get().(bool)
 
 	ticker := time.NewTicker(time.Second / 30)
 	defer ticker.Stop()
+	defer m.title.Stop()
 
 	for {
 		select {
@@ -216,18 +284,9 @@ func (m *Device) renderLoop() {
 			close(m.quitChan)
 			return
 		case <-ticker.C:
-			select {
-			case <-m.windowTitleTicker.C:
-				hlp := " (Press F12 for menu)"
-				if dialog.MainMenuWasOpen() || time.Since(applicationStart) > time.Second*10 {
-					hlp = ""
-				}
-				numCycles := float64(atomic.SwapInt32(&m.atomicCycleCounter, 0))
-				p.SetTitle(fmt.Sprintf("VirtualXT - %.2f MIPS%s", numCycles/1000000, hlp))
-			default:
-			}
+			m.title.Update(p)
 
-			blink := blinkTick()
+			blink := m.blinkTick()
 			dirtyMemory := atomic.LoadInt32(&m.dirtyMemory) != 0
 
 			if dirtyMemory || m.prevCursorState != blink {
@@ -235,103 +294,209 @@ func (m *Device) renderLoop() {
 				atomic.StoreInt32(&m.dirtyMemory, 0)
 				m.prevCursorState = blink
 
-				numCol := 80
-				if m.modeCtrlReg&1 == 0 {
-					numCol = 40
-				}
-
 				backgroundColorIndex := m.colorCtrlReg & 0xF
 				backgroundColor := cgaColor[backgroundColorIndex]
 				bgRComponent, bgGComponent, bgBComponent := byte(backgroundColor&0xFF0000), byte(backgroundColor&0x00FF00), byte(backgroundColor&0x0000FF)
 
-				// In graphics mode?
-				if m.modeCtrlReg&2 != 0 {
-					dst := m.surface
-
-					// Is in high-resolution mode?
-					if m.modeCtrlReg&0x10 != 0 {
-						for y := 0; y < 200; y++ {
-							for x := 0; x < 640; x++ {
-								addr := (y>>1)*80 + (y&1)*8192 + (x >> 3)
-								pixel := (m.mem[addr] >> (7 - (x & 7))) & 1
-								col := cgaColor[pixel*15]
-								offset := (y*640 + x) * 4
-								blit32(dst, offset, col)
-							}
-						}
-					} else {
-						palette := (m.colorCtrlReg >> 5) & 1
-						intensity := ((m.colorCtrlReg >> 4) & 1) << 3
-
-						for y := 0; y < 200; y++ {
-							for x := 0; x < 320; x++ {
-								addr := (y>>1)*80 + (y&1)*8192 + (x >> 2)
-								pixel := m.mem[addr]
-
-								switch x & 3 {
-								case 0:
-									pixel = (pixel >> 6) & 3
-								case 1:
-									pixel = (pixel >> 4) & 3
-								case 2:
-									pixel = (pixel >> 2) & 3
-								case 3:
-									pixel = pixel & 3
-								}
-
-								col := backgroundColor
-								if pixel != 0 {
-									col = cgaColor[pixel*2+palette+intensity]
-								}
-
-								offset := (y*640 + x*2) * 4
-								blit32(dst, offset, col)
-								blit32(dst, offset+4, col)
-							}
-						}
-					}
-
-					m.lock.RUnlock()
-					p.RenderGraphics(dst, bgRComponent, bgGComponent, bgBComponent)
-				} else if cliMode {
+				if m.modeCtrlReg&2 == 0 && cliMode {
 					if dirtyMemory {
-						cx, cy := -1, -1
-						if m.cursorVisible {
-							cx = int(m.cursorPosition) % numCol
-							cy = int(m.cursorPosition) / numCol
-						}
-
-						// We need to render before unlock.
-						p.RenderText(m.mem[:numCol*25*2], m.modeCtrlReg&0x20 != 0, int(backgroundColorIndex), cx, cy)
+						t := m.text()
+						p.RenderText(t.Mem, t.Width, false, m.modeCtrlReg&0x20 != 0, int(backgroundColorIndex), t.CursorX, t.CursorY)
 					}
 					m.lock.RUnlock()
 				} else {
-					videoPage := int(m.crtReg[0xC]<<8) + int(m.crtReg[0xD])
-					numChar := numCol * 25
+					g := m.geometry()
+					width, height := g.surfaceSize()
+					if len(m.surface) != width*height*4 {
+						m.surface = make([]byte, width*height*4)
+					}
+					m.renderSurface(m.surface, g, blink)
+					m.lock.RUnlock()
+					p.RenderGraphics(m.surface, width, height, bgRComponent, bgGComponent, bgBComponent)
+				}
+			}
+		}
+	}
+}
+
+// textColors returns the foreground and background colors of a character.
+func (m *Device) textColors(attrib byte, blink bool) (uint32, uint32) {
+	bgColorIndex := (attrib & 0x70) >> 4
+	fgColorIndex := attrib & 0xF
+
+	if attrib&0x80 != 0 {
+		if m.modeCtrlReg&0x20 != 0 {
+			if blink {
+				fgColorIndex = bgColorIndex
+			}
+		} else {
+			// High intensity!
+			bgColorIndex += 8
+		}
+	}
+	return cgaColor[fgColorIndex], cgaColor[bgColorIndex]
+}
+
+// renderSurface draws the screen to an RGBA surface of the size given by the geometry.
+// The lock must be held.
+func (m *Device) renderSurface(dst []byte, g geometry, blink bool) {
+	if g.blank() {
+		for i := 0; i < len(dst); i += 4 {
+			blit32(dst, i, cgaColor[0])
+		}
+		return
+	}
 
-					for i := 0; i < numChar*2; i += 2 {
-						ch := m.mem[videoPage+i]
-						idx := i / 2
-						m.blitChar(ch, m.mem[videoPage+i+1], (idx%numCol)*8, (idx/numCol)*8)
+	width, height := g.size()
+
+	// In graphics mode?
+	if m.modeCtrlReg&2 != 0 {
+		backgroundColor := cgaColor[m.colorCtrlReg&0xF]
+		palette := (m.colorCtrlReg >> 5) & 1
+		intensity := ((m.colorCtrlReg >> 4) & 1) << 3
+
+		for y := 0; y < height; y++ {
+			row, scan := y/g.rowHeight, y%g.rowHeight
+			offset := y * width * 4
+
+			for c := 0; c < g.columns; c++ {
+				// Bit 0 of the row scan counter selects the memory bank.
+				addr := (g.start+row*g.columns+c)*2 + (scan&1)*0x2000
+				pixels := uint16(m.mem[addr&(memorySize-1)])<<8 | uint16(m.mem[(addr+1)&(memorySize-1)])
+
+				// Is in high-resolution mode?
+				if m.modeCtrlReg&0x10 != 0 {
+					for i := uint(0); i < 16; i++ {
+						pixel := (pixels >> (15 - i)) & 1
+						blit32(dst, offset, cgaColor[pixel*15])
+						offset += 4
 					}
+					continue
+				}
 
-					if blink && m.cursorVisible {
-						x := int(m.cursorPosition) % numCol
-						y := int(m.cursorPosition) / numCol
-						if x < 80 && y < 25 {
-							attr := (m.mem[videoPage+(numCol*2*y+x*2+1)] & 0x70) | 0xF
-							m.blitChar('_', attr, x*8, y*8)
-						}
+				for i := uint(0); i < 8; i++ {
+					pixel := byte(pixels>>(14-i*2)) & 3
+					col := backgroundColor
+					if pixel != 0 {
+						col = cgaColor[pixel*2+palette+intensity]
 					}
+					blit32(dst, offset, col)
+					blit32(dst, offset+4, col)
+					offset += 8
+				}
+			}
+		}
+		return
+	}
 
-					m.lock.RUnlock()
-					p.RenderGraphics(m.surface, bgRComponent, bgGComponent, bgBComponent)
+	cursorStart, cursorEnd := int(m.crtReg[0xA]&0x1F), int(m.crtReg[0xB]&0x1F)
+	showCursor := blink && m.cursorVisible
+	scale := g.charWidth / 8
+
+	for y := 0; y < height; y++ {
+		row, scan := y/g.rowHeight, y%g.rowHeight
+		offset := y * width * 4
+
+		for c := 0; c < g.columns; c++ {
+			ma := (g.start + row*g.columns + c) & (memorySize/2 - 1)
+			ch, attrib := m.mem[ma*2], m.mem[ma*2+1]
+			fgColor, bgColor := m.textColors(attrib, blink)
+
+			// The character generator only sees the three lowest bits of the row scan counter.
+			glyphLine := cgaFont[int(ch)*8+scan&7]
+
+			// The cursor position is relative to the start of video memory.
+			if showCursor && ma == int(m.cursorPosition)&(memorySize/2-1) && scan >= cursorStart && scan <= cursorEnd {
+				glyphLine = 0xFF
+			}
+
+			for j := uint(0); j < 8; j++ {
+				col := fgColor
+				if glyphLine&(0x80>>j) == 0 {
+					col = bgColor
+				}
+				for s := 0; s < scale; s++ {
+					blit32(dst, offset, col)
+					offset += 4
 				}
 			}
 		}
 	}
 }
 
+// Framebuffer renders the current screen content as RGBA pixels. The size
+// follows the CRTC and is 640x200 in the standard modes.
+func (m *Device) Framebuffer() ([]byte, int, int) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	g := m.geometry()
+	width, height := g.surfaceSize()
+	pixels := make([]byte, width*height*4)
+	m.renderSurface(pixels, g, m.blinkTick())
+	return pixels, width, height
+}
+
+// Palette returns the colors used in the rendered surfaces.
+func Palette() color.Palette {
+	palette := make(color.Palette, len(cgaColor))
+	for i, c := range cgaColor {
+		palette[i] = color.RGBA{byte(c >> 16), byte(c >> 8), byte(c), 0xFF}
+	}
+	return palette
+}
+
+// Image renders the current screen content. With aspect correction the surface
+// is stretched to 4:3, which is how it looks on the monitor.
+func (m *Device) Image(correctAspect bool) *image.RGBA {
+	pixels, w, h := m.Framebuffer()
+	return screen.Image(pixels, w, h, correctAspect)
+}
+
+// TextScreen returns a copy of the character and attribute pairs of the visible
+// text page. Nothing is returned in graphics mode.
+func (m *Device) TextScreen() ([]byte, int, int) {
+	if t := m.Text(); t != nil {
+		return t.Mem, t.Width, t.Height
+	}
+	return nil, 0, 0
+}
+
+// Text returns a copy of the visible text page with the cursor position relative
+// to the page. It returns nil in graphics mode.
+func (m *Device) Text() *screen.Text {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.modeCtrlReg&2 != 0 {
+		return nil
+	}
+	return m.text()
+}
+
+// text copies the visible text page. Addresses wrap around like on the real adapter.
+// The lock must be held.
+func (m *Device) text() *screen.Text {
+	g := m.geometry()
+	t := &screen.Text{
+		Width:   g.columns,
+		Height:  g.rows,
+		Page:    (g.start * 2) & (memorySize - 1),
+		CursorX: -1,
+		CursorY: -1,
+		Mem:     make([]byte, g.columns*g.rows*2),
+	}
+	for i := range t.Mem {
+		t.Mem[i] = m.mem[(t.Page+i)&(memorySize-1)]
+	}
+
+	pos := (int(m.cursorPosition) - g.start) & (memorySize/2 - 1)
+	if m.cursorVisible && pos < g.columns*g.rows {
+		t.CursorX, t.CursorY = pos%g.columns, pos/g.columns
+	}
+	return t
+}
+
 func (m *Device) In(port uint16) byte {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
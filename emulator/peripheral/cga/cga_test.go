@@ -0,0 +1,208 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package cga
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/pic"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/ram"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor/cpu"
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+var graphicsModeCRTC = []byte{0x38, 0x28, 0x2D, 0x0A, 0x7F, 0x06, 0x64, 0x70, 0x02, 0x01, 0x06, 0x07}
+
+func newTestCPU(t *testing.T) (*cpu.CPU, *Device) {
+	d := &Device{Display: platform.NewHeadless(), Seed: 1}
+	p, errs := cpu.NewCPU([]peripheral.Peripheral{
+		&ram.Device{Clear: true},
+		&pic.Device{},
+		d,
+	})
+	for _, err := range errs {
+		t.Fatal(err)
+	}
+	p.Reset()
+	return p, d
+}
+
+func setCRTC(p *cpu.CPU, regs []byte) {
+	for i, v := range regs {
+		p.OutByte(0x3D4, byte(i))
+		p.OutByte(0x3D5, v)
+	}
+}
+
+func clearMemory(p *cpu.CPU) {
+	for i := 0; i < memorySize; i++ {
+		p.WriteByte(memory.Pointer(memoryBase+i), 0)
+	}
+}
+
+func pixel(pixels []byte, w, x, y int) uint32 {
+	offset := (y*w + x) * 4
+	return uint32(pixels[offset])<<16 | uint32(pixels[offset+1])<<8 | uint32(pixels[offset+2])
+}
+
+func TestText(t *testing.T) {
+	p, d := newTestCPU(t)
+	defer p.Close()
+
+	clearMemory(p)
+	p.OutByte(0x3D8, 0x09) // 80 columns with video enabled.
+
+	pixels, w, h := d.Framebuffer()
+	if w != 640 || h != 200 {
+		t.Fatalf("unexpected surface size: %dx%d", w, h)
+	}
+	if text := d.Text(); text == nil || text.Width != 80 || text.Height != 25 {
+		t.Fatalf("unexpected text screen: %+v", text)
+	}
+
+	// Cursor on the first character, drawn on scanline 6 and 7.
+	atomic.StoreInt32(&d.atomicBlink, 1)
+	p.WriteByte(memoryBase+1, 0x07)
+	pixels, _, _ = d.Framebuffer()
+	if c := pixel(pixels, w, 0, 5); c != cgaColor[0] {
+		t.Errorf("expected no cursor on scanline 5, got 0x%06X", c)
+	}
+	if c := pixel(pixels, w, 0, 6); c != cgaColor[7] {
+		t.Errorf("expected cursor on scanline 6, got 0x%06X", c)
+	}
+
+	// 80x50 with four scanlines per row.
+	setCRTC(p, []byte{0x71, 0x50, 0x5A, 0x0A, 0x3F, 0x06, 0x32, 0x38, 0x02, 0x03})
+	p.WriteByte(memoryBase+49*160, 0xDB)
+	p.WriteByte(memoryBase+49*160+1, 0x0E)
+
+	pixels, w, h = d.Framebuffer()
+	if w != 640 || h != 200 {
+		t.Fatalf("unexpected surface size: %dx%d", w, h)
+	}
+	if c := pixel(pixels, w, 0, 49*4+3); c != cgaColor[0xE] {
+		t.Errorf("expected character on the last row, got 0x%06X", c)
+	}
+
+	text := d.Text()
+	if text == nil || text.Width != 80 || text.Height != 50 {
+		t.Fatalf("unexpected text screen: %+v", text)
+	}
+	if ch, attr := text.Cell(0, 49); ch != '█' || attr != 0x0E {
+		t.Errorf("unexpected cell: %q 0x%X", ch, attr)
+	}
+}
+
+func TestLowResolution(t *testing.T) {
+	p, d := newTestCPU(t)
+	defer p.Close()
+
+	// 160x100 with 16 colors is 80 column text mode with two scanlines per row.
+	setCRTC(p, []byte{0x71, 0x50, 0x5A, 0x0A, 0x7F, 0x06, 0x64, 0x70, 0x02, 0x01})
+	p.OutByte(0x3D8, 0x09)
+
+	for i := 0; i < 80*100; i++ {
+		p.WriteByte(memory.Pointer(memoryBase+i*2), 0xDE)
+		p.WriteByte(memory.Pointer(memoryBase+i*2+1), 0x1E)
+	}
+
+	pixels, w, h := d.Framebuffer()
+	if w != 640 || h != 200 {
+		t.Fatalf("unexpected surface size: %dx%d", w, h)
+	}
+
+	// Every character is a blue and a yellow pixel.
+	for _, y := range []int{0, 1, 199} {
+		if c := pixel(pixels, w, 632, y); c != cgaColor[1] {
+			t.Errorf("expected blue at [632 %d], got 0x%06X", y, c)
+		}
+		if c := pixel(pixels, w, 636, y); c != cgaColor[0xE] {
+			t.Errorf("expected yellow at [636 %d], got 0x%06X", y, c)
+		}
+	}
+}
+
+func TestGraphics(t *testing.T) {
+	p, d := newTestCPU(t)
+	defer p.Close()
+
+	clearMemory(p)
+	setCRTC(p, graphicsModeCRTC)
+	p.OutByte(0x3D8, 0x0A) // 320x200 with video enabled.
+	p.OutByte(0x3D9, 0x30) // Intense cyan, magenta and white.
+
+	if d.Text() != nil {
+		t.Fatal("expected graphics mode")
+	}
+
+	// Even and odd lines are in separate banks.
+	p.WriteByte(memoryBase, 0xC0)
+	p.WriteByte(memoryBase+0x2000+79, 0x01)
+
+	pixels, w, h := d.Framebuffer()
+	if w != 640 || h != 200 {
+		t.Fatalf("unexpected surface size: %dx%d", w, h)
+	}
+	if c := pixel(pixels, w, 1, 0); c != cgaColor[15] {
+		t.Errorf("expected white at [1 0], got 0x%06X", c)
+	}
+	if c := pixel(pixels, w, 639, 1); c != cgaColor[11] {
+		t.Errorf("expected cyan at [639 1], got 0x%06X", c)
+	}
+
+	// Scroll down one line by moving the start address one row.
+	p.OutByte(0x3D4, 0xD)
+	p.OutByte(0x3D5, 40)
+
+	pixels, _, _ = d.Framebuffer()
+	if c := pixel(pixels, w, 1, 0); c != cgaColor[0] {
+		t.Errorf("expected scrolled out pixel at [1 0], got 0x%06X", c)
+	}
+	if c := pixel(pixels, w, 639, 197); c != cgaColor[0] {
+		t.Errorf("expected empty pixel at [639 197], got 0x%06X", c)
+	}
+
+	// The last row of the even bank moves up one row.
+	p.WriteByte(memoryBase+99*80, 0x40)
+	pixels, _, _ = d.Framebuffer()
+	if c := pixel(pixels, w, 0, 196); c != cgaColor[11] {
+		t.Errorf("expected cyan at [0 196], got 0x%06X", c)
+	}
+}
+
+func TestBlank(t *testing.T) {
+	p, d := newTestCPU(t)
+	defer p.Close()
+
+	setCRTC(p, []byte{0x71, 0})
+	pixels, w, h := d.Framebuffer()
+	if w != 640 || h != 200 {
+		t.Fatalf("unexpected surface size: %dx%d", w, h)
+	}
+	for i := 0; i < len(pixels); i += 4 {
+		if c := pixel(pixels, w, (i/4)%w, i/4/w); c != cgaColor[0] {
+			t.Fatalf("expected a blank screen, got 0x%06X", c)
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package cga
 
+// Glyph returns the eight rows of the character in the 8x8 font.
+func Glyph(ch byte) []byte {
+	return cgaFont[int(ch)*8 : int(ch)*8+8]
+}
+
 var cgaFont = []byte{
 	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x7e, 0x81, 0xa5, 0x81, 0xbd, 0x99, 0x81, 0x7e,
 	0x7e, 0xff, 0xdb, 0xff, 0xc3, 0xe7, 0xff, 0x7e, 0x6c, 0xfe, 0xfe, 0xfe, 0x7c, 0x38, 0x10, 0x00,
@@ -38,14 +38,14 @@ import (
 	"github.com/andreas-jonsson/virtualxt/emulator/memory"
 	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
 	"github.com/andreas-jonsson/virtualxt/emulator/processor"
+	"github.com/andreas-jonsson/virtualxt/emulator/screen"
 )
 
 var ErrQuit = errors.New("QUIT!")
 
 var (
-	EnableDebug bool
-	Stream      io.ReadWriter = &ioStream{}
-	Log                       = log.New(Stream, "", 0)
+	Stream io.ReadWriter = &ioStream{}
+	Log                  = log.New(Stream, "", 0)
 )
 
 var (
@@ -72,7 +72,6 @@ func (s *ioStream) Write(p []byte) (n int, err error) {
 
 func init() {
 	flag.BoolVar(&traceInstructions, "trace", false, "Trace instruction execution")
-	flag.BoolVar(&EnableDebug, "debug", false, "Enable debugger")
 	flag.BoolVar(&debugBreak, "break", false, "Break on startup")
 }
 
@@ -208,26 +207,29 @@ func (m *Device) showMemory(rng string) {
 	}
 }
 
-func toASCII(b byte) string {
-	if b == 0 {
-		return "."
-	} else if b < 0x20 {
-		return fmt.Sprint("?")
-	} else if b > 0x7E {
-		return fmt.Sprint("#")
-	}
-	return fmt.Sprintf("%c", b)
+type textScreen interface {
+	Text() *screen.Text
 }
 
 func (m *Device) renderVideo() {
-	p := memory.Pointer(0xB8000) // Assume CGA!
-	for i := 0; i < 25*2; i += 2 {
-		log.Print("| <<<!")
-		for j := 0; j < 80*2; j += 2 {
-			log.Print(toASCII(m.p.ReadByte(p)) + "<<<!")
-			p += 2
-		}
-		log.Print("")
+	video, ok := m.p.GetMappedIODevice(0x3D4).(textScreen)
+	if !ok {
+		video, ok = m.p.GetMappedIODevice(0x3B4).(textScreen)
+	}
+	if !ok {
+		log.Print("No video adapter!")
+		return
+	}
+
+	t := video.Text()
+	if t == nil {
+		log.Print("Video adapter is in graphics mode!")
+		return
+	}
+
+	log.Printf("Page: 0x%X, Cursor: %d,%d\n", t.Page, t.CursorX, t.CursorY)
+	for _, row := range t.Rows() {
+		log.Print("| " + row)
 	}
 }
 
@@ -27,6 +27,7 @@ import (
 	"sync"
 
 	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
 	"github.com/andreas-jonsson/virtualxt/emulator/processor"
 )
 
@@ -75,10 +76,27 @@ func (m *Device) Reset() {
 	}
 }
 
+func (m *Device) EventDriven() bool {
+	return true
+}
+
 func (m *Device) Step(int) error {
 	return nil
 }
 
+// SaveState only saves the controller state. The disk images are not part of the snapshot.
+func (m *Device) SaveState(w io.Writer) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return peripheral.WriteState(w, &m.lookupAH, &m.lookupCF)
+}
+
+func (m *Device) LoadState(r io.Reader) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return peripheral.ReadState(r, &m.lookupAH, &m.lookupCF)
+}
+
 func (m *Device) Eject(dnum byte) (io.ReadWriteSeeker, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
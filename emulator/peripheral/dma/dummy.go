@@ -30,10 +30,16 @@ type Device struct {
 }
 
 func (m *Device) Install(p processor.Processor) error {
-	p.InstallIODevice(m, 0x80, 0x8F)
+	if err := p.InstallIODevice(m, 0x80, 0x8F); err != nil {
+		return err
+	}
 	return p.InstallIODevice(m, 0xC0, 0xDF)
 }
 
+func (m *Device) EventDriven() bool {
+	return true
+}
+
 func (m *Device) In(port uint16) byte {
 	return 0xFF
 }
@@ -0,0 +1,125 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+// Package ega emulates an IBM Enhanced Graphics Adapter with 256KB of memory
+// connected to an Enhanced Color Display. The video modes are set up by the
+// BIOS on the card, which needs to be mapped at C000:0.
+package ega
+
+import (
+	"image/color"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/planar"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor"
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+// Scanline lengths for the 350 and 200 line timings, 45.8us and 63.7us at 4.77MHz.
+const (
+	scanlineCycles    = 218
+	scanlineCycles200 = 304
+)
+
+// DefaultSwitches selects an Enhanced Color Display in high resolution mode,
+// with a monochrome adapter as secondary display.
+const DefaultSwitches = 0x9
+
+// egaColor holds the 64 colors of the Enhanced Color Display. The bits are
+// the primary red, green and blue signals and the secondary signals in the same order.
+var egaColor [64]uint32
+
+// cgaColor maps the 16 colors of the 200 line modes, where bit 4 of the palette is
+// the intensity signal, to the Enhanced Color Display. Dark yellow is shown as brown.
+var cgaColor = [16]byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x14, 0x07, 0x38, 0x39, 0x3A, 0x3B, 0x3C, 0x3D, 0x3E, 0x3F}
+
+func init() {
+	for i := range egaColor {
+		level := func(primary, secondary int) uint32 {
+			return uint32((i>>uint(primary))&1)*0xAA + uint32((i>>uint(secondary))&1)*0x55
+		}
+		egaColor[i] = level(2, 5)<<16 | level(1, 4)<<8 | level(0, 3)
+	}
+}
+
+type Device struct {
+	// Display shows the rendered screen.
+	Display platform.Display
+
+	// Seed is used to scramble the video memory. A zero seed gives different memory each run.
+	Seed int64
+
+	// Switches are the configuration switches on the card. SW1 is bit 0 and
+	// a set bit means the switch is off. Zero selects DefaultSwitches.
+	Switches byte
+
+	planar.Adapter
+}
+
+func (m *Device) Install(p processor.Processor) error {
+	if m.Switches == 0 {
+		m.Switches = DefaultSwitches
+	}
+	return m.Setup(p, m, planar.Config{
+		Display:        m.Display,
+		Seed:           m.Seed,
+		ScanlineCycles: m.scanlineCycles,
+		Color:          m.color,
+		In:             m.in,
+	})
+}
+
+func (m *Device) Name() string {
+	return "Enhanced Graphics Adapter"
+}
+
+func (m *Device) scanlineCycles() int {
+	if m.MiscOutput()&0x80 == 0 {
+		return scanlineCycles200
+	}
+	return scanlineCycles
+}
+
+// color returns the color on the display of a value from the attribute controller.
+func (m *Device) color(c byte) uint32 {
+	if m.MiscOutput()&0x80 == 0 {
+		// Positive vertical sync selects 200 lines on the display.
+		return egaColor[cgaColor[c&7|(c>>1)&8]]
+	}
+	return egaColor[c]
+}
+
+func (m *Device) in(port uint16) (byte, bool) {
+	if port != 0x3C2 {
+		return 0, false
+	}
+
+	// Input status 0. Bit 4 reads the configuration switch selected by the clock select bits.
+	sel := 3 - (m.MiscOutput()>>2)&3
+	return ((m.Switches >> sel) & 1) << 4, true
+}
+
+// Palette returns the colors used in the rendered surfaces.
+func Palette() color.Palette {
+	palette := make(color.Palette, len(egaColor))
+	for i, c := range egaColor {
+		palette[i] = color.RGBA{byte(c >> 16), byte(c >> 8), byte(c), 0xFF}
+	}
+	return palette
+}
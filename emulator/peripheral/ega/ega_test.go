@@ -0,0 +1,289 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package ega
+
+import (
+	"testing"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/planar"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/planar/planartest"
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+// modes holds the register values the IBM EGA BIOS uses for the video modes.
+var modes = map[int]planartest.Mode{
+	0x3: {
+		Misc: 0xA7,
+		Seq:  []byte{0x01, 0x03, 0x00, 0x03},
+		CRTC: []byte{0x5B, 0x4F, 0x53, 0x37, 0x51, 0x5B, 0x6C, 0x1F, 0x00, 0x0D, 0x0B, 0x0C, 0x00, 0x00, 0x00, 0x00, 0x5E, 0x2B, 0x5D, 0x28, 0x0F, 0x5E, 0x0A, 0xA3, 0xFF},
+		Attr: []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x14, 0x07, 0x38, 0x39, 0x3A, 0x3B, 0x3C, 0x3D, 0x3E, 0x3F, 0x08, 0x00, 0x0F, 0x00},
+		GC:   []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x0E, 0x00, 0xFF},
+	},
+	0x4: {
+		Misc: 0x63,
+		Seq:  []byte{0x09, 0x03, 0x00, 0x02},
+		CRTC: []byte{0x37, 0x27, 0x2D, 0x37, 0x31, 0x15, 0x04, 0x11, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xE1, 0x24, 0xC7, 0x14, 0x00, 0xE0, 0xF0, 0xA2, 0xFF},
+		Attr: []byte{0x00, 0x13, 0x15, 0x17, 0x02, 0x04, 0x06, 0x07, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x01, 0x00, 0x03, 0x00},
+		GC:   []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x30, 0x0F, 0x00, 0xFF},
+	},
+	0xD: {
+		Misc: 0x63,
+		Seq:  []byte{0x09, 0x0F, 0x00, 0x06},
+		CRTC: []byte{0x37, 0x27, 0x2D, 0x37, 0x31, 0x15, 0x04, 0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xE1, 0x24, 0xC7, 0x14, 0x00, 0xE0, 0xF0, 0xE3, 0xFF},
+		Attr: []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x01, 0x00, 0x0F, 0x00},
+		GC:   []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x0F, 0xFF},
+	},
+	0x10: {
+		Misc: 0xA7,
+		Seq:  []byte{0x01, 0x0F, 0x00, 0x06},
+		CRTC: []byte{0x5B, 0x4F, 0x53, 0x37, 0x52, 0x00, 0x6C, 0x1F, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x5E, 0x2B, 0x5D, 0x28, 0x0F, 0x5F, 0x0A, 0xE3, 0xFF},
+		Attr: []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x14, 0x07, 0x38, 0x39, 0x3A, 0x3B, 0x3C, 0x3D, 0x3E, 0x3F, 0x01, 0x00, 0x0F, 0x00},
+		GC:   []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x0F, 0xFF},
+	},
+}
+
+func TestWriteModes(t *testing.T) {
+	p := planartest.NewCPU(t, &Device{Display: platform.NewHeadless(), Seed: 1})
+	defer p.Close()
+
+	modes[0x10].Set(p)
+	addr := memory.Pointer(planar.MemoryBase)
+
+	// Write mode 0 with set/reset.
+	planartest.SetGC(p, planar.GCEnableSetReset, 0xF)
+	planartest.SetGC(p, planar.GCSetReset, 0x5)
+	p.WriteByte(addr, 0)
+	if v := planartest.ReadPlanes(p, addr); v != [4]byte{0xFF, 0, 0xFF, 0} {
+		t.Errorf("set/reset: %X", v)
+	}
+
+	// The bit mask keeps the latched bits.
+	p.ReadByte(addr)
+	planartest.SetGC(p, planar.GCBitMask, 0x0F)
+	planartest.SetGC(p, planar.GCSetReset, 0xA)
+	p.WriteByte(addr, 0)
+	if v := planartest.ReadPlanes(p, addr); v != [4]byte{0xF0, 0x0F, 0xF0, 0x0F} {
+		t.Errorf("bit mask: %X", v)
+	}
+	planartest.SetGC(p, planar.GCBitMask, 0xFF)
+	planartest.SetGC(p, planar.GCEnableSetReset, 0)
+
+	// Rotated data combined with the latches.
+	p.ReadByte(addr)
+	planartest.SetGC(p, planar.GCDataRotate, 0x18|4)
+	p.WriteByte(addr, 0x0F)
+	if v := planartest.ReadPlanes(p, addr); v != [4]byte{0x00, 0xFF, 0x00, 0xFF} {
+		t.Errorf("rotate and xor: %X", v)
+	}
+	planartest.SetGC(p, planar.GCDataRotate, 0)
+
+	// Write mode 1 copies the latches.
+	p.ReadByte(addr)
+	planartest.SetGC(p, planar.GCMode, 1)
+	p.WriteByte(addr+1, 0)
+	if v := planartest.ReadPlanes(p, addr+1); v != [4]byte{0x00, 0xFF, 0x00, 0xFF} {
+		t.Errorf("write mode 1: %X", v)
+	}
+
+	// Write mode 2 expands the color to all planes.
+	planartest.SetGC(p, planar.GCMode, 2)
+	p.WriteByte(addr+2, 0x9)
+	if v := planartest.ReadPlanes(p, addr+2); v != [4]byte{0xFF, 0x00, 0x00, 0xFF} {
+		t.Errorf("write mode 2: %X", v)
+	}
+
+	// Only planes in the map mask are written.
+	planartest.SetSeq(p, planar.SeqMapMask, 0x2)
+	p.WriteByte(addr+2, 0x2)
+	if v := planartest.ReadPlanes(p, addr+2); v != [4]byte{0xFF, 0xFF, 0x00, 0xFF} {
+		t.Errorf("map mask: %X", v)
+	}
+
+	// Read mode 1 compares all planes that are not ignored.
+	planartest.SetGC(p, planar.GCMode, 8)
+	planartest.SetGC(p, planar.GCColorCompare, 0x3)
+	if v := p.ReadByte(addr + 2); v != 0x00 {
+		t.Errorf("color compare: %X", v)
+	}
+	planartest.SetGC(p, planar.GCColorDontCare, 0x7)
+	if v := p.ReadByte(addr + 2); v != 0xFF {
+		t.Errorf("color compare with ignored plane: %X", v)
+	}
+}
+
+func TestGraphics(t *testing.T) {
+	d := &Device{Display: platform.NewHeadless(), Seed: 1}
+	p := planartest.NewCPU(t, d)
+	defer p.Close()
+
+	t.Run("640x350", func(t *testing.T) {
+		modes[0x10].Set(p)
+		planartest.Clear(p, 80*350)
+
+		// Pixel 1 with color 6 and pixel 9 on the second line with color 8.
+		planartest.SetGC(p, planar.GCMode, 2)
+		planartest.SetGC(p, planar.GCBitMask, 0x40)
+		p.WriteByte(planar.MemoryBase, 6)
+		planartest.SetGC(p, planar.GCBitMask, 0x40)
+		p.WriteByte(planar.MemoryBase+81, 8)
+
+		pixels, w, h := d.Framebuffer()
+		if w != 640 || h != 350 {
+			t.Fatalf("unexpected surface size: %dx%d", w, h)
+		}
+		if c := planartest.Pixel(pixels, w, 1, 0); c != 0xAA5500 {
+			t.Errorf("invalid color: %06X", c)
+		}
+		if c := planartest.Pixel(pixels, w, 9, 1); c != 0x555555 {
+			t.Errorf("invalid color: %06X", c)
+		}
+		if c := planartest.Pixel(pixels, w, 0, 0); c != 0 {
+			t.Errorf("invalid color: %06X", c)
+		}
+	})
+
+	t.Run("320x200", func(t *testing.T) {
+		modes[0xD].Set(p)
+		planartest.Clear(p, 40*200)
+
+		planartest.SetGC(p, planar.GCMode, 2)
+		planartest.SetGC(p, planar.GCBitMask, 0x80)
+		p.WriteByte(planar.MemoryBase+40, 12)
+
+		// Pixels are twice as wide and the palette has the colors of a color display.
+		pixels, w, h := d.Framebuffer()
+		if w != 640 || h != 200 {
+			t.Fatalf("unexpected surface size: %dx%d", w, h)
+		}
+		if c0, c1 := planartest.Pixel(pixels, w, 0, 1), planartest.Pixel(pixels, w, 1, 1); c0 != 0xFF5555 || c1 != c0 {
+			t.Errorf("invalid colors: %06X %06X", c0, c1)
+		}
+		if c := planartest.Pixel(pixels, w, 2, 1); c != 0 {
+			t.Errorf("invalid color: %06X", c)
+		}
+	})
+
+	t.Run("CGA", func(t *testing.T) {
+		modes[0x4].Set(p)
+
+		// Even and odd bytes go to different planes and odd lines start at 2000h.
+		p.WriteByte(0xB8000, 0x1B)
+		p.WriteByte(0xB8001, 0xC0)
+		p.WriteByte(0xBA000, 0x80)
+
+		pixels, w, h := d.Framebuffer()
+		if w != 640 || h != 200 {
+			t.Fatalf("unexpected surface size: %dx%d", w, h)
+		}
+		for i, c := range []uint32{0x000000, 0x55FFFF, 0xFF55FF, 0xFFFFFF, 0xFFFFFF} {
+			if v := planartest.Pixel(pixels, w, i*2, 0); v != c {
+				t.Errorf("invalid color of pixel %d: %06X", i, v)
+			}
+		}
+		if c := planartest.Pixel(pixels, w, 0, 1); c != 0xFF55FF {
+			t.Errorf("invalid color on odd line: %06X", c)
+		}
+	})
+}
+
+func TestText(t *testing.T) {
+	d := &Device{Display: platform.NewHeadless(), Seed: 1}
+	p := planartest.NewCPU(t, d)
+	defer p.Close()
+
+	// Load a solid glyph for 'A' in plane 2, the way the BIOS does.
+	modes[0x3].Set(p)
+	planartest.SetSeq(p, planar.SeqMapMask, 4)
+	planartest.SetSeq(p, planar.SeqMemoryMode, 6)
+	planartest.SetGC(p, planar.GCMode, 0)
+	planartest.SetGC(p, planar.GCMisc, 0x4)
+	for i := 0; i < 32; i++ {
+		p.WriteByte(memory.Pointer(planar.MemoryBase+'A'*32+i), 0xFF)
+	}
+	modes[0x3].Set(p)
+
+	for i := 0; i < 80*25; i++ {
+		p.WriteByte(memory.Pointer(0xB8000+i*2), ' ')
+		p.WriteByte(memory.Pointer(0xB8000+i*2+1), 0x07)
+	}
+	p.WriteByte(0xB8000+2, 'A')
+	p.WriteByte(0xB8000+3, 0x1E)
+
+	txt := d.Text()
+	if txt == nil || txt.Width != 80 || txt.Height != 25 {
+		t.Fatalf("unexpected text screen: %v", txt)
+	}
+	if txt.Row(0) != " A" {
+		t.Errorf("unexpected row: %q", txt.Row(0))
+	}
+
+	pixels, w, h := d.Framebuffer()
+	if w != 640 || h != 350 {
+		t.Fatalf("unexpected surface size: %dx%d", w, h)
+	}
+	if c := planartest.Pixel(pixels, w, 8, 13); c != 0xFFFF55 {
+		t.Errorf("invalid foreground color: %06X", c)
+	}
+	if c := planartest.Pixel(pixels, w, 8, 14); c != 0 {
+		t.Errorf("invalid color below the glyph: %06X", c)
+	}
+
+	// Move the cursor to the second row.
+	p.OutByte(0x3D4, 0xF)
+	p.OutByte(0x3D5, 81)
+	if txt := d.Text(); txt.CursorX != 1 || txt.CursorY != 1 {
+		t.Errorf("unexpected cursor position: %d,%d", txt.CursorX, txt.CursorY)
+	}
+}
+
+func TestMemoryMap(t *testing.T) {
+	p := planartest.NewCPU(t, &Device{Display: platform.NewHeadless(), Seed: 1})
+	defer p.Close()
+
+	// RAM is visible at 0A0000h until the adapter maps it.
+	p.WriteByte(planar.MemoryBase, 0x12)
+	modes[0x10].Set(p)
+	p.WriteByte(planar.MemoryBase, 0x34)
+	if v := p.ReadByte(0xB8000); v != 0 {
+		t.Errorf("RAM at 0B8000h is not mapped back: %X", v)
+	}
+
+	modes[0x3].Set(p)
+	if v := p.ReadByte(planar.MemoryBase); v != 0x12 {
+		t.Errorf("RAM at 0A0000h is not mapped back: %X", v)
+	}
+}
+
+func TestSwitches(t *testing.T) {
+	p := planartest.NewCPU(t, &Device{Display: platform.NewHeadless(), Seed: 1})
+	defer p.Close()
+
+	// The clock select bits choose the switch, from SW4 to SW1.
+	var sw byte
+	for i := byte(0); i < 4; i++ {
+		p.OutByte(0x3C2, i<<2)
+		sw = sw<<1 | (p.InByte(0x3C2)>>4)&1
+	}
+	if sw != DefaultSwitches {
+		t.Errorf("unexpected switches: %X", sw)
+	}
+}
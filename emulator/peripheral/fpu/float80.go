@@ -0,0 +1,239 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package fpu
+
+import (
+	"math"
+	"math/big"
+)
+
+const (
+	expBias = 16383
+	expMax  = 0x7FFF
+	intBit  = 1 << 63
+)
+
+// float80 is the 8087 temporary real format with an explicit integer bit.
+type float80 struct {
+	sign bool
+	exp  uint16
+	mant uint64
+}
+
+var (
+	indefinite = float80{sign: true, exp: expMax, mant: 0xC000000000000000}
+
+	constOne  = float80{exp: 0x3FFF, mant: intBit}
+	constL2T  = float80{exp: 0x4000, mant: 0xD49A784BCD1B8AFE}
+	constL2E  = float80{exp: 0x3FFF, mant: 0xB8AA3B295C17F0BC}
+	constPI   = float80{exp: 0x4000, mant: 0xC90FDAA22168C235}
+	constLG2  = float80{exp: 0x3FFD, mant: 0x9A209A84FBCFF799}
+	constLN2  = float80{exp: 0x3FFE, mant: 0xB17217F7D1CF79AC}
+	constZero = float80{}
+)
+
+func (f float80) isNaN() bool {
+	return f.exp == expMax && f.mant&^intBit != 0
+}
+
+func (f float80) isSNaN() bool {
+	return f.isNaN() && f.mant&(1<<62) == 0
+}
+
+func (f float80) isInf() bool {
+	return f.exp == expMax && f.mant&^intBit == 0
+}
+
+func (f float80) isZero() bool {
+	return f.exp == 0 && f.mant == 0
+}
+
+func (f float80) isDenormal() bool {
+	return f.exp == 0 && f.mant != 0
+}
+
+func (f float80) quiet() float80 {
+	f.mant |= 1 << 62
+	return f
+}
+
+func (f float80) neg() float80 {
+	f.sign = !f.sign
+	return f
+}
+
+func (f float80) abs() float80 {
+	f.sign = false
+	return f
+}
+
+func (f float80) big() *big.Float {
+	b := new(big.Float).SetPrec(64)
+	if f.isInf() {
+		return b.SetInf(f.sign)
+	}
+
+	exp := int(f.exp) - expBias - 63
+	if f.exp == 0 {
+		exp = 1 - expBias - 63
+	}
+	b.SetMantExp(b.SetUint64(f.mant), exp)
+	if f.sign {
+		b.Neg(b)
+	}
+	return b
+}
+
+func (f float80) float64() float64 {
+	switch {
+	case f.isNaN():
+		return math.NaN()
+	case f.isInf():
+		return math.Inf(sign(f.sign))
+	}
+	v, _ := f.big().Float64()
+	return v
+}
+
+func (f float80) bytes() (b [10]byte) {
+	for i := 0; i < 8; i++ {
+		b[i] = byte(f.mant >> (i * 8))
+	}
+	exp := f.exp
+	if f.sign {
+		exp |= 0x8000
+	}
+	b[8], b[9] = byte(exp), byte(exp>>8)
+	return
+}
+
+func float80FromBytes(b [10]byte) (f float80) {
+	for i := 0; i < 8; i++ {
+		f.mant |= uint64(b[i]) << (i * 8)
+	}
+	exp := uint16(b[8]) | uint16(b[9])<<8
+	f.sign = exp&0x8000 != 0
+	f.exp = exp & expMax
+	return
+}
+
+func float80FromInt(i *big.Int) float80 {
+	b := new(big.Float).SetPrec(64).SetInt(i)
+	f, _ := roundFloat80(b, big.ToNearestEven)
+	return f
+}
+
+// float80FromFloat64 converts any value that is already representable
+// in the temporary real format without rounding.
+func float80FromFloat64(v float64) float80 {
+	if math.IsNaN(v) {
+		bits := math.Float64bits(v)
+		return float80{sign: bits>>63 != 0, exp: expMax, mant: intBit | (bits&(1<<52-1))<<11}
+	}
+	f, _ := roundFloat80(big.NewFloat(v), big.ToNearestEven)
+	return f
+}
+
+func float80FromFloat32(v float32) float80 {
+	if v != v {
+		bits := math.Float32bits(v)
+		return float80{sign: bits>>31 != 0, exp: expMax, mant: intBit | uint64(bits&(1<<23-1))<<40}
+	}
+	return float80FromFloat64(float64(v))
+}
+
+// roundFloat80 fits an already rounded mantissa into the exponent range of the
+// temporary real format. It returns the overflow and underflow exceptions.
+func roundFloat80(b *big.Float, mode big.RoundingMode) (float80, byte) {
+	f := float80{sign: b.Signbit()}
+	if b.IsInf() {
+		f.exp, f.mant = expMax, intBit
+		return f, 0
+	}
+	if b.Sign() == 0 {
+		return f, 0
+	}
+
+	var m big.Float
+	exp := b.MantExp(&m) - 1 + expBias
+	m.Abs(&m)
+
+	if exp >= expMax {
+		switch {
+		case mode == big.ToZero, mode == big.ToNegativeInf && !f.sign, mode == big.ToPositiveInf && f.sign:
+			f.exp, f.mant = expMax-1, math.MaxUint64
+		default:
+			f.exp, f.mant = expMax, intBit
+		}
+		return f, exOverflow | exPrecision
+	}
+
+	if exp <= 0 {
+		m.SetMantExp(&m, 63+exp)
+		i, exact := roundInt(&m, mode)
+		f.mant = i.Uint64()
+		if f.mant&intBit != 0 {
+			f.exp = 1
+		}
+		if !exact {
+			return f, exUnderflow | exPrecision
+		}
+		return f, 0
+	}
+
+	m.SetMantExp(&m, 64)
+	f.exp = uint16(exp)
+	f.mant, _ = m.Uint64()
+	return f, 0
+}
+
+// roundInt rounds x to an integer using the specified rounding mode.
+func roundInt(x *big.Float, mode big.RoundingMode) (*big.Int, bool) {
+	i, acc := x.Int(nil)
+	if acc == big.Exact {
+		return i, true
+	}
+
+	one := big.NewInt(int64(x.Sign()))
+	switch mode {
+	case big.ToNegativeInf:
+		if x.Sign() < 0 {
+			i.Add(i, one)
+		}
+	case big.ToPositiveInf:
+		if x.Sign() > 0 {
+			i.Add(i, one)
+		}
+	case big.ToNearestEven:
+		frac := new(big.Float).SetPrec(x.Prec()).Sub(x, new(big.Float).SetInt(i))
+		if c := frac.Abs(frac).Cmp(big.NewFloat(0.5)); c > 0 || (c == 0 && i.Bit(0) == 1) {
+			i.Add(i, one)
+		}
+	}
+	return i, false
+}
+
+func sign(b bool) int {
+	if b {
+		return -1
+	}
+	return 1
+}
@@ -0,0 +1,608 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package fpu
+
+import (
+	"io"
+	"math/big"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor"
+)
+
+const (
+	exInvalid = 1 << iota
+	exDenormal
+	exZeroDivide
+	exOverflow
+	exUnderflow
+	exPrecision
+)
+
+const (
+	tagValid = iota
+	tagZero
+	tagSpecial
+	tagEmpty
+)
+
+const (
+	flagIR = 1 << 7
+	flagC0 = 1 << 8
+	flagC1 = 1 << 9
+	flagC2 = 1 << 10
+	flagC3 = 1 << 14
+
+	flagIEM = 1 << 7
+)
+
+type Device struct {
+	regs [8]float80
+	tags [8]byte
+	top  int
+
+	control, status,
+	opcode uint16
+	ip, dp memory.Pointer
+	intr   bool
+
+	p processor.Processor
+}
+
+func (m *Device) Install(p processor.Processor) error {
+	m.p = p
+	return nil
+}
+
+func (m *Device) Name() string {
+	return "Math Coprocessor (Intel 8087)"
+}
+
+func (m *Device) Reset() {
+	m.finit()
+}
+
+func (m *Device) EventDriven() bool {
+	return true
+}
+
+func (m *Device) Step(int) error {
+	return nil
+}
+
+func (m *Device) SaveState(w io.Writer) error {
+	for i := range m.regs {
+		r := &m.regs[i]
+		if err := peripheral.WriteState(w, r.sign, r.exp, r.mant); err != nil {
+			return err
+		}
+	}
+	return peripheral.WriteState(w, &m.tags, int32(m.top), m.control, m.status, m.opcode, m.ip, m.dp, m.intr)
+}
+
+func (m *Device) LoadState(r io.Reader) error {
+	for i := range m.regs {
+		reg := &m.regs[i]
+		if err := peripheral.ReadState(r, &reg.sign, &reg.exp, &reg.mant); err != nil {
+			return err
+		}
+	}
+
+	var top int32
+	if err := peripheral.ReadState(r, &m.tags, &top, &m.control, &m.status, &m.opcode, &m.ip, &m.dp, &m.intr); err != nil {
+		return err
+	}
+	m.top = int(top)
+	return nil
+}
+
+func (m *Device) finit() {
+	m.control = 0x3FF
+	m.status = 0
+	m.top = 0
+	m.ip, m.dp, m.opcode = 0, 0, 0
+	m.intr = false
+	for i := range m.tags {
+		m.tags[i] = tagEmpty
+	}
+}
+
+func (m *Device) rounding() big.RoundingMode {
+	return [4]big.RoundingMode{big.ToNearestEven, big.ToNegativeInf, big.ToPositiveInf, big.ToZero}[(m.control>>10)&3]
+}
+
+func (m *Device) precision() uint {
+	return [4]uint{24, 64, 53, 64}[(m.control>>8)&3]
+}
+
+func (m *Device) statusWord() uint16 {
+	return m.status&^(7<<11) | uint16(m.top)<<11
+}
+
+func (m *Device) tagWord() (w uint16) {
+	for i, t := range m.tags {
+		w |= uint16(t) << (i * 2)
+	}
+	return
+}
+
+func (m *Device) setTagWord(w uint16) {
+	for i := range m.tags {
+		m.tags[i] = byte(w>>(i*2)) & 3
+	}
+}
+
+// update sets the interrupt request flag and signals the CPU through NMI when an unmasked exception is pending.
+func (m *Device) update() {
+	if byte(m.status)&^byte(m.control)&0x3F != 0 {
+		m.status |= flagIR
+	} else {
+		m.status &^= flagIR
+	}
+
+	intr := m.status&flagIR != 0 && m.control&flagIEM == 0
+	if intr && !m.intr {
+		m.p.NMI()
+	}
+	m.intr = intr
+}
+
+// raise records the exceptions and returns true if the result should be stored.
+func (m *Device) raise(ex byte) bool {
+	if ex == 0 {
+		return true
+	}
+	m.status |= uint16(ex)
+	m.update()
+	return ex&^byte(m.control)&^exPrecision&0x3F == 0
+}
+
+func (m *Device) setConditions(c3, c2, c1, c0 bool) {
+	m.status &^= flagC3 | flagC2 | flagC1 | flagC0
+	for _, f := range []struct {
+		b    bool
+		flag uint16
+	}{{c3, flagC3}, {c2, flagC2}, {c1, flagC1}, {c0, flagC0}} {
+		if f.b {
+			m.status |= f.flag
+		}
+	}
+}
+
+func (m *Device) phys(i int) int {
+	return (m.top + i) & 7
+}
+
+func (m *Device) get(i int) (float80, byte) {
+	n := m.phys(i)
+	if m.tags[n] == tagEmpty {
+		return indefinite, exInvalid
+	}
+	return m.regs[n], 0
+}
+
+func (m *Device) set(i int, v float80) {
+	n := m.phys(i)
+	m.regs[n] = v
+
+	switch {
+	case v.isZero():
+		m.tags[n] = tagZero
+	case v.exp == expMax || v.exp == 0 || v.mant&intBit == 0:
+		m.tags[n] = tagSpecial
+	default:
+		m.tags[n] = tagValid
+	}
+}
+
+func (m *Device) push(v float80, ex byte) {
+	top := (m.top - 1) & 7
+	if m.tags[top] != tagEmpty {
+		v, ex = indefinite, ex|exInvalid
+	}
+	if m.raise(ex) {
+		m.top = top
+		m.set(0, v)
+	}
+}
+
+func (m *Device) pop() {
+	m.tags[m.top] = tagEmpty
+	m.top = (m.top + 1) & 7
+}
+
+// store writes a result to ST(i) if the exceptions are masked.
+func (m *Device) store(i int, v float80, ex byte, pop bool) {
+	if m.raise(ex) {
+		m.set(i, v)
+		if pop {
+			m.pop()
+		}
+	}
+}
+
+func (m *Device) Escape(op, modRegRM byte, ip memory.Pointer, addr memory.Address) {
+	reg, rm := (modRegRM>>3)&7, int(modRegRM&7)
+	isMem := modRegRM < 0xC0
+
+	if !isControl(op, modRegRM) {
+		m.ip = ip
+		m.opcode = uint16(op&7)<<8 | uint16(modRegRM)
+		if isMem {
+			m.dp = addr.Pointer()
+		}
+	}
+
+	if isMem {
+		m.escapeMemory(op&7, reg, addr)
+	} else {
+		m.escapeRegister(op&7, reg, rm)
+	}
+}
+
+func isControl(op, modRegRM byte) bool {
+	reg := (modRegRM >> 3) & 7
+	switch op & 7 {
+	case 1:
+		return modRegRM < 0xC0 && reg >= 4
+	case 3:
+		return modRegRM >= 0xE0 && modRegRM <= 0xE4
+	case 5:
+		return modRegRM < 0xC0 && (reg == 4 || reg == 6 || reg == 7)
+	}
+	return false
+}
+
+func (m *Device) escapeMemory(op, reg byte, addr memory.Address) {
+	switch op {
+	case 0: // m32real
+		v, ex := m.readReal32(addr)
+		m.arith(reg, 0, v, ex, false)
+	case 1:
+		switch reg {
+		case 0: // FLD m32real
+			m.push(m.readReal32(addr))
+		case 2, 3: // FST/FSTP m32real
+			v, ex := m.get(0)
+			bits, e := m.toReal32(v)
+			if m.raise(ex | e) {
+				m.writeInt(addr, uint64(bits), 4)
+				if reg == 3 {
+					m.pop()
+				}
+			}
+		case 4: // FLDENV
+			m.loadEnv(addr)
+		case 5: // FLDCW
+			m.control = uint16(m.readInt(addr, 2))
+			m.update()
+		case 6: // FSTENV
+			m.storeEnv(addr)
+			m.control |= 0x3F
+			m.update()
+		case 7: // FSTCW
+			m.writeInt(addr, uint64(m.control), 2)
+		}
+	case 2: // m32int
+		m.arith(reg, 0, float80FromInt(big.NewInt(int64(int32(m.readInt(addr, 4))))), 0, false)
+	case 3:
+		switch reg {
+		case 0: // FILD m32int
+			m.push(float80FromInt(big.NewInt(int64(int32(m.readInt(addr, 4))))), 0)
+		case 2, 3: // FIST/FISTP m32int
+			m.storeInt(addr, 4, reg == 3)
+		case 5: // FLD m80real
+			var b [10]byte
+			for i := range b {
+				b[i] = m.p.ReadByte(addr.AddInt(i).Pointer())
+			}
+			m.push(float80FromBytes(b), 0)
+		case 7: // FSTP m80real
+			v, ex := m.get(0)
+			if m.raise(ex) {
+				for i, b := range v.bytes() {
+					m.p.WriteByte(addr.AddInt(i).Pointer(), b)
+				}
+				m.pop()
+			}
+		}
+	case 4: // m64real
+		v, ex := m.readReal64(addr)
+		m.arith(reg, 0, v, ex, false)
+	case 5:
+		switch reg {
+		case 0: // FLD m64real
+			m.push(m.readReal64(addr))
+		case 2, 3: // FST/FSTP m64real
+			v, ex := m.get(0)
+			bits, e := m.toReal64(v)
+			if m.raise(ex | e) {
+				m.writeInt(addr, bits, 8)
+				if reg == 3 {
+					m.pop()
+				}
+			}
+		case 4: // FRSTOR
+			m.loadEnv(addr)
+			for i := 0; i < 8; i++ {
+				var b [10]byte
+				for j := range b {
+					b[j] = m.p.ReadByte(addr.AddInt(14 + i*10 + j).Pointer())
+				}
+				m.regs[m.phys(i)] = float80FromBytes(b)
+			}
+		case 6: // FSAVE
+			m.storeEnv(addr)
+			for i := 0; i < 8; i++ {
+				for j, b := range m.regs[m.phys(i)].bytes() {
+					m.p.WriteByte(addr.AddInt(14+i*10+j).Pointer(), b)
+				}
+			}
+			m.finit()
+		case 7: // FSTSW
+			m.writeInt(addr, uint64(m.statusWord()), 2)
+		}
+	case 6: // m16int
+		m.arith(reg, 0, float80FromInt(big.NewInt(int64(int16(m.readInt(addr, 2))))), 0, false)
+	case 7:
+		switch reg {
+		case 0: // FILD m16int
+			m.push(float80FromInt(big.NewInt(int64(int16(m.readInt(addr, 2))))), 0)
+		case 2, 3: // FIST/FISTP m16int
+			m.storeInt(addr, 2, reg == 3)
+		case 4: // FBLD
+			m.loadBCD(addr)
+		case 5: // FILD m64int
+			m.push(float80FromInt(big.NewInt(int64(m.readInt(addr, 8)))), 0)
+		case 6: // FBSTP
+			m.storeBCD(addr)
+		case 7: // FISTP m64int
+			m.storeInt(addr, 8, true)
+		}
+	}
+}
+
+func (m *Device) escapeRegister(op, reg byte, rm int) {
+	switch op {
+	case 0: // ST,ST(i)
+		v, ex := m.get(rm)
+		m.arith(reg, 0, v, ex, false)
+	case 1:
+		switch reg {
+		case 0: // FLD ST(i)
+			m.push(m.get(rm))
+		case 1: // FXCH ST(i)
+			a, ex1 := m.get(0)
+			b, ex2 := m.get(rm)
+			if m.raise(ex1 | ex2) {
+				m.set(0, b)
+				m.set(rm, a)
+			}
+		case 2: // FNOP
+		case 3: // *FSTP ST(i)
+			v, ex := m.get(0)
+			m.store(rm, v, ex, true)
+		case 4:
+			m.escapeSign(rm)
+		case 5:
+			if rm < 7 {
+				m.push([...]float80{constOne, constL2T, constL2E, constPI, constLG2, constLN2, constZero}[rm], 0)
+			}
+		case 6, 7:
+			m.escapeTranscendental(reg, rm)
+		}
+	case 3:
+		if reg == 4 {
+			switch rm {
+			case 0: // FENI
+				m.control &^= flagIEM
+				m.update()
+			case 1: // FDISI
+				m.control |= flagIEM
+				m.update()
+			case 2: // FCLEX
+				m.status &= ^uint16(0x3F | flagIR | 0x8000)
+				m.update()
+			case 3: // FINIT
+				m.finit()
+			}
+		}
+	case 4: // ST(i),ST
+		v, ex := m.get(rm)
+		m.arith(reg, rm, v, ex, false)
+	case 5:
+		switch reg {
+		case 0: // FFREE ST(i)
+			m.tags[m.phys(rm)] = tagEmpty
+		case 1: // *FXCH ST(i)
+			m.escapeRegister(1, 1, rm)
+		case 2, 3: // FST/FSTP ST(i)
+			v, ex := m.get(0)
+			m.store(rm, v, ex, reg == 3)
+		}
+	case 6: // ST(i),ST with pop
+		switch {
+		case reg == 3 && rm == 1: // FCOMPP
+			a, ex1 := m.get(0)
+			b, ex2 := m.get(1)
+			if m.raise(ex1 | ex2 | m.compare(a, b)) {
+				m.pop()
+				m.pop()
+			}
+		case reg != 3:
+			v, ex := m.get(rm)
+			m.arith(reg, rm, v, ex, true)
+		}
+	case 7:
+		switch reg {
+		case 0: // *FFREEP ST(i)
+			m.tags[m.phys(rm)] = tagEmpty
+			m.pop()
+		case 1: // *FXCH ST(i)
+			m.escapeRegister(1, 1, rm)
+		case 2, 3: // *FSTP ST(i)
+			v, ex := m.get(0)
+			m.store(rm, v, ex, true)
+		}
+	}
+}
+
+// arith performs the basic arithmetic group on ST and the source operand and stores the result in ST(dest).
+// Compare instructions only update the condition codes.
+func (m *Device) arith(reg byte, dest int, src float80, ex byte, pop bool) {
+	st, e := m.get(0)
+	ex |= e
+
+	switch reg {
+	case 2, 3: // FCOM/FCOMP
+		if ex |= m.compare(st, src); m.raise(ex) && (reg == 3 || pop) {
+			m.pop()
+		}
+		return
+	}
+
+	// The reversed forms are encoded so that the operation is always relative to ST.
+	v, e := m.compute(reg, st, src)
+	m.store(dest, v, ex|e, pop)
+}
+
+func (m *Device) compare(a, b float80) byte {
+	if a.isNaN() || b.isNaN() {
+		m.setConditions(true, true, false, true)
+		return exInvalid
+	}
+
+	var ex byte
+	if a.isDenormal() || b.isDenormal() {
+		ex = exDenormal
+	}
+
+	switch a.big().Cmp(b.big()) {
+	case -1:
+		m.setConditions(false, false, false, true)
+	case 0:
+		m.setConditions(true, false, false, false)
+	case 1:
+		m.setConditions(false, false, false, false)
+	}
+	return ex
+}
+
+func nanResult(a, b float80) (float80, byte) {
+	var ex byte
+	if a.isSNaN() || b.isSNaN() {
+		ex = exInvalid
+	}
+	if !a.isNaN() || (b.isNaN() && b.mant > a.mant) {
+		a = b
+	}
+	return a.quiet(), ex
+}
+
+func (m *Device) newBig() *big.Float {
+	return new(big.Float).SetPrec(m.precision()).SetMode(m.rounding())
+}
+
+func (m *Device) round(z *big.Float) (float80, byte) {
+	v, ex := roundFloat80(z, m.rounding())
+	if z.Acc() != big.Exact {
+		ex |= exPrecision
+	}
+	return v, ex
+}
+
+// compute implements the ADD, MUL, SUB, SUBR, DIV and DIVR operations of the arithmetic group.
+func (m *Device) compute(op byte, a, b float80) (float80, byte) {
+	if op == 5 || op == 7 {
+		a, b = b, a
+		op--
+	}
+	if a.isNaN() || b.isNaN() {
+		return nanResult(a, b)
+	}
+
+	var ex byte
+	if a.isDenormal() || b.isDenormal() {
+		ex = exDenormal
+	}
+
+	x, y, z := a.big(), b.big(), m.newBig()
+	switch op {
+	case 0:
+		if a.isInf() && b.isInf() && a.sign != b.sign {
+			return indefinite, exInvalid
+		}
+		z.Add(x, y)
+	case 1:
+		if (a.isInf() && b.isZero()) || (a.isZero() && b.isInf()) {
+			return indefinite, exInvalid
+		}
+		z.Mul(x, y)
+	case 4:
+		if a.isInf() && b.isInf() && a.sign == b.sign {
+			return indefinite, exInvalid
+		}
+		z.Sub(x, y)
+	case 6:
+		switch {
+		case (a.isZero() && b.isZero()) || (a.isInf() && b.isInf()):
+			return indefinite, exInvalid
+		case b.isZero():
+			return float80{sign: a.sign != b.sign, exp: expMax, mant: intBit}, ex | exZeroDivide
+		}
+		z.Quo(x, y)
+	}
+
+	v, e := m.round(z)
+	return v, ex | e
+}
+
+func (m *Device) escapeSign(rm int) {
+	v, ex := m.get(0)
+	switch rm {
+	case 0: // FCHS
+		m.store(0, v.neg(), ex, false)
+	case 1: // FABS
+		m.store(0, v.abs(), ex, false)
+	case 4: // FTST
+		m.raise(ex | m.compare(v, constZero))
+	case 5: // FXAM
+		n := m.phys(0)
+		c1 := v.sign
+		switch {
+		case m.tags[n] == tagEmpty:
+			m.setConditions(true, false, c1, true)
+		case v.isNaN():
+			m.setConditions(false, false, c1, true)
+		case v.isInf():
+			m.setConditions(false, true, c1, true)
+		case v.isZero():
+			m.setConditions(true, false, c1, false)
+		case v.isDenormal():
+			m.setConditions(true, true, c1, false)
+		case v.mant&intBit == 0:
+			m.setConditions(false, false, c1, false)
+		default:
+			m.setConditions(false, true, c1, false)
+		}
+	}
+}
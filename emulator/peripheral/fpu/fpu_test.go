@@ -0,0 +1,150 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package fpu
+
+import (
+	"math"
+	"testing"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/pic"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/ram"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor/cpu"
+)
+
+func runCode(t *testing.T, code []byte, data map[uint16]uint16) *cpu.CPU {
+	p, errs := cpu.NewCPU([]peripheral.Peripheral{
+		&ram.Device{Clear: true},
+		&pic.Device{},
+		&Device{},
+	})
+	for _, err := range errs {
+		t.Fatal(err)
+	}
+
+	p.Reset()
+	p.CS, p.IP, p.DS = 0x1000, 0, 0x2000
+	for i, v := range code {
+		p.WriteByte(memory.NewPointer(p.CS, uint16(i)), v)
+	}
+	for addr, v := range data {
+		p.WriteWord(memory.NewPointer(p.DS, addr), v)
+	}
+
+	for p.CS == 0x1000 && p.IP < uint16(len(code)) {
+		if _, err := p.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+func readWord(p *cpu.CPU, addr uint16) uint16 {
+	return p.ReadWord(memory.NewPointer(p.DS, addr))
+}
+
+func TestDetection(t *testing.T) {
+	p := runCode(t, []byte{
+		0xDB, 0xE3, // FNINIT
+		0xD9, 0x3E, 0x00, 0x01, // FNSTCW [100h]
+	}, nil)
+	if cw := readWord(p, 0x100); cw != 0x3FF {
+		t.Errorf("expected control word 0x3FF, got 0x%X", cw)
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	p := runCode(t, []byte{
+		0xD9, 0xE8, // FLD1
+		0xD9, 0xEB, // FLDPI
+		0xDE, 0xC1, // FADDP ST(1),ST
+		0xDD, 0x1E, 0x00, 0x01, // FSTP qword [100h]
+	}, nil)
+
+	var bits uint64
+	for i := uint16(0); i < 4; i++ {
+		bits |= uint64(readWord(p, 0x100+i*2)) << (i * 16)
+	}
+	if v := math.Float64frombits(bits); math.Abs(v-(math.Pi+1)) > 1e-15 {
+		t.Errorf("expected %v, got %v", math.Pi+1, v)
+	}
+}
+
+func TestInteger(t *testing.T) {
+	p := runCode(t, []byte{
+		0xDF, 0x06, 0x00, 0x01, // FILD word [100h]
+		0xD9, 0xFA, // FSQRT
+		0xDF, 0x1E, 0x02, 0x01, // FISTP word [102h]
+		0xDF, 0x06, 0x04, 0x01, // FILD word [104h]
+		0xDF, 0x06, 0x06, 0x01, // FILD word [106h]
+		0xDE, 0xE9, // FSUBP ST(1),ST
+		0xDF, 0x1E, 0x08, 0x01, // FISTP word [108h]
+	}, map[uint16]uint16{0x100: 144, 0x104: 10, 0x106: 4})
+
+	if v := readWord(p, 0x102); v != 12 {
+		t.Errorf("expected sqrt(144) = 12, got %d", v)
+	}
+	if v := readWord(p, 0x108); v != 6 {
+		t.Errorf("expected 10 - 4 = 6, got %d", v)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	p := runCode(t, []byte{
+		0xD9, 0xE8, // FLD1
+		0xD9, 0xEE, // FLDZ
+		0xDE, 0xD9, // FCOMPP
+		0xDD, 0x3E, 0x00, 0x01, // FSTSW [100h]
+	}, nil)
+	if sw := readWord(p, 0x100); sw&0x4700 != 0x100 {
+		t.Errorf("expected C0 to be set, got status word 0x%X", sw)
+	}
+}
+
+func TestBCD(t *testing.T) {
+	p := runCode(t, []byte{
+		0xDF, 0x26, 0x00, 0x01, // FBLD tbyte [100h]
+		0xDF, 0x36, 0x10, 0x01, // FBSTP tbyte [110h]
+	}, map[uint16]uint16{0x100: 0x2345, 0x102: 0x1, 0x108: 0x8000})
+
+	for i := uint16(0); i < 10; i += 2 {
+		if a, b := readWord(p, 0x100+i), readWord(p, 0x110+i); a != b {
+			t.Errorf("expected 0x%X at offset %d, got 0x%X", a, i, b)
+		}
+	}
+}
+
+func TestException(t *testing.T) {
+	p := runCode(t, []byte{
+		0xDB, 0xE3, // FNINIT
+		0xD9, 0x2E, 0x00, 0x01, // FLDCW [100h]
+		0xD9, 0xE8, // FLD1
+		0xD9, 0xEE, // FLDZ
+		0xDE, 0xF9, // FDIVP ST(1),ST
+		0x90, // NOP
+	}, map[uint16]uint16{0x100: 0x37B})
+
+	// Divide by zero is unmasked so we should end up in the NMI handler. (Vector is 0x0:0x0)
+	if p.CS != 0 {
+		t.Errorf("expected NMI, got 0x%X:0x%X", p.CS, p.IP)
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package fpu
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+)
+
+func (m *Device) readInt(addr memory.Address, size int) (v uint64) {
+	for i := 0; i < size; i++ {
+		v |= uint64(m.p.ReadByte(addr.AddInt(i).Pointer())) << (i * 8)
+	}
+	return
+}
+
+func (m *Device) writeInt(addr memory.Address, v uint64, size int) {
+	for i := 0; i < size; i++ {
+		m.p.WriteByte(addr.AddInt(i).Pointer(), byte(v>>(i*8)))
+	}
+}
+
+func (m *Device) readReal32(addr memory.Address) (float80, byte) {
+	bits := uint32(m.readInt(addr, 4))
+	v := float80FromFloat32(math.Float32frombits(bits))
+
+	switch exp, frac := (bits>>23)&0xFF, bits&(1<<23-1); {
+	case exp == 0 && frac != 0:
+		return v, exDenormal
+	case v.isSNaN():
+		return v, exInvalid
+	}
+	return v, 0
+}
+
+func (m *Device) readReal64(addr memory.Address) (float80, byte) {
+	bits := m.readInt(addr, 8)
+	v := float80FromFloat64(math.Float64frombits(bits))
+
+	switch exp, frac := (bits>>52)&0x7FF, bits&(1<<52-1); {
+	case exp == 0 && frac != 0:
+		return v, exDenormal
+	case v.isSNaN():
+		return v, exInvalid
+	}
+	return v, 0
+}
+
+func (m *Device) roundReal(v float80, prec uint) (*big.Float, byte) {
+	var ex byte
+	if v.isDenormal() {
+		ex = exDenormal
+	}
+	z := new(big.Float).SetPrec(prec).SetMode(m.rounding()).Set(v.big())
+	if z.Acc() != big.Exact {
+		ex |= exPrecision
+	}
+	return z, ex
+}
+
+func (m *Device) toReal32(v float80) (uint32, byte) {
+	if v.isNaN() {
+		bits := uint32(0x7F800000) | uint32(v.quiet().mant>>40)&(1<<23-1)
+		if v.sign {
+			bits |= 1 << 31
+		}
+		if v.isSNaN() {
+			return bits, exInvalid
+		}
+		return bits, 0
+	}
+
+	z, ex := m.roundReal(v, 24)
+	f, acc := z.Float32()
+	if acc != big.Exact {
+		ex |= exPrecision
+	}
+	if !v.isInf() && math.IsInf(float64(f), 0) {
+		ex |= exOverflow
+	} else if ex&exPrecision != 0 && math.Abs(float64(f)) < 0x1p-126 {
+		ex |= exUnderflow
+	}
+	return math.Float32bits(f), ex
+}
+
+func (m *Device) toReal64(v float80) (uint64, byte) {
+	if v.isNaN() {
+		bits := uint64(0x7FF0000000000000) | (v.quiet().mant>>11)&(1<<52-1)
+		if v.sign {
+			bits |= 1 << 63
+		}
+		if v.isSNaN() {
+			return bits, exInvalid
+		}
+		return bits, 0
+	}
+
+	z, ex := m.roundReal(v, 53)
+	f, acc := z.Float64()
+	if acc != big.Exact {
+		ex |= exPrecision
+	}
+	if !v.isInf() && math.IsInf(f, 0) {
+		ex |= exOverflow
+	} else if ex&exPrecision != 0 && math.Abs(f) < 0x1p-1022 {
+		ex |= exUnderflow
+	}
+	return math.Float64bits(f), ex
+}
+
+func (m *Device) storeInt(addr memory.Address, size int, pop bool) {
+	v, ex := m.get(0)
+	bits := uint(size * 8)
+	res := int64(-1) << (bits - 1) // Integer indefinite
+
+	if v.isNaN() || v.isInf() {
+		ex |= exInvalid
+	} else {
+		n, exact := roundInt(v.big(), m.rounding())
+		if !exact {
+			ex |= exPrecision
+		}
+		if i := n.Int64(); n.IsInt64() && i >= res && i <= ^res {
+			res = i
+		} else {
+			ex |= exInvalid
+		}
+	}
+
+	if m.raise(ex) {
+		m.writeInt(addr, uint64(res), size)
+		if pop {
+			m.pop()
+		}
+	}
+}
+
+func (m *Device) loadBCD(addr memory.Address) {
+	n := new(big.Int)
+	for i := 8; i >= 0; i-- {
+		b := m.p.ReadByte(addr.AddInt(i).Pointer())
+		n.Mul(n, big.NewInt(100))
+		n.Add(n, big.NewInt(int64(b>>4)*10+int64(b&0xF)))
+	}
+
+	v := float80FromInt(n)
+	if m.p.ReadByte(addr.AddInt(9).Pointer())&0x80 != 0 {
+		v = v.neg()
+	}
+	m.push(v, 0)
+}
+
+func (m *Device) storeBCD(addr memory.Address) {
+	v, ex := m.get(0)
+	var digits [10]byte
+
+	if v.isNaN() || v.isInf() {
+		ex |= exInvalid
+	} else {
+		n, exact := roundInt(v.big(), m.rounding())
+		if !exact {
+			ex |= exPrecision
+		}
+
+		if n.CmpAbs(big.NewInt(999999999999999999)) > 0 {
+			ex |= exInvalid
+		} else {
+			d := new(big.Int).Abs(n).Uint64()
+			for i := 0; i < 9; i++ {
+				digits[i] = byte(d%10) | byte((d/10)%10)<<4
+				d /= 100
+			}
+			if v.sign {
+				digits[9] = 0x80
+			}
+		}
+	}
+
+	if ex&exInvalid != 0 {
+		// Packed decimal indefinite
+		digits = [10]byte{7: 0xC0, 8: 0xFF, 9: 0xFF}
+	}
+
+	if m.raise(ex) {
+		for i, b := range digits {
+			m.p.WriteByte(addr.AddInt(i).Pointer(), b)
+		}
+		m.pop()
+	}
+}
+
+// storeEnv writes the 14 byte real mode environment.
+func (m *Device) storeEnv(addr memory.Address) {
+	m.writeInt(addr, uint64(m.control), 2)
+	m.writeInt(addr.AddInt(2), uint64(m.statusWord()), 2)
+	m.writeInt(addr.AddInt(4), uint64(m.tagWord()), 2)
+	m.writeInt(addr.AddInt(6), uint64(m.ip), 2)
+	m.writeInt(addr.AddInt(8), uint64(m.ip>>16)<<12|uint64(m.opcode&0x7FF), 2)
+	m.writeInt(addr.AddInt(10), uint64(m.dp), 2)
+	m.writeInt(addr.AddInt(12), uint64(m.dp>>16)<<12, 2)
+}
+
+func (m *Device) loadEnv(addr memory.Address) {
+	m.control = uint16(m.readInt(addr, 2))
+	sw := uint16(m.readInt(addr.AddInt(2), 2))
+	m.status, m.top = sw, int(sw>>11)&7
+	m.setTagWord(uint16(m.readInt(addr.AddInt(4), 2)))
+
+	w := m.readInt(addr.AddInt(8), 2)
+	m.ip = memory.Pointer(m.readInt(addr.AddInt(6), 2) | (w>>12)<<16)
+	m.opcode = uint16(w & 0x7FF)
+	m.dp = memory.Pointer(m.readInt(addr.AddInt(10), 2) | (m.readInt(addr.AddInt(12), 2)>>12)<<16)
+	m.update()
+}
@@ -0,0 +1,254 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package fpu
+
+import (
+	"math"
+	"math/big"
+)
+
+// fromFloat64 converts the result of a transcendental function.
+func fromFloat64(r float64) (float80, byte) {
+	if math.IsNaN(r) {
+		return indefinite, exInvalid
+	}
+	return float80FromFloat64(r), exPrecision
+}
+
+func (m *Device) escapeTranscendental(reg byte, rm int) {
+	x, ex := m.get(0)
+	y, ey := m.get(1)
+
+	switch reg<<3 | byte(rm) {
+	case 060: // F2XM1
+		if x.isNaN() {
+			v, e := nanResult(x, x)
+			m.store(0, v, ex|e, false)
+			return
+		}
+		v, e := fromFloat64(math.Expm1(x.float64() * math.Ln2))
+		m.store(0, v, ex|e, false)
+	case 061, 071: // FYL2X, FYL2XP1
+		if x.isNaN() || y.isNaN() {
+			v, e := nanResult(x, y)
+			m.store(1, v, ex|ey|e, true)
+			return
+		}
+
+		var r float64
+		if reg == 6 {
+			if x.isZero() && !y.isZero() {
+				v := float80{sign: !y.sign, exp: expMax, mant: intBit}
+				m.store(1, v, ex|ey|exZeroDivide, true)
+				return
+			}
+			r = y.float64() * math.Log2(x.float64())
+		} else {
+			r = y.float64() * math.Log1p(x.float64()) / math.Ln2
+		}
+		v, e := fromFloat64(r)
+		m.store(1, v, ex|ey|e, true)
+	case 062: // FPTAN
+		if x.isNaN() {
+			v, e := nanResult(x, x)
+			m.store(0, v, ex|e, false)
+			return
+		}
+		v, e := fromFloat64(math.Tan(x.float64()))
+		if m.raise(ex | e) {
+			m.set(0, v)
+			m.push(constOne, 0)
+		}
+	case 063: // FPATAN
+		if x.isNaN() || y.isNaN() {
+			v, e := nanResult(x, y)
+			m.store(1, v, ex|ey|e, true)
+			return
+		}
+		v, e := fromFloat64(math.Atan2(y.float64(), x.float64()))
+		m.store(1, v, ex|ey|e, true)
+	case 064: // FXTRACT
+		m.fxtract(x, ex)
+	case 066: // FDECSTP
+		m.top = (m.top - 1) & 7
+	case 067: // FINCSTP
+		m.top = (m.top + 1) & 7
+	case 070: // FPREM
+		m.fprem(x, y, ex|ey)
+	case 072: // FSQRT
+		m.fsqrt(x, ex)
+	case 074: // FRNDINT
+		if x.isNaN() || x.isInf() || x.isZero() {
+			v, e := x, byte(0)
+			if x.isNaN() {
+				v, e = nanResult(x, x)
+			}
+			m.store(0, v, ex|e, false)
+			return
+		}
+		n, exact := roundInt(x.big(), m.rounding())
+		v := float80FromInt(n)
+		v.sign = x.sign
+		if !exact {
+			ex |= exPrecision
+		}
+		m.store(0, v, ex, false)
+	case 075: // FSCALE
+		m.fscale(x, y, ex|ey)
+	}
+}
+
+func (m *Device) fxtract(x float80, ex byte) {
+	switch {
+	case x.isNaN():
+		v, e := nanResult(x, x)
+		m.store(0, v, ex|e, false)
+		return
+	case x.isZero():
+		if m.raise(ex | exZeroDivide) {
+			m.set(0, float80{sign: true, exp: expMax, mant: intBit})
+			m.push(x, 0)
+		}
+		return
+	case x.isInf():
+		if m.raise(ex) {
+			m.set(0, x.abs())
+			m.push(x, 0)
+		}
+		return
+	}
+
+	var mant big.Float
+	exp := x.big().MantExp(&mant)
+	sig, _ := roundFloat80(mant.SetMantExp(&mant, 1), big.ToNearestEven)
+	if m.raise(ex) {
+		m.set(0, float80FromInt(big.NewInt(int64(exp-1))))
+		m.push(sig, 0)
+	}
+}
+
+func (m *Device) fscale(x, y float80, ex byte) {
+	switch {
+	case x.isNaN() || y.isNaN():
+		v, e := nanResult(x, y)
+		m.store(0, v, ex|e, false)
+		return
+	case y.isInf():
+		if (x.isZero() && !y.sign) || (x.isInf() && y.sign) {
+			m.store(0, indefinite, ex|exInvalid, false)
+		} else if y.sign {
+			m.store(0, float80{sign: x.sign}, ex, false)
+		} else {
+			m.store(0, float80{sign: x.sign, exp: expMax, mant: intBit}, ex, false)
+		}
+		return
+	case x.isZero() || x.isInf():
+		m.store(0, x, ex, false)
+		return
+	}
+
+	n, _ := y.big().Int64()
+	if n > 0x10000 {
+		n = 0x10000
+	} else if n < -0x10000 {
+		n = -0x10000
+	}
+
+	z := m.newBig()
+	z.SetMantExp(x.big(), int(n))
+	v, e := m.round(z)
+	m.store(0, v, ex|e, false)
+}
+
+func (m *Device) fsqrt(x float80, ex byte) {
+	switch {
+	case x.isNaN():
+		v, e := nanResult(x, x)
+		m.store(0, v, ex|e, false)
+		return
+	case x.isZero() || (x.isInf() && !x.sign):
+		m.store(0, x, ex, false)
+		return
+	case x.sign:
+		m.store(0, indefinite, ex|exInvalid, false)
+		return
+	}
+
+	b := x.big()
+	z := m.newBig().Sqrt(b)
+	v, e := roundFloat80(z, m.rounding())
+	if sq := new(big.Float).SetPrec(256).Mul(z, z); sq.Cmp(b) != 0 {
+		e |= exPrecision
+	}
+	m.store(0, v, ex|e, false)
+}
+
+// fprem computes the partial remainder. The exponent is reduced by at most 63
+// per iteration and C2 is set if the reduction is incomplete.
+func (m *Device) fprem(x, y float80, ex byte) {
+	switch {
+	case x.isNaN() || y.isNaN():
+		v, e := nanResult(x, y)
+		m.store(0, v, ex|e, false)
+		return
+	case x.isInf() || y.isZero():
+		m.store(0, indefinite, ex|exInvalid, false)
+		return
+	case x.isZero() || y.isInf():
+		m.setConditions(false, false, false, false)
+		m.store(0, x, ex, false)
+		return
+	}
+
+	var mx, my big.Float
+	ex1, ey1 := x.big().MantExp(&mx)-64, y.big().MantExp(&my)-64
+	ix, _ := mx.SetMantExp(mx.Abs(&mx), 64).Int(nil)
+	iy, _ := my.SetMantExp(my.Abs(&my), 64).Int(nil)
+
+	d := ex1 - ey1
+	if d < 0 {
+		m.setConditions(false, false, false, false)
+		m.store(0, x, ex, false)
+		return
+	}
+
+	shift, partial := d, false
+	if d > 63 {
+		shift, partial = 63, true
+	}
+
+	q, r := new(big.Int).QuoRem(ix.Lsh(ix, uint(shift)), iy, new(big.Int))
+	res := new(big.Float).SetPrec(64).SetInt(r)
+	res.SetMantExp(res, ey1+d-shift)
+	if x.sign {
+		res.Neg(res)
+	}
+
+	v, _ := roundFloat80(res, big.ToNearestEven)
+	v.sign = x.sign
+
+	if partial {
+		m.status |= flagC2
+	} else {
+		m.setConditions(q.Bit(1) != 0, false, q.Bit(0) != 0, q.Bit(2) != 0)
+	}
+	m.store(0, v, ex, false)
+}
@@ -44,7 +44,8 @@ type joystick struct {
 type Device struct {
 	lock      sync.RWMutex
 	sticks    [2]joystick
-	timeStamp time.Time
+	scheduler *processor.Scheduler
+	timeStamp time.Duration
 	quitChan  chan struct{}
 }
 
@@ -76,6 +77,7 @@ func (m *Device) Install(p processor.Processor) error {
 		return err
 	}
 
+	m.scheduler = p.GetScheduler()
 	m.startUpdateLoop()
 	return p.InstallIODeviceAt(m, 0x201)
 }
@@ -87,6 +89,10 @@ func (m *Device) Name() string {
 func (m *Device) Reset() {
 }
 
+func (m *Device) EventDriven() bool {
+	return true
+}
+
 func (m *Device) Step(int) error {
 	return nil
 }
@@ -142,7 +148,7 @@ func (m *Device) startUpdateLoop() {
 
 func (m *Device) In(port uint16) byte {
 	var data byte = 0xF0
-	d := float64(time.Since(m.timeStamp) / time.Microsecond)
+	d := float64((m.scheduler.Time() - m.timeStamp) / time.Microsecond)
 
 	m.lock.RLock()
 	for i := range m.sticks {
@@ -179,7 +185,7 @@ func axisTimeout(axis int16) float64 {
 }
 
 func (m *Device) Out(port uint16, data byte) {
-	m.timeStamp = time.Now()
+	m.timeStamp = m.scheduler.Time()
 	m.lock.RLock()
 	for i := range m.sticks {
 		if stick := &m.sticks[i]; stick.attached {
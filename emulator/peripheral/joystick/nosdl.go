@@ -27,3 +27,7 @@ import "github.com/andreas-jonsson/virtualxt/emulator/peripheral"
 type Device struct {
 	peripheral.NullDevice
 }
+
+func (m *Device) EventDriven() bool {
+	return true
+}
@@ -21,33 +21,51 @@ freely, subject to the following restrictions:
 package keyboard
 
 import (
+	"io"
 	"log"
-	"time"
 
+	"github.com/andreas-jonsson/virtualxt/emulator/input"
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
 	"github.com/andreas-jonsson/virtualxt/emulator/processor"
 	"github.com/andreas-jonsson/virtualxt/platform"
 )
 
-const MaxEvents = 64
+const (
+	MaxEvents      = 64
+	deliveryCycles = processor.ClockFrequency / 100 // Deliver one scancode every 10ms.
+
+	// BIOS data area pointers to the head and tail of the keyboard buffer.
+	bufferHead = memory.Pointer(0x41A)
+	bufferTail = memory.Pointer(0x41C)
+)
 
 type Device struct {
+	// Platform delivers keyboard input from the host.
+	Platform platform.Platform
+
+	// Journal records or replays the scancodes delivered to the machine.
+	Journal *input.Journal
+
 	dataPort, commandPort byte
 
-	state  platform.Scancode
-	events chan platform.Scancode
-	ticker *time.Ticker
-	pic    processor.InterruptController
+	state   platform.Scancode
+	events  chan platform.Scancode
+	pending []platform.Scancode
+	pic     processor.InterruptController
+	cpu     processor.Processor
 }
 
 func (m *Device) Install(p processor.Processor) error {
+	m.cpu = p
 	m.pic = p.GetInterruptController()
-	m.ticker = time.NewTicker(time.Millisecond * 10)
 	m.events = make(chan platform.Scancode, MaxEvents)
 
 	if err := p.InstallIODeviceAt(m, 0x60, 0x62, 0x64); err != nil {
 		return err
 	}
-	platform.Instance.SetKeyboardHandler(m.eventHandler)
+	p.GetScheduler().Every(deliveryCycles, m.update)
+	m.Platform.SetKeyboardHandler(m.KeyEvent)
 	return nil
 }
 
@@ -58,6 +76,7 @@ func (m *Device) Name() string {
 func (m *Device) Reset() {
 	m.dataPort = 0
 	m.commandPort = 0
+	m.pending = nil
 	for {
 		select {
 		case <-m.events:
@@ -68,19 +87,57 @@ func (m *Device) Reset() {
 }
 
 func (m *Device) checkEvents() bool {
-	select {
-	case <-m.ticker.C:
-		select {
-		case m.state = <-m.events:
+	cycle := m.cpu.GetScheduler().Cycles()
+	if m.Journal.Replaying() {
+		if data, ok := m.Journal.Next(input.Keyboard, cycle); ok {
+			m.state = platform.Scancode(data[0])
 			return true
-		default:
 		}
+		return false
+	}
+
+	select {
+	case m.state = <-m.events:
 	default:
+		if len(m.pending) == 0 || !m.bufferEmpty() {
+			return false
+		}
+		m.state = m.pending[0]
+		m.pending = m.pending[1:]
+	}
+	m.Journal.Record(input.Keyboard, cycle, [3]byte{byte(m.state)})
+	return true
+}
+
+// bufferEmpty reports if the BIOS has no unread characters in its keyboard buffer.
+func (m *Device) bufferEmpty() bool {
+	head := uint16(m.cpu.ReadByte(bufferHead)) | uint16(m.cpu.ReadByte(bufferHead+1))<<8
+	tail := uint16(m.cpu.ReadByte(bufferTail)) | uint16(m.cpu.ReadByte(bufferTail+1))<<8
+	return head == tail
+}
+
+// Type queues scancodes that are delivered after host input. A queued scancode
+// is only delivered when the BIOS keyboard buffer is empty, so the guest never
+// loses characters no matter how long the input is. Type must be called from
+// the goroutine that steps the machine.
+func (m *Device) Type(keys []platform.Scancode) {
+	if m.Journal.Replaying() {
+		return
 	}
-	return false
+	m.pending = append(m.pending, keys...)
+}
+
+// Typing reports if there are scancodes from Type left to deliver.
+func (m *Device) Typing() bool {
+	return len(m.pending) > 0
 }
 
-func (m *Device) eventHandler(ev platform.Scancode) {
+// KeyEvent queues a scancode for delivery to the machine.
+func (m *Device) KeyEvent(ev platform.Scancode) {
+	if m.Journal.Replaying() {
+		return
+	}
+
 	select {
 	case m.events <- ev:
 	default:
@@ -88,7 +145,23 @@ func (m *Device) eventHandler(ev platform.Scancode) {
 	}
 }
 
+func (m *Device) EventDriven() bool {
+	return true
+}
+
 func (m *Device) Step(int) error {
+	return nil
+}
+
+func (m *Device) SaveState(w io.Writer) error {
+	return peripheral.WriteState(w, m.dataPort, m.commandPort, m.state)
+}
+
+func (m *Device) LoadState(r io.Reader) error {
+	return peripheral.ReadState(r, &m.dataPort, &m.commandPort, &m.state)
+}
+
+func (m *Device) update() error {
 	if m.checkEvents() {
 		m.commandPort |= 2
 		m.dataPort = byte(m.state)
@@ -97,23 +170,39 @@ func (m *Device) Step(int) error {
 	return nil
 }
 
-func (m *Device) Close() error {
-	m.ticker.Stop()
-	return nil
-}
-
 func (m *Device) In(port uint16) byte {
 	switch port {
 	case 0x60:
 		m.commandPort = 0
 		return m.dataPort
 	case 0x62:
-		return 0
+		// Motherboard configuration switches. Bit 1 reports the math coprocessor.
+		var sw byte
+		if m.cpu.GetCoprocessor() != nil {
+			sw |= 2
+		}
+
+		// Bits 4 and 5 select the display. Color and EGA displays are detected by
+		// the BIOS, but a monochrome display must be reported.
+		if !m.hasIODevice(0x3D4) && m.hasIODevice(0x3B4) {
+			sw |= 0x30
+		}
+
+		// Bit 3 of port 0x61 selects the high nibble.
+		if m.cpu.GetMappedIODevice(0x61).In(0x61)&8 != 0 {
+			return sw >> 4
+		}
+		return sw & 0xF
 	case 0x64:
 		return m.commandPort
 	}
 	return 0
 }
 
+func (m *Device) hasIODevice(port uint16) bool {
+	_, dummy := m.cpu.GetMappedIODevice(port).(*memory.DummyIO)
+	return !dummy
+}
+
 func (m *Device) Out(port uint16, data byte) {
 }
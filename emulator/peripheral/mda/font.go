@@ -0,0 +1,65 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package mda
+
+import "github.com/andreas-jonsson/virtualxt/emulator/peripheral/cga"
+
+const (
+	charWidth    = 9
+	charHeight   = 14
+	underlineRow = 12
+
+	// glyphTop is the first row of the 8x8 glyph in the character cell.
+	glyphTop = 3
+)
+
+// mdaFont holds 9 pixel wide rows of the characters. The MDA character ROM is not
+// included, so the glyphs are made from the CGA font centered in the taller cell.
+var mdaFont [256 * charHeight]uint16
+
+func init() {
+	for ch := 0; ch < 256; ch++ {
+		glyph := cga.Glyph(byte(ch))
+		for i := 0; i < charHeight; i++ {
+			row := i - glyphTop
+
+			// Line drawing and block characters are stretched to fill the cell.
+			lineDrawing := ch >= 0xB0 && ch <= 0xDF
+			if lineDrawing {
+				if row < 0 {
+					row = 0
+				} else if row > 7 {
+					row = 7
+				}
+			}
+			if row < 0 || row > 7 {
+				continue
+			}
+
+			line := uint16(glyph[row]) << 1
+			// Like on the real adapter, the ninth column repeats the eighth for line drawing characters.
+			if ch >= 0xC0 && ch <= 0xDF {
+				line |= (line >> 1) & 1
+			}
+			mdaFont[ch*charHeight+i] = line
+		}
+	}
+}
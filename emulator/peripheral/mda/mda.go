@@ -0,0 +1,538 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+// Package mda emulates a Hercules Graphics Card. It is compatible with the IBM
+// Monochrome Display Adapter and adds a 720x348 graphics mode with two pages.
+package mda
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor"
+	"github.com/andreas-jonsson/virtualxt/emulator/screen"
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+const (
+	memorySize     = 0x10000
+	memoryBase     = 0xB0000
+	pageSize       = 0x8000
+	scanlineCycles = 259 // 54.25us at 4.77MHz.
+	numScanlines   = 370
+	numVisible     = 350
+
+	// Width and Height are the size of the rendered surface.
+	Width  = 720
+	Height = 350
+
+	graphicsHeight = 348
+	numColumns     = 80
+	numRows        = 25
+)
+
+const (
+	black = iota
+	normal
+	bright
+)
+
+var mdaColor = []uint32{
+	0x000000,
+	0xAAAAAA,
+	0xFFFFFF,
+}
+
+type Device struct {
+	// Display shows the rendered screen.
+	Display platform.Display
+
+	// Seed is used to scramble the video memory. A zero seed gives different memory each run.
+	Seed int64
+
+	lock     sync.RWMutex
+	quitChan chan struct{}
+
+	dirtyMemory int32
+	mem         [memorySize]byte
+	crtReg      [0x100]byte
+
+	crtAddr, modeCtrlReg,
+	configSwitch, statusReg byte
+
+	currentScanline int
+
+	prevCursorState bool
+	surface         []byte
+
+	// upper is the device that was mapped at 0B8000h before the second page was enabled.
+	upper memory.Memory
+
+	title       *screen.Title
+	atomicBlink int32
+
+	p processor.Processor
+}
+
+func (m *Device) Install(p processor.Processor) error {
+	m.p = p
+	m.title = screen.NewTitle()
+	m.quitChan = make(chan struct{})
+
+	// Scramble memory.
+	if m.Seed != 0 {
+		rand.New(rand.NewSource(m.Seed)).Read(m.mem[:])
+	} else {
+		rand.Read(m.mem[:])
+	}
+
+	// The second page at 0B8000h is mapped when it is enabled with the configuration switch.
+	if err := p.InstallMemoryDevice(m, memoryBase, memoryBase+pageSize-1); err != nil {
+		return err
+	}
+
+	// Ports 3BCh-3BEh belong to the parallel port.
+	if err := p.InstallIODevice(m, 0x3B0, 0x3BB); err != nil {
+		return err
+	}
+	if err := p.InstallIODeviceAt(m, 0x3BF); err != nil {
+		return err
+	}
+	p.GetScheduler().Every(scanlineCycles, m.update)
+
+	m.surface = make([]byte, Width*Height*4)
+	go m.renderLoop()
+	return nil
+}
+
+func (m *Device) Name() string {
+	return "Hercules Graphics Card"
+}
+
+func (m *Device) Reset() {
+	m.lock.Lock()
+	m.currentScanline = 0
+	m.modeCtrlReg = 0x28
+	m.configSwitch = 0
+	m.statusReg = 0
+	m.crtReg[0xA] = 0xB
+	m.crtReg[0xB] = 0xC
+	m.mapUpperPage()
+	m.lock.Unlock()
+}
+
+func (m *Device) EventDriven() bool {
+	return true
+}
+
+func (m *Device) Step(int) error {
+	return nil
+}
+
+func (m *Device) SaveState(w io.Writer) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return peripheral.WriteState(w,
+		m.mem[:], &m.crtReg,
+		m.crtAddr, m.modeCtrlReg, m.configSwitch, m.statusReg,
+		int32(m.currentScanline),
+	)
+}
+
+func (m *Device) LoadState(r io.Reader) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var scanline int32
+	err := peripheral.ReadState(r,
+		m.mem[:], &m.crtReg,
+		&m.crtAddr, &m.modeCtrlReg, &m.configSwitch, &m.statusReg,
+		&scanline,
+	)
+	m.currentScanline = int(scanline)
+	m.mapUpperPage()
+	atomic.StoreInt32(&m.dirtyMemory, 1)
+	return err
+}
+
+// mapUpperPage maps the second page at 0B8000h if it is enabled by the
+// configuration switch. Otherwise the previous device is mapped back.
+// The lock must be held.
+func (m *Device) mapUpperPage() {
+	if m.configSwitch&2 != 0 {
+		if m.upper == nil {
+			m.upper = m.p.GetMappedMemoryDevice(memoryBase + pageSize)
+		}
+		m.p.InstallMemoryDevice(m, memoryBase+pageSize, memoryBase+memorySize-1)
+	} else if m.upper != nil {
+		m.p.InstallMemoryDevice(m.upper, memoryBase+pageSize, memoryBase+memorySize-1)
+		m.upper = nil
+	}
+}
+
+func (m *Device) update() error {
+	m.title.AddCycles(scanlineCycles)
+
+	if m.currentScanline = (m.currentScanline + 1) % numScanlines; m.currentScanline == 0 {
+		// Blink is toggled every 500ms of emulated time.
+		var blink int32
+		if (m.p.GetScheduler().Time()/(time.Millisecond*500))%2 == 0 {
+			blink = 1
+		}
+		atomic.StoreInt32(&m.atomicBlink, blink)
+	}
+
+	// Bit 7 is cleared during vertical retrace. Bit 0 is the horizontal sync.
+	if m.currentScanline >= numVisible {
+		m.statusReg = 0
+	} else {
+		m.statusReg = 0x80
+	}
+	m.statusReg |= 1
+	return nil
+}
+
+func (m *Device) Close() error {
+	m.quitChan <- struct{}{}
+	<-m.quitChan
+	return nil
+}
+
+func blit32(pixels []byte, offset int, color uint32) {
+	pixels[offset] = byte((color & 0xFF0000) >> 16)
+	pixels[offset+1] = byte((color & 0x00FF00) >> 8)
+	pixels[offset+2] = byte(color & 0x0000FF)
+	pixels[offset+3] = 0xFF
+}
+
+func (m *Device) blinkTick() bool {
+	return atomic.LoadInt32(&m.atomicBlink) != 0
+}
+
+func (m *Device) graphicsMode() bool {
+	return m.modeCtrlReg&2 != 0
+}
+
+func (m *Device) blinkEnabled() bool {
+	return m.modeCtrlReg&0x20 != 0
+}
+
+// colors returns the foreground and background color indices of the attribute
+// and if the character is underlined.
+func (m *Device) colors(attrib byte, blink bool) (fg, bg int, underline bool) {
+	switch attrib & 0x77 {
+	case 0x00: // Invisible
+		return black, black, false
+	case 0x70: // Reverse video
+		fg, bg = black, normal
+		if attrib&0x80 != 0 && !m.blinkEnabled() {
+			bg = bright
+		}
+	default:
+		fg, bg = normal, black
+		if attrib&8 != 0 {
+			fg = bright
+		}
+		underline = attrib&7 == 1
+	}
+
+	if attrib&0x80 != 0 && m.blinkEnabled() && blink {
+		fg = bg
+	}
+	return
+}
+
+func (m *Device) blitChar(pixels []byte, ch, attrib byte, x, y int, blink bool) {
+	fgIndex, bgIndex, underline := m.colors(attrib, blink)
+	fgColor, bgColor := mdaColor[fgIndex], mdaColor[bgIndex]
+
+	for i := 0; i < charHeight; i++ {
+		glyphLine := mdaFont[int(ch)*charHeight+i]
+		if underline && i == underlineRow {
+			glyphLine = 0x1FF
+		}
+		for j := 0; j < charWidth; j++ {
+			col := fgColor
+			if glyphLine&(0x100>>j) == 0 {
+				col = bgColor
+			}
+			blit32(pixels, (Width*(y+i)+x+j)*4, col)
+		}
+	}
+}
+
+func (m *Device) renderLoop() {
+	p := m.Display
+	textFlag := flag.Lookup("text")
+	cliMode := textFlag != nil && textFlag.Value.(flag.Getter).Get().(bool)
+
+	ticker := time.NewTicker(time.Second / 30)
+	defer ticker.Stop()
+	defer m.title.Stop()
+
+	for {
+		select {
+		case <-m.quitChan:
+			close(m.quitChan)
+			return
+		case <-ticker.C:
+			m.title.Update(p)
+
+			blink := m.blinkTick()
+			dirtyMemory := atomic.LoadInt32(&m.dirtyMemory) != 0
+
+			if dirtyMemory || m.prevCursorState != blink {
+				m.lock.RLock()
+				atomic.StoreInt32(&m.dirtyMemory, 0)
+				m.prevCursorState = blink
+
+				if !m.graphicsMode() && cliMode {
+					if dirtyMemory {
+						t := m.text()
+						p.RenderText(t.Mem, t.Width, true, m.blinkEnabled(), 0, t.CursorX, t.CursorY)
+					}
+					m.lock.RUnlock()
+				} else {
+					m.renderSurface(m.surface, blink)
+					m.lock.RUnlock()
+					p.RenderGraphics(m.surface, Width, Height, 0, 0, 0)
+				}
+			}
+		}
+	}
+}
+
+// videoPage returns the offset of the visible text page from the CRTC start address.
+func (m *Device) videoPage() int {
+	return ((int(m.crtReg[0xC])<<8 | int(m.crtReg[0xD])) * 2) & (pageSize - 1)
+}
+
+func (m *Device) cursorPosition() int {
+	return int(m.crtReg[0xE])<<8 | int(m.crtReg[0xF])
+}
+
+// cursorVisible reports if the cursor is enabled in the CRTC cursor start register.
+func (m *Device) cursorVisible() bool {
+	return m.crtReg[0xA]&0x60 != 0x20
+}
+
+// copyPage copies video memory from the visible text page. Addresses wrap around within the first page.
+func (m *Device) copyPage(dst []byte) {
+	page := m.videoPage()
+	for i := range dst {
+		dst[i] = m.mem[(page+i)&(pageSize-1)]
+	}
+}
+
+// renderSurface draws the screen to a 720x350 RGBA surface. The lock must be held.
+func (m *Device) renderSurface(dst []byte, blink bool) {
+	if m.modeCtrlReg&8 == 0 {
+		// Video is disabled.
+		for i := 0; i < len(dst); i += 4 {
+			blit32(dst, i, mdaColor[black])
+		}
+		return
+	}
+
+	if m.graphicsMode() {
+		page := 0
+		if m.modeCtrlReg&0x80 != 0 {
+			page = pageSize
+		}
+
+		for y := 0; y < Height; y++ {
+			for x := 0; x < Width; x++ {
+				col := mdaColor[black]
+				if y < graphicsHeight {
+					addr := page + (y&3)*0x2000 + (y>>2)*(Width/8) + (x >> 3)
+					if (m.mem[addr]>>(7-(x&7)))&1 != 0 {
+						col = mdaColor[normal]
+					}
+				}
+				blit32(dst, (y*Width+x)*4, col)
+			}
+		}
+		return
+	}
+
+	videoPage := m.videoPage()
+	for i := 0; i < numColumns*numRows; i++ {
+		ch := m.mem[(videoPage+i*2)&(pageSize-1)]
+		attr := m.mem[(videoPage+i*2+1)&(pageSize-1)]
+		m.blitChar(dst, ch, attr, (i%numColumns)*charWidth, (i/numColumns)*charHeight, blink)
+	}
+
+	// The cursor position is relative to the start of video memory.
+	if pos := m.cursorPosition() - videoPage/2; blink && m.cursorVisible() && pos >= 0 && pos < numColumns*numRows {
+		x := (pos % numColumns) * charWidth
+		y := (pos / numColumns) * charHeight
+
+		attr := m.mem[(videoPage+pos*2+1)&(pageSize-1)]
+		fg, _, _ := m.colors(attr, false)
+		if fg == black {
+			fg = normal
+		}
+
+		start, end := int(m.crtReg[0xA]&0x1F), int(m.crtReg[0xB]&0x1F)
+		for i := start; i <= end && i < charHeight; i++ {
+			for j := 0; j < charWidth; j++ {
+				blit32(dst, (Width*(y+i)+x+j)*4, mdaColor[fg])
+			}
+		}
+	}
+}
+
+// Framebuffer renders the current screen content as 720x350 RGBA pixels.
+func (m *Device) Framebuffer() ([]byte, int, int) {
+	pixels := make([]byte, Width*Height*4)
+
+	m.lock.RLock()
+	m.renderSurface(pixels, m.blinkTick())
+	m.lock.RUnlock()
+	return pixels, Width, Height
+}
+
+// Palette returns the colors used in the rendered surfaces.
+func Palette() color.Palette {
+	palette := make(color.Palette, len(mdaColor))
+	for i, c := range mdaColor {
+		palette[i] = color.RGBA{byte(c >> 16), byte(c >> 8), byte(c), 0xFF}
+	}
+	return palette
+}
+
+// Image renders the current screen content. With aspect correction the 720x350
+// surface is stretched to 720x540, which is how it looks on a 4:3 monitor.
+func (m *Device) Image(correctAspect bool) *image.RGBA {
+	pixels, w, h := m.Framebuffer()
+	return screen.Image(pixels, w, h, correctAspect)
+}
+
+// TextScreen returns a copy of the character and attribute pairs of the visible
+// text page. Nothing is returned in graphics mode.
+func (m *Device) TextScreen() ([]byte, int, int) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.graphicsMode() {
+		return nil, 0, 0
+	}
+
+	mem := make([]byte, numColumns*numRows*2)
+	m.copyPage(mem)
+	return mem, numColumns, numRows
+}
+
+// Text returns a copy of the visible text page with the cursor position relative
+// to the page. It returns nil in graphics mode.
+func (m *Device) Text() *screen.Text {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.graphicsMode() {
+		return nil
+	}
+	return m.text()
+}
+
+// text copies the visible text page. The lock must be held.
+func (m *Device) text() *screen.Text {
+	t := &screen.Text{
+		Width:   numColumns,
+		Height:  numRows,
+		Page:    m.videoPage(),
+		CursorX: -1,
+		CursorY: -1,
+		Mem:     make([]byte, numColumns*numRows*2),
+	}
+	m.copyPage(t.Mem)
+
+	pos := m.cursorPosition() - t.Page/2
+	if m.cursorVisible() && pos >= 0 && pos < numColumns*numRows {
+		t.CursorX, t.CursorY = pos%numColumns, pos/numColumns
+	}
+	return t
+}
+
+func (m *Device) In(port uint16) byte {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	switch port {
+	case 0x3B1, 0x3B3, 0x3B5, 0x3B7:
+		return m.crtReg[m.crtAddr]
+	case 0x3BA:
+		status := m.statusReg
+		m.statusReg &= 0xFE
+		return status
+	}
+	return 0
+}
+
+func (m *Device) Out(port uint16, data byte) {
+	m.lock.Lock()
+
+	// We likely need to redraw the screen.
+	atomic.StoreInt32(&m.dirtyMemory, 1)
+
+	switch port {
+	case 0x3B0, 0x3B2, 0x3B4, 0x3B6:
+		m.crtAddr = data
+	case 0x3B1, 0x3B3, 0x3B5, 0x3B7:
+		m.crtReg[m.crtAddr] = data
+	case 0x3B8:
+		// Graphics mode and the second page are only selectable if they are allowed by the configuration switch.
+		if m.configSwitch&1 == 0 {
+			data &^= 2
+		}
+		if m.configSwitch&2 == 0 {
+			data &^= 0x80
+		}
+		m.modeCtrlReg = data
+	case 0x3BF:
+		m.configSwitch = data & 3
+		m.mapUpperPage()
+	}
+
+	m.lock.Unlock()
+}
+
+func (m *Device) ReadByte(addr memory.Pointer) byte {
+	m.lock.RLock()
+	v := m.mem[(addr-memoryBase)&(memorySize-1)]
+	m.lock.RUnlock()
+	return v
+}
+
+func (m *Device) WriteByte(addr memory.Pointer, data byte) {
+	m.lock.Lock()
+	atomic.StoreInt32(&m.dirtyMemory, 1)
+	m.mem[(addr-memoryBase)&(memorySize-1)] = data
+	m.lock.Unlock()
+}
@@ -0,0 +1,142 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package mda
+
+import (
+	"testing"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/pic"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/ram"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor/cpu"
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+func newTestCPU(t *testing.T) (*cpu.CPU, *Device) {
+	d := &Device{Display: platform.NewHeadless(), Seed: 1}
+	p, errs := cpu.NewCPU([]peripheral.Peripheral{
+		&ram.Device{Clear: true},
+		&pic.Device{},
+		d,
+	})
+	for _, err := range errs {
+		t.Fatal(err)
+	}
+	p.Reset()
+	return p, d
+}
+
+func pixel(pixels []byte, x, y int) uint32 {
+	offset := (y*Width + x) * 4
+	return uint32(pixels[offset])<<16 | uint32(pixels[offset+1])<<8 | uint32(pixels[offset+2])
+}
+
+func TestText(t *testing.T) {
+	p, d := newTestCPU(t)
+	defer p.Close()
+
+	p.OutByte(0x3B8, 0x08) // Video enabled, no blink.
+	cells := []byte{
+		0xDB, 0x07, // Normal
+		0xDB, 0x0F, // Intense
+		' ', 0x01, // Underline
+		' ', 0x70, // Reverse
+		0xDB, 0x00, // Invisible
+	}
+	for i, v := range cells {
+		p.WriteByte(memory.Pointer(memoryBase+i), v)
+	}
+
+	pixels, w, h := d.Framebuffer()
+	if w != 720 || h != 350 {
+		t.Fatalf("unexpected surface size: %dx%d", w, h)
+	}
+
+	tests := []struct {
+		name  string
+		x, y  int
+		color uint32
+	}{
+		{"normal", 4, 6, mdaColor[normal]},
+		{"intense", 9 + 4, 6, mdaColor[bright]},
+		{"underline", 18 + 4, underlineRow, mdaColor[normal]},
+		{"no underline", 18 + 4, 6, mdaColor[black]},
+		{"reverse", 27 + 4, 6, mdaColor[normal]},
+		{"invisible", 36 + 4, 6, mdaColor[black]},
+	}
+	for _, test := range tests {
+		if c := pixel(pixels, test.x, test.y); c != test.color {
+			t.Errorf("%s: expected 0x%06X, got 0x%06X", test.name, test.color, c)
+		}
+	}
+
+	text := d.Text()
+	if text == nil || text.Width != 80 || text.Height != 25 {
+		t.Fatalf("unexpected text screen: %+v", text)
+	}
+	if _, attr := text.Cell(2, 0); attr != 0x01 {
+		t.Errorf("expected underline attribute, got 0x%X", attr)
+	}
+}
+
+func TestGraphics(t *testing.T) {
+	p, d := newTestCPU(t)
+	defer p.Close()
+
+	// Graphics mode and the second page are ignored until they are allowed.
+	p.OutByte(0x3B8, 0x8A)
+	if d.Text() == nil {
+		t.Error("graphics mode was selected without the configuration switch")
+	}
+
+	p.WriteByte(0xB8000, 0x55)
+	if v := p.ReadByte(0xB8000); v != 0x55 {
+		t.Fatalf("expected RAM at 0B8000h, got 0x%X", v)
+	}
+
+	p.OutByte(0x3BF, 3)
+	p.OutByte(0x3B8, 0x8A) // Graphics mode on page 2 with video enabled.
+	if d.Text() != nil {
+		t.Error("expected graphics mode")
+	}
+
+	// Rows are interleaved in four banks of 8K.
+	p.WriteByte(0xB8000, 0x80)
+	p.WriteByte(0xB8000+0x2000, 0x01)
+	p.WriteByte(0xB8000+90, 0x80)
+
+	pixels, _, _ := d.Framebuffer()
+	for _, pos := range [][2]int{{0, 0}, {7, 1}, {0, 4}} {
+		if c := pixel(pixels, pos[0], pos[1]); c != mdaColor[normal] {
+			t.Errorf("expected pixel at %v to be set", pos)
+		}
+	}
+	if c := pixel(pixels, 1, 0); c != mdaColor[black] {
+		t.Error("expected pixel at [1 0] to be cleared")
+	}
+
+	// Disabling the second page maps the RAM back.
+	p.OutByte(0x3BF, 1)
+	if v := p.ReadByte(0xB8000); v != 0x55 {
+		t.Errorf("expected RAM at 0B8000h, got 0x%X", v)
+	}
+}
@@ -34,6 +34,8 @@ import (
 	"github.com/google/gopacket/pcap"
 )
 
+const pollCycles = 1000
+
 type Device struct {
 	cpu      processor.Processor
 	quitChan chan struct{}
@@ -96,6 +98,7 @@ func (m *Device) Install(p processor.Processor) error {
 	log.Print("Packet capture is active!")
 
 	m.startCapture()
+	p.GetScheduler().Every(pollCycles, m.update)
 	return p.InstallIODeviceAt(m, 0xB2)
 }
 
@@ -140,7 +143,15 @@ func (m *Device) startCapture() {
 	}()
 }
 
-func (m *Device) Step(cycles int) error {
+func (m *Device) EventDriven() bool {
+	return true
+}
+
+func (m *Device) Step(int) error {
+	return nil
+}
+
+func (m *Device) update() error {
 	if !m.canRecv {
 		return nil
 	}
@@ -29,3 +29,7 @@ import (
 type Device struct {
 	peripheral.NullDevice
 }
+
+func (m *Device) EventDriven() bool {
+	return true
+}
@@ -21,6 +21,9 @@ freely, subject to the following restrictions:
 package peripheral
 
 import (
+	"encoding/binary"
+	"io"
+
 	"github.com/andreas-jonsson/virtualxt/emulator/processor"
 )
 
@@ -31,10 +34,49 @@ type Peripheral interface {
 	Install(processor.Processor) error
 }
 
+// EventDriven is implemented by peripherals that register their own events
+// with the scheduler. Step is only called on peripherals that are not event
+// driven, after every instruction.
+type EventDriven interface {
+	Peripheral
+	EventDriven() bool
+}
+
+// Snapshotter is implemented by peripherals that have state that needs to be
+// part of a machine snapshot. LoadState reads what SaveState wrote.
+type Snapshotter interface {
+	Peripheral
+	SaveState(w io.Writer) error
+	LoadState(r io.Reader) error
+}
+
+// WriteState writes the values in little endian byte order.
+// The values must be fixed size data or pointers to fixed size data.
+func WriteState(w io.Writer, data ...interface{}) error {
+	for _, v := range data {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadState reads values written by WriteState. The values must be pointers.
+func ReadState(r io.Reader, data ...interface{}) error {
+	for _, v := range data {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type PeripheralCloser interface {
 	Close() error
 }
 
+// NullDevice is stepped like any other peripheral. Devices that embed it
+// and never need to be stepped can implement EventDriven.
 type NullDevice struct {
 }
 
@@ -22,7 +22,9 @@ package pic
 
 import (
 	"errors"
+	"io"
 
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
 	"github.com/andreas-jonsson/virtualxt/emulator/processor"
 )
 
@@ -48,10 +50,30 @@ func (m *Device) Reset() {
 	*m = Device{}
 }
 
+func (m *Device) EventDriven() bool {
+	return true
+}
+
 func (m *Device) Step(int) error {
 	return nil
 }
 
+func (m *Device) SaveState(w io.Writer) error {
+	return peripheral.WriteState(w,
+		m.maskReg, m.requestReg, m.serviceReg, m.icwStep,
+		m.intOffset, m.priority, m.autoEOI, m.readMode,
+		&m.icw, m.ticks, m.enabled,
+	)
+}
+
+func (m *Device) LoadState(r io.Reader) error {
+	return peripheral.ReadState(r,
+		&m.maskReg, &m.requestReg, &m.serviceReg, &m.icwStep,
+		&m.intOffset, &m.priority, &m.autoEOI, &m.readMode,
+		&m.icw, &m.ticks, &m.enabled,
+	)
+}
+
 func (m *Device) GetInterrupt() (int, error) {
 	has := m.requestReg & (^m.maskReg)
 	if has == 0 {
@@ -27,11 +27,16 @@ References:
 package pit
 
 import (
+	"io"
 	"time"
 
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
 	"github.com/andreas-jonsson/virtualxt/emulator/processor"
 )
 
+// The channels are updated every 10 PIT ticks.
+const updateCycles = 40
+
 const (
 	modeLatchCount = iota
 	modeLowByte
@@ -49,12 +54,15 @@ type pitChannel struct {
 
 type Device struct {
 	pic                processor.InterruptController
+	scheduler          *processor.Scheduler
 	channels           [3]pitChannel
 	ticks, deviceTicks int64
 }
 
 func (m *Device) Install(p processor.Processor) error {
 	m.pic = p.GetInterruptController()
+	m.scheduler = p.GetScheduler()
+	m.scheduler.Every(updateCycles, m.update)
 	return p.InstallIODevice(m, 0x40, 0x43)
 }
 
@@ -63,12 +71,45 @@ func (m *Device) Name() string {
 }
 
 func (m *Device) Reset() {
-	*m = Device{pic: m.pic, ticks: time.Now().UnixNano() / 1000}
+	*m = Device{pic: m.pic, scheduler: m.scheduler}
+	m.ticks = m.now()
+}
+
+func (m *Device) EventDriven() bool {
+	return true
 }
 
 func (m *Device) Step(int) error {
-	// ticks in microseconds
-	ticks := time.Now().UnixNano() / 1000
+	return nil
+}
+
+func (m *Device) SaveState(w io.Writer) error {
+	for i := range m.channels {
+		c := &m.channels[i]
+		if err := peripheral.WriteState(w, c.enabled, c.toggle, c.frequency, c.effective, c.counter, c.data, c.mode); err != nil {
+			return err
+		}
+	}
+	return peripheral.WriteState(w, m.ticks, m.deviceTicks)
+}
+
+func (m *Device) LoadState(r io.Reader) error {
+	for i := range m.channels {
+		c := &m.channels[i]
+		if err := peripheral.ReadState(r, &c.enabled, &c.toggle, &c.frequency, &c.effective, &c.counter, &c.data, &c.mode); err != nil {
+			return err
+		}
+	}
+	return peripheral.ReadState(r, &m.ticks, &m.deviceTicks)
+}
+
+// now returns the emulated time in microseconds.
+func (m *Device) now() int64 {
+	return int64(m.scheduler.Time() / time.Microsecond)
+}
+
+func (m *Device) update() error {
+	ticks := m.now()
 
 	if ch := &m.channels[0]; ch.enabled && ch.frequency > 0 {
 		next := 1000000 / int64(ch.frequency)
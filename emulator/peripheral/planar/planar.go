@@ -0,0 +1,577 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+// Package planar is the display adapter core that the EGA and VGA have in common.
+// It holds the four memory planes with their latches, the sequencer, graphics controller,
+// CRTC and attribute controller, and renders the screen. The ega and vga packages
+// build their cards on it and add what differs between them.
+package planar
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor"
+	"github.com/andreas-jonsson/virtualxt/emulator/screen"
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+const (
+	planeSize  = 0x10000
+	windowSize = 0x8000
+	numWindows = 4
+
+	// MemoryBase is the start of the 128KB region where video memory can be mapped.
+	MemoryBase = 0xA0000
+)
+
+// Registers of the sequencer.
+const (
+	SeqClockingMode = 1
+	SeqMapMask      = 2
+	SeqCharMap      = 3
+	SeqMemoryMode   = 4
+)
+
+// Registers of the graphics controller.
+const (
+	GCSetReset       = 0
+	GCEnableSetReset = 1
+	GCColorCompare   = 2
+	GCDataRotate     = 3
+	GCReadMapSelect  = 4
+	GCMode           = 5
+	GCMisc           = 6
+	GCColorDontCare  = 7
+	GCBitMask        = 8
+)
+
+// Registers of the attribute controller. Registers 0-15 are the palette.
+const (
+	AttrMode        = 0x10
+	AttrOverscan    = 0x11
+	AttrPlaneEnable = 0x12
+	AttrPanning     = 0x13
+	AttrColorSelect = 0x14
+)
+
+// Card is the device that embeds the adapter. It is installed for the ports and
+// the video memory, so it is the card that shows up in the memory map.
+type Card interface {
+	memory.IO
+	memory.Memory
+}
+
+// Config describes the card. The functions are called with the lock of the adapter held.
+type Config struct {
+	// Display shows the rendered screen.
+	Display platform.Display
+
+	// Seed is used to scramble the video memory. A zero seed gives different memory each run.
+	Seed int64
+
+	// VGA enables what the VGA adds to the EGA registers: chain-4 addressing, write mode 3,
+	// the 256 color shift mode, line compare, double scanning, doubleword addressing,
+	// the extra CRTC overflow bits, the color select register and readable registers.
+	VGA bool
+
+	// ScanlineCycles returns the length of a scanline in CPU cycles.
+	ScanlineCycles func() int
+
+	// Color returns the RGB color of a 6 bit value from the attribute controller.
+	// On the VGA it is an 8 bit DAC index, which is also used in the 256 color mode.
+	Color func(c byte) uint32
+
+	// In and Out are called first for every port access and report if they handled the port.
+	In  func(port uint16) (byte, bool)
+	Out func(port uint16, data byte) bool
+
+	// Reset, SaveState and LoadState handle the state that belongs to the card.
+	Reset     func()
+	SaveState func(w io.Writer) error
+	LoadState func(r io.Reader) error
+}
+
+// Adapter is embedded by the card. It has the methods of peripheral.Peripheral except
+// Install and Name, which the card provides, and those of the memory and IO devices.
+// The Install method of the card calls Setup.
+type Adapter struct {
+	cfg Config
+
+	lock     sync.RWMutex
+	quitChan chan struct{}
+
+	dirtyMemory int32
+	planes      [4][planeSize]byte
+	latch       [4]byte
+
+	miscOutput,
+	seqAddr, gcAddr, crtAddr, attrAddr byte
+	seqReg  [8]byte
+	gcReg   [16]byte
+	crtReg  [0x20]byte
+	attrReg [0x20]byte
+
+	// attrData is set when the next write to port 3C0h is data for the attribute controller.
+	attrData bool
+
+	currentScanline int
+	hsync           bool
+	event           *processor.Event
+
+	// windows holds the devices that were mapped in the 32KB windows at 0A0000h-0BFFFFh
+	// before this adapter took them over.
+	windows [numWindows]memory.Memory
+	mapped  [numWindows]bool
+
+	prevBlink bool
+	surface   []byte
+	line      []uint32
+
+	title       *screen.Title
+	atomicBlink int32
+
+	card Card
+	p    processor.Processor
+}
+
+// Setup installs the card and starts rendering.
+func (m *Adapter) Setup(p processor.Processor, card Card, cfg Config) error {
+	m.p = p
+	m.cfg = cfg
+	m.card = card
+	m.title = screen.NewTitle()
+	m.quitChan = make(chan struct{})
+
+	// Scramble memory.
+	read := rand.Read
+	if cfg.Seed != 0 {
+		read = rand.New(rand.NewSource(cfg.Seed)).Read
+	}
+	for i := range m.planes {
+		read(m.planes[i][:])
+	}
+
+	// Video memory is mapped by Reset, since it depends on the graphics controller.
+	// Only color addressing of the CRTC is supported, so a monochrome adapter can
+	// be installed next to this one.
+	if err := p.InstallIODevice(card, 0x3C0, 0x3CF); err != nil {
+		return err
+	}
+	if err := p.InstallIODevice(card, 0x3D0, 0x3DF); err != nil {
+		return err
+	}
+	m.event = p.GetScheduler().After(int64(cfg.ScanlineCycles()), m.update)
+
+	go m.renderLoop()
+	return nil
+}
+
+func (m *Adapter) Reset() {
+	m.lock.Lock()
+	m.currentScanline = 0
+	m.miscOutput = 0
+	m.attrData = false
+	m.latch = [4]byte{}
+
+	// Start in a text mode layout, so the system BIOS can write to memory
+	// before the video BIOS has set a mode.
+	m.seqReg = [8]byte{3, 0, 3, 0, 2}
+	m.gcReg = [16]byte{GCMode: 0x10, GCMisc: 0xE, GCColorDontCare: 0xF, GCBitMask: 0xFF}
+	m.attrReg[AttrPlaneEnable] = 0xF
+	if m.cfg.Reset != nil {
+		m.cfg.Reset()
+	}
+	m.mapMemory()
+	m.lock.Unlock()
+}
+
+func (m *Adapter) EventDriven() bool {
+	return true
+}
+
+func (m *Adapter) Step(int) error {
+	return nil
+}
+
+func (m *Adapter) SaveState(w io.Writer) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	err := peripheral.WriteState(w,
+		m.planes[0][:], m.planes[1][:], m.planes[2][:], m.planes[3][:], &m.latch,
+		m.miscOutput, m.seqAddr, m.gcAddr, m.crtAddr, m.attrAddr,
+		&m.seqReg, &m.gcReg, &m.crtReg, &m.attrReg,
+		m.attrData, int32(m.currentScanline),
+	)
+	if err == nil && m.cfg.SaveState != nil {
+		err = m.cfg.SaveState(w)
+	}
+	return err
+}
+
+func (m *Adapter) LoadState(r io.Reader) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var scanline int32
+	err := peripheral.ReadState(r,
+		m.planes[0][:], m.planes[1][:], m.planes[2][:], m.planes[3][:], &m.latch,
+		&m.miscOutput, &m.seqAddr, &m.gcAddr, &m.crtAddr, &m.attrAddr,
+		&m.seqReg, &m.gcReg, &m.crtReg, &m.attrReg,
+		&m.attrData, &scanline,
+	)
+	if err == nil && m.cfg.LoadState != nil {
+		err = m.cfg.LoadState(r)
+	}
+	m.currentScanline = int(scanline)
+	m.mapMemory()
+	atomic.StoreInt32(&m.dirtyMemory, 1)
+	return err
+}
+
+// MiscOutput returns the miscellaneous output register. It is meant for the
+// functions in the configuration, which are called with the lock held.
+func (m *Adapter) MiscOutput() byte {
+	return m.miscOutput
+}
+
+// mapMemory maps the 32KB windows selected by the graphics controller and
+// gives the other windows back to the devices that had them. The lock must be held.
+func (m *Adapter) mapMemory() {
+	selected := [...]byte{0xF, 0x3, 0x4, 0x8}[(m.gcReg[GCMisc]>>2)&3]
+	for i := 0; i < numWindows; i++ {
+		from := memory.Pointer(MemoryBase + i*windowSize)
+		to := from + windowSize - 1
+
+		if selected&(1<<uint(i)) != 0 {
+			if !m.mapped[i] {
+				m.windows[i] = m.p.GetMappedMemoryDevice(from)
+				m.p.InstallMemoryDevice(m.card, from, to)
+				m.mapped[i] = true
+			}
+		} else if m.mapped[i] {
+			m.p.InstallMemoryDevice(m.windows[i], from, to)
+			m.windows[i], m.mapped[i] = nil, false
+		}
+	}
+}
+
+// verticalTotal returns the number of scanlines in a frame.
+func (m *Adapter) verticalTotal() int {
+	overflow := int(m.crtReg[7])
+	total := int(m.crtReg[6]) | (overflow&1)<<8
+	if !m.cfg.VGA {
+		if total > 0 {
+			return total + 1
+		}
+		return 262
+	}
+
+	if total |= (overflow & 0x20) << 4; total > 0 {
+		return total + 2
+	}
+	return 449
+}
+
+// verticalDisplayed returns the number of visible scanlines.
+func (m *Adapter) verticalDisplayed() int {
+	overflow := int(m.crtReg[7])
+	displayed := int(m.crtReg[0x12]) | (overflow&2)<<7
+	if m.cfg.VGA {
+		displayed |= (overflow & 0x40) << 3
+	}
+	return displayed + 1
+}
+
+// lineCompare returns the scanline after which the display starts over from address zero.
+func (m *Adapter) lineCompare() int {
+	lc := int(m.crtReg[0x18]) | int(m.crtReg[7]&0x10)<<4
+	if m.cfg.VGA {
+		lc |= int(m.crtReg[9]&0x40) << 3
+	}
+	return lc
+}
+
+func (m *Adapter) update() error {
+	cycles := m.cfg.ScanlineCycles()
+	m.p.GetScheduler().Reschedule(m.event, int64(cycles))
+	m.title.AddCycles(cycles)
+
+	if m.currentScanline++; m.currentScanline >= m.verticalTotal() {
+		m.currentScanline = 0
+
+		// Blink is toggled every 500ms of emulated time.
+		var blink int32
+		if (m.p.GetScheduler().Time()/(time.Millisecond*500))%2 == 0 {
+			blink = 1
+		}
+		atomic.StoreInt32(&m.atomicBlink, blink)
+	}
+	m.hsync = true
+	return nil
+}
+
+func (m *Adapter) Close() error {
+	m.quitChan <- struct{}{}
+	<-m.quitChan
+	return nil
+}
+
+func (m *Adapter) In(port uint16) byte {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.cfg.In != nil {
+		if v, ok := m.cfg.In(port); ok {
+			return v
+		}
+	}
+
+	switch port {
+	case 0x3D1, 0x3D3, 0x3D5, 0x3D7:
+		return m.crtReg[m.crtAddr&0x1F]
+	case 0x3DA:
+		// Input status 1. Bit 3 is the vertical retrace and bit 0 is set when
+		// the display is not drawing. Reading it resets the attribute controller.
+		m.attrData = false
+
+		var status byte
+		if m.currentScanline >= m.verticalDisplayed() {
+			status = 9
+		}
+		if m.hsync {
+			status |= 1
+			m.hsync = false
+		}
+		return status
+	}
+
+	// Most registers are write only on the EGA.
+	if !m.cfg.VGA {
+		return 0xFF
+	}
+
+	switch port {
+	case 0x3C0:
+		return m.attrAddr
+	case 0x3C1:
+		return m.attrReg[m.attrAddr&0x1F]
+	case 0x3C4:
+		return m.seqAddr
+	case 0x3C5:
+		return m.seqReg[m.seqAddr]
+	case 0x3CC:
+		return m.miscOutput
+	case 0x3CE:
+		return m.gcAddr
+	case 0x3CF:
+		return m.gcReg[m.gcAddr]
+	case 0x3D0, 0x3D2, 0x3D4, 0x3D6:
+		return m.crtAddr
+	}
+	return 0xFF
+}
+
+func (m *Adapter) Out(port uint16, data byte) {
+	m.lock.Lock()
+
+	// We likely need to redraw the screen.
+	atomic.StoreInt32(&m.dirtyMemory, 1)
+
+	if m.cfg.Out != nil && m.cfg.Out(port, data) {
+		m.lock.Unlock()
+		return
+	}
+
+	switch port {
+	case 0x3C0:
+		if m.attrData {
+			m.attrReg[m.attrAddr&0x1F] = data
+		} else {
+			// Bit 5 gives the display access to the palette. The screen is blank while it is cleared.
+			m.attrAddr = data & 0x3F
+		}
+		m.attrData = !m.attrData
+	case 0x3C2:
+		m.miscOutput = data
+	case 0x3C4:
+		m.seqAddr = data & 7
+	case 0x3C5:
+		m.seqReg[m.seqAddr] = data
+	case 0x3CE:
+		m.gcAddr = data & 0xF
+	case 0x3CF:
+		m.gcReg[m.gcAddr] = data
+		if m.gcAddr == GCMisc {
+			m.mapMemory()
+		}
+	case 0x3D0, 0x3D2, 0x3D4, 0x3D6:
+		m.crtAddr = data & 0x1F
+	case 0x3D1, 0x3D3, 0x3D5, 0x3D7:
+		// On the VGA, bit 7 of the vertical retrace end register protects the horizontal
+		// and vertical timing registers, except the line compare bit in the overflow register.
+		if m.cfg.VGA && m.crtReg[0x11]&0x80 != 0 && m.crtAddr <= 7 {
+			if m.crtAddr == 7 {
+				m.crtReg[7] = m.crtReg[7]&^0x10 | data&0x10
+			}
+			break
+		}
+		m.crtReg[m.crtAddr] = data
+	}
+
+	m.lock.Unlock()
+}
+
+// chain4 reports if the two lowest address bits select the plane. It is only available on the VGA.
+func (m *Adapter) chain4() bool {
+	return m.cfg.VGA && m.seqReg[SeqMemoryMode]&8 != 0
+}
+
+// oddEven reports if even addresses go to planes 0 and 2 and odd addresses to planes 1 and 3.
+func (m *Adapter) oddEven() bool {
+	return m.seqReg[SeqMemoryMode]&4 == 0
+}
+
+// planeOffset translates a CPU address to an offset in the planes. The lock must be held.
+func (m *Adapter) planeOffset(addr memory.Pointer) int {
+	var offset int
+	switch (m.gcReg[GCMisc] >> 2) & 3 {
+	case 0, 1:
+		offset = int(addr - 0xA0000)
+	case 2:
+		offset = int(addr - 0xB0000)
+	case 3:
+		offset = int(addr - 0xB8000)
+	}
+	if m.chain4() {
+		offset &^= 3
+	} else if m.oddEven() {
+		offset &^= 1
+	}
+	return offset & (planeSize - 1)
+}
+
+func (m *Adapter) ReadByte(addr memory.Pointer) byte {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	offset := m.planeOffset(addr)
+	for i := range m.latch {
+		m.latch[i] = m.planes[i][offset]
+	}
+
+	if m.chain4() {
+		return m.latch[addr&3]
+	}
+
+	if m.gcReg[GCMode]&8 != 0 {
+		// Read mode 1 sets the bits where the planes that are not ignored match the compare color.
+		var diff byte
+		for i := uint(0); i < 4; i++ {
+			if m.gcReg[GCColorDontCare]&(1<<i) != 0 {
+				diff |= m.latch[i] ^ expand(m.gcReg[GCColorCompare], i)
+			}
+		}
+		return ^diff
+	}
+
+	plane := m.gcReg[GCReadMapSelect] & 3
+	if m.oddEven() {
+		plane = plane&2 | byte(addr&1)
+	}
+	return m.latch[plane]
+}
+
+func (m *Adapter) WriteByte(addr memory.Pointer, data byte) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	atomic.StoreInt32(&m.dirtyMemory, 1)
+
+	offset := m.planeOffset(addr)
+	planes := m.seqReg[SeqMapMask]
+	if m.chain4() {
+		planes &= 1 << (addr & 3)
+	} else if m.oddEven() {
+		planes &= 5 << (addr & 1)
+	}
+
+	mode := m.gcReg[GCMode] & 3
+	if mode == 3 && !m.cfg.VGA {
+		// The EGA has no write mode 3 and treats it as write mode 2.
+		mode = 2
+	}
+	if mode == 0 || mode == 3 {
+		rotate := m.gcReg[GCDataRotate] & 7
+		data = data>>rotate | data<<(8-rotate)
+	}
+
+	mask := m.gcReg[GCBitMask]
+	if mode == 3 {
+		// Write mode 3 uses the rotated data as bit mask for the set/reset color.
+		mask &= data
+	}
+
+	for i := uint(0); i < 4; i++ {
+		if planes&(1<<i) == 0 {
+			continue
+		}
+
+		var v byte
+		switch mode {
+		case 0:
+			v = data
+			if m.gcReg[GCEnableSetReset]&(1<<i) != 0 {
+				v = expand(m.gcReg[GCSetReset], i)
+			}
+		case 1:
+			m.planes[i][offset] = m.latch[i]
+			continue
+		case 2:
+			v = expand(data, i)
+		case 3:
+			v = expand(m.gcReg[GCSetReset], i)
+		}
+
+		latch := m.latch[i]
+		switch (m.gcReg[GCDataRotate] >> 3) & 3 {
+		case 1:
+			v &= latch
+		case 2:
+			v |= latch
+		case 3:
+			v ^= latch
+		}
+		m.planes[i][offset] = v&mask | latch&^mask
+	}
+}
+
+// expand returns all ones if bit n of v is set and otherwise zero.
+func expand(v byte, n uint) byte {
+	if v&(1<<n) != 0 {
+		return 0xFF
+	}
+	return 0
+}
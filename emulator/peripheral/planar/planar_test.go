@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package planar_test
+
+import (
+	"testing"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/planar"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/planar/planartest"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor"
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+type testCard struct {
+	planar.Adapter
+	vga bool
+}
+
+func (m *testCard) Install(p processor.Processor) error {
+	return m.Setup(p, m, planar.Config{
+		Display:        platform.NewHeadless(),
+		Seed:           1,
+		VGA:            m.vga,
+		ScanlineCycles: func() int { return 152 },
+		Color:          func(c byte) uint32 { return uint32(c) },
+	})
+}
+
+func (m *testCard) Name() string {
+	return "Test Card"
+}
+
+func TestVGAExtensions(t *testing.T) {
+	for _, vga := range []bool{false, true} {
+		p := planartest.NewCPU(t, &testCard{vga: vga})
+
+		// 64KB at 0A0000h with all planes enabled.
+		planartest.SetSeq(p, planar.SeqMapMask, 0xF)
+		planartest.SetSeq(p, planar.SeqMemoryMode, 0x06)
+		planartest.SetGC(p, planar.GCMisc, 0x05)
+		planartest.Clear(p, 4)
+
+		// Chain-4 writes a single plane on the VGA and is ignored by the EGA.
+		planartest.SetSeq(p, planar.SeqMemoryMode, 0x0E)
+		p.WriteByte(planar.MemoryBase+1, 0x12)
+		planartest.SetSeq(p, planar.SeqMemoryMode, 0x06)
+
+		expected := [4]byte{0, 0x12, 0, 0}
+		if !vga {
+			expected = [4]byte{}
+		}
+		if v := planartest.ReadPlanes(p, planar.MemoryBase); v != expected {
+			t.Errorf("chain-4 with VGA %v: %X", vga, v)
+		}
+
+		// Write mode 3 masks the set/reset color on the VGA. The EGA treats it as write mode 2.
+		p.ReadByte(planar.MemoryBase + 2)
+		planartest.SetGC(p, planar.GCSetReset, 0xF)
+		planartest.SetGC(p, planar.GCMode, 3)
+		p.WriteByte(planar.MemoryBase+2, 0x0F)
+		planartest.SetGC(p, planar.GCMode, 0)
+
+		expected = [4]byte{0x0F, 0x0F, 0x0F, 0x0F}
+		if !vga {
+			expected = [4]byte{0xFF, 0xFF, 0xFF, 0xFF}
+		}
+		if v := planartest.ReadPlanes(p, planar.MemoryBase+2); v != expected {
+			t.Errorf("write mode 3 with VGA %v: %X", vga, v)
+		}
+		p.Close()
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+// Package planartest has the helpers that the tests of the adapters built on the
+// planar package use to set video modes and look at the rendered surface.
+package planartest
+
+import (
+	"testing"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/pic"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/planar"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/ram"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor/cpu"
+)
+
+// Mode holds the register values a video BIOS uses for a video mode. The
+// sequencer values start at register 1.
+type Mode struct {
+	Misc                byte
+	Seq, CRTC, Attr, GC []byte
+}
+
+// Set programs the registers in the same order as the BIOS. The CRTC timing
+// registers are unprotected first, which makes no difference on the EGA.
+func (m Mode) Set(p *cpu.CPU) {
+	p.OutByte(0x3C2, m.Misc)
+	for i, v := range m.Seq {
+		p.OutByte(0x3C4, byte(i+1))
+		p.OutByte(0x3C5, v)
+	}
+
+	p.OutByte(0x3D4, 0x11)
+	p.OutByte(0x3D5, 0)
+	for i, v := range m.CRTC {
+		p.OutByte(0x3D4, byte(i))
+		p.OutByte(0x3D5, v)
+	}
+
+	p.InByte(0x3DA)
+	for i, v := range m.Attr {
+		p.OutByte(0x3C0, byte(i))
+		p.OutByte(0x3C0, v)
+	}
+	p.OutByte(0x3C0, 0x20)
+	for i, v := range m.GC {
+		SetGC(p, byte(i), v)
+	}
+}
+
+// NewCPU returns a reset CPU with RAM, an interrupt controller and the card.
+func NewCPU(t *testing.T, card peripheral.Peripheral) *cpu.CPU {
+	p, errs := cpu.NewCPU([]peripheral.Peripheral{
+		&ram.Device{Clear: true},
+		&pic.Device{},
+		card,
+	})
+	for _, err := range errs {
+		t.Fatal(err)
+	}
+	p.Reset()
+	return p
+}
+
+// SetGC sets a register of the graphics controller.
+func SetGC(p *cpu.CPU, index, v byte) {
+	p.OutByte(0x3CE, index)
+	p.OutByte(0x3CF, v)
+}
+
+// SetSeq sets a register of the sequencer.
+func SetSeq(p *cpu.CPU, index, v byte) {
+	p.OutByte(0x3C4, index)
+	p.OutByte(0x3C5, v)
+}
+
+// Clear sets all planes to zero in the first n bytes of the 0A0000h window.
+func Clear(p *cpu.CPU, n int) {
+	SetGC(p, planar.GCEnableSetReset, 0xF)
+	SetGC(p, planar.GCSetReset, 0)
+	for i := 0; i < n; i++ {
+		p.WriteByte(memory.Pointer(planar.MemoryBase+i), 0)
+	}
+	SetGC(p, planar.GCEnableSetReset, 0)
+}
+
+// ReadPlanes reads the byte at addr from each of the planes.
+func ReadPlanes(p *cpu.CPU, addr memory.Pointer) [4]byte {
+	var v [4]byte
+	for i := range v {
+		SetGC(p, planar.GCReadMapSelect, byte(i))
+		v[i] = p.ReadByte(addr)
+	}
+	return v
+}
+
+// Pixel returns the RGB color of a pixel in an RGBA surface.
+func Pixel(pixels []byte, width, x, y int) uint32 {
+	offset := (y*width + x) * 4
+	return uint32(pixels[offset])<<16 | uint32(pixels[offset+1])<<8 | uint32(pixels[offset+2])
+}
@@ -0,0 +1,476 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package planar
+
+import (
+	"flag"
+	"image"
+	"sync/atomic"
+	"time"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/screen"
+)
+
+const (
+	maxWidth     = 720
+	maxHeightEGA = 350
+	maxHeightVGA = 480
+
+	// The surface is blank and 640 pixels wide when the CRTC is not programmed.
+	// It has the height of the 200 line modes on the EGA and of the 400 line modes on the VGA.
+	blankWidth     = 640
+	blankHeightEGA = 200
+	blankHeightVGA = 400
+)
+
+// geometry is the screen layout programmed in the CRTC and sequencer.
+type geometry struct {
+	columns, rows,
+	charWidth, charHeight,
+	lines int
+
+	// doubleWidth is set when the dot clock is halved and doubleScan when every scanline is shown twice.
+	doubleWidth,
+	doubleScan bool
+
+	// start and offset are the memory address of the first row and the distance between rows.
+	start, offset int
+
+	// lineCompare is the last scanline before the display starts over from address zero.
+	lineCompare int
+}
+
+func (g geometry) size() (int, int) {
+	width := g.columns * g.charWidth
+	if g.doubleWidth {
+		width *= 2
+	}
+	return width, g.lines
+}
+
+// blank reports if the CRTC is not programmed to show anything sensible.
+func (g geometry) blank() bool {
+	width, height := g.size()
+	return width < 320 || height < 200
+}
+
+// position returns the memory address of the character row and the scanline within it.
+func (g geometry) position(y int) (int, int) {
+	start := g.start
+	if y > g.lineCompare {
+		start, y = 0, y-g.lineCompare-1
+	}
+	if g.doubleScan {
+		y >>= 1
+	}
+	return start + (y/g.charHeight)*g.offset, y % g.charHeight
+}
+
+// geometry returns the current screen layout. The lock must be held.
+func (m *Adapter) geometry() geometry {
+	g := geometry{
+		columns:     int(m.crtReg[1]) + 1,
+		charWidth:   8,
+		charHeight:  int(m.crtReg[9]&0x1F) + 1,
+		lines:       m.verticalDisplayed(),
+		doubleWidth: m.seqReg[SeqClockingMode]&8 != 0,
+		doubleScan:  m.cfg.VGA && m.crtReg[9]&0x80 != 0,
+		start:       int(m.crtReg[0xC])<<8 | int(m.crtReg[0xD]),
+		offset:      int(m.crtReg[0x13]) * 2,
+		lineCompare: m.lineCompare(),
+	}
+	if !m.graphicsMode() && m.seqReg[SeqClockingMode]&1 == 0 {
+		g.charWidth = 9
+	}
+
+	if width, _ := g.size(); width > maxWidth {
+		g.columns = g.columns * maxWidth / width
+	}
+	maxHeight := maxHeightEGA
+	if m.cfg.VGA {
+		maxHeight = maxHeightVGA
+	}
+	if g.lines > maxHeight {
+		g.lines = maxHeight
+	}
+
+	rowHeight := g.charHeight
+	if g.doubleScan {
+		rowHeight *= 2
+	}
+	g.rows = g.lines / rowHeight
+	return g
+}
+
+// address returns the offset in the planes that the CRTC reads for a memory address
+// counter value on the given scanline of a character row. The lock must be held.
+func (m *Adapter) address(ma, scan int) int {
+	mode := m.crtReg[0x17]
+	addr := ma
+	switch {
+	case m.cfg.VGA && m.crtReg[0x14]&0x40 != 0:
+		// Doubleword mode reads one byte from each plane for every four bytes in chain-4 mode.
+		addr = ma << 2
+	case mode&0x40 == 0:
+		// Word mode. The lowest bit comes from bit 13 or 15 of the counter.
+		bit := uint(13)
+		if mode&0x20 != 0 {
+			bit = 15
+		}
+		addr = ma<<1 | (ma>>bit)&1
+	}
+
+	// Compatibility with the CGA and Hercules memory layouts, where
+	// the row scan counter selects the memory bank.
+	if mode&1 == 0 {
+		addr = addr&^0x2000 | (scan&1)<<13
+	}
+	if mode&2 == 0 {
+		addr = addr&^0x4000 | (scan&2)<<13
+	}
+	return addr & (planeSize - 1)
+}
+
+func blit32(pixels []byte, offset int, color uint32) {
+	pixels[offset] = byte((color & 0xFF0000) >> 16)
+	pixels[offset+1] = byte((color & 0x00FF00) >> 8)
+	pixels[offset+2] = byte(color & 0x0000FF)
+	pixels[offset+3] = 0xFF
+}
+
+func (m *Adapter) blinkTick() bool {
+	return atomic.LoadInt32(&m.atomicBlink) != 0
+}
+
+func (m *Adapter) graphicsMode() bool {
+	return m.attrReg[AttrMode]&1 != 0
+}
+
+func (m *Adapter) blinkEnabled() bool {
+	return m.attrReg[AttrMode]&8 != 0
+}
+
+// color returns the color of a pixel value after the attribute controller palette.
+// The VGA replaces bits of the palette entry with the color select register.
+func (m *Adapter) color(index byte) uint32 {
+	c := m.attrReg[index&m.attrReg[AttrPlaneEnable]&0xF]
+	if m.cfg.VGA {
+		sel := m.attrReg[AttrColorSelect]
+		if m.attrReg[AttrMode]&0x80 != 0 {
+			c = c&0xF | (sel&3)<<4
+		}
+		return m.cfg.Color(c&0x3F | (sel&0xC)<<4)
+	}
+	return m.cfg.Color(c & 0x3F)
+}
+
+func (m *Adapter) cursorPosition() int {
+	return int(m.crtReg[0xE])<<8 | int(m.crtReg[0xF])
+}
+
+// cursorVisible reports if the cursor is enabled in the CRTC cursor start register.
+// The EGA turns it off when bits 5 and 6 are 01.
+func (m *Adapter) cursorVisible() bool {
+	if m.cfg.VGA {
+		return m.crtReg[0xA]&0x20 == 0
+	}
+	return m.crtReg[0xA]&0x60 != 0x20
+}
+
+// glyphLine returns a scanline of the character as 9 pixels, with the first pixel in bit 8.
+// The font is selected by the character map register and bit 3 of the attribute. The EGA
+// has four fonts of 16KB and the VGA eight of 8KB, selected with an extra bit.
+func (m *Adapter) glyphLine(ch, attrib byte, scan int, charWidth int) uint16 {
+	sel := m.seqReg[SeqCharMap]
+	if attrib&8 != 0 {
+		sel = sel>>2&3 | sel>>1&0x10
+	}
+	charMap := sel & 3 << 1
+	if m.cfg.VGA {
+		charMap |= sel >> 4 & 1
+	}
+	line := m.planes[2][(int(charMap)*0x2000+int(ch)*32+scan)&(planeSize-1)]
+
+	glyph := uint16(line) << 1
+	if charWidth == 9 && m.attrReg[AttrMode]&4 != 0 && ch >= 0xC0 && ch <= 0xDF {
+		// Line graphics characters extend into the ninth column.
+		glyph |= uint16(line & 1)
+	}
+
+	// The EGA only underlines when the attribute controller emulates a monochrome display.
+	underline := attrib&0x77 == 1
+	if !m.cfg.VGA {
+		underline = m.attrReg[AttrMode]&2 != 0 && attrib&7 == 1
+	}
+	if underline && scan == int(m.crtReg[0x14]&0x1F) {
+		glyph = 0x1FF
+	}
+	return glyph
+}
+
+// decodeLine fills the line buffer with the pixel colors of a scanline in graphics
+// mode. It decodes one character clock more than the geometry to allow for panning.
+func (m *Adapter) decodeLine(line []uint32, g geometry, ma, scan int) {
+	// Shift register interleave gives the 2 bit pixels of the CGA compatible modes
+	// and the 256 color shift of the VGA gives one byte per pixel.
+	interleave := m.gcReg[GCMode]&0x20 != 0
+	shift256 := m.cfg.VGA && m.gcReg[GCMode]&0x40 != 0
+
+	x := 0
+	for c := 0; c <= g.columns; c++ {
+		addr := m.address(ma+c, scan)
+		p := [4]byte{m.planes[0][addr], m.planes[1][addr], m.planes[2][addr], m.planes[3][addr]}
+
+		if shift256 {
+			for _, v := range p {
+				col := m.cfg.Color(v)
+				line[x], line[x+1] = col, col
+				x += 2
+			}
+			continue
+		}
+
+		for i := uint(0); i < 8; i++ {
+			var index byte
+			if interleave {
+				lo, hi := p[0], p[2]
+				if i >= 4 {
+					lo, hi = p[1], p[3]
+				}
+				shift := 6 - 2*(i&3)
+				index = (lo>>shift)&3 | ((hi>>shift)&3)<<2
+			} else {
+				shift := 7 - i
+				index = (p[0]>>shift)&1 | ((p[1]>>shift)&1)<<1 | ((p[2]>>shift)&1)<<2 | ((p[3]>>shift)&1)<<3
+			}
+			line[x] = m.color(index)
+			x++
+		}
+	}
+}
+
+// renderSurface draws the screen to an RGBA surface of the size given by the geometry.
+// The lock must be held.
+func (m *Adapter) renderSurface(dst []byte, g geometry, blink bool) {
+	screenOff := m.cfg.VGA && m.seqReg[SeqClockingMode]&0x20 != 0
+	if g.blank() || m.attrAddr&0x20 == 0 || screenOff {
+		// The screen is blank while the palette is disconnected from the display
+		// or when the sequencer turns it off.
+		for i := 0; i < len(dst); i += 4 {
+			blit32(dst, i, 0)
+		}
+		return
+	}
+
+	width, _ := g.size()
+	scale := 1
+	if g.doubleWidth {
+		scale = 2
+	}
+
+	if m.graphicsMode() {
+		if n := (g.columns + 1) * 8; len(m.line) < n {
+			m.line = make([]uint32, n)
+		}
+		pan := int(m.attrReg[AttrPanning] & 7)
+
+		for y := 0; y < g.lines; y++ {
+			ma, scan := g.position(y)
+			m.decodeLine(m.line, g, ma, scan)
+
+			offset := y * width * 4
+			for _, col := range m.line[pan : pan+width/scale] {
+				for s := 0; s < scale; s++ {
+					blit32(dst, offset, col)
+					offset += 4
+				}
+			}
+		}
+		return
+	}
+
+	cursor := m.cursorPosition()
+	cursorStart, cursorEnd := int(m.crtReg[0xA]&0x1F), int(m.crtReg[0xB]&0x1F)
+	showCursor := blink && m.cursorVisible()
+
+	for y := 0; y < g.lines; y++ {
+		rowStart, scan := g.position(y)
+		offset := y * width * 4
+
+		for c := 0; c < g.columns; c++ {
+			ma := rowStart + c
+			addr := m.address(ma, scan)
+			ch, attrib := m.planes[0][addr], m.planes[1][addr]
+
+			fgIndex, bgIndex := attrib&0xF, attrib>>4
+			if m.blinkEnabled() {
+				bgIndex &= 7
+				if attrib&0x80 != 0 && blink {
+					fgIndex = bgIndex
+				}
+			}
+			fg, bg := m.color(fgIndex), m.color(bgIndex)
+
+			glyph := m.glyphLine(ch, attrib, scan, g.charWidth)
+			if showCursor && ma == cursor && scan >= cursorStart && scan <= cursorEnd {
+				glyph, fg = 0x1FF, m.color(attrib&0xF)
+			}
+
+			for i := 0; i < g.charWidth; i++ {
+				col := bg
+				if glyph&(0x100>>uint(i)) != 0 {
+					col = fg
+				}
+				for s := 0; s < scale; s++ {
+					blit32(dst, offset, col)
+					offset += 4
+				}
+			}
+		}
+	}
+}
+
+// surfaceSize returns the size of the rendered surface. The lock must be held.
+func (m *Adapter) surfaceSize(g geometry) (int, int) {
+	if !g.blank() {
+		return g.size()
+	}
+	if m.cfg.VGA {
+		return blankWidth, blankHeightVGA
+	}
+	return blankWidth, blankHeightEGA
+}
+
+func (m *Adapter) renderLoop() {
+	p := m.cfg.Display
+	textFlag := flag.Lookup("text")
+	cliMode := textFlag != nil && textFlag.Value.(flag.Getter).Get().(bool)
+
+	ticker := time.NewTicker(time.Second / 30)
+	defer ticker.Stop()
+	defer m.title.Stop()
+
+	for {
+		select {
+		case <-m.quitChan:
+			close(m.quitChan)
+			return
+		case <-ticker.C:
+			m.title.Update(p)
+
+			blink := m.blinkTick()
+			dirtyMemory := atomic.LoadInt32(&m.dirtyMemory) != 0
+
+			if dirtyMemory || m.prevBlink != blink {
+				// The line buffer is written while rendering.
+				m.lock.Lock()
+				atomic.StoreInt32(&m.dirtyMemory, 0)
+				m.prevBlink = blink
+
+				if !m.graphicsMode() && cliMode {
+					if dirtyMemory {
+						t := m.text()
+						p.RenderText(t.Mem, t.Width, false, m.blinkEnabled(), 0, t.CursorX, t.CursorY)
+					}
+					m.lock.Unlock()
+				} else {
+					g := m.geometry()
+					width, height := m.surfaceSize(g)
+					if len(m.surface) != width*height*4 {
+						m.surface = make([]byte, width*height*4)
+					}
+					m.renderSurface(m.surface, g, blink)
+					m.lock.Unlock()
+					p.RenderGraphics(m.surface, width, height, 0, 0, 0)
+				}
+			}
+		}
+	}
+}
+
+// Framebuffer renders the current screen content as RGBA pixels. The size depends
+// on the video mode, for example 640x350 in the high resolution EGA modes and
+// 720x400 in the text modes of the VGA.
+func (m *Adapter) Framebuffer() ([]byte, int, int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	g := m.geometry()
+	width, height := m.surfaceSize(g)
+	pixels := make([]byte, width*height*4)
+	m.renderSurface(pixels, g, m.blinkTick())
+	return pixels, width, height
+}
+
+// Image renders the current screen content. With aspect correction the surface
+// is stretched to 4:3, which is how it looks on the monitor.
+func (m *Adapter) Image(correctAspect bool) *image.RGBA {
+	pixels, w, h := m.Framebuffer()
+	return screen.Image(pixels, w, h, correctAspect)
+}
+
+// TextScreen returns a copy of the character and attribute pairs of the visible
+// text page. Nothing is returned in graphics mode.
+func (m *Adapter) TextScreen() ([]byte, int, int) {
+	if t := m.Text(); t != nil {
+		return t.Mem, t.Width, t.Height
+	}
+	return nil, 0, 0
+}
+
+// Text returns a copy of the visible text page with the cursor position relative
+// to the page. It returns nil in graphics mode.
+func (m *Adapter) Text() *screen.Text {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.graphicsMode() {
+		return nil
+	}
+	return m.text()
+}
+
+// text copies the visible text page from planes 0 and 1. The lock must be held.
+func (m *Adapter) text() *screen.Text {
+	g := m.geometry()
+	t := &screen.Text{
+		Width:   g.columns,
+		Height:  g.rows,
+		Page:    g.start * 2,
+		CursorX: -1,
+		CursorY: -1,
+		Mem:     make([]byte, g.columns*g.rows*2),
+	}
+	for row := 0; row < g.rows; row++ {
+		for c := 0; c < g.columns; c++ {
+			addr := m.address(g.start+row*g.offset+c, 0)
+			i := (row*g.columns + c) * 2
+			t.Mem[i], t.Mem[i+1] = m.planes[0][addr], m.planes[1][addr]
+		}
+	}
+
+	if pos := m.cursorPosition() - g.start; m.cursorVisible() && pos >= 0 && g.offset > 0 {
+		if x, y := pos%g.offset, pos/g.offset; x < g.columns && y < g.rows {
+			t.CursorX, t.CursorY = x, y
+		}
+	}
+	return t
+}
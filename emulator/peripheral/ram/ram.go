@@ -22,8 +22,11 @@ package ram
 
 import (
 	"crypto/rand"
+	"io"
+	mrand "math/rand"
 
 	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
 	"github.com/andreas-jonsson/virtualxt/emulator/processor"
 )
 
@@ -31,12 +34,20 @@ const Size = 0x100000 // 1MB
 
 type Device struct {
 	Clear bool
-	mem   [Size]byte
+
+	// Seed is used to scramble the memory. A zero seed gives different memory each run.
+	Seed int64
+
+	mem [Size]byte
 }
 
 func (m *Device) Install(p processor.Processor) error {
-	if !m.Clear {
-		rand.Read(m.mem[:]) // Scramble memory.
+	if !m.Clear { // Scramble memory.
+		if m.Seed != 0 {
+			mrand.New(mrand.NewSource(m.Seed)).Read(m.mem[:])
+		} else {
+			rand.Read(m.mem[:])
+		}
 	}
 	return p.InstallMemoryDevice(m, 0x0, Size-1)
 }
@@ -48,10 +59,22 @@ func (m *Device) Name() string {
 func (m *Device) Reset() {
 }
 
+func (m *Device) EventDriven() bool {
+	return true
+}
+
 func (m *Device) Step(int) error {
 	return nil
 }
 
+func (m *Device) SaveState(w io.Writer) error {
+	return peripheral.WriteState(w, m.mem[:])
+}
+
+func (m *Device) LoadState(r io.Reader) error {
+	return peripheral.ReadState(r, m.mem[:])
+}
+
 func (m *Device) ReadByte(addr memory.Pointer) byte {
 	return m.mem[addr]
 }
@@ -59,3 +82,7 @@ func (m *Device) ReadByte(addr memory.Pointer) byte {
 func (m *Device) WriteByte(addr memory.Pointer, data byte) {
 	m.mem[addr] = data
 }
+
+func (m *Device) DirectPage(addr memory.Pointer) ([]byte, bool) {
+	return m.mem[addr : addr+memory.PageSize], false
+}
@@ -54,14 +54,29 @@ func (m *Device) Name() string {
 func (m *Device) Reset() {
 }
 
+func (m *Device) EventDriven() bool {
+	return true
+}
+
 func (m *Device) Step(int) error {
 	return nil
 }
 
 func (m *Device) ReadByte(addr memory.Pointer) byte {
-	return m.mem[addr-m.Base]
+	// The last page can be partially covered by the ROM.
+	if offset := int(addr - m.Base); addr >= m.Base && offset < len(m.mem) {
+		return m.mem[offset]
+	}
+	return 0xFF
 }
 
 func (m *Device) WriteByte(addr memory.Pointer, data byte) {
 	//log.Printf("don't write to ROM! %v <- 0x%X", addr, data)
 }
+
+func (m *Device) DirectPage(addr memory.Pointer) ([]byte, bool) {
+	if offset := int(addr - m.Base); addr >= m.Base && offset+memory.PageSize <= len(m.mem) {
+		return m.mem[offset : offset+memory.PageSize], true
+	}
+	return nil, true
+}
@@ -23,7 +23,10 @@ package smouse
 import (
 	"bytes"
 	"flag"
+	"io"
 
+	"github.com/andreas-jonsson/virtualxt/emulator/input"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
 	"github.com/andreas-jonsson/virtualxt/emulator/processor"
 	"github.com/andreas-jonsson/virtualxt/platform"
 )
@@ -31,6 +34,7 @@ import (
 const (
 	maxBufferSize = 16
 	maxNumEvents  = 128
+	pollCycles    = 4770
 )
 
 type mouseEvent struct {
@@ -42,10 +46,18 @@ type Device struct {
 	BasePort uint16
 	IRQ      int
 
+	// Platform delivers mouse input from the host.
+	Platform platform.Platform
+
+	// Journal records or replays the mouse events delivered to the machine.
+	Journal *input.Journal
+
 	registers [8]byte
 	events    chan mouseEvent
+	pending   []mouseEvent
 	buffer    bytes.Buffer
 	pic       processor.InterruptController
+	scheduler *processor.Scheduler
 }
 
 func (m *Device) Install(p processor.Processor) error {
@@ -54,9 +66,11 @@ func (m *Device) Install(p processor.Processor) error {
 	}
 
 	m.pic = p.GetInterruptController()
+	m.scheduler = p.GetScheduler()
 	m.events = make(chan mouseEvent, maxNumEvents)
 
-	platform.Instance.SetMouseHandler(m.eventHandler)
+	m.Platform.SetMouseHandler(m.MouseEvent)
+	m.scheduler.Every(pollCycles, m.update)
 	return p.InstallIODevice(m, m.BasePort, m.BasePort+7)
 }
 
@@ -71,25 +85,80 @@ func (m *Device) Reset() {
 	}
 }
 
+func (m *Device) EventDriven() bool {
+	return true
+}
+
 func (m *Device) Step(int) error {
-	for {
-		select {
-		case ev := <-m.events:
-			var upper byte
-			if ev.xrel < 0 {
-				upper = 0x3
-			}
-			if ev.yrel < 0 {
-				upper |= 0xC
+	return nil
+}
+
+func (m *Device) SaveState(w io.Writer) error {
+	return peripheral.WriteState(w, &m.registers, uint8(m.buffer.Len()), m.buffer.Bytes())
+}
+
+func (m *Device) LoadState(r io.Reader) error {
+	var size uint8
+	if err := peripheral.ReadState(r, &m.registers, &size); err != nil {
+		return err
+	}
+
+	data := make([]byte, size)
+	if err := peripheral.ReadState(r, data); err != nil {
+		return err
+	}
+	m.buffer.Reset()
+	m.buffer.Write(data)
+	return nil
+}
+
+func (m *Device) update() error {
+	cycle := m.scheduler.Cycles()
+	if m.Journal.Replaying() {
+		for {
+			data, ok := m.Journal.Next(input.Mouse, cycle)
+			if !ok {
+				return nil
 			}
+			m.pushEvent(mouseEvent{data[0], int8(data[1]), int8(data[2])})
+		}
+	}
 
-			m.pushData(0x40 | ((ev.buttons & 3) << 4) | upper)
-			m.pushData(byte(ev.xrel & 0x3F))
-			m.pushData(byte(ev.yrel & 0x3F))
+	for done := false; !done; {
+		select {
+		case ev := <-m.events:
+			m.deliver(cycle, ev)
 		default:
-			return nil
+			done = true
 		}
 	}
+
+	// The mouse is powered by DTR. Without a driver nobody would read the queued packets.
+	if m.registers[4]&1 == 0 {
+		m.pending = nil
+	} else if len(m.pending) > 0 && m.buffer.Len() == 0 {
+		m.deliver(cycle, m.pending[0])
+		m.pending = m.pending[1:]
+	}
+	return nil
+}
+
+func (m *Device) deliver(cycle int64, ev mouseEvent) {
+	m.Journal.Record(input.Mouse, cycle, [3]byte{ev.buttons, byte(ev.xrel), byte(ev.yrel)})
+	m.pushEvent(ev)
+}
+
+func (m *Device) pushEvent(ev mouseEvent) {
+	// Packets that don't fit are dropped as a whole.
+	if m.buffer.Len()+3 > maxBufferSize {
+		return
+	}
+
+	// Bit 6 and 7 of the movements are sent in the first byte.
+	x, y := byte(ev.xrel), byte(ev.yrel)
+	m.pushData(0x40 | ((ev.buttons & 3) << 4) | (y>>6)<<2 | x>>6)
+	m.pushData(x & 0x3F)
+	m.pushData(y & 0x3F)
 }
 
 func (m *Device) pushData(data byte) {
@@ -103,7 +172,46 @@ func (m *Device) pushData(data byte) {
 	m.buffer.WriteByte(data)
 }
 
-func (m *Device) eventHandler(buttons byte, xrel, yrel int8) {
+// Move queues a relative mouse movement and the button state that are delivered
+// after host input. Movements are split into as many packets as needed and a
+// packet is only sent when the guest has read the previous one. Move must be
+// called from the goroutine that steps the machine.
+func (m *Device) Move(buttons byte, xrel, yrel int) {
+	if m.Journal.Replaying() {
+		return
+	}
+
+	for {
+		ev := mouseEvent{buttons, clamp(xrel), clamp(yrel)}
+		m.pending = append(m.pending, ev)
+		xrel -= int(ev.xrel)
+		yrel -= int(ev.yrel)
+		if xrel == 0 && yrel == 0 {
+			return
+		}
+	}
+}
+
+// Moving reports if there are packets from Move left to deliver.
+func (m *Device) Moving() bool {
+	return len(m.pending) > 0
+}
+
+func clamp(v int) int8 {
+	if v > 127 {
+		return 127
+	} else if v < -127 {
+		return -127
+	}
+	return int8(v)
+}
+
+// MouseEvent queues a relative mouse movement and the button state for delivery to the machine.
+func (m *Device) MouseEvent(buttons byte, xrel, yrel int8) {
+	if m.Journal.Replaying() {
+		return
+	}
+
 	select {
 	case m.events <- mouseEvent{buttons, xrel, yrel}:
 	default:
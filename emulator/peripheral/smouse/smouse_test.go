@@ -0,0 +1,114 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package smouse
+
+import (
+	"testing"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/pic"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/ram"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor/cpu"
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+const basePort = 0x3F8
+
+func newTestCPU(t *testing.T) (*cpu.CPU, *Device) {
+	d := &Device{BasePort: basePort, IRQ: 4, Platform: platform.NewHeadless()}
+	p, errs := cpu.NewCPU([]peripheral.Peripheral{
+		&ram.Device{Clear: true},
+		&pic.Device{},
+		d,
+	})
+	for _, err := range errs {
+		t.Fatal(err)
+	}
+	p.Reset()
+	return p, d
+}
+
+// readPort returns the bytes waiting in the receive buffer of COM1.
+func readPort(p *cpu.CPU) []byte {
+	var data []byte
+	for p.InByte(basePort+5)&1 != 0 {
+		data = append(data, p.InByte(basePort))
+	}
+	return data
+}
+
+func TestMove(t *testing.T) {
+	p, d := newTestCPU(t)
+	defer p.Close()
+
+	// The driver powers the mouse with DTR and it answers with M.
+	p.OutByte(basePort+4, 1)
+	if data := readPort(p); string(data) != "M" {
+		t.Fatalf("expected M, got %q", data)
+	}
+
+	d.Move(1, 300, -5)
+
+	var x, y, packets int
+	for i := 0; i < 100 && d.Moving(); i++ {
+		if err := p.GetScheduler().Advance(pollCycles); err != nil {
+			t.Fatal(err)
+		}
+
+		data := readPort(p)
+		if len(data) != 3 {
+			t.Fatalf("expected one packet, got %d bytes", len(data))
+		}
+		if data[0]&0x40 == 0 || data[1]&0x40 != 0 || data[2]&0x40 != 0 {
+			t.Fatalf("invalid packet: %v", data)
+		}
+		if buttons := (data[0] >> 4) & 3; buttons != 1 {
+			t.Errorf("expected button 1, got %d", buttons)
+		}
+		x += int(int8(data[0]<<6 | data[1]))
+		y += int(int8((data[0]&0xC)<<4 | data[2]))
+		packets++
+	}
+	if x != 300 || y != -5 || packets != 3 {
+		t.Errorf("expected 300,-5 in 3 packets, got %d,%d in %d packets", x, y, packets)
+	}
+
+	// Only one packet is sent until the guest reads it.
+	d.Move(0, 1000, 0)
+	for i := 0; i < 10; i++ {
+		if err := p.GetScheduler().Advance(pollCycles); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data := readPort(p); len(data) != 3 {
+		t.Errorf("expected one packet, got %d bytes", len(data))
+	}
+
+	// Nothing is sent without power.
+	p.OutByte(basePort+4, 0)
+	readPort(p)
+	if err := p.GetScheduler().Advance(pollCycles); err != nil {
+		t.Fatal(err)
+	}
+	if data := readPort(p); len(data) != 0 || d.Moving() {
+		t.Errorf("expected no data, got %q", data)
+	}
+}
@@ -21,10 +21,10 @@ freely, subject to the following restrictions:
 package speaker
 
 import (
+	"io"
 	"log"
-	"sync"
-	"time"
 
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
 	"github.com/andreas-jonsson/virtualxt/emulator/processor"
 	"github.com/andreas-jonsson/virtualxt/platform"
 )
@@ -39,19 +39,28 @@ type pitInterface interface {
 	GetFrequency(channel int) float64
 }
 
+// recordSpec is the audio format used for recordings if the platform has no audio.
+var recordSpec = platform.AudioSpec{Freq: 44100, Channels: 1, Samples: 512}
+
 type Device struct {
-	cpu   processor.Processor
-	pit   pitInterface
-	pInst platform.Platform
+	// Platform plays the generated audio.
+	Platform platform.Platform
+
+	cpu processor.Processor
+	pit pitInterface
+
+	spec        platform.AudioSpec
+	hasAudio    bool
+	soundBuffer []byte
 
-	sampleIndex          uint64
-	toneHz, toneHzBuffer float64
+	recorder     io.Writer
+	recordBuffer []byte
+
+	sampleIndex uint64
+	toneHz      float64
 
 	enabled, turbo bool
 	port           byte
-
-	lock     sync.Mutex
-	quitChan chan struct{}
 }
 
 func nextPow(v uint16) uint16 {
@@ -65,12 +74,51 @@ func nextPow(v uint16) uint16 {
 }
 
 func (m *Device) Install(p processor.Processor) error {
-	m.pInst = platform.Instance
 	m.cpu = p
-	m.startUpdateLoop()
+
+	var ok bool
+	if m.pit, ok = p.GetMappedIODevice(0x40).(pitInterface); !ok {
+		log.Print("could not find PIT")
+	} else {
+		// Audio is generated in emulated time, one buffer at the time.
+		// We keep generating without platform audio so the sound can be recorded.
+		m.spec = recordSpec
+		if m.hasAudio = m.Platform.HasAudio(); m.hasAudio {
+			m.spec = m.Platform.AudioSpec()
+		}
+		m.soundBuffer = make([]byte, m.spec.Samples*m.spec.Channels)
+		m.recordBuffer = make([]byte, len(m.soundBuffer))
+		p.GetScheduler().Every(processor.ClockFrequency*int64(m.spec.Samples)/int64(m.spec.Freq), m.update)
+	}
 	return p.InstallIODeviceAt(m, 0x61)
 }
 
+// AudioSpec returns the format of the generated audio.
+func (m *Device) AudioSpec() platform.AudioSpec {
+	return m.spec
+}
+
+// SetRecorder sends all generated audio, including silence, to w as unsigned
+// 8-bit PCM in the format given by AudioSpec. Write errors are ignored so w
+// should keep track of them. A nil writer stops the recording.
+func (m *Device) SetRecorder(w io.Writer) {
+	m.recorder = w
+}
+
+func (m *Device) record(samples []byte) {
+	if m.recorder == nil {
+		return
+	}
+	for i := range m.recordBuffer {
+		if samples == nil {
+			m.recordBuffer[i] = 0x80
+		} else {
+			m.recordBuffer[i] = samples[i] ^ 0x80 // Signed to unsigned.
+		}
+	}
+	m.recorder.Write(m.recordBuffer)
+}
+
 func (m *Device) TurboSwitch() bool {
 	return m.turbo
 }
@@ -80,97 +128,66 @@ func (m *Device) Name() string {
 }
 
 func (m *Device) Reset() {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
 	m.sampleIndex = 0
 	m.toneHz = 0
-	m.toneHzBuffer = 0
 	m.port = 4
 	m.turbo = true
 	m.enabled = false
 
-	m.pInst.EnableAudio(false)
+	m.Platform.EnableAudio(false)
 }
 
-func (m *Device) startUpdateLoop() {
-	m.quitChan = make(chan struct{})
+func (m *Device) EventDriven() bool {
+	return true
+}
 
-	if m.pit == nil {
-		var ok bool
-		if m.pit, ok = m.cpu.GetMappedIODevice(0x40).(pitInterface); !ok {
-			log.Print("could not find PIT")
-			return
-		}
+func (m *Device) Step(int) error {
+	return nil
+}
+
+func (m *Device) SaveState(w io.Writer) error {
+	return peripheral.WriteState(w, m.sampleIndex, m.toneHz, m.enabled, m.turbo, m.port)
+}
+
+func (m *Device) LoadState(r io.Reader) error {
+	if err := peripheral.ReadState(r, &m.sampleIndex, &m.toneHz, &m.enabled, &m.turbo, &m.port); err != nil {
+		return err
+	}
+	m.Platform.EnableAudio(m.enabled)
+	return nil
+}
+
+func (m *Device) update() error {
+	m.toneHz = m.pit.GetFrequency(2)
+	if !m.enabled || m.toneHz == 0 {
+		m.record(nil)
+		return nil
 	}
 
-	go func() {
-		if !m.pInst.HasAudio() {
-			<-m.quitChan
-			close(m.quitChan)
-			return
+	squareWavePeriod := uint64(float64(m.spec.Freq) / m.toneHz)
+	halfSquareWavePeriod := squareWavePeriod / 2
+	if halfSquareWavePeriod == 0 {
+		m.record(nil)
+		return nil
+	}
+
+	var ptr int
+	for i := 0; i < m.spec.Samples; i++ {
+		var sampleValue int8 = -toneVolume
+		if m.sampleIndex++; (m.sampleIndex/halfSquareWavePeriod)%2 != 0 {
+			sampleValue = toneVolume
 		}
 
-		spec := m.pInst.AudioSpec()
-		numSamples := spec.Samples
-		bytesPerSample := spec.Channels
-		bytesToWrite := numSamples * bytesPerSample
-
-		soundBuffer := make([]byte, bytesToWrite)
-		sampleCount := bytesToWrite / bytesPerSample
-
-		ticker := time.NewTicker(time.Second / time.Duration(spec.Freq/numSamples))
-		defer ticker.Stop()
-
-	loop:
-		for {
-			select {
-			case <-m.quitChan:
-				close(m.quitChan)
-				return
-			case <-ticker.C:
-				m.lock.Lock()
-
-				if !m.enabled || m.toneHz == 0 {
-					m.lock.Unlock()
-					continue loop
-				}
-
-				squareWavePeriod := uint64(float64(spec.Freq) / m.toneHz)
-				halfSquareWavePeriod := squareWavePeriod / 2
-				if halfSquareWavePeriod == 0 {
-					m.lock.Unlock()
-					continue loop
-				}
-
-				var ptr int
-				for i := 0; i < sampleCount; i++ {
-					var sampleValue int8 = -toneVolume
-					if m.sampleIndex++; (m.sampleIndex/halfSquareWavePeriod)%2 != 0 {
-						sampleValue = toneVolume
-					}
-
-					for j := 0; j < spec.Channels; j++ {
-						soundBuffer[ptr] = byte(sampleValue)
-						ptr++
-					}
-				}
-
-				m.lock.Unlock()
-
-				m.pInst.QueueAudio(soundBuffer)
-			}
+		for j := 0; j < m.spec.Channels; j++ {
+			m.soundBuffer[ptr] = byte(sampleValue)
+			ptr++
 		}
-	}()
-}
+	}
 
-func (m *Device) Step(int) error {
-	if toneHz := m.pit.GetFrequency(2); toneHz != m.toneHzBuffer {
-		m.lock.Lock()
-		m.toneHzBuffer = toneHz
-		m.toneHz = toneHz
-		m.lock.Unlock()
+	if m.hasAudio {
+		m.Platform.QueueAudio(m.soundBuffer)
 	}
+	m.record(m.soundBuffer)
 	return nil
 }
 
@@ -179,9 +196,6 @@ func (m *Device) In(port uint16) byte {
 }
 
 func (m *Device) Out(_ uint16, data byte) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
 	m.port = data
 	turbo := data&4 != 0
 
@@ -193,12 +207,6 @@ func (m *Device) Out(_ uint16, data byte) {
 	if b := data&3 == 3; b != m.enabled {
 		m.enabled = b
 		m.sampleIndex = 0
-		m.pInst.EnableAudio(b)
+		m.Platform.EnableAudio(b)
 	}
 }
-
-func (m *Device) Close() error {
-	m.quitChan <- struct{}{}
-	<-m.quitChan
-	return nil
-}
@@ -0,0 +1,173 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+// Package vga emulates an IBM Video Graphics Array on an 8-bit card with 256KB
+// of memory. The video modes are set up by the BIOS on the card, which needs to
+// be mapped at C000:0.
+package vga
+
+import (
+	"image/color"
+	"io"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/planar"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor"
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+const (
+	dacSize        = 256
+	scanlineCycles = 152 // 31.78us at 4.77MHz.
+)
+
+type Device struct {
+	// Display shows the rendered screen.
+	Display platform.Display
+
+	// Seed is used to scramble the video memory. A zero seed gives different memory each run.
+	Seed int64
+
+	featureCtrl byte
+
+	// The DAC has 6 bits for each of red, green and blue. Entries are read and
+	// written one color component at a time.
+	dac                 [dacSize][3]byte
+	dacMask, dacState   byte
+	dacRead, dacWrite   byte
+	dacReadC, dacWriteC byte
+	dacWriteBuffer      [3]byte
+
+	planar.Adapter
+}
+
+func (m *Device) Install(p processor.Processor) error {
+	return m.Setup(p, m, planar.Config{
+		Display:        m.Display,
+		Seed:           m.Seed,
+		VGA:            true,
+		ScanlineCycles: func() int { return scanlineCycles },
+		Color:          m.dacColor,
+		In:             m.in,
+		Out:            m.out,
+		Reset:          m.reset,
+		SaveState:      m.saveState,
+		LoadState:      m.loadState,
+	})
+}
+
+func (m *Device) Name() string {
+	return "Video Graphics Array"
+}
+
+func (m *Device) reset() {
+	m.dacMask = 0xFF
+}
+
+func (m *Device) saveState(w io.Writer) error {
+	return peripheral.WriteState(w,
+		m.featureCtrl, &m.dac, m.dacMask, m.dacState,
+		m.dacRead, m.dacWrite, m.dacReadC, m.dacWriteC, &m.dacWriteBuffer,
+	)
+}
+
+func (m *Device) loadState(r io.Reader) error {
+	return peripheral.ReadState(r,
+		&m.featureCtrl, &m.dac, &m.dacMask, &m.dacState,
+		&m.dacRead, &m.dacWrite, &m.dacReadC, &m.dacWriteC, &m.dacWriteBuffer,
+	)
+}
+
+// dacColor returns the color of a DAC entry, with the 6 bit components scaled to 8 bits.
+func (m *Device) dacColor(index byte) uint32 {
+	c := m.dac[index&m.dacMask]
+	level := func(v byte) uint32 {
+		return uint32(v<<2 | v>>4)
+	}
+	return level(c[0])<<16 | level(c[1])<<8 | level(c[2])
+}
+
+func (m *Device) in(port uint16) (byte, bool) {
+	switch port {
+	case 0x3C2:
+		// Input status 0. Bit 4 is the monitor sense, which is set for a color monitor.
+		return 0x10, true
+	case 0x3C6:
+		return m.dacMask, true
+	case 0x3C7:
+		return m.dacState, true
+	case 0x3C8:
+		return m.dacWrite, true
+	case 0x3C9:
+		v := m.dac[m.dacRead][m.dacReadC]
+		if m.dacReadC++; m.dacReadC == 3 {
+			m.dacReadC = 0
+			m.dacRead++
+		}
+		return v, true
+	case 0x3CA:
+		return m.featureCtrl, true
+	}
+	return 0, false
+}
+
+func (m *Device) out(port uint16, data byte) bool {
+	switch port {
+	case 0x3C6:
+		m.dacMask = data
+	case 0x3C7:
+		m.dacRead, m.dacReadC, m.dacState = data, 0, 3
+	case 0x3C8:
+		m.dacWrite, m.dacWriteC, m.dacState = data, 0, 0
+	case 0x3C9:
+		// An entry is changed when all three components are written.
+		m.dacWriteBuffer[m.dacWriteC] = data & 0x3F
+		if m.dacWriteC++; m.dacWriteC == 3 {
+			m.dac[m.dacWrite] = m.dacWriteBuffer
+			m.dacWriteC = 0
+			m.dacWrite++
+		}
+	case 0x3DA:
+		m.featureCtrl = data
+	default:
+		return false
+	}
+	return true
+}
+
+// Palette returns colors that approximate the rendered surfaces. The DAC can be
+// changed at any time, so this is the 64 EGA colors followed by a 8x8x3 color cube.
+func Palette() color.Palette {
+	palette := make(color.Palette, 0, 256)
+	level := func(i, secondary int) byte {
+		return byte((i>>uint(secondary-3))&1*0xAA + (i>>uint(secondary))&1*0x55)
+	}
+	for i := 0; i < 64; i++ {
+		palette = append(palette, color.RGBA{level(i, 5), level(i, 4), level(i, 3), 0xFF})
+	}
+	for r := 0; r < 8; r++ {
+		for g := 0; g < 8; g++ {
+			for b := 0; b < 3; b++ {
+				palette = append(palette, color.RGBA{byte(r * 255 / 7), byte(g * 255 / 7), byte(b * 255 / 2), 0xFF})
+			}
+		}
+	}
+	return palette
+}
@@ -0,0 +1,323 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package vga
+
+import (
+	"testing"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/planar"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/planar/planartest"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor/cpu"
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+// modes holds the register values the IBM VGA BIOS uses for the video modes.
+var modes = map[int]planartest.Mode{
+	0x3: {
+		Misc: 0x67,
+		Seq:  []byte{0x00, 0x03, 0x00, 0x02},
+		CRTC: []byte{0x5F, 0x4F, 0x50, 0x82, 0x55, 0x81, 0xBF, 0x1F, 0x00, 0x4F, 0x0D, 0x0E, 0x00, 0x00, 0x00, 0x00, 0x9C, 0x8E, 0x8F, 0x28, 0x1F, 0x96, 0xB9, 0xA3, 0xFF},
+		Attr: []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x14, 0x07, 0x38, 0x39, 0x3A, 0x3B, 0x3C, 0x3D, 0x3E, 0x3F, 0x0C, 0x00, 0x0F, 0x08, 0x00},
+		GC:   []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x0E, 0x00, 0xFF},
+	},
+	0x12: {
+		Misc: 0xE3,
+		Seq:  []byte{0x01, 0x0F, 0x00, 0x06},
+		CRTC: []byte{0x5F, 0x4F, 0x50, 0x82, 0x54, 0x80, 0x0B, 0x3E, 0x00, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xEA, 0x8C, 0xDF, 0x28, 0x00, 0xE7, 0x04, 0xE3, 0xFF},
+		Attr: []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x14, 0x07, 0x38, 0x39, 0x3A, 0x3B, 0x3C, 0x3D, 0x3E, 0x3F, 0x01, 0x00, 0x0F, 0x00, 0x00},
+		GC:   []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x0F, 0xFF},
+	},
+	0x13: {
+		Misc: 0x63,
+		Seq:  []byte{0x01, 0x0F, 0x00, 0x0E},
+		CRTC: []byte{0x5F, 0x4F, 0x50, 0x82, 0x54, 0x80, 0xBF, 0x1F, 0x00, 0x41, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x9C, 0x8E, 0x8F, 0x28, 0x40, 0x96, 0xB9, 0xA3, 0xFF},
+		Attr: []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x41, 0x00, 0x0F, 0x00, 0x00},
+		GC:   []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0x05, 0x0F, 0xFF},
+	},
+}
+
+// modeX is the unchained 320x240 mode.
+var modeX = planartest.Mode{
+	Misc: 0xE3,
+	Seq:  []byte{0x01, 0x0F, 0x00, 0x06},
+	CRTC: []byte{0x5F, 0x4F, 0x50, 0x82, 0x54, 0x80, 0x0D, 0x3E, 0x00, 0x41, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xEA, 0xAC, 0xDF, 0x28, 0x00, 0xE7, 0x06, 0xE3, 0xFF},
+	Attr: modes[0x13].Attr,
+	GC:   modes[0x13].GC,
+}
+
+func setDAC(p *cpu.CPU, index byte, rgb ...byte) {
+	p.OutByte(0x3C8, index)
+	for _, v := range rgb {
+		p.OutByte(0x3C9, v)
+	}
+}
+
+func TestRegisters(t *testing.T) {
+	p := planartest.NewCPU(t, &Device{Display: platform.NewHeadless(), Seed: 1})
+	defer p.Close()
+
+	// All entries are written and read as three 6 bit values in a row.
+	setDAC(p, 5, 0x3F, 0x20, 0x01, 0x02, 0x03, 0x04)
+	if v := p.InByte(0x3C8); v != 7 {
+		t.Errorf("unexpected write index: %d", v)
+	}
+	p.OutByte(0x3C7, 5)
+	if v := p.InByte(0x3C7); v != 3 {
+		t.Errorf("unexpected DAC state: %d", v)
+	}
+	var rgb []byte
+	for i := 0; i < 6; i++ {
+		rgb = append(rgb, p.InByte(0x3C9))
+	}
+	if string(rgb) != "\x3F\x20\x01\x02\x03\x04" {
+		t.Errorf("unexpected DAC entries: %X", rgb)
+	}
+
+	// Registers can be read back.
+	modes[0x13].Set(p)
+	p.OutByte(0x3C4, planar.SeqMemoryMode)
+	if v := p.InByte(0x3C5); v != 0x0E {
+		t.Errorf("unexpected sequencer register: %X", v)
+	}
+	if v := p.InByte(0x3CC); v != 0x63 {
+		t.Errorf("unexpected misc output register: %X", v)
+	}
+	p.InByte(0x3DA)
+	p.OutByte(0x3C0, planar.AttrMode|0x20)
+	if v := p.InByte(0x3C1); v != 0x41 {
+		t.Errorf("unexpected attribute register: %X", v)
+	}
+
+	// The timing registers are protected, except for the line compare bit.
+	p.OutByte(0x3D4, 1)
+	p.OutByte(0x3D5, 0x27)
+	p.OutByte(0x3D4, 7)
+	p.OutByte(0x3D5, 0)
+	if v := p.InByte(0x3D5); v != 0x0F {
+		t.Errorf("unexpected overflow register: %X", v)
+	}
+	p.OutByte(0x3D4, 1)
+	if v := p.InByte(0x3D5); v != 0x4F {
+		t.Errorf("protected register was changed: %X", v)
+	}
+}
+
+func TestWriteMode3(t *testing.T) {
+	p := planartest.NewCPU(t, &Device{Display: platform.NewHeadless(), Seed: 1})
+	defer p.Close()
+
+	modes[0x12].Set(p)
+	planartest.SetGC(p, planar.GCEnableSetReset, 0xF)
+	p.WriteByte(planar.MemoryBase, 0)
+	planartest.SetGC(p, planar.GCEnableSetReset, 0)
+
+	planartest.SetGC(p, planar.GCSetReset, 0x5)
+	planartest.SetGC(p, planar.GCMode, 3)
+	planartest.SetGC(p, planar.GCBitMask, 0xF0)
+
+	// The rotated data masks the set/reset color.
+	p.ReadByte(planar.MemoryBase)
+	planartest.SetGC(p, planar.GCDataRotate, 4)
+	p.WriteByte(planar.MemoryBase, 0x3C)
+
+	planartest.SetGC(p, planar.GCMode, 0)
+	for i, expected := range []byte{0xC0, 0x00, 0xC0, 0x00} {
+		planartest.SetGC(p, planar.GCReadMapSelect, byte(i))
+		if v := p.ReadByte(planar.MemoryBase) & 0xF0; v != expected {
+			t.Errorf("unexpected value in plane %d: %X", i, v)
+		}
+	}
+}
+
+func TestMode13h(t *testing.T) {
+	d := &Device{Display: platform.NewHeadless(), Seed: 1}
+	p := planartest.NewCPU(t, d)
+	defer p.Close()
+
+	modes[0x13].Set(p)
+	setDAC(p, 1, 0x3F, 0, 0)
+	setDAC(p, 2, 0, 0x2A, 0x15)
+	for i := 0; i < 320*200; i++ {
+		p.WriteByte(memory.Pointer(planar.MemoryBase+i), 0)
+	}
+	p.WriteByte(planar.MemoryBase, 1)
+	p.WriteByte(planar.MemoryBase+1, 2)
+	p.WriteByte(planar.MemoryBase+320*200-1, 2)
+
+	if v := p.ReadByte(planar.MemoryBase + 1); v != 2 {
+		t.Errorf("unexpected value: %d", v)
+	}
+
+	// Pixels are doubled in both directions.
+	pixels, w, h := d.Framebuffer()
+	if w != 640 || h != 400 {
+		t.Fatalf("unexpected surface size: %dx%d", w, h)
+	}
+	for _, pos := range [][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		if c := planartest.Pixel(pixels, w, pos[0], pos[1]); c != 0xFF0000 {
+			t.Errorf("invalid color at %v: %06X", pos, c)
+		}
+	}
+	if c := planartest.Pixel(pixels, w, 2, 0); c != 0x00AA55 {
+		t.Errorf("invalid color: %06X", c)
+	}
+	if c := planartest.Pixel(pixels, w, 639, 399); c != 0x00AA55 {
+		t.Errorf("invalid color: %06X", c)
+	}
+	if c := planartest.Pixel(pixels, w, 4, 0); c != 0 {
+		t.Errorf("invalid color: %06X", c)
+	}
+
+	// The pel mask applies to the DAC index.
+	p.OutByte(0x3C6, 0xFE)
+	pixels, _, _ = d.Framebuffer()
+	if c := planartest.Pixel(pixels, w, 0, 0); c != 0 {
+		t.Errorf("invalid color with pel mask: %06X", c)
+	}
+}
+
+func TestModeX(t *testing.T) {
+	d := &Device{Display: platform.NewHeadless(), Seed: 1}
+	p := planartest.NewCPU(t, d)
+	defer p.Close()
+
+	modeX.Set(p)
+	setDAC(p, 3, 0x3F, 0x3F, 0x3F)
+
+	// Clear both pages in all planes.
+	for i := 0; i < 80*480; i++ {
+		p.WriteByte(memory.Pointer(planar.MemoryBase+i), 0)
+	}
+
+	// Pixel 1 is in plane 1 and the second page starts after 80*240 bytes.
+	planartest.SetSeq(p, planar.SeqMapMask, 2)
+	p.WriteByte(planar.MemoryBase, 3)
+	p.WriteByte(planar.MemoryBase+80*240+80, 3)
+
+	pixels, w, h := d.Framebuffer()
+	if w != 640 || h != 480 {
+		t.Fatalf("unexpected surface size: %dx%d", w, h)
+	}
+	if c := planartest.Pixel(pixels, w, 2, 1); c != 0xFFFFFF {
+		t.Errorf("invalid color: %06X", c)
+	}
+	if c := planartest.Pixel(pixels, w, 2, 2); c != 0 {
+		t.Errorf("invalid color: %06X", c)
+	}
+
+	// Flip to the second page.
+	start := 80 * 240
+	p.OutByte(0x3D4, 0xC)
+	p.OutByte(0x3D5, byte(start>>8))
+	p.OutByte(0x3D4, 0xD)
+	p.OutByte(0x3D5, byte(start))
+
+	pixels, _, _ = d.Framebuffer()
+	if c := planartest.Pixel(pixels, w, 2, 0); c != 0 {
+		t.Errorf("invalid color: %06X", c)
+	}
+	if c := planartest.Pixel(pixels, w, 2, 2); c != 0xFFFFFF {
+		t.Errorf("invalid color: %06X", c)
+	}
+}
+
+func TestMode12h(t *testing.T) {
+	d := &Device{Display: platform.NewHeadless(), Seed: 1}
+	p := planartest.NewCPU(t, d)
+	defer p.Close()
+
+	modes[0x12].Set(p)
+	setDAC(p, 0x14, 0x2A, 0x15, 0)
+
+	planartest.SetGC(p, planar.GCEnableSetReset, 0xF)
+	planartest.SetGC(p, planar.GCSetReset, 0)
+	for i := 0; i < 80*480; i++ {
+		p.WriteByte(memory.Pointer(planar.MemoryBase+i), 0)
+	}
+	planartest.SetGC(p, planar.GCEnableSetReset, 0)
+
+	planartest.SetGC(p, planar.GCMode, 2)
+	planartest.SetGC(p, planar.GCBitMask, 0x01)
+	p.WriteByte(planar.MemoryBase+80*479+79, 6)
+
+	pixels, w, h := d.Framebuffer()
+	if w != 640 || h != 480 {
+		t.Fatalf("unexpected surface size: %dx%d", w, h)
+	}
+	if c := planartest.Pixel(pixels, w, 639, 479); c != 0xAA5500 {
+		t.Errorf("invalid color: %06X", c)
+	}
+	if c := planartest.Pixel(pixels, w, 638, 479); c != 0 {
+		t.Errorf("invalid color: %06X", c)
+	}
+}
+
+func TestText(t *testing.T) {
+	d := &Device{Display: platform.NewHeadless(), Seed: 1}
+	p := planartest.NewCPU(t, d)
+	defer p.Close()
+
+	// Load glyphs in plane 2, the way the BIOS does. The box drawing
+	// character has its last column set, which extends into the ninth column.
+	modes[0x3].Set(p)
+	planartest.SetSeq(p, planar.SeqMapMask, 4)
+	planartest.SetSeq(p, planar.SeqMemoryMode, 6)
+	planartest.SetGC(p, planar.GCMode, 0)
+	planartest.SetGC(p, planar.GCMisc, 0x4)
+	for i := 0; i < 16; i++ {
+		p.WriteByte(memory.Pointer(planar.MemoryBase+'A'*32+i), 0x80)
+		p.WriteByte(memory.Pointer(planar.MemoryBase+0xC4*32+i), 0x01)
+	}
+	modes[0x3].Set(p)
+	setDAC(p, 0x3E, 0x3F, 0x3F, 0x15)
+
+	for i := 0; i < 80*25; i++ {
+		p.WriteByte(memory.Pointer(0xB8000+i*2), ' ')
+		p.WriteByte(memory.Pointer(0xB8000+i*2+1), 0x07)
+	}
+	p.WriteByte(0xB8000+2, 'A')
+	p.WriteByte(0xB8000+3, 0x0E)
+	p.WriteByte(0xB8000+160, 0xC4)
+	p.WriteByte(0xB8000+161, 0x0E)
+
+	txt := d.Text()
+	if txt == nil || txt.Width != 80 || txt.Height != 25 {
+		t.Fatalf("unexpected text screen: %v", txt)
+	}
+	if txt.Row(0) != " A" || txt.Row(1) != "─" {
+		t.Errorf("unexpected rows: %q", txt.Rows()[:2])
+	}
+
+	pixels, w, h := d.Framebuffer()
+	if w != 720 || h != 400 {
+		t.Fatalf("unexpected surface size: %dx%d", w, h)
+	}
+	if c := planartest.Pixel(pixels, w, 9, 15); c != 0xFFFF55 {
+		t.Errorf("invalid foreground color: %06X", c)
+	}
+	if c := planartest.Pixel(pixels, w, 10, 15); c != 0 {
+		t.Errorf("invalid background color: %06X", c)
+	}
+	if c := planartest.Pixel(pixels, w, 8, 16); c != 0xFFFF55 {
+		t.Errorf("line graphics does not extend into the ninth column: %06X", c)
+	}
+}
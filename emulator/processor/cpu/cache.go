@@ -0,0 +1,172 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package cpu
+
+import (
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+)
+
+const (
+	cachePageBits        = 12
+	cachePageSize        = 1 << cachePageBits
+	maxCachedInstruction = 8
+)
+
+// decodedInstruction holds the decoded prefixes and the raw bytes of an instruction
+// so it can be replayed without going through the memory bus. A zero length marks an empty entry.
+type decodedInstruction struct {
+	length, prefixes   byte
+	opcode, repeatMode byte
+	segOverride        *uint16
+	data               [maxCachedInstruction]byte
+}
+
+type cachePage [cachePageSize]decodedInstruction
+
+// The instruction cache is keyed by physical address. Pages are allocated the first time
+// code executes from them and any write to a cached instruction will invalidate it.
+type instructionCache struct {
+	enabled, requested bool
+	pages              [0x100000 >> cachePageBits]*cachePage
+
+	hit *decodedInstruction
+	pos byte
+
+	recording bool
+	addr      memory.Pointer
+	entry     decodedInstruction
+}
+
+// SetInstructionCache enables the decoded instruction cache. It is always disabled while
+// a validator is attached since it needs to see every instruction fetch. The cache is
+// bypassed if the prefetch queue is enabled.
+func (p *CPU) SetInstructionCache(b bool) {
+	p.cache = instructionCache{enabled: b && p.validator == nil, requested: b}
+}
+
+func (p *CPU) flushCache() {
+	p.cache.pages = [len(p.cache.pages)]*cachePage{}
+	p.cache.hit, p.cache.recording = nil, false
+}
+
+// lookupInstruction restores the decoded state of the instruction at CS:IP. If there is no
+// cached instruction we start recording the bytes read from the opcode stream instead.
+func (p *CPU) lookupInstruction() bool {
+	c := &p.cache
+	c.hit, c.recording = nil, false
+	if !c.enabled || p.queue.enabled {
+		return false
+	}
+
+	addr := memory.NewPointer(p.CS, p.IP) & 0xFFFFF
+	if page := c.pages[addr>>cachePageBits]; page != nil {
+		if e := &page[addr&(cachePageSize-1)]; e.length != 0 {
+			c.hit, c.pos = e, e.prefixes+1
+			p.IP += uint16(c.pos)
+			p.cycleCount += int(e.prefixes) * p.timing.prefix
+
+			p.segOverride = e.segOverride
+			p.repeatMode = e.repeatMode
+			p.opcode = e.opcode
+			p.isWide = e.opcode&1 != 0
+			p.rmToReg = e.opcode&2 != 0
+			return true
+		}
+	}
+
+	c.recording, c.addr = true, addr
+	c.entry = decodedInstruction{}
+	return false
+}
+
+// decodedPrefixes saves the state after the prefixes and opcode have been parsed.
+func (p *CPU) decodedPrefixes() {
+	if e := &p.cache.entry; p.cache.recording {
+		e.prefixes = byte(p.IP - p.decodeAt - 1)
+		e.opcode = p.opcode
+		e.repeatMode = p.repeatMode
+		e.segOverride = p.segOverride
+	}
+}
+
+func (p *CPU) advanceCache(v byte) {
+	c := &p.cache
+	if c.hit != nil {
+		c.pos++
+	} else if c.recording {
+		e := &c.entry
+		if int(e.length) == len(e.data) || memory.NewPointer(p.CS, p.IP)&0xFFFFF != c.addr+memory.Pointer(e.length) {
+			c.recording = false
+			return
+		}
+		e.data[e.length] = v
+		e.length++
+	}
+}
+
+// storeInstruction adds the recorded instruction to the cache. Instructions that cross a page are not cached.
+func (p *CPU) storeInstruction() {
+	c := &p.cache
+	if !c.recording {
+		c.hit = nil
+		return
+	}
+	c.recording = false
+
+	end := c.addr + memory.Pointer(c.entry.length) - 1
+	if c.entry.length == 0 || end>>cachePageBits != c.addr>>cachePageBits {
+		return
+	}
+
+	page := c.pages[c.addr>>cachePageBits]
+	if page == nil {
+		page = &cachePage{}
+		c.pages[c.addr>>cachePageBits] = page
+	}
+	page[c.addr&(cachePageSize-1)] = c.entry
+}
+
+func (p *CPU) InvalidateMemory(from, to memory.Pointer) {
+	if !p.cache.enabled {
+		return
+	}
+	for addr := from & 0xFFFFF; addr <= to&0xFFFFF; addr++ {
+		p.invalidateCache(addr)
+	}
+}
+
+// invalidateCache removes all cached instructions that include addr.
+func (p *CPU) invalidateCache(addr memory.Pointer) {
+	c := &p.cache
+	if c.recording && addr >= c.addr && addr < c.addr+maxCachedInstruction {
+		c.recording = false
+	}
+
+	page := c.pages[addr>>cachePageBits]
+	if page == nil {
+		return
+	}
+
+	offset := int(addr & (cachePageSize - 1))
+	for i := 0; i < maxCachedInstruction && i <= offset; i++ {
+		page[offset-i].length = 0
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package cpu
+
+import (
+	"testing"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+)
+
+func TestInstructionCache(t *testing.T) {
+	// MOV AL,[CS:4] ; ADD AL,1
+	p := newCodeCPU(t, []byte{0x2E, 0xA0, 0x04, 0x00, 0x04, 0x01})
+	p.SetInstructionCache(true)
+
+	for i := 0; i < 2; i++ {
+		p.IP = 0
+		if _, err := p.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if p.AL() != 0x4 || p.IP != 4 {
+			t.Fatalf("expected AL=4 and IP=4, got AL=0x%X and IP=%d", p.AL(), p.IP)
+		}
+	}
+	if p.cache.pages[0x10] == nil || p.cache.pages[0x10][0].length != 4 {
+		t.Fatal("expected instruction to be cached")
+	}
+
+	// Modify the cached instruction.
+	p.WriteByte(memory.NewPointer(p.CS, 2), 0x05)
+	if p.cache.pages[0x10][0].length != 0 {
+		t.Error("expected cached instruction to be invalidated")
+	}
+
+	p.IP = 0
+	if _, err := p.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if p.AL() != 0x1 {
+		t.Errorf("expected AL=1, got 0x%X", p.AL())
+	}
+
+	// Devices that write memory directly must invalidate it.
+	addr := memory.NewPointer(p.CS, 2)
+	p.GetMappedMemoryDevice(addr).WriteByte(addr, 0x04)
+	p.InvalidateMemory(addr, addr)
+
+	p.IP = 0
+	if _, err := p.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if p.AL() != 0x4 {
+		t.Errorf("expected AL=4, got 0x%X", p.AL())
+	}
+}
+
+func BenchmarkInstructionCache(b *testing.B) {
+	for _, cache := range []bool{false, true} {
+		name := "Disabled"
+		if cache {
+			name = "Enabled"
+		}
+		b.Run(name, func(b *testing.B) {
+			for _, prog := range []string{"add", "bitwise", "control", "datatrnf", "jump1", "rep", "rotate", "shifts", "strings"} {
+				p := newTestCPU(b, prog)
+				p.SetInstructionCache(cache)
+
+				b.Run(prog, func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						runProgram(b, p)
+					}
+				})
+				p.Close()
+			}
+		})
+	}
+}
@@ -22,6 +22,7 @@ package cpu
 
 import (
 	"errors"
+	"fmt"
 	"log"
 
 	"github.com/andreas-jonsson/virtualxt/emulator/memory"
@@ -30,54 +31,61 @@ import (
 	"github.com/andreas-jonsson/virtualxt/emulator/processor/validator"
 )
 
-const MaxPeripherals = 32
-
 type CPU struct {
 	processor.Registers
 	instructionState
 
-	isV20, trap bool
+	model     Model
+	trap, nmi bool
+	emulation bool
+	timing    *timingModel
+	queue     prefetchQueue
+	cache     instructionCache
+
+	// fixedFlags are the bits of FLAGS that always read as set.
+	fixedFlags uint16
+
+	// modeWritable is set when IRET is allowed to change the NEC mode flag.
+	modeWritable bool
 
 	stats        processor.Stats
+	validator    *validator.Validator
+	scheduler    processor.Scheduler
+	adapters     []*stepAdapter
 	peripherals  []peripheral.Peripheral
 	pic          processor.InterruptController
+	fpu          processor.Coprocessor
 	interceptors [0x100]processor.InterruptHandler
 
-	iomap         [0x10000]byte
-	ioPeripherals [MaxPeripherals]memory.IO
+	ports [0x10000]memory.IO
 
-	mmap           [0x100000]byte
-	memPeripherals [MaxPeripherals]memory.Memory
+	pages [memory.NumPages]memoryPage
+}
+
+// memoryPage is an entry in the page table of the memory bus. Devices that implement
+// memory.Direct are accessed through the data slice instead of the interface.
+type memoryPage struct {
+	device   memory.Memory
+	data     []byte
+	readOnly bool
 }
 
 func NewCPU(peripherals []peripheral.Peripheral) (*CPU, []error) {
-	p := &CPU{peripherals: peripherals}
+	p := &CPU{peripherals: peripherals, timing: &timing8088, fixedFlags: 0xF000}
 
 	dummyIO := &memory.DummyIO{}
-	for i := range p.ioPeripherals[:] {
-		p.ioPeripherals[i] = dummyIO
+	for i := range p.ports[:] {
+		p.ports[i] = dummyIO
 	}
 
 	dummyMem := &memory.DummyMemory{}
-	for i := range p.memPeripherals[:] {
-		p.memPeripherals[i] = dummyMem
+	for i := range p.pages[:] {
+		p.pages[i].device = dummyMem
 	}
 
-	for i := 1; i <= len(peripherals); i++ {
-		if dev, ok := peripherals[i-1].(memory.IO); ok {
-			p.ioPeripherals[i] = dev
-		}
-		if dev, ok := peripherals[i-1].(memory.Memory); ok {
-			p.memPeripherals[i] = dev
-		}
-	}
 	return p, p.installPeripherals()
 }
 
-func (p *CPU) SetV20Support(b bool) {
-	p.isV20 = b
-}
-
 func (p *CPU) installPeripherals() []error {
 	var errs []error
 
@@ -95,6 +103,14 @@ func (p *CPU) installPeripherals() []error {
 		if pic, ok := d.(processor.InterruptController); ok {
 			p.pic = pic
 		}
+		if fpu, ok := d.(processor.Coprocessor); ok {
+			p.fpu = fpu
+		}
+		if ed, ok := d.(peripheral.EventDriven); !ok || !ed.EventDriven() {
+			a := &stepAdapter{device: d, scheduler: &p.scheduler}
+			a.event = p.scheduler.After(1, a.step)
+			p.adapters = append(p.adapters, a)
+		}
 	}
 
 	if p.pic == nil {
@@ -113,6 +129,14 @@ func (p *CPU) Close() {
 	}
 }
 
+// SetValidator makes the CPU report every executed instruction to the validator.
+// Features the validator can't follow are disabled while it is attached.
+func (p *CPU) SetValidator(v *validator.Validator) {
+	p.validator = v
+	p.SetPrefetchQueue(p.queue.requested)
+	p.SetInstructionCache(p.cache.requested)
+}
+
 func (p *CPU) Break() {
 	p.Debug = true
 }
@@ -127,21 +151,35 @@ func (p *CPU) GetInterruptController() processor.InterruptController {
 	return p.pic
 }
 
+func (p *CPU) GetScheduler() *processor.Scheduler {
+	return &p.scheduler
+}
+
+func (p *CPU) GetCoprocessor() processor.Coprocessor {
+	return p.fpu
+}
+
+func (p *CPU) NMI() {
+	p.nmi = true
+}
+
 func (p *CPU) Reset() {
 	log.Print("CPU reset!")
 
 	p.Registers = processor.Registers{CS: 0xFFFF}
+	p.nmi, p.emulation, p.modeWritable = false, false, false
+	p.flushQueue()
 	for _, d := range p.peripherals {
 		d.Reset()
 	}
 }
 
 func (p *CPU) GetMappedMemoryDevice(addr memory.Pointer) memory.Memory {
-	return p.memPeripherals[p.mmap[addr]]
+	return p.pages[(addr&0xFFFFF)>>memory.PageShift].device
 }
 
 func (p *CPU) GetMappedIODevice(port uint16) memory.IO {
-	return p.ioPeripherals[p.iomap[port]]
+	return p.ports[port]
 }
 
 func (p *CPU) GetRegisters() *processor.Registers {
@@ -159,10 +197,19 @@ func (p *CPU) OutByte(port uint16, data byte) {
 }
 
 func (p *CPU) InWord(port uint16) uint16 {
+	if dev, ok := p.ports[port].(memory.IO16); ok && p.ports[port+1] == dev {
+		p.stats.RX++
+		return dev.InWord(port)
+	}
 	return uint16(p.InByte(port)) | (uint16(p.InByte(port+1)) << 8)
 }
 
 func (p *CPU) OutWord(port uint16, data uint16) {
+	if dev, ok := p.ports[port].(memory.IO16); ok && p.ports[port+1] == dev {
+		p.stats.TX++
+		dev.OutWord(port, data)
+		return
+	}
 	p.OutByte(port, byte(data&0xFF))
 	p.OutByte(port+1, byte(data>>8))
 }
@@ -170,23 +217,40 @@ func (p *CPU) OutWord(port uint16, data uint16) {
 func (p *CPU) ReadByte(addr memory.Pointer) byte {
 	p.stats.RX++
 	addr &= 0xFFFFF
-	data := p.GetMappedMemoryDevice(addr).ReadByte(addr)
-	validator.ReadByte(uint32(addr), data)
+
+	var data byte
+	if page := &p.pages[addr>>memory.PageShift]; page.data != nil {
+		data = page.data[addr&(memory.PageSize-1)]
+	} else {
+		data = page.device.ReadByte(addr)
+	}
+	p.validator.ReadMemory(uint32(addr), data)
 	return data
 }
 
 func (p *CPU) WriteByte(addr memory.Pointer, data byte) {
 	p.stats.TX++
 	addr &= 0xFFFFF
-	p.GetMappedMemoryDevice(addr).WriteByte(addr, data)
-	validator.WriteByte(uint32(addr), data)
+
+	if page := &p.pages[addr>>memory.PageShift]; page.data == nil {
+		page.device.WriteByte(addr, data)
+	} else if !page.readOnly {
+		page.data[addr&(memory.PageSize-1)] = data
+	}
+	p.validator.WriteMemory(uint32(addr), data)
+
+	if p.cache.enabled {
+		p.invalidateCache(addr)
+	}
 }
 
 func (p *CPU) ReadWord(addr memory.Pointer) uint16 {
+	p.wordCycles(addr)
 	return uint16(p.ReadByte(addr)) | (uint16(p.ReadByte(addr+1)) << 8)
 }
 
 func (p *CPU) WriteWord(addr memory.Pointer, data uint16) {
+	p.wordCycles(addr)
 	p.WriteByte(addr, byte(data&0xFF))
 	p.WriteByte(addr+1, byte(data>>8))
 }
@@ -201,39 +265,76 @@ func (p *CPU) InstallInterruptHandler(handler processor.InterruptHandler, num ..
 	return nil
 }
 
+// InstallMemoryDevice maps all pages in the range to the device. It can be
+// called at any time to remap memory, for example by bank switched devices.
 func (p *CPU) InstallMemoryDevice(device memory.Memory, from, to memory.Pointer) error {
-	for i, d := range p.memPeripherals[:] {
-		if d == device {
-			for from <= to {
-				p.mmap[from] = byte(i)
-				from++
-			}
-			return nil
+	if from > to || to > 0xFFFFF {
+		return errors.New("invalid memory range")
+	}
+
+	direct, isDirect := device.(memory.Direct)
+	for i := from >> memory.PageShift; i <= to>>memory.PageShift; i++ {
+		page := memoryPage{device: device}
+		if isDirect {
+			page.data, page.readOnly = direct.DirectPage(i << memory.PageShift)
 		}
+		p.pages[i] = page
 	}
-	return errors.New("could not find peripheral")
+	p.flushCache()
+	return nil
+}
+
+// UninstallMemoryDevice unmaps all pages in the range.
+func (p *CPU) UninstallMemoryDevice(from, to memory.Pointer) error {
+	return p.InstallMemoryDevice(&memory.DummyMemory{}, from, to)
 }
 
+// InstallMemoryDeviceAt maps the page starting at each address to the device.
+// The addresses must be page aligned.
 func (p *CPU) InstallMemoryDeviceAt(device memory.Memory, addr ...memory.Pointer) error {
 	for _, a := range addr {
-		if err := p.InstallMemoryDevice(device, a, a); err != nil {
+		if a&(memory.PageSize-1) != 0 {
+			return fmt.Errorf("memory address %v is not page aligned", a)
+		}
+		if err := p.InstallMemoryDevice(device, a, a+memory.PageSize-1); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// InstallIODevice maps the ports to the device. It is an error if any of the
+// ports are already claimed by another device.
 func (p *CPU) InstallIODevice(device memory.IO, from, to uint16) error {
-	for i, d := range p.ioPeripherals[:] {
-		if d == device {
-			for from <= to {
-				p.iomap[from] = byte(i)
-				from++
+	if from > to {
+		return errors.New("invalid IO port range")
+	}
+
+	for i := int(from); i <= int(to); i++ {
+		if d := p.ports[i]; d != device {
+			if _, ok := d.(*memory.DummyIO); !ok {
+				return fmt.Errorf("IO port 0x%X is claimed by both %s and %s", i, deviceName(d), deviceName(device))
 			}
-			return nil
 		}
 	}
-	return errors.New("could not find peripheral")
+
+	for i := int(from); i <= int(to); i++ {
+		p.ports[i] = device
+	}
+	return nil
+}
+
+// UninstallIODevice unmaps the ports.
+func (p *CPU) UninstallIODevice(from, to uint16) error {
+	if from > to {
+		return errors.New("invalid IO port range")
+	}
+
+	dummyIO := &memory.DummyIO{}
+	for i := int(from); i <= int(to); i++ {
+		p.ports[i] = dummyIO
+	}
+	return nil
 }
 
 func (p *CPU) InstallIODeviceAt(device memory.IO, port ...uint16) error {
@@ -244,3 +345,26 @@ func (p *CPU) InstallIODeviceAt(device memory.IO, port ...uint16) error {
 	}
 	return nil
 }
+
+func deviceName(device interface{}) string {
+	if p, ok := device.(peripheral.Peripheral); ok {
+		return p.Name()
+	}
+	return fmt.Sprintf("%T", device)
+}
+
+// stepAdapter calls Step on peripherals that are not event driven after every instruction.
+type stepAdapter struct {
+	device    peripheral.Peripheral
+	scheduler *processor.Scheduler
+	event     *processor.Event
+	last      int64
+}
+
+func (a *stepAdapter) step() error {
+	now := a.scheduler.Cycles()
+	a.scheduler.Reschedule(a.event, 1)
+	err := a.device.Step(int(now - a.last))
+	a.last = now
+	return err
+}
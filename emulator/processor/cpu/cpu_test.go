@@ -0,0 +1,211 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package cpu
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/pic"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/ram"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor"
+)
+
+type bankMemory struct {
+	mem [memory.PageSize]byte
+}
+
+func (m *bankMemory) ReadByte(addr memory.Pointer) byte {
+	return m.mem[addr&(memory.PageSize-1)]
+}
+
+func (m *bankMemory) WriteByte(addr memory.Pointer, data byte) {
+	m.mem[addr&(memory.PageSize-1)] = data
+}
+
+func TestMemoryBus(t *testing.T) {
+	p := newCodeCPU(t, nil)
+	addr := memory.NewPointer(0xD000, 0x10)
+
+	p.WriteByte(addr, 0x12)
+	if p.pages[addr>>memory.PageShift].data == nil {
+		t.Error("expected RAM to be accessed directly")
+	}
+
+	bank := &bankMemory{}
+	if err := p.InstallMemoryDevice(bank, 0xD0000, 0xD3FFF); err != nil {
+		t.Fatal(err)
+	}
+	p.WriteByte(addr, 0x34)
+	if bank.mem[0x10] != 0x34 || p.ReadByte(addr) != 0x34 {
+		t.Error("expected memory to be remapped")
+	}
+	if p.GetMappedMemoryDevice(0xD3FFF) != bank || p.GetMappedMemoryDevice(0xD4000) == bank {
+		t.Error("invalid page mapping")
+	}
+
+	if err := p.UninstallMemoryDevice(0xD0000, 0xD3FFF); err != nil {
+		t.Fatal(err)
+	}
+	if v := p.ReadByte(addr); v != 0xFF {
+		t.Errorf("expected unmapped memory to read 0xFF, got 0x%X", v)
+	}
+
+	if err := p.InstallMemoryDevice(bank, 0xFFFFF, 0x100000); err == nil {
+		t.Error("expected error")
+	}
+
+	// Only whole pages can be mapped.
+	if err := p.InstallMemoryDeviceAt(bank, 0xD0010); err == nil {
+		t.Error("expected error for unaligned address")
+	}
+	if err := p.InstallMemoryDeviceAt(bank, 0xD0800); err != nil {
+		t.Fatal(err)
+	}
+	if p.GetMappedMemoryDevice(0xD07FF) == bank || p.GetMappedMemoryDevice(0xD0FFF) != bank || p.GetMappedMemoryDevice(0xD1000) == bank {
+		t.Error("invalid page mapping")
+	}
+}
+
+type wordDevice struct {
+	name string
+	data uint16
+}
+
+func (m *wordDevice) Name() string                      { return m.name }
+func (m *wordDevice) Reset()                            {}
+func (m *wordDevice) Step(int) error                    { return nil }
+func (m *wordDevice) Install(processor.Processor) error { return nil }
+
+func (m *wordDevice) In(port uint16) byte {
+	return byte(m.data >> ((port & 1) * 8))
+}
+
+func (m *wordDevice) Out(port uint16, data byte) {
+	m.data = 0
+}
+
+func (m *wordDevice) InWord(port uint16) uint16 {
+	return m.data
+}
+
+func (m *wordDevice) OutWord(port uint16, data uint16) {
+	m.data = data
+}
+
+func TestIOBus(t *testing.T) {
+	p := newCodeCPU(t, nil)
+	a, b := &wordDevice{name: "A"}, &wordDevice{name: "B"}
+
+	if err := p.InstallIODevice(a, 0x300, 0x301); err != nil {
+		t.Fatal(err)
+	}
+	p.OutWord(0x300, 0x1234)
+	if a.data != 0x1234 || p.InWord(0x300) != 0x1234 || p.InByte(0x301) != 0x12 {
+		t.Errorf("expected native word access, got 0x%X", a.data)
+	}
+
+	// Word access that spans two devices is split.
+	if err := p.InstallIODevice(b, 0x302, 0x302); err != nil {
+		t.Fatal(err)
+	}
+	if v := p.InWord(0x301); v != 0x12 {
+		t.Errorf("expected 0x12, got 0x%X", v)
+	}
+
+	err := p.InstallIODevice(b, 0x2F0, 0x300)
+	if err == nil || !strings.Contains(err.Error(), "A") || !strings.Contains(err.Error(), "B") {
+		t.Errorf("expected conflict error, got %v", err)
+	}
+	if p.GetMappedIODevice(0x2F0) == b {
+		t.Error("expected no ports to be installed")
+	}
+
+	if err := p.UninstallIODevice(0x300, 0x301); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.InstallIODevice(b, 0x2F0, 0x301); err != nil {
+		t.Error(err)
+	}
+}
+
+type stepCounter struct {
+	peripheral.NullDevice
+	steps, cycles int
+}
+
+func (m *stepCounter) Step(cycles int) error {
+	m.steps++
+	m.cycles += cycles
+	return nil
+}
+
+func TestScheduler(t *testing.T) {
+	counter := &stepCounter{}
+	p, errs := NewCPU([]peripheral.Peripheral{
+		&ram.Device{Clear: true},
+		&pic.Device{},
+		counter,
+	})
+	for _, err := range errs {
+		t.Fatal(err)
+	}
+
+	// STI ; HLT ; NOP
+	p.Reset()
+	p.CS, p.IP = 0x1000, 0
+	for i, v := range []byte{0xFB, 0xF4, 0x90} {
+		p.WriteByte(memory.NewPointer(p.CS, uint16(i)), v)
+	}
+	p.SS, p.SP = 0x2000, 0x100
+	p.WriteWord(0, 0x100)
+	p.WriteWord(2, 0x1000)
+	p.WriteByte(memory.NewPointer(0x1000, 0x100), 0x90)
+
+	p.GetScheduler().After(100, func() error {
+		p.GetInterruptController().IRQ(0)
+		return nil
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if counter.steps != 2 || counter.cycles != int(p.GetScheduler().Cycles()) {
+		t.Errorf("expected peripheral to be stepped twice, got %d", counter.steps)
+	}
+
+	// The CPU stays halted until the IRQ.
+	for p.halted {
+		if _, err := p.Step(); err != processor.ErrCPUHalt && err != nil {
+			t.Fatal(err)
+		}
+		if p.GetScheduler().Cycles() > 1000 {
+			t.Fatal("expected IRQ")
+		}
+	}
+	if p.GetScheduler().Cycles() < 100 || p.IP != 0x101 {
+		t.Errorf("expected interrupt handler to run after cycle 100, got IP=0x%X at %d", p.IP, p.GetScheduler().Cycles())
+	}
+}
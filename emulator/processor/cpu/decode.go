@@ -27,7 +27,6 @@ import (
 
 	"github.com/andreas-jonsson/virtualxt/emulator/memory"
 	"github.com/andreas-jonsson/virtualxt/emulator/processor"
-	"github.com/andreas-jonsson/virtualxt/emulator/processor/validator"
 )
 
 var zero16 uint16
@@ -63,19 +62,34 @@ func (p *CPU) rmLocation() dataLocation {
 }
 
 func (p *CPU) peakOpcodeStream() byte {
+	if p.queue.enabled {
+		return p.peakQueue()
+	}
+	if c := &p.cache; c.hit != nil && c.pos < c.hit.length {
+		return c.hit.data[c.pos]
+	}
 	return p.ReadByte(memory.NewPointer(p.CS, p.IP))
 }
 
 func (p *CPU) readOpcodeStream() byte {
+	if p.queue.enabled {
+		v := p.popQueue()
+		p.IP++
+
+		// Keep the queue topped up so it holds the bytes following the instruction.
+		p.fillQueue()
+		return v
+	}
+
 	v := p.peakOpcodeStream()
+	p.advanceCache(v)
 	p.IP++
 	return v
 }
 
 func (p *CPU) readOpcodeImm16() uint16 {
-	v := p.ReadWord(memory.NewPointer(p.CS, p.IP))
-	p.IP += 2
-	return v
+	// Instruction fetch is part of the documented timings so don't pay the word penalty here.
+	return uint16(p.readOpcodeStream()) | uint16(p.readOpcodeStream())<<8
 }
 
 func (p *CPU) readModRegRM() {
@@ -96,6 +110,10 @@ func (p *CPU) parseOpcode() {
 	p.repeatMode = 0
 	p.decodeAt = p.IP
 
+	if p.lookupInstruction() {
+		return
+	}
+
 	var op byte
 loop:
 	for {
@@ -119,11 +137,13 @@ loop:
 		default:
 			break loop
 		}
+		p.cycleCount += p.timing.prefix
 	}
 
 	p.opcode = op
 	p.isWide = op&1 != 0
 	p.rmToReg = op&2 != 0
+	p.decodedPrefixes()
 }
 
 func (p *CPU) packFlags8() byte {
@@ -160,6 +180,12 @@ func (p *CPU) packFlags16() uint16 {
 	if p.OF {
 		flags |= 0x800
 	}
+	flags |= p.fixedFlags
+
+	// Bit 15 is the NEC mode flag. It is cleared in 8080 emulation mode.
+	if p.isNEC() && !p.emulation {
+		flags |= 0x8000
+	}
 	return flags
 }
 
@@ -289,15 +315,20 @@ func (p *CPU) getSeg(seg uint16) uint16 {
 }
 
 func (p *CPU) divisionByZero() {
-	p.IP = p.decodeAt
+	// The 8086 pushes the address of the next instruction.
+	if p.has186() {
+		p.IP = p.decodeAt
+	}
 	p.doInterrupt(0)
 }
 
 func (p *CPU) doInterrupt(n int) {
 	p.stats.NumInterrupts++
-	validator.Discard()
+	p.cycleCount += p.timing.interrupt
+	p.validator.Discard()
 
 	p.halted = false
+	p.flushQueue()
 
 	if handler := p.interceptors[n]; handler != nil {
 		if err := handler.HandleInterrupt(n); err == nil {
@@ -316,12 +347,39 @@ func (p *CPU) doInterrupt(n int) {
 	p.CS = p.ReadWord(memory.Pointer(offset + 2))
 	p.IP = p.ReadWord(memory.Pointer(offset))
 	p.TF, p.IF = false, false
+
+	// The handler runs in native mode and IRET returns to emulation mode.
+	if p.emulation {
+		p.emulation = false
+		p.modeWritable = true
+	}
+}
+
+func (p *CPU) iret() uint16 {
+	p.IP = p.pop16()
+	p.CS = p.pop16()
+	flags := p.pop16()
+	p.unpackFlags16(flags)
+
+	// The mode flag is write protected unless we return from an interrupt taken in emulation
+	// mode. Returns from nested interrupts in native mode leave the latch alone.
+	if p.modeWritable && flags&0x8000 == 0 {
+		p.emulation = true
+		p.modeWritable = false
+	}
+	p.flushQueue()
+	return flags
 }
 
 func (p *CPU) Step() (int, error) {
 	// Reset cycle counter.
 	p.cycleCount = 0
 
+	if p.nmi {
+		p.nmi = false
+		p.doInterrupt(2)
+	}
+
 	if p.trap {
 		p.doInterrupt(1)
 	}
@@ -329,37 +387,58 @@ func (p *CPU) Step() (int, error) {
 
 	if !p.trap && p.IF {
 		if n, err := p.pic.GetInterrupt(); err == nil {
+			p.cycleCount += p.timing.irq
 			p.doInterrupt(n)
 		}
 	}
 
 	if p.halted {
-		return 0, processor.ErrCPUHalt
+		// Only an interrupt can resume execution so skip ahead to the next event.
+		p.cycleCount = 1
+		if n := p.scheduler.Next(); n > 0 {
+			p.cycleCount = int(n)
+		}
+		if err := p.scheduler.Advance(p.cycleCount); err != nil {
+			return p.cycleCount, err
+		}
+		return p.cycleCount, processor.ErrCPUHalt
+	}
+
+	if p.emulation {
+		p.execute8080()
+		return p.cycleCount, p.scheduler.Advance(p.cycleCount)
 	}
 
 	p.parseOpcode()
 	if p.repeatMode != 0 {
 		err := p.doRepeat()
-		return p.cycleCount, err
+		p.storeInstruction()
+		if err != nil {
+			return p.cycleCount, err
+		}
+		return p.cycleCount, p.scheduler.Advance(p.cycleCount)
 	}
 
-	validator.Begin(p.opcode, p.Registers)
+	p.validator.Begin(p.opcode, p.Registers)
 	if err := p.execute(); err != nil {
 		return p.cycleCount, err
 	}
-	validator.End(p.Registers)
+	p.validator.End(p.Registers)
+	p.storeInstruction()
 
-	for _, d := range p.peripherals {
-		if err := d.Step(p.cycleCount); err != nil {
-			return p.cycleCount, err
-		}
-	}
-	return p.cycleCount, nil
+	return p.cycleCount, p.scheduler.Advance(p.cycleCount)
 }
 
 func (p *CPU) execute() error {
-	// All instructions are 1 cycle right now.
-	p.cycleCount++
+	if !p.has186() {
+		// The 8086 ignores one bit when decoding these opcodes.
+		switch op := p.opcode; {
+		case op >= 0x60 && op <= 0x6F:
+			p.opcode += 0x10
+		case op == 0xC0, op == 0xC1, op == 0xC8, op == 0xC9:
+			p.opcode += 2
+		}
+	}
 
 	op := p.opcode
 	carry := op > 0x0F && p.CF
@@ -419,8 +498,14 @@ func (p *CPU) execute() error {
 	case 0x0E: // PUSH CS
 		p.push16(p.CS)
 	case 0x0F: // *POP CS
-		if !p.isV20 {
+		switch {
+		case !p.has186():
 			p.CS = p.pop16()
+			p.flushQueue()
+		case p.isNEC():
+			p.extendedOpcode()
+		default:
+			p.invalidOpcode()
 		}
 
 	// 0x1x
@@ -641,7 +726,10 @@ func (p *CPU) execute() error {
 
 	// 0x5x
 
-	case 0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57: // PUSH AX/CX/DX/BX/SP/BP/SI/DI
+	case 0x54: // PUSH SP
+		// Pushes the value after the decrement. This was changed with the 80286.
+		p.push16(p.SP - 2)
+	case 0x50, 0x51, 0x52, 0x53, 0x55, 0x56, 0x57: // PUSH AX/CX/DX/BX/BP/SI/DI
 		p.push16((dataLocation(op-0x50) | registerLocation).readWord(p))
 	case 0x58, 0x59, 0x5A, 0x5B, 0x5C, 0x5D, 0x5E, 0x5F: // POP AX/CX/DX/BX/SP/BP/SI/DI
 		(dataLocation(op-0x58) | registerLocation).writeWord(p, p.pop16())
@@ -649,79 +737,71 @@ func (p *CPU) execute() error {
 	// 0x6x
 
 	case 0x60: // PUSHA (80186)
-		if p.isV20 {
-			sp := p.SP
-			p.push16(p.AX)
-			p.push16(p.CX)
-			p.push16(p.DX)
-			p.push16(p.BX)
-			p.push16(sp)
-			p.push16(p.BP)
-			p.push16(p.SI)
-			p.push16(p.DI)
-		} else {
-			p.invalidOpcode()
-		}
+		sp := p.SP
+		p.push16(p.AX)
+		p.push16(p.CX)
+		p.push16(p.DX)
+		p.push16(p.BX)
+		p.push16(sp)
+		p.push16(p.BP)
+		p.push16(p.SI)
+		p.push16(p.DI)
 	case 0x61: // POPA (80186)
-		if p.isV20 {
-			p.DI = p.pop16()
-			p.SI = p.pop16()
-			p.BP = p.pop16()
-			p.pop16()
-			p.BX = p.pop16()
-			p.DX = p.pop16()
-			p.CX = p.pop16()
-			p.AX = p.pop16()
-		} else {
-			p.invalidOpcode()
-		}
+		p.DI = p.pop16()
+		p.SI = p.pop16()
+		p.BP = p.pop16()
+		p.pop16()
+		p.BX = p.pop16()
+		p.DX = p.pop16()
+		p.CX = p.pop16()
+		p.AX = p.pop16()
 	case 0x62: // BOUND (80186)
-		if p.isV20 {
-			p.readModRegRM()
-			idx := signExtend32(p.regLocation().readWord(p))
-			addr := p.rmLocation().getAddress()
-
-			if idx < signExtend32(p.ReadWord(addr.Pointer())) || idx > signExtend32(p.ReadWord(addr.AddInt(2).Pointer())) {
-				p.IP = p.decodeAt
-				p.doInterrupt(5)
-			}
-		} else {
-			p.invalidOpcode()
-		}
-	case 0x69, 0x6B: // IMUL r/m16,d8/d16 (80186)
-		if p.isV20 {
-			p.readModRegRM()
-			dest := p.rmLocation()
-			a := signExtend32(dest.readWord(p))
-
-			var res uint32
-			if op == 69 {
-				res = a * signExtend32(signExtend16(p.readOpcodeStream()))
-			} else {
-				res = a * signExtend32(p.readOpcodeImm16())
-			}
-			res16 := uint16(res & 0xFFFF)
-			upper := uint16(res >> 16)
+		p.readModRegRM()
+		idx := signExtend32(p.regLocation().readWord(p))
+		addr := p.rmLocation().getAddress()
 
-			dest.writeWord(p, res16)
-			p.updateFlagsSZP16(res16)
+		if idx < signExtend32(p.ReadWord(addr.Pointer())) || idx > signExtend32(p.ReadWord(addr.AddInt(2).Pointer())) {
+			p.IP = p.decodeAt
+			p.doInterrupt(5)
+		}
+	case 0x68: // PUSH d16 (80186)
+		p.push16(p.readOpcodeImm16())
+	case 0x69, 0x6B: // IMUL r16,r/m16,d16/d8 (80186)
+		p.readModRegRM()
+		a := signExtend32(p.rmLocation().readWord(p))
 
-			if res16&0x8000 == 0x8000 {
-				p.CF = upper != 0xFFFF
-			} else {
-				p.CF = upper != 0x0
-			}
-			p.OF = p.CF
+		var res uint32
+		if op == 0x69 {
+			res = a * signExtend32(p.readOpcodeImm16())
 		} else {
-			p.invalidOpcode()
+			res = a * signExtend32(signExtend16(p.readOpcodeStream()))
 		}
-	case 0x68, 0x6A, 0x6C, 0x6D, 0x6E, 0x6F:
-		if p.isV20 {
-			log.Printf("opcode not implemented: 0x%X", op)
-			p.Break()
+		res16 := uint16(res & 0xFFFF)
+		upper := uint16(res >> 16)
+
+		p.regLocation().writeWord(p, res16)
+		p.updateFlagsSZP16(res16)
+
+		if res16&0x8000 == 0x8000 {
+			p.CF = upper != 0xFFFF
 		} else {
-			p.invalidOpcode()
+			p.CF = upper != 0x0
 		}
+		p.OF = p.CF
+	case 0x6A: // PUSH d8 (80186)
+		p.push16(signExtend16(p.readOpcodeStream()))
+	case 0x6C: // INSB (80186)
+		p.WriteByte(memory.NewPointer(p.ES, p.DI), p.InByte(p.DX))
+		p.updateDI()
+	case 0x6D: // INSW (80186)
+		p.WriteWord(memory.NewPointer(p.ES, p.DI), p.InWord(p.DX))
+		p.updateDI()
+	case 0x6E: // OUTSB (80186)
+		p.OutByte(p.DX, p.ReadByte(memory.NewPointer(p.getSeg(p.DS), p.SI)))
+		p.updateSI()
+	case 0x6F: // OUTSW (80186)
+		p.OutWord(p.DX, p.ReadWord(memory.NewPointer(p.getSeg(p.DS), p.SI)))
+		p.updateSI()
 
 	// 0x7x
 
@@ -801,6 +881,9 @@ func (p *CPU) execute() error {
 	case 0x8E: // _MOV sr,r/m16
 		p.readModRegRM()
 		p.segLocation().writeWord(p, p.rmLocation().readWord(p))
+		if p.getReg() == 1 {
+			p.flushQueue()
+		}
 	case 0x8F: // _POP r/m16
 		p.readModRegRM()
 		p.rmLocation().writeWord(p, p.pop16())
@@ -837,6 +920,7 @@ func (p *CPU) execute() error {
 		p.push16(p.CS)
 		p.push16(p.IP)
 		p.IP, p.CS = ip, cs
+		p.flushQueue()
 	case 0x9B: // WAIT
 	case 0x9C: // PUSHF
 		p.push16(p.packFlags16())
@@ -914,27 +998,25 @@ func (p *CPU) execute() error {
 	// 0xCx
 
 	case 0xC0: // SHL r/m8,d8 (80186)
-		if p.isV20 {
-			p.readModRegRM()
-			dest := p.rmLocation()
-			dest.writeByte(p, p.shiftOrRotate8(p.getReg(), dest.readByte(p), p.readOpcodeStream()))
-		} else {
-			p.invalidOpcode()
-		}
-	case 0xC1: // SHL r/m16,d16 (80186)
-		if p.isV20 {
-			p.readModRegRM()
-			dest := p.rmLocation()
-			dest.writeWord(p, p.shiftOrRotate16(p.getReg(), dest.readWord(p), p.readOpcodeStream()))
-		} else {
-			p.invalidOpcode()
-		}
+		p.readModRegRM()
+		dest := p.rmLocation()
+		n := p.readOpcodeStream()
+		p.shiftCycles(n)
+		dest.writeByte(p, p.shiftOrRotate8(p.getReg(), dest.readByte(p), n))
+	case 0xC1: // SHL r/m16,d8 (80186)
+		p.readModRegRM()
+		dest := p.rmLocation()
+		n := p.readOpcodeStream()
+		p.shiftCycles(n)
+		dest.writeWord(p, p.shiftOrRotate16(p.getReg(), dest.readWord(p), n))
 	case 0xC2: // RET d16
 		ip := p.pop16()
 		p.SP += p.readOpcodeImm16()
 		p.IP = ip
+		p.flushQueue()
 	case 0xC3: // RET
 		p.IP = p.pop16()
+		p.flushQueue()
 	case 0xC4: // LES r16,m32
 		p.readModRegRM()
 		addr := p.rmLocation().getAddress()
@@ -951,18 +1033,34 @@ func (p *CPU) execute() error {
 	case 0xC7: // _MOV r/m16,d16
 		p.readModRegRM()
 		p.rmLocation().writeWord(p, p.readOpcodeImm16())
-	case 0xC8: // ENTER (80186)
-		p.invalidOpcode()
+	case 0xC8: // ENTER d16,d8 (80186)
+		size := p.readOpcodeImm16()
+		level := p.readOpcodeStream() & 0x1F
+
+		p.push16(p.BP)
+		frame := p.SP
+		if level > 0 {
+			for i := byte(1); i < level; i++ {
+				p.BP -= 2
+				p.push16(p.ReadWord(memory.NewPointer(p.SS, p.BP)))
+			}
+			p.push16(frame)
+		}
+		p.BP = frame
+		p.SP -= size
 	case 0xC9: // LEAVE (80186)
-		p.invalidOpcode()
+		p.SP = p.BP
+		p.BP = p.pop16()
 	case 0xCA: // RETF d16
 		sp := p.readOpcodeImm16()
 		p.IP = p.pop16()
 		p.CS = p.pop16()
 		p.SP += sp
+		p.flushQueue()
 	case 0xCB: // RETF
 		p.IP = p.pop16()
 		p.CS = p.pop16()
+		p.flushQueue()
 	case 0xCC: // INT 3
 		p.doInterrupt(3)
 	case 0xCD: // INT d8
@@ -972,9 +1070,7 @@ func (p *CPU) execute() error {
 			p.doInterrupt(4)
 		}
 	case 0xCF: // IRET
-		p.IP = p.pop16()
-		p.CS = p.pop16()
-		p.unpackFlags16(p.pop16())
+		p.iret()
 
 	// 0xDx
 
@@ -989,13 +1085,21 @@ func (p *CPU) execute() error {
 	case 0xD2: // _ROT r/m8,CL
 		p.readModRegRM()
 		dest := p.rmLocation()
+		p.shiftCycles(p.CL())
 		dest.writeByte(p, p.shiftOrRotate8(p.getReg(), dest.readByte(p), p.CL()))
 	case 0xD3: // _ROT r/m16,CL
 		p.readModRegRM()
 		dest := p.rmLocation()
+		p.shiftCycles(p.CL())
 		dest.writeWord(p, p.shiftOrRotate16(p.getReg(), dest.readWord(p), p.CL()))
 	case 0xD4: // AAM *d8
-		if a, b := p.AL(), p.readOpcodeStream(); b == 0 {
+		b := p.readOpcodeStream()
+		if p.isNEC() {
+			// The immediate is ignored on NEC CPU's.
+			b = 10
+		}
+
+		if a := p.AL(); b == 0 {
 			p.divisionByZero()
 		} else {
 			p.SetAH(a / b)
@@ -1003,11 +1107,15 @@ func (p *CPU) execute() error {
 			p.updateFlagsSZP16(p.AX)
 		}
 	case 0xD5: // AAD *d8
-		p.AX = (uint16(p.AL()) + uint16(p.AH())*uint16(p.readOpcodeStream())) & 0xFF
+		b := p.readOpcodeStream()
+		if p.isNEC() {
+			b = 10
+		}
+		p.AX = (uint16(p.AL()) + uint16(p.AH())*uint16(b)) & 0xFF
 		p.updateFlagsSZP16(p.AX)
 	case 0xD6: // *SALC
 		// This is XLAT on V20.
-		if !p.isV20 {
+		if !p.isNEC() {
 			if p.CF {
 				p.SetAL(0xFF)
 			} else {
@@ -1020,8 +1128,17 @@ func (p *CPU) execute() error {
 		p.SetAL(p.ReadByte(memory.NewPointer(p.getSeg(p.DS), p.BX+uint16(p.AL()))))
 	case 0xD8, 0xD9, 0xDA, 0xDB, 0xDC, 0xDD, 0xDE, 0xDF: // ESC
 		p.readModRegRM()
-		_, src := p.parseOperands()
-		src.readByte(p)
+		var addr memory.Address
+		if p.modRegRM < 0xC0 {
+			addr = p.rmLocation().getAddress()
+		}
+
+		if p.fpu != nil {
+			p.fpu.Escape(op, p.modRegRM, memory.NewPointer(p.CS, p.decodeAt), addr)
+		} else if p.modRegRM < 0xC0 {
+			// Without a coprocessor the CPU still performs the operand read.
+			p.ReadByte(addr.Pointer())
+		}
 
 	// 0xEx
 
@@ -1052,6 +1169,7 @@ func (p *CPU) execute() error {
 		ip := p.readOpcodeImm16()
 		p.CS = p.readOpcodeImm16()
 		p.IP = ip
+		p.flushQueue()
 	case 0xEB: // JMP rel8
 		p.jmpRel8()
 	case 0xEC: // IN AL,[DX]
@@ -1093,6 +1211,7 @@ func (p *CPU) execute() error {
 		p.invalidOpcode()
 	}
 
+	p.cycleCount += p.instructionCycles()
 	p.stats.NumInstructions++
 	return nil
 }
@@ -1303,7 +1422,7 @@ func (p *CPU) grp3a() {
 		p.updateFlagsSZP8(uint8(res))
 		p.CF = p.AH() != 0
 		p.OF = p.CF
-		if !p.isV20 {
+		if !p.has186() {
 			p.ZF = false
 		}
 	case 5:
@@ -1315,7 +1434,7 @@ func (p *CPU) grp3a() {
 			p.CF = p.AH() != 0x0
 		}
 		p.OF = p.CF
-		if !p.isV20 {
+		if !p.has186() {
 			p.ZF = false
 		}
 	case 6:
@@ -1352,7 +1471,7 @@ func (p *CPU) grp3b() {
 		p.updateFlagsSZP16(uint16(res))
 		p.CF = p.DX != 0
 		p.OF = p.CF
-		if !p.isV20 {
+		if !p.has186() {
 			p.ZF = false
 		}
 	case 5:
@@ -1365,7 +1484,7 @@ func (p *CPU) grp3b() {
 			p.CF = p.DX != 0x0
 		}
 		p.OF = p.CF
-		if !p.isV20 {
+		if !p.has186() {
 			p.ZF = false
 		}
 	case 6:
@@ -1418,19 +1537,23 @@ func (p *CPU) grp5() {
 	case 2:
 		p.push16(p.IP)
 		p.IP = uint16(v)
+		p.flushQueue()
 		return
 	case 3:
 		p.push16(p.CS)
 		p.push16(p.IP)
 		p.IP = uint16(v)
 		p.CS = p.ReadWord(dest.getAddress().AddInt(2).Pointer())
+		p.flushQueue()
 		return
 	case 4:
 		p.IP = uint16(v)
+		p.flushQueue()
 		return
 	case 5:
 		p.IP = uint16(v)
 		p.CS = p.ReadWord(dest.getAddress().AddInt(2).Pointer())
+		p.flushQueue()
 		return
 	case 6:
 		p.push16(uint16(v))
@@ -1449,6 +1572,7 @@ func (p *CPU) jmpRel8() uint16 {
 	diff := uint16(int8(p.readOpcodeStream()))
 	ip := p.IP
 	p.IP += diff
+	p.flushQueue()
 	return ip
 }
 
@@ -1456,11 +1580,13 @@ func (p *CPU) jmpRel16() uint16 {
 	diff := p.readOpcodeImm16()
 	ip := p.IP
 	p.IP += diff
+	p.flushQueue()
 	return ip
 }
 
 func (p *CPU) jmpRel8Cond(cond bool) {
 	if cond {
+		p.branchCycles()
 		p.jmpRel8()
 	} else {
 		p.readOpcodeStream()
@@ -1469,7 +1595,7 @@ func (p *CPU) jmpRel8Cond(cond bool) {
 
 func (p *CPU) invalidOpcode() {
 	log.Printf("invalid opcode: 0x%X", p.opcode)
-	if p.isV20 {
+	if p.is80186() {
 		p.IP = p.decodeAt
 		p.doInterrupt(6)
 	} else {
@@ -1480,10 +1606,7 @@ func (p *CPU) invalidOpcode() {
 func (p *CPU) isValidRepeat() (bool, bool) {
 	switch p.opcode {
 	case 0x6C, 0x6D, 0x6E, 0x6F:
-		if p.isV20 {
-			return false, false
-		}
-		fallthrough
+		return p.has186(), false
 	case 0xA4, 0xA5, 0xAC, 0xAD, 0xAA, 0xAB:
 		return true, false
 	case 0xA6, 0xA7, 0xAE, 0xAF:
@@ -1494,6 +1617,7 @@ func (p *CPU) isValidRepeat() (bool, bool) {
 
 func (p *CPU) doRepeat() error {
 	if valid, primitive := p.isValidRepeat(); valid {
+		p.cycleCount += p.timing.repeat
 		ip := p.IP
 		for p.CX > 0 {
 			p.IP = ip
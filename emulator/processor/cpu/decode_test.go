@@ -35,7 +35,7 @@ import (
 	"github.com/andreas-jonsson/virtualxt/emulator/processor/validator"
 )
 
-func loadBin(t *testing.T, name string) []byte {
+func loadBin(t testing.TB, name string) []byte {
 	bin, err := ioutil.ReadFile(name)
 	if err != nil {
 		t.Fatal(err)
@@ -43,10 +43,20 @@ func loadBin(t *testing.T, name string) []byte {
 	return bin
 }
 
-func runTest(t *testing.T, progName string) *CPU {
-	validator.Initialize(progName+"_validator.json", validator.DefulatQueueSize, validator.DefaultBufferSize)
-	defer validator.Shutdown()
+func runTest(t *testing.T, progName string, cache bool) *CPU {
+	v := validator.New(progName+"_validator.json", validator.DefulatQueueSize, validator.DefaultBufferSize)
+	defer v.Shutdown()
 
+	p := newTestCPU(t, progName)
+	defer p.Close()
+	p.SetValidator(v)
+	p.SetInstructionCache(cache)
+
+	runProgram(t, p)
+	return p
+}
+
+func newTestCPU(t testing.TB, progName string) *CPU {
 	p, errs := NewCPU([]peripheral.Peripheral{
 		&ram.Device{Clear: true},
 		&rom.Device{
@@ -57,20 +67,25 @@ func runTest(t *testing.T, progName string) *CPU {
 		&pic.Device{},
 		//&debug.Device{},
 	})
-	defer p.Close()
 
 	for _, err := range errs {
 		t.Error(err)
 	}
 
-	//debug.EnableDebug = true
-
 	// Tests are written for 80186+ machines.
-	p.SetV20Support(true)
+	p.SetModel(Intel80186)
+
+	// The expected results were recorded on a CPU that clears flag bits 12-15.
+	// The interrupt test even jumps to the address it pops from FLAGS.
+	p.fixedFlags = 0
 
 	p.Reset()
-	p.IP = 0xFFF0
-	p.CS = 0xF000
+	return p
+}
+
+func runProgram(t testing.TB, p *CPU) {
+	p.Registers = processor.Registers{CS: 0xF000, IP: 0xFFF0}
+	p.halted = false
 
 	for {
 		if _, err := p.Step(); err != nil {
@@ -83,22 +98,39 @@ func runTest(t *testing.T, progName string) *CPU {
 			t.Fatal("CPU hit breakpoint!")
 		}
 	}
-	return p
 }
 
-func runTestAndVerify(t *testing.T, progName string, nerr int) {
-	p := runTest(t, progName)
-	res := loadBin(t, fmt.Sprintf("testdata/res_%s.bin", progName))
-	cerr := 0
-	for i, v := range res {
-		if r := p.ReadByte(memory.NewPointer(0, uint16(i))); r != v {
-			t.Logf("Invalid result at offset 0x%X. (Got 0x%X but expected 0x%X)", i, r, v)
-			cerr++
+// withCache runs the test with and without the instruction cache.
+func withCache(t *testing.T, test func(t *testing.T, cache bool)) {
+	for _, cache := range []bool{false, true} {
+		name := "NoCache"
+		if cache {
+			name = "Cache"
 		}
+		t.Run(name, func(t *testing.T) {
+			if cache && validator.Enabled {
+				t.Skip("the instruction cache is disabled while validating")
+			}
+			test(t, cache)
+		})
 	}
-	if cerr != nerr {
-		t.Fatalf("%d bytes diff", cerr)
-	}
+}
+
+func runTestAndVerify(t *testing.T, progName string, nerr int) {
+	withCache(t, func(t *testing.T, cache bool) {
+		p := runTest(t, progName, cache)
+		res := loadBin(t, fmt.Sprintf("testdata/res_%s.bin", progName))
+		cerr := 0
+		for i, v := range res {
+			if r := p.ReadByte(memory.NewPointer(0, uint16(i))); r != v {
+				t.Logf("Invalid result at offset 0x%X. (Got 0x%X but expected 0x%X)", i, r, v)
+				cerr++
+			}
+		}
+		if cerr != nerr {
+			t.Fatalf("%d bytes diff", cerr)
+		}
+	})
 }
 
 func TestAdd(t *testing.T) {
@@ -134,10 +166,12 @@ func TestInterrupt(t *testing.T) {
 }
 
 func TestJmpmov(t *testing.T) {
-	p := runTest(t, "jmpmov")
-	if r := p.ReadWord(memory.Pointer(0)); r != 0x4001 {
-		t.Errorf("Invalid result! (Got 0x%X but expected 0x4001)", r)
-	}
+	withCache(t, func(t *testing.T, cache bool) {
+		p := runTest(t, "jmpmov", cache)
+		if r := p.ReadWord(memory.Pointer(0)); r != 0x4001 {
+			t.Errorf("Invalid result! (Got 0x%X but expected 0x4001)", r)
+		}
+	})
 }
 
 func TestJump1(t *testing.T) {
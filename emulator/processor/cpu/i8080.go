@@ -0,0 +1,326 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package cpu
+
+import (
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+)
+
+// The NEC CPU's can execute 8080 code in emulation mode. The 8080 registers are mapped to the native
+// registers as A=AL, B=CH, C=CL, D=DH, E=DL, H=BH, L=BL and SP=BP. Data and stack are accessed through DS.
+
+var cycles8080 = [0x100]byte{
+	4, 10, 7, 5, 5, 5, 7, 4, 4, 10, 7, 5, 5, 5, 7, 4,
+	4, 10, 7, 5, 5, 5, 7, 4, 4, 10, 7, 5, 5, 5, 7, 4,
+	4, 10, 16, 5, 5, 5, 7, 4, 4, 10, 16, 5, 5, 5, 7, 4,
+	4, 10, 13, 5, 10, 10, 10, 4, 4, 10, 13, 5, 5, 5, 7, 4,
+	5, 5, 5, 5, 5, 5, 7, 5, 5, 5, 5, 5, 5, 5, 7, 5,
+	5, 5, 5, 5, 5, 5, 7, 5, 5, 5, 5, 5, 5, 5, 7, 5,
+	5, 5, 5, 5, 5, 5, 7, 5, 5, 5, 5, 5, 5, 5, 7, 5,
+	7, 7, 7, 7, 7, 7, 7, 7, 5, 5, 5, 5, 5, 5, 7, 5,
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4,
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4,
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4,
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4,
+	5, 10, 10, 10, 11, 11, 7, 11, 5, 10, 10, 10, 11, 17, 7, 11,
+	5, 10, 10, 10, 11, 11, 7, 11, 5, 10, 10, 10, 11, 17, 7, 11,
+	5, 10, 10, 18, 11, 11, 7, 11, 5, 5, 10, 4, 11, 17, 7, 11,
+	5, 10, 10, 4, 11, 11, 7, 11, 5, 5, 10, 4, 11, 17, 7, 11,
+}
+
+// Native register index for B, C, D, E, H, L, M and A.
+var registers8080 = [8]byte{5, 1, 6, 2, 7, 3, 0xFF, 0}
+
+func (p *CPU) pair8080(n byte) *uint16 {
+	return [4]*uint16{&p.CX, &p.DX, &p.BX, &p.BP}[n]
+}
+
+func (p *CPU) get8080(n byte) byte {
+	if n == 6 {
+		return p.ReadByte(memory.NewPointer(p.DS, p.BX))
+	}
+	return (dataLocation(registers8080[n]) | registerLocation).readByte(p)
+}
+
+func (p *CPU) set8080(n, v byte) {
+	if n == 6 {
+		p.WriteByte(memory.NewPointer(p.DS, p.BX), v)
+		return
+	}
+	(dataLocation(registers8080[n]) | registerLocation).writeByte(p, v)
+}
+
+func (p *CPU) push8080(v uint16) {
+	p.BP -= 2
+	p.WriteWord(memory.NewPointer(p.DS, p.BP), v)
+}
+
+func (p *CPU) pop8080() uint16 {
+	v := p.ReadWord(memory.NewPointer(p.DS, p.BP))
+	p.BP += 2
+	return v
+}
+
+func (p *CPU) jump8080(addr uint16) {
+	p.IP = addr
+	p.flushQueue()
+}
+
+func (p *CPU) call8080(addr uint16) {
+	p.push8080(p.IP)
+	p.jump8080(addr)
+}
+
+func (p *CPU) cond8080(n byte) bool {
+	switch n {
+	case 0:
+		return !p.ZF
+	case 1:
+		return p.ZF
+	case 2:
+		return !p.CF
+	case 3:
+		return p.CF
+	case 4:
+		return !p.PF
+	case 5:
+		return p.PF
+	case 6:
+		return !p.SF
+	default:
+		return p.SF
+	}
+}
+
+func (p *CPU) alu8080(op, v byte) {
+	a := p.AL()
+	carry := b2ui16(p.CF && (op == 1 || op == 3))
+
+	switch op {
+	case 0, 1: // ADD/ADC
+		res := uint16(a) + uint16(v) + carry
+		p.AF = uint16(a&0xF)+uint16(v&0xF)+carry > 0xF
+		p.CF = res > 0xFF
+		a = byte(res)
+	case 2, 3, 7: // SUB/SBB/CMP
+		res := int(a) - int(v) - int(carry)
+		// The auxiliary carry is set if there was no borrow from bit 4.
+		p.AF = int(a&0xF)-int(v&0xF)-int(carry) >= 0
+		p.CF = res < 0
+		if op == 7 {
+			p.updateFlagsSZP8(byte(res))
+			return
+		}
+		a = byte(res)
+	case 4: // ANA
+		p.AF = (a|v)&8 != 0
+		p.CF = false
+		a &= v
+	case 5: // XRA
+		p.AF, p.CF = false, false
+		a ^= v
+	case 6: // ORA
+		p.AF, p.CF = false, false
+		a |= v
+	}
+	p.SetAL(a)
+	p.updateFlagsSZP8(a)
+}
+
+func (p *CPU) daa8080() {
+	a := p.AL()
+	var corr byte
+	cf := p.CF
+
+	if a&0xF > 9 || p.AF {
+		corr |= 0x06
+	}
+	if a>>4 > 9 || cf || (a>>4 >= 9 && a&0xF > 9) {
+		corr |= 0x60
+		cf = true
+	}
+
+	p.AF = (a&0xF)+(corr&0xF) > 0xF
+	a += corr
+	p.CF = cf
+	p.SetAL(a)
+	p.updateFlagsSZP8(a)
+}
+
+func (p *CPU) execute8080() {
+	op := p.readOpcodeStream()
+	p.opcode = op
+	p.cycleCount += int(cycles8080[op])
+
+	y, z := (op>>3)&7, op&7
+	rp := y >> 1
+
+	switch op >> 6 {
+	case 0:
+		switch z {
+		case 0: // NOP
+		case 1:
+			if y&1 == 0 { // LXI rp,d16
+				*p.pair8080(rp) = p.readOpcodeImm16()
+			} else { // DAD rp
+				res := uint32(p.BX) + uint32(*p.pair8080(rp))
+				p.BX = uint16(res)
+				p.CF = res > 0xFFFF
+			}
+		case 2:
+			switch y {
+			case 0, 2: // STAX B/D
+				p.WriteByte(memory.NewPointer(p.DS, *p.pair8080(rp)), p.AL())
+			case 1, 3: // LDAX B/D
+				p.SetAL(p.ReadByte(memory.NewPointer(p.DS, *p.pair8080(rp))))
+			case 4: // SHLD a16
+				p.WriteWord(memory.NewPointer(p.DS, p.readOpcodeImm16()), p.BX)
+			case 5: // LHLD a16
+				p.BX = p.ReadWord(memory.NewPointer(p.DS, p.readOpcodeImm16()))
+			case 6: // STA a16
+				p.WriteByte(memory.NewPointer(p.DS, p.readOpcodeImm16()), p.AL())
+			case 7: // LDA a16
+				p.SetAL(p.ReadByte(memory.NewPointer(p.DS, p.readOpcodeImm16())))
+			}
+		case 3:
+			if y&1 == 0 { // INX rp
+				*p.pair8080(rp)++
+			} else { // DCX rp
+				*p.pair8080(rp)--
+			}
+		case 4: // INR r
+			v := p.get8080(y) + 1
+			p.AF = v&0xF == 0
+			p.set8080(y, v)
+			p.updateFlagsSZP8(v)
+		case 5: // DCR r
+			v := p.get8080(y) - 1
+			p.AF = v&0xF != 0xF
+			p.set8080(y, v)
+			p.updateFlagsSZP8(v)
+		case 6: // MVI r,d8
+			p.set8080(y, p.readOpcodeStream())
+		case 7:
+			a := p.AL()
+			switch y {
+			case 0: // RLC
+				p.CF = a&0x80 != 0
+				p.SetAL(a<<1 | a>>7)
+			case 1: // RRC
+				p.CF = a&1 != 0
+				p.SetAL(a>>1 | a<<7)
+			case 2: // RAL
+				p.SetAL(a<<1 | byte(b2ui16(p.CF)))
+				p.CF = a&0x80 != 0
+			case 3: // RAR
+				p.SetAL(a>>1 | byte(b2ui16(p.CF))<<7)
+				p.CF = a&1 != 0
+			case 4: // DAA
+				p.daa8080()
+			case 5: // CMA
+				p.SetAL(^a)
+			case 6: // STC
+				p.CF = true
+			case 7: // CMC
+				p.CF = !p.CF
+			}
+		}
+	case 1:
+		if op == 0x76 { // HLT
+			p.halted = true
+		} else { // MOV r,r
+			p.set8080(y, p.get8080(z))
+		}
+	case 2: // ALU r
+		p.alu8080(y, p.get8080(z))
+	case 3:
+		switch z {
+		case 0: // Rcc
+			if p.cond8080(y) {
+				p.cycleCount += 6
+				p.jump8080(p.pop8080())
+			}
+		case 1:
+			switch {
+			case y == 6: // POP PSW
+				v := p.pop8080()
+				p.unpackFlags8(byte(v))
+				p.SetAL(byte(v >> 8))
+			case y&1 == 0: // POP rp
+				*p.pair8080(rp) = p.pop8080()
+			case y == 1, y == 3: // RET
+				p.jump8080(p.pop8080())
+			case y == 5: // PCHL
+				p.jump8080(p.BX)
+			case y == 7: // SPHL
+				p.BP = p.BX
+			}
+		case 2: // Jcc a16
+			if addr := p.readOpcodeImm16(); p.cond8080(y) {
+				p.jump8080(addr)
+			}
+		case 3:
+			switch y {
+			case 0, 1: // JMP a16
+				p.jump8080(p.readOpcodeImm16())
+			case 2: // OUT d8
+				p.OutByte(uint16(p.readOpcodeStream()), p.AL())
+			case 3: // IN d8
+				p.SetAL(p.InByte(uint16(p.readOpcodeStream())))
+			case 4: // XTHL
+				ptr := memory.NewPointer(p.DS, p.BP)
+				v := p.ReadWord(ptr)
+				p.WriteWord(ptr, p.BX)
+				p.BX = v
+			case 5: // XCHG
+				p.BX, p.DX = p.DX, p.BX
+			case 6: // DI
+				p.IF = false
+			case 7: // EI
+				p.IF = true
+			}
+		case 4: // Ccc a16
+			if addr := p.readOpcodeImm16(); p.cond8080(y) {
+				p.cycleCount += 6
+				p.call8080(addr)
+			}
+		case 5:
+			switch {
+			case y == 6: // PUSH PSW
+				p.push8080(uint16(p.AL())<<8 | uint16(p.packFlags8()))
+			case y&1 == 0: // PUSH rp
+				p.push8080(*p.pair8080(rp))
+			case op == 0xED && p.peakOpcodeStream() == 0xED: // CALLN d8
+				p.readOpcodeStream()
+				p.doInterrupt(int(p.readOpcodeStream()))
+			case op == 0xED && p.peakOpcodeStream() == 0xFD: // RETEM
+				p.readOpcodeStream()
+				p.retem()
+			default: // CALL a16
+				p.call8080(p.readOpcodeImm16())
+			}
+		case 6: // ALU d8
+			p.alu8080(y, p.readOpcodeStream())
+		case 7: // RST n
+			p.call8080(uint16(y) * 8)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package cpu
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Model int
+
+const (
+	Intel8088 Model = iota
+	Intel8086
+	NECV20
+	NECV30
+	Intel80188
+	Intel80186
+)
+
+var modelNames = [...]string{"8088", "8086", "V20", "V30", "80188", "80186"}
+
+func (m Model) String() string {
+	if int(m) < len(modelNames) {
+		return modelNames[m]
+	}
+	return fmt.Sprintf("Model(%d)", int(m))
+}
+
+// ParseModel returns the CPU model matching the name. Case is ignored.
+func ParseModel(name string) (Model, error) {
+	for i, n := range modelNames {
+		if strings.EqualFold(n, name) {
+			return Model(i), nil
+		}
+	}
+	return Intel8088, fmt.Errorf("unknown CPU model: %s", name)
+}
+
+func (p *CPU) SetModel(m Model) {
+	p.model = m
+	switch m {
+	case Intel8086:
+		p.timing = &timing8086
+	case NECV20:
+		p.timing = &timingV20
+	case NECV30:
+		p.timing = &timingV30
+	case Intel80188:
+		p.timing = &timing80188
+	case Intel80186:
+		p.timing = &timing80186
+	default:
+		p.model = Intel8088
+		p.timing = &timing8088
+	}
+	// Bits 12-15 read as set on the 8086 and 80186. Only the 286 and later clear them.
+	// On NEC CPU's bit 15 is the mode flag.
+	p.fixedFlags = 0xF000
+	if p.isNEC() {
+		p.fixedFlags = 0x7000
+	} else {
+		p.emulation, p.modeWritable = false, false
+	}
+}
+
+func (p *CPU) Model() Model {
+	return p.model
+}
+
+// has186 returns true if the CPU supports the 80186 instruction set.
+// This includes the NEC CPU's.
+func (p *CPU) has186() bool {
+	return p.model >= NECV20
+}
+
+func (p *CPU) isNEC() bool {
+	return p.model == NECV20 || p.model == NECV30
+}
+
+func (p *CPU) is80186() bool {
+	return p.model == Intel80188 || p.model == Intel80186
+}
@@ -0,0 +1,109 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package cpu
+
+import "testing"
+
+func stepCode(t *testing.T, m Model, code []byte, n int) *CPU {
+	p := newCodeCPU(t, code)
+	p.SetModel(m)
+	p.SS, p.SP = 0x2000, 0x100
+
+	for i := 0; i < n; i++ {
+		if _, err := p.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+func TestParseModel(t *testing.T) {
+	for i, n := range modelNames {
+		if m, err := ParseModel(n); err != nil || m != Model(i) {
+			t.Errorf("could not parse %s", n)
+		}
+	}
+	if _, err := ParseModel("80286"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestModel8086(t *testing.T) {
+	// PUSHF, POP AX
+	for _, m := range []Model{Intel8088, Intel80186, Intel80188} {
+		if p := stepCode(t, m, []byte{0x9C, 0x58}, 2); p.AX&0xF000 != 0xF000 {
+			t.Errorf("%v: expected flag bits 12-15 to be set, got 0x%X", m, p.AX)
+		}
+	}
+
+	// 0x64 is decoded as JZ.
+	if p := stepCode(t, Intel8086, []byte{0x64, 0x10}, 1); p.IP != 2 {
+		t.Errorf("expected JZ not taken, got IP=0x%X", p.IP)
+	}
+
+	// 0xC1 is decoded as RET.
+	p := newCodeCPU(t, []byte{0xC1})
+	p.SP = 0x100
+	p.WriteWord(p.stackTop(), 0x1234)
+	if _, err := p.Step(); err != nil || p.IP != 0x1234 {
+		t.Errorf("expected RET to 0x1234, got IP=0x%X", p.IP)
+	}
+
+	// PUSH SP pushes the decremented value.
+	if p := stepCode(t, Intel8088, []byte{0x54, 0x58}, 2); p.AX != 0xFE {
+		t.Errorf("expected 0xFE, got 0x%X", p.AX)
+	}
+}
+
+func TestModel80186(t *testing.T) {
+	// ENTER 4,0 ; LEAVE
+	p := stepCode(t, Intel80186, []byte{0xC8, 0x04, 0x00, 0x00}, 1)
+	if p.BP != 0xFE || p.SP != 0xFA {
+		t.Errorf("ENTER: expected BP=0xFE and SP=0xFA, got BP=0x%X and SP=0x%X", p.BP, p.SP)
+	}
+	if p = stepCode(t, Intel80186, []byte{0xC8, 0x04, 0x00, 0x00, 0xC9}, 2); p.SP != 0x100 {
+		t.Errorf("LEAVE: expected SP=0x100, got 0x%X", p.SP)
+	}
+
+	// PUSH -2 ; POP CX ; IMUL AX,CX,7
+	if p = stepCode(t, Intel80186, []byte{0x6A, 0xFE, 0x59, 0x6B, 0xC1, 0x07}, 3); p.AX != 0xFFF2 || p.CX != 0xFFFE {
+		t.Errorf("IMUL: expected AX=0xFFF2 and CX=0xFFFE, got AX=0x%X and CX=0x%X", p.AX, p.CX)
+	}
+
+	// 0x0F raises the invalid opcode exception.
+	p = newCodeCPU(t, []byte{0x0F})
+	p.SetModel(Intel80186)
+	p.SS, p.SP = 0x2000, 0x100
+	p.WriteWord(6*4, 0x5678)
+	if _, err := p.Step(); err != nil || p.IP != 0x5678 {
+		t.Errorf("expected INT 6, got IP=0x%X", p.IP)
+	}
+}
+
+func TestModelV20(t *testing.T) {
+	// AAM 16 is AAM 10 on NEC CPU's.
+	p := newCodeCPU(t, []byte{0xD4, 0x10})
+	p.SetModel(NECV20)
+	p.AX = 42
+	if _, err := p.Step(); err != nil || p.AX != 0x402 {
+		t.Errorf("expected AX=0x402, got 0x%X", p.AX)
+	}
+}
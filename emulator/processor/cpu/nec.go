@@ -0,0 +1,213 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+/*
+References:
+	NEC uPD70108/70116 User's Manual
+*/
+
+package cpu
+
+import (
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+)
+
+// extendedOpcode executes the NEC specific instructions prefixed with 0x0F.
+func (p *CPU) extendedOpcode() {
+	op := p.readOpcodeStream()
+	switch {
+	case op >= 0x10 && op <= 0x1F: // TEST1/CLR1/SET1/NOT1
+		p.readModRegRM()
+		p.bitOperation(op)
+	case op == 0x20: // ADD4S
+		p.bcdString(func(dst, src int, carry bool) (int, bool) {
+			res := dst + src + int(b2ui16(carry))
+			return res % 100, res > 99
+		}, true)
+	case op == 0x22: // SUB4S
+		p.bcdString(subBCD, true)
+	case op == 0x26: // CMP4S
+		p.bcdString(subBCD, false)
+	case op == 0x28: // ROL4 r/m8
+		p.readModRegRM()
+		dest := p.rmLocation()
+		v := uint16(dest.readByte(p))<<4 | uint16(p.AL()&0xF)
+		p.SetAL(p.AL()&0xF0 | byte(v>>8))
+		dest.writeByte(p, byte(v))
+		p.cycleCount += 25
+	case op == 0x2A: // ROR4 r/m8
+		p.readModRegRM()
+		dest := p.rmLocation()
+		v := dest.readByte(p)
+		al := p.AL()
+		p.SetAL(al&0xF0 | v&0xF)
+		dest.writeByte(p, al<<4|v>>4)
+		p.cycleCount += 29
+	case op == 0x31, op == 0x39: // INS reg8,reg8/d4
+		p.readModRegRM()
+		offset, length := p.bitField(op == 0x39)
+		mask := uint32(1)<<length - 1
+		v := p.readBitField(p.ES, p.DI, offset+length)
+		v = v&^(mask<<offset) | (uint32(p.AX)&mask)<<offset
+
+		p.WriteWord(memory.NewPointer(p.ES, p.DI), uint16(v))
+		if offset+length > 16 {
+			p.WriteWord(memory.NewPointer(p.ES, p.DI+2), uint16(v>>16))
+		}
+
+		if p.advanceBitField(offset + length) {
+			p.DI += 2
+		}
+		p.cycleCount += 35
+	case op == 0x33, op == 0x3B: // EXT reg8,reg8/d4
+		p.readModRegRM()
+		offset, length := p.bitField(op == 0x3B)
+		v := p.readBitField(p.getSeg(p.DS), p.SI, offset+length)
+		p.AX = uint16((v >> offset) & (uint32(1)<<length - 1))
+
+		if p.advanceBitField(offset + length) {
+			p.SI += 2
+		}
+		p.cycleCount += 26
+	case op == 0xFF: // BRKEM d8
+		n := p.readOpcodeStream()
+		p.doInterrupt(int(n))
+		p.emulation, p.modeWritable = true, true
+	default:
+		p.invalidOpcode()
+	}
+}
+
+// retem returns from BRKEM. Unlike IRET it always restores the mode flag.
+func (p *CPU) retem() {
+	flags := p.iret()
+	p.emulation = flags&0x8000 == 0
+	p.modeWritable = false
+}
+
+func (p *CPU) bitOperation(op byte) {
+	dest := p.rmLocation()
+	wide := op&1 != 0
+
+	var bit byte
+	if op&8 != 0 {
+		bit = p.readOpcodeStream()
+	} else {
+		bit = p.CL()
+	}
+
+	if wide {
+		bit &= 0xF
+	} else {
+		bit &= 0x7
+	}
+
+	var v uint16
+	if wide {
+		v = dest.readWord(p)
+	} else {
+		v = uint16(dest.readByte(p))
+	}
+
+	mask := uint16(1) << bit
+	switch (op >> 1) & 3 {
+	case 0: // TEST1
+		p.ZF = v&mask == 0
+		p.CF, p.OF = false, false
+		p.cycleCount += 3
+		return
+	case 1: // CLR1
+		v &^= mask
+	case 2: // SET1
+		v |= mask
+	case 3: // NOT1
+		v ^= mask
+	}
+	p.cycleCount += 5
+
+	if wide {
+		dest.writeWord(p, v)
+	} else {
+		dest.writeByte(p, byte(v))
+	}
+}
+
+// bitField returns the bit offset and length of a bit field instruction. The offset is stored in
+// the r/m register and the length in the reg register, or the immediate value, minus one.
+func (p *CPU) bitField(imm bool) (uint, uint) {
+	offset := uint((dataLocation(p.modRegRM&7) | registerLocation).readByte(p) & 0xF)
+	var length byte
+	if imm {
+		length = p.readOpcodeStream()
+	} else {
+		length = p.regLocation().readByte(p)
+	}
+	return offset, uint(length&0xF) + 1
+}
+
+func (p *CPU) readBitField(seg, offset uint16, end uint) uint32 {
+	v := uint32(p.ReadWord(memory.NewPointer(seg, offset)))
+	if end > 16 {
+		v |= uint32(p.ReadWord(memory.NewPointer(seg, offset+2))) << 16
+	}
+	return v
+}
+
+// advanceBitField updates the bit offset and returns true if the field crossed a word boundary.
+func (p *CPU) advanceBitField(offset uint) bool {
+	(dataLocation(p.modRegRM&7) | registerLocation).writeByte(p, byte(offset&0xF))
+	return offset > 0xF
+}
+
+func subBCD(dst, src int, carry bool) (int, bool) {
+	res := dst - src - int(b2ui16(carry))
+	if res < 0 {
+		return res + 100, true
+	}
+	return res, false
+}
+
+// bcdString performs the operation on the packed BCD strings at DS:SI and ES:DI. CL is the number of digits.
+func (p *CPU) bcdString(op func(int, int, bool) (int, bool), store bool) {
+	si, di := p.SI, p.DI
+	seg := p.getSeg(p.DS)
+	carry, zero := false, true
+
+	for i := 0; i < (int(p.CL())+1)/2; i++ {
+		src := p.ReadByte(memory.NewPointer(seg, si))
+		dstPtr := memory.NewPointer(p.ES, di)
+		dst := p.ReadByte(dstPtr)
+
+		var res int
+		res, carry = op(int(dst>>4)*10+int(dst&0xF), int(src>>4)*10+int(src&0xF), carry)
+		v := byte(res/10)<<4 | byte(res%10)
+		if v != 0 {
+			zero = false
+		}
+		if store {
+			p.WriteByte(dstPtr, v)
+		}
+
+		si++
+		di++
+		p.cycleCount += 19
+	}
+	p.CF, p.ZF = carry, zero
+}
@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package cpu
+
+import (
+	"testing"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+)
+
+func TestExtendedOpcodes(t *testing.T) {
+	// SET1 AX,3 ; TEST1 AX,3
+	p := stepCode(t, NECV20, []byte{0x0F, 0x1D, 0xC0, 0x03, 0x0F, 0x19, 0xC0, 0x03}, 2)
+	if p.AX != 8 || p.ZF {
+		t.Errorf("expected AX=8 and ZF clear, got AX=0x%X", p.AX)
+	}
+
+	// ADD4S
+	p = newCodeCPU(t, []byte{0x0F, 0x20})
+	p.SetModel(NECV20)
+	p.DS, p.ES, p.SI, p.DI = 0x3000, 0x3000, 0, 2
+	p.SetCL(4)
+	p.WriteWord(memory.NewPointer(p.DS, p.SI), 0x1245)
+	p.WriteWord(memory.NewPointer(p.ES, p.DI), 0x5038)
+	if _, err := p.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if v := p.ReadWord(memory.NewPointer(p.ES, p.DI)); v != 0x6283 || p.CF || p.ZF {
+		t.Errorf("expected 0x6283, got 0x%X", v)
+	}
+
+	// EXT DL,CL
+	p = newCodeCPU(t, []byte{0x0F, 0x33, 0xCA})
+	p.SetModel(NECV20)
+	p.DS, p.SI = 0x3000, 0
+	p.DX, p.CX = 14, 3
+	p.WriteWord(memory.NewPointer(p.DS, 0), 0xC000)
+	p.WriteWord(memory.NewPointer(p.DS, 2), 0x0005)
+	if _, err := p.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if p.AX != 7 || p.DX != 2 || p.SI != 2 {
+		t.Errorf("expected AX=7, DX=2 and SI=2, got AX=0x%X, DX=0x%X and SI=%d", p.AX, p.DX, p.SI)
+	}
+}
+
+func TestEmulationMode(t *testing.T) {
+	p := newCodeCPU(t, []byte{0x0F, 0xFF, 0x20, 0x90})
+	p.SetModel(NECV20)
+	p.SS, p.SP = 0x2000, 0x100
+	p.DS, p.BP = 0x3000, 0x100
+	p.WriteWord(0x20*4, 0x100)
+	p.WriteWord(0x20*4+2, 0x1000)
+
+	// MVI A,42h ; MVI B,1 ; ADD B ; PUSH B ; POP H ; RETEM
+	for i, v := range []byte{0x3E, 0x42, 0x06, 0x01, 0x80, 0xC5, 0xE1, 0xED, 0xFD} {
+		p.WriteByte(memory.NewPointer(0x1000, uint16(0x100+i)), v)
+	}
+
+	steps := func(n int) {
+		for i := 0; i < n; i++ {
+			if _, err := p.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	steps(1)
+	if !p.emulation || p.IP != 0x100 {
+		t.Fatalf("expected emulation mode at 0x100, got IP=0x%X", p.IP)
+	}
+	if flags := p.ReadWord(memory.NewPointer(p.SS, p.SP+4)); flags&0x8000 == 0 {
+		t.Errorf("expected mode flag to be set, got 0x%X", flags)
+	}
+
+	steps(5)
+	if p.AL() != 0x43 || p.BX != 0x100 || p.BP != 0x100 {
+		t.Errorf("expected AL=0x43, BX=0x100 and BP=0x100, got AL=0x%X, BX=0x%X and BP=0x%X", p.AL(), p.BX, p.BP)
+	}
+
+	steps(1)
+	if p.emulation || p.IP != 3 || p.SP != 0x100 {
+		t.Errorf("expected native mode at 3, got IP=0x%X", p.IP)
+	}
+}
+
+func TestModeFlag(t *testing.T) {
+	// XOR AX,AX ; PUSH AX ; PUSH CS ; PUSH 8 ; IRET ; NOP
+	p := stepCode(t, NECV20, []byte{0x31, 0xC0, 0x50, 0x0E, 0x68, 0x08, 0x00, 0xCF, 0x90}, 5)
+	if p.emulation || p.IP != 8 {
+		t.Errorf("expected IRET to stay in native mode, got IP=0x%X", p.IP)
+	}
+
+	// BRKEM 20h ; NOP
+	p = stepCode(t, NECV20, []byte{0x0F, 0xFF, 0x20, 0x90}, 0)
+	p.WriteWord(0x20*4, 0x100)
+	p.WriteWord(0x20*4+2, 0x1000)
+	p.WriteWord(0x21*4, 0x200)
+	p.WriteWord(0x21*4+2, 0x1000)
+	p.WriteWord(0x22*4, 0x300)
+	p.WriteWord(0x22*4+2, 0x1000)
+
+	// CALLN 21h ; RETEM
+	for i, v := range []byte{0xED, 0xED, 0x21, 0xED, 0xFD} {
+		p.WriteByte(memory.NewPointer(0x1000, uint16(0x100+i)), v)
+	}
+	// INT 22h ; IRET
+	p.WriteByte(memory.NewPointer(0x1000, 0x200), 0xCD)
+	p.WriteByte(memory.NewPointer(0x1000, 0x201), 0x22)
+	p.WriteByte(memory.NewPointer(0x1000, 0x202), 0xCF)
+	// IRET
+	p.WriteByte(memory.NewPointer(0x1000, 0x300), 0xCF)
+
+	for i, expected := range []struct {
+		emulation bool
+		ip        uint16
+	}{{true, 0x100}, {false, 0x200}, {false, 0x300}, {false, 0x202}, {true, 0x103}, {false, 3}} {
+		if _, err := p.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if p.emulation != expected.emulation || p.IP != expected.ip {
+			t.Errorf("step %d: expected emulation=%v at 0x%X, got emulation=%v at 0x%X", i, expected.emulation, expected.ip, p.emulation, p.IP)
+		}
+	}
+}
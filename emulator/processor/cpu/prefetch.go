@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package cpu
+
+import (
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+)
+
+const maxQueueSize = 6
+
+// The BIU fetches ahead of the EU so code that modifies the next few bytes
+// will still execute the stale bytes that are already in the queue.
+type prefetchQueue struct {
+	enabled, requested bool
+	data               [maxQueueSize]byte
+	length             int
+	cs, ip             uint16
+}
+
+// SetPrefetchQueue enables emulation of the prefetch queue. It is always
+// disabled while a validator is attached since it can't predict the extra bus reads.
+func (p *CPU) SetPrefetchQueue(b bool) {
+	p.queue = prefetchQueue{enabled: b && p.validator == nil, requested: b}
+}
+
+func (p *CPU) flushQueue() {
+	p.queue.length = 0
+}
+
+func (p *CPU) fillQueue() {
+	q := &p.queue
+
+	// Catch any change of CS:IP that did not explicitly flush the queue.
+	if q.cs != p.CS || q.ip != p.IP {
+		q.length = 0
+	}
+	if q.length == 0 {
+		q.cs, q.ip = p.CS, p.IP
+	}
+
+	for q.length < p.timing.queueSize {
+		q.data[q.length] = p.ReadByte(memory.NewPointer(q.cs, q.ip+uint16(q.length)))
+		q.length++
+	}
+}
+
+func (p *CPU) peakQueue() byte {
+	p.fillQueue()
+	return p.queue.data[0]
+}
+
+func (p *CPU) popQueue() byte {
+	v := p.peakQueue()
+	q := &p.queue
+	copy(q.data[:], q.data[1:q.length])
+	q.length--
+	q.ip++
+	return v
+}
@@ -0,0 +1,52 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package cpu
+
+import "testing"
+
+func TestPrefetchQueue(t *testing.T) {
+	tests := []struct {
+		target   uint16
+		prefetch bool
+		ax       uint16
+	}{
+		{6, false, 1},
+		{6, true, 0},
+		{9, true, 0},
+		{10, true, 1},
+	}
+
+	for _, test := range tests {
+		// MOV byte [CS:target],40h (INC AX) followed by NOP's.
+		code := []byte{0x2E, 0xC6, 0x06, byte(test.target), byte(test.target >> 8), 0x40, 0x90, 0x90, 0x90, 0x90, 0x90}
+		p := newCodeCPU(t, code)
+		p.SetPrefetchQueue(test.prefetch)
+
+		for p.IP < uint16(len(code)) {
+			if _, err := p.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if p.AX != test.ax {
+			t.Errorf("target %d, prefetch %v: expected AX=%d, got %d", test.target, test.prefetch, test.ax, p.AX)
+		}
+	}
+}
@@ -135,7 +135,7 @@ func (p *CPU) shiftOrRotate8(op, a, b byte) byte {
 		return a
 	}
 
-	if p.isV20 {
+	if p.has186() {
 		b &= 0x1F
 	}
 
@@ -189,7 +189,7 @@ func (p *CPU) shiftOrRotate16(op byte, a uint16, b byte) uint16 {
 		return a
 	}
 
-	if p.isV20 {
+	if p.has186() {
 		b &= 0x1F
 	}
 	org := a
@@ -0,0 +1,243 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package cpu
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
+)
+
+// SnapshotVersion is the version of the snapshot format. It must be increased
+// whenever the state of the CPU or any of the peripherals changes layout.
+const SnapshotVersion = 4
+
+var snapshotMagic = [4]byte{'V', 'X', 'T', 'S'}
+
+var (
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+	ErrSnapshotVersion = errors.New("unsupported snapshot version")
+)
+
+// Snapshot writes the state of the CPU and all peripherals that implement
+// peripheral.Snapshotter. The content of mounted disk images is not included.
+func (p *CPU) Snapshot(w io.Writer) error {
+	devices := p.snapshotters()
+	cpuState, states, err := p.captureState(devices)
+	if err != nil {
+		return err
+	}
+
+	if err := peripheral.WriteState(w, snapshotMagic, uint16(SnapshotVersion), uint32(len(cpuState)), cpuState, uint16(len(devices))); err != nil {
+		return err
+	}
+	for i, d := range devices {
+		name := d.Name()
+		if err := peripheral.WriteState(w, uint8(len(name)), []byte(name), uint32(len(states[i])), states[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore loads a snapshot written by Snapshot. The machine must be configured
+// with the same peripherals as the one that was saved. If a device fails to load
+// its state the machine is rolled back to where it was before the call.
+func (p *CPU) Restore(r io.Reader) error {
+	var (
+		magic   [4]byte
+		version uint16
+		cpuSize uint32
+	)
+
+	if err := peripheral.ReadState(r, &magic, &version); err != nil {
+		return err
+	}
+	if magic != snapshotMagic {
+		return ErrInvalidSnapshot
+	}
+	if version != SnapshotVersion {
+		return ErrSnapshotVersion
+	}
+
+	// Read everything before changing any state so a bad snapshot leaves the machine as is.
+	if err := peripheral.ReadState(r, &cpuSize); err != nil {
+		return err
+	}
+	cpuState := make([]byte, cpuSize)
+	if _, err := io.ReadFull(r, cpuState); err != nil {
+		return err
+	}
+
+	var numDevices uint16
+	if err := peripheral.ReadState(r, &numDevices); err != nil {
+		return err
+	}
+
+	devices := p.snapshotters()
+	if int(numDevices) != len(devices) {
+		return fmt.Errorf("snapshot has %d devices, expected %d", numDevices, len(devices))
+	}
+
+	states := make([][]byte, numDevices)
+	for i, d := range devices {
+		var nameLen uint8
+		if err := peripheral.ReadState(r, &nameLen); err != nil {
+			return err
+		}
+
+		name := make([]byte, nameLen)
+		var size uint32
+		if err := peripheral.ReadState(r, name, &size); err != nil {
+			return err
+		}
+		if string(name) != d.Name() {
+			return fmt.Errorf("snapshot has %s where %s was expected", name, d.Name())
+		}
+
+		states[i] = make([]byte, size)
+		if _, err := io.ReadFull(r, states[i]); err != nil {
+			return err
+		}
+	}
+
+	rollbackCPU, rollbackStates, err := p.captureState(devices)
+	if err != nil {
+		return err
+	}
+
+	if err := p.applyState(devices, cpuState, states); err != nil {
+		if rerr := p.applyState(devices, rollbackCPU, rollbackStates); rerr != nil {
+			return fmt.Errorf("%v (rollback failed: %v)", err, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// captureState returns the state of the CPU and each of the devices.
+func (p *CPU) captureState(devices []peripheral.Snapshotter) ([]byte, [][]byte, error) {
+	var buf bytes.Buffer
+	if err := p.saveState(&buf); err != nil {
+		return nil, nil, err
+	}
+
+	states := make([][]byte, len(devices))
+	for i, d := range devices {
+		var state bytes.Buffer
+		if err := d.SaveState(&state); err != nil {
+			return nil, nil, fmt.Errorf("could not save state of %s: %v", d.Name(), err)
+		}
+		states[i] = state.Bytes()
+	}
+	return buf.Bytes(), states, nil
+}
+
+// applyState loads state returned by captureState.
+func (p *CPU) applyState(devices []peripheral.Snapshotter, cpuState []byte, states [][]byte) error {
+	if err := p.loadState(bytes.NewReader(cpuState)); err != nil {
+		return err
+	}
+	for i, d := range devices {
+		if err := d.LoadState(bytes.NewReader(states[i])); err != nil {
+			return fmt.Errorf("could not restore state of %s: %v", d.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (p *CPU) snapshotters() []peripheral.Snapshotter {
+	var devices []peripheral.Snapshotter
+	for _, d := range p.peripherals {
+		if s, ok := d.(peripheral.Snapshotter); ok {
+			devices = append(devices, s)
+		}
+	}
+	return devices
+}
+
+func (p *CPU) saveState(w io.Writer) error {
+	s := &p.instructionState
+	q := &p.queue
+
+	err := peripheral.WriteState(w,
+		&p.Registers,
+		s.opcode, s.modRegRM, s.repeatMode, s.isWide, s.rmToReg, s.halted, s.decodeAt,
+		int32(p.model), p.trap, p.nmi, p.emulation, p.modeWritable,
+		&q.data, int32(q.length), q.cs, q.ip,
+		uint16(len(p.adapters)),
+	)
+	if err != nil {
+		return err
+	}
+	for _, a := range p.adapters {
+		if err := peripheral.WriteState(w, a.last); err != nil {
+			return err
+		}
+	}
+	return p.scheduler.SaveState(w)
+}
+
+func (p *CPU) loadState(r io.Reader) error {
+	var (
+		model       int32
+		queueLength int32
+		numAdapters uint16
+	)
+
+	s := &p.instructionState
+	q := &p.queue
+
+	err := peripheral.ReadState(r,
+		&p.Registers,
+		&s.opcode, &s.modRegRM, &s.repeatMode, &s.isWide, &s.rmToReg, &s.halted, &s.decodeAt,
+		&model, &p.trap, &p.nmi, &p.emulation, &p.modeWritable,
+		&q.data, &queueLength, &q.cs, &q.ip,
+		&numAdapters,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Devices that are stepped by the CPU need to know when they were last stepped.
+	if int(numAdapters) != len(p.adapters) {
+		return fmt.Errorf("snapshot has %d stepped devices, expected %d", numAdapters, len(p.adapters))
+	}
+	for _, a := range p.adapters {
+		if err := peripheral.ReadState(r, &a.last); err != nil {
+			return err
+		}
+	}
+
+	if err := p.scheduler.LoadState(r); err != nil {
+		return err
+	}
+
+	s.segOverride = nil
+	q.length = int(queueLength)
+
+	p.SetModel(Model(model))
+	p.flushCache()
+	return nil
+}
@@ -0,0 +1,181 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package cpu
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/pic"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/pit"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/ram"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor"
+)
+
+type brokenDevice struct {
+	peripheral.NullDevice
+	fail bool
+}
+
+func (m *brokenDevice) SaveState(w io.Writer) error {
+	return nil
+}
+
+func (m *brokenDevice) LoadState(r io.Reader) error {
+	if m.fail {
+		return errors.New("broken device")
+	}
+	return nil
+}
+
+func newSnapshotCPU(t *testing.T) *CPU {
+	p, errs := NewCPU([]peripheral.Peripheral{
+		&ram.Device{Clear: true},
+		&pic.Device{},
+		&pit.Device{},
+	})
+	for _, err := range errs {
+		t.Fatal(err)
+	}
+
+	p.Reset()
+	p.CS, p.IP = 0x1000, 0
+
+	// Run PIT channel 0 as a rate generator.
+	p.OutByte(0x43, 0x34)
+	p.OutByte(0x40, 0x00)
+	p.OutByte(0x40, 0x01)
+
+	// INC AX; MOV [200h],AX; JMP 0
+	for i, v := range []byte{0x40, 0xA3, 0x00, 0x02, 0xEB, 0xFA} {
+		p.WriteByte(memory.NewPointer(p.CS, uint16(i)), v)
+	}
+	return p
+}
+
+func runSteps(t *testing.T, p *CPU, n int) {
+	for i := 0; i < n; i++ {
+		if _, err := p.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// machineState returns the state of the CPU, the scheduler and all devices.
+func machineState(t *testing.T, p *CPU) []byte {
+	cpuState, states, err := p.captureState(p.snapshotters())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Join(append(states, cpuState), nil)
+}
+
+func TestSnapshot(t *testing.T) {
+	p := newSnapshotCPU(t)
+	runSteps(t, p, 50)
+
+	var snapshot bytes.Buffer
+	if err := p.Snapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	runSteps(t, p, 500)
+	regs, cycles := p.Registers, p.scheduler.Cycles()
+	data := p.ReadWord(memory.NewPointer(0, 0x200))
+	state := machineState(t, p)
+
+	// Restore into a new machine and into one that has already been running,
+	// then run the same number of steps.
+	for _, n := range []int{0, 73} {
+		q := newSnapshotCPU(t)
+		runSteps(t, q, n)
+		if err := q.Restore(bytes.NewReader(snapshot.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		runSteps(t, q, 500)
+
+		if q.Registers != regs {
+			t.Errorf("registers differ: %+v != %+v", q.Registers, regs)
+		}
+		if c := q.scheduler.Cycles(); c != cycles {
+			t.Errorf("cycles differ: %d != %d", c, cycles)
+		}
+		if v := q.ReadWord(memory.NewPointer(0, 0x200)); v != data {
+			t.Errorf("memory differs: 0x%X != 0x%X", v, data)
+		}
+		if n := q.scheduler.Next(); n != p.scheduler.Next() {
+			t.Errorf("next event differs: %d != %d", n, p.scheduler.Next())
+		}
+		if !bytes.Equal(machineState(t, q), state) {
+			t.Error("machine state differs")
+		}
+	}
+	q := newSnapshotCPU(t)
+
+	if err := q.Restore(bytes.NewReader([]byte("XXXX"))); err == nil {
+		t.Error("restored invalid snapshot")
+	}
+
+	other, errs := NewCPU([]peripheral.Peripheral{&ram.Device{Clear: true}, &pic.Device{}})
+	for _, err := range errs {
+		t.Fatal(err)
+	}
+	if err := other.Restore(bytes.NewReader(snapshot.Bytes())); err == nil {
+		t.Error("restored snapshot with a different set of devices")
+	}
+	if other.Registers != (processor.Registers{}) {
+		t.Error("failed restore modified the machine")
+	}
+}
+
+func TestSnapshotRollback(t *testing.T) {
+	broken := &brokenDevice{}
+	p, errs := NewCPU([]peripheral.Peripheral{&ram.Device{Clear: true}, &pic.Device{}, broken})
+	for _, err := range errs {
+		t.Fatal(err)
+	}
+	p.Reset()
+
+	var snapshot bytes.Buffer
+	if err := p.Snapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	p.AX = 0x1234
+	p.WriteWord(0x200, 0x5678)
+	regs := p.Registers
+
+	// RAM is restored before the broken device fails.
+	broken.fail = true
+	if err := p.Restore(bytes.NewReader(snapshot.Bytes())); err == nil {
+		t.Fatal("expected error")
+	}
+	if p.Registers != regs {
+		t.Errorf("registers were not rolled back: %+v != %+v", p.Registers, regs)
+	}
+	if v := p.ReadWord(0x200); v != 0x5678 {
+		t.Errorf("memory was not rolled back, got 0x%X", v)
+	}
+}
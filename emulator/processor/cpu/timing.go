@@ -0,0 +1,222 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+/*
+References:
+	Intel iAPX 86/88 User's Manual, Instruction Set Timing
+	NEC uPD70108/70116 User's Manual
+	Intel 80C186/80C188 Data Sheet
+*/
+
+package cpu
+
+import "github.com/andreas-jonsson/virtualxt/emulator/memory"
+
+type opTiming struct {
+	// Register (or no operand) form, memory form excluding EA calculation, and
+	// the cost of a taken branch or a repeated string iteration.
+	reg, mem, alt int16
+}
+
+type timingModel struct {
+	ops [0x100]opTiming
+	grp [10][8]opTiming
+	ea  [2][8]int16
+
+	prefix, repeat,
+	interrupt, irq,
+	shiftPerBit,
+	wordPenalty,
+	queueSize int
+
+	// CPU's with a 16-bit data bus only pay the word penalty for odd addresses.
+	wideBus bool
+}
+
+// The 8088 uses the 8086 instruction timings plus 4 cycles for every word transferred over its 8-bit bus.
+var timing8088 = timingModel{
+	prefix:      2,
+	repeat:      7,
+	interrupt:   51,
+	irq:         10,
+	shiftPerBit: 4,
+	wordPenalty: 4,
+	queueSize:   4,
+	ea: [2][8]int16{
+		{7, 8, 8, 7, 5, 5, 6, 5},
+		{11, 12, 12, 11, 9, 9, 9, 9},
+	},
+	ops: [0x100]opTiming{
+		{3, 16, 0}, {3, 16, 0}, {3, 9, 0}, {3, 9, 0}, {4, 0, 0}, {4, 0, 0}, {10, 0, 0}, {8, 0, 0}, {3, 16, 0}, {3, 16, 0}, {3, 9, 0}, {3, 9, 0}, {4, 0, 0}, {4, 0, 0}, {10, 0, 0}, {8, 0, 0},
+		{3, 16, 0}, {3, 16, 0}, {3, 9, 0}, {3, 9, 0}, {4, 0, 0}, {4, 0, 0}, {10, 0, 0}, {8, 0, 0}, {3, 16, 0}, {3, 16, 0}, {3, 9, 0}, {3, 9, 0}, {4, 0, 0}, {4, 0, 0}, {10, 0, 0}, {8, 0, 0},
+		{3, 16, 0}, {3, 16, 0}, {3, 9, 0}, {3, 9, 0}, {4, 0, 0}, {4, 0, 0}, {2, 0, 0}, {4, 0, 0}, {3, 16, 0}, {3, 16, 0}, {3, 9, 0}, {3, 9, 0}, {4, 0, 0}, {4, 0, 0}, {2, 0, 0}, {4, 0, 0},
+		{3, 16, 0}, {3, 16, 0}, {3, 9, 0}, {3, 9, 0}, {4, 0, 0}, {4, 0, 0}, {2, 0, 0}, {8, 0, 0}, {3, 9, 0}, {3, 9, 0}, {3, 9, 0}, {3, 9, 0}, {4, 0, 0}, {4, 0, 0}, {2, 0, 0}, {8, 0, 0},
+		{2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0},
+		{11, 0, 0}, {11, 0, 0}, {11, 0, 0}, {11, 0, 0}, {11, 0, 0}, {11, 0, 0}, {11, 0, 0}, {11, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0},
+		{4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12},
+		{4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12}, {4, 0, 12},
+		{4, 17, 0}, {4, 17, 0}, {4, 17, 0}, {4, 17, 0}, {3, 9, 0}, {3, 9, 0}, {4, 17, 0}, {4, 17, 0}, {2, 9, 0}, {2, 9, 0}, {2, 8, 0}, {2, 8, 0}, {2, 9, 0}, {2, 2, 0}, {2, 8, 0}, {8, 17, 0},
+		{3, 0, 0}, {3, 0, 0}, {3, 0, 0}, {3, 0, 0}, {3, 0, 0}, {3, 0, 0}, {3, 0, 0}, {3, 0, 0}, {2, 0, 0}, {5, 0, 0}, {28, 0, 0}, {3, 0, 0}, {10, 0, 0}, {8, 0, 0}, {4, 0, 0}, {4, 0, 0},
+		{10, 0, 0}, {10, 0, 0}, {10, 0, 0}, {10, 0, 0}, {18, 0, 17}, {18, 0, 17}, {22, 0, 22}, {22, 0, 22}, {4, 0, 0}, {4, 0, 0}, {11, 0, 10}, {11, 0, 10}, {12, 0, 13}, {12, 0, 13}, {15, 0, 15}, {15, 0, 15},
+		{4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0},
+		{12, 0, 0}, {8, 0, 0}, {12, 0, 0}, {8, 0, 0}, {16, 16, 0}, {16, 16, 0}, {4, 10, 0}, {4, 10, 0}, {17, 0, 0}, {18, 0, 0}, {17, 0, 0}, {18, 0, 0}, {1, 0, 0}, {0, 0, 0}, {4, 0, 0}, {24, 0, 0},
+		{2, 15, 0}, {2, 15, 0}, {8, 20, 0}, {8, 20, 0}, {83, 0, 0}, {60, 0, 0}, {4, 0, 0}, {11, 0, 0}, {2, 8, 0}, {2, 8, 0}, {2, 8, 0}, {2, 8, 0}, {2, 8, 0}, {2, 8, 0}, {2, 8, 0}, {2, 8, 0},
+		{5, 0, 14}, {6, 0, 12}, {5, 0, 12}, {6, 0, 12}, {10, 0, 0}, {10, 0, 0}, {10, 0, 0}, {10, 0, 0}, {19, 0, 0}, {15, 0, 0}, {15, 0, 0}, {15, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0},
+		{2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {5, 11, 0}, {5, 11, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {3, 15, 0}, {3, 15, 0},
+	},
+	grp: [10][8]opTiming{
+		{{4, 17, 0}, {4, 17, 0}, {4, 17, 0}, {4, 17, 0}, {4, 17, 0}, {4, 17, 0}, {4, 17, 0}, {4, 10, 0}},             // 0x80, 0x82
+		{{4, 17, 0}, {4, 17, 0}, {4, 17, 0}, {4, 17, 0}, {4, 17, 0}, {4, 17, 0}, {4, 17, 0}, {4, 10, 0}},             // 0x81, 0x83
+		{{2, 15, 0}, {2, 15, 0}, {2, 15, 0}, {2, 15, 0}, {2, 15, 0}, {2, 15, 0}, {2, 15, 0}, {2, 15, 0}},             // 0xD0
+		{{2, 15, 0}, {2, 15, 0}, {2, 15, 0}, {2, 15, 0}, {2, 15, 0}, {2, 15, 0}, {2, 15, 0}, {2, 15, 0}},             // 0xD1
+		{{8, 20, 0}, {8, 20, 0}, {8, 20, 0}, {8, 20, 0}, {8, 20, 0}, {8, 20, 0}, {8, 20, 0}, {8, 20, 0}},             // 0xD2
+		{{8, 20, 0}, {8, 20, 0}, {8, 20, 0}, {8, 20, 0}, {8, 20, 0}, {8, 20, 0}, {8, 20, 0}, {8, 20, 0}},             // 0xD3
+		{{5, 11, 0}, {5, 11, 0}, {3, 16, 0}, {3, 16, 0}, {74, 80, 0}, {89, 96, 0}, {85, 91, 0}, {107, 113, 0}},       // 0xF6
+		{{5, 11, 0}, {5, 11, 0}, {3, 16, 0}, {3, 16, 0}, {126, 132, 0}, {141, 147, 0}, {153, 159, 0}, {175, 181, 0}}, // 0xF7
+		{{3, 15, 0}, {3, 15, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}},                   // 0xFE
+		{{3, 15, 0}, {3, 15, 0}, {16, 21, 0}, {37, 37, 0}, {11, 18, 0}, {24, 24, 0}, {11, 16, 0}, {0, 0, 0}},         // 0xFF
+	},
+}
+
+// The V20 has dedicated address hardware so its memory forms do not depend on the addressing mode.
+var timingV20 = timingModel{
+	prefix:      2,
+	repeat:      7,
+	interrupt:   50,
+	irq:         6,
+	shiftPerBit: 1,
+	wordPenalty: 4,
+	queueSize:   4,
+	ops: [0x100]opTiming{
+		{2, 16, 0}, {2, 16, 0}, {2, 11, 0}, {2, 11, 0}, {4, 0, 0}, {4, 0, 0}, {8, 0, 0}, {8, 0, 0}, {2, 16, 0}, {2, 16, 0}, {2, 11, 0}, {2, 11, 0}, {4, 0, 0}, {4, 0, 0}, {8, 0, 0}, {2, 0, 0},
+		{2, 16, 0}, {2, 16, 0}, {2, 11, 0}, {2, 11, 0}, {4, 0, 0}, {4, 0, 0}, {8, 0, 0}, {8, 0, 0}, {2, 16, 0}, {2, 16, 0}, {2, 11, 0}, {2, 11, 0}, {4, 0, 0}, {4, 0, 0}, {8, 0, 0}, {8, 0, 0},
+		{2, 16, 0}, {2, 16, 0}, {2, 11, 0}, {2, 11, 0}, {4, 0, 0}, {4, 0, 0}, {2, 0, 0}, {3, 0, 0}, {2, 16, 0}, {2, 16, 0}, {2, 11, 0}, {2, 11, 0}, {4, 0, 0}, {4, 0, 0}, {2, 0, 0}, {3, 0, 0},
+		{2, 16, 0}, {2, 16, 0}, {2, 11, 0}, {2, 11, 0}, {4, 0, 0}, {4, 0, 0}, {2, 0, 0}, {3, 0, 0}, {2, 11, 0}, {2, 11, 0}, {2, 11, 0}, {2, 11, 0}, {4, 0, 0}, {4, 0, 0}, {2, 0, 0}, {3, 0, 0},
+		{2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0},
+		{8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0},
+		{35, 0, 0}, {43, 0, 0}, {18, 18, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {7, 0, 0}, {29, 36, 0}, {7, 0, 0}, {29, 36, 0}, {10, 0, 8}, {10, 0, 8}, {10, 0, 8}, {10, 0, 8},
+		{4, 0, 10}, {4, 0, 10}, {4, 0, 10}, {4, 0, 10}, {4, 0, 10}, {4, 0, 10}, {4, 0, 10}, {4, 0, 10}, {4, 0, 10}, {4, 0, 10}, {4, 0, 10}, {4, 0, 10}, {4, 0, 10}, {4, 0, 10}, {4, 0, 10}, {4, 0, 10},
+		{4, 18, 0}, {4, 18, 0}, {4, 18, 0}, {4, 18, 0}, {2, 10, 0}, {2, 10, 0}, {3, 16, 0}, {3, 16, 0}, {2, 9, 0}, {2, 9, 0}, {2, 11, 0}, {2, 11, 0}, {2, 10, 0}, {4, 4, 0}, {2, 11, 0}, {8, 17, 0},
+		{3, 0, 0}, {3, 0, 0}, {3, 0, 0}, {3, 0, 0}, {3, 0, 0}, {3, 0, 0}, {3, 0, 0}, {3, 0, 0}, {2, 0, 0}, {4, 0, 0}, {29, 0, 0}, {2, 0, 0}, {8, 0, 0}, {8, 0, 0}, {3, 0, 0}, {2, 0, 0},
+		{10, 0, 0}, {10, 0, 0}, {9, 0, 0}, {9, 0, 0}, {11, 0, 8}, {11, 0, 8}, {13, 0, 14}, {13, 0, 14}, {4, 0, 0}, {4, 0, 0}, {7, 0, 4}, {7, 0, 4}, {7, 0, 9}, {7, 0, 9}, {7, 0, 10}, {7, 0, 10},
+		{4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0}, {4, 0, 0},
+		{7, 19, 0}, {7, 19, 0}, {20, 0, 0}, {15, 0, 0}, {18, 18, 0}, {18, 18, 0}, {4, 11, 0}, {4, 11, 0}, {16, 0, 0}, {6, 0, 0}, {24, 0, 0}, {21, 0, 0}, {0, 0, 0}, {0, 0, 0}, {3, 0, 0}, {27, 0, 0},
+		{2, 16, 0}, {2, 16, 0}, {7, 19, 0}, {7, 19, 0}, {15, 0, 0}, {7, 0, 0}, {9, 0, 0}, {9, 0, 0}, {2, 11, 0}, {2, 11, 0}, {2, 11, 0}, {2, 11, 0}, {2, 11, 0}, {2, 11, 0}, {2, 11, 0}, {2, 11, 0},
+		{5, 0, 9}, {5, 0, 9}, {5, 0, 8}, {5, 0, 8}, {9, 0, 0}, {9, 0, 0}, {9, 0, 0}, {9, 0, 0}, {16, 0, 0}, {13, 0, 0}, {15, 0, 0}, {12, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0}, {8, 0, 0},
+		{2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {4, 11, 0}, {4, 11, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 0, 0}, {2, 16, 0}, {2, 16, 0},
+	},
+	grp: [10][8]opTiming{
+		{{4, 18, 0}, {4, 18, 0}, {4, 18, 0}, {4, 18, 0}, {4, 18, 0}, {4, 18, 0}, {4, 18, 0}, {4, 13, 0}},     // 0x80, 0x82
+		{{4, 18, 0}, {4, 18, 0}, {4, 18, 0}, {4, 18, 0}, {4, 18, 0}, {4, 18, 0}, {4, 18, 0}, {4, 13, 0}},     // 0x81, 0x83
+		{{2, 16, 0}, {2, 16, 0}, {2, 16, 0}, {2, 16, 0}, {2, 16, 0}, {2, 16, 0}, {2, 16, 0}, {2, 16, 0}},     // 0xD0
+		{{2, 16, 0}, {2, 16, 0}, {2, 16, 0}, {2, 16, 0}, {2, 16, 0}, {2, 16, 0}, {2, 16, 0}, {2, 16, 0}},     // 0xD1
+		{{7, 19, 0}, {7, 19, 0}, {7, 19, 0}, {7, 19, 0}, {7, 19, 0}, {7, 19, 0}, {7, 19, 0}, {7, 19, 0}},     // 0xD2
+		{{7, 19, 0}, {7, 19, 0}, {7, 19, 0}, {7, 19, 0}, {7, 19, 0}, {7, 19, 0}, {7, 19, 0}, {7, 19, 0}},     // 0xD3
+		{{4, 11, 0}, {4, 11, 0}, {2, 16, 0}, {2, 16, 0}, {21, 27, 0}, {33, 39, 0}, {19, 25, 0}, {29, 35, 0}}, // 0xF6
+		{{4, 11, 0}, {4, 11, 0}, {2, 16, 0}, {2, 16, 0}, {29, 35, 0}, {41, 47, 0}, {25, 31, 0}, {38, 44, 0}}, // 0xF7
+		{{2, 16, 0}, {2, 16, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}},           // 0xFE
+		{{2, 16, 0}, {2, 16, 0}, {14, 23, 0}, {32, 32, 0}, {11, 20, 0}, {19, 24, 0}, {8, 18, 0}, {0, 0, 0}},  // 0xFF
+	},
+}
+
+// The 80188 has dedicated EA hardware like the V20 and mostly the same timings.
+var timing80188 = func() timingModel {
+	t := timingV20
+	t.interrupt = 47
+	t.irq = 8
+	t.ops[0xC8] = opTiming{15, 0, 0}
+	t.ops[0xC9] = opTiming{8, 0, 0}
+	t.ops[0xD4] = opTiming{19, 0, 0}
+	t.ops[0xD5] = opTiming{15, 0, 0}
+	t.grp[6][4] = opTiming{26, 32, 0}
+	t.grp[6][5] = opTiming{25, 31, 0}
+	t.grp[6][6] = opTiming{29, 35, 0}
+	t.grp[6][7] = opTiming{44, 50, 0}
+	t.grp[7][4] = opTiming{35, 41, 0}
+	t.grp[7][5] = opTiming{34, 40, 0}
+	t.grp[7][6] = opTiming{38, 44, 0}
+	t.grp[7][7] = opTiming{53, 59, 0}
+	return t
+}()
+
+var (
+	timing8086  = wideBus(timing8088)
+	timingV30   = wideBus(timingV20)
+	timing80186 = wideBus(timing80188)
+)
+
+func wideBus(t timingModel) timingModel {
+	t.wideBus = true
+	t.queueSize = 6
+	return t
+}
+
+func groupIndex(op byte) int {
+	switch op {
+	case 0x80, 0x82:
+		return 0
+	case 0x81, 0x83:
+		return 1
+	case 0xD0, 0xD1, 0xD2, 0xD3:
+		return int(op-0xD0) + 2
+	case 0xF6, 0xF7:
+		return int(op-0xF6) + 6
+	case 0xFE, 0xFF:
+		return int(op-0xFE) + 8
+	}
+	return -1
+}
+
+func (p *CPU) instructionCycles() int {
+	t := &p.timing.ops[p.opcode]
+	if i := groupIndex(p.opcode); i >= 0 {
+		t = &p.timing.grp[i][p.getReg()]
+	}
+
+	if p.repeatMode != 0 && t.alt != 0 {
+		return int(t.alt)
+	}
+	if t.mem == 0 {
+		return int(t.reg)
+	}
+
+	mod, rm := p.modRegRM>>6, p.modRegRM&7
+	switch mod {
+	case 0:
+		return int(t.mem + p.timing.ea[0][rm])
+	case 1, 2:
+		return int(t.mem + p.timing.ea[1][rm])
+	}
+	return int(t.reg)
+}
+
+func (p *CPU) branchCycles() {
+	p.cycleCount += int(p.timing.ops[p.opcode].alt)
+}
+
+func (p *CPU) shiftCycles(n byte) {
+	p.cycleCount += p.timing.shiftPerBit * int(n)
+}
+
+func (p *CPU) wordCycles(addr memory.Pointer) {
+	if !p.timing.wideBus || addr&1 != 0 {
+		p.cycleCount += p.timing.wordPenalty
+	}
+}
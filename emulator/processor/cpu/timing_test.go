@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package cpu
+
+import (
+	"testing"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/memory"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/pic"
+	"github.com/andreas-jonsson/virtualxt/emulator/peripheral/ram"
+)
+
+func newCodeCPU(t *testing.T, code []byte) *CPU {
+	p, errs := NewCPU([]peripheral.Peripheral{
+		&ram.Device{Clear: true},
+		&pic.Device{},
+	})
+	for _, err := range errs {
+		t.Fatal(err)
+	}
+
+	p.Reset()
+	p.CS, p.IP = 0x1000, 0
+	for i, v := range code {
+		p.WriteByte(memory.NewPointer(p.CS, uint16(i)), v)
+	}
+	return p
+}
+
+func TestTiming8088(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   []byte
+		setup  func(p *CPU)
+		cycles int
+	}{
+		{"NOP", []byte{0x90}, nil, 3},
+		{"MOV AX,BX", []byte{0x8B, 0xC3}, nil, 2},
+		{"MOV AX,[BX]", []byte{0x8B, 0x07}, nil, 8 + 5 + 4},
+		{"MOV AL,[BX]", []byte{0x8A, 0x07}, nil, 8 + 5},
+		{"ADD [BX+SI+4],AX", []byte{0x01, 0x40, 0x04}, nil, 16 + 11 + 8},
+		{"ES: MOV AX,[BP+DI]", []byte{0x26, 0x8B, 0x03}, nil, 2 + 8 + 7 + 4},
+		{"PUSH AX", []byte{0x50}, nil, 11 + 4},
+		{"JZ taken", []byte{0x74, 0x00}, func(p *CPU) { p.ZF = true }, 16},
+		{"JZ not taken", []byte{0x74, 0x00}, func(p *CPU) { p.ZF = false }, 4},
+		{"SHL AX,CL", []byte{0xD3, 0xE0}, func(p *CPU) { p.CX = 3 }, 8 + 3*4},
+		{"MUL BL", []byte{0xF6, 0xE3}, nil, 74},
+		{"REP MOVSB", []byte{0xF3, 0xA4}, func(p *CPU) { p.CX = 3 }, 2 + 7 + 3*17},
+		{"REP MOVSW", []byte{0xF3, 0xA5}, func(p *CPU) { p.CX = 3 }, 2 + 7 + 3*(17+8)},
+		{"INT 3", []byte{0xCC}, nil, 52 + 5*4},
+	}
+
+	for _, test := range tests {
+		p := newCodeCPU(t, test.code)
+		if test.setup != nil {
+			test.setup(p)
+		}
+		c, err := p.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != test.cycles {
+			t.Errorf("%s: got %d cycles but expected %d", test.name, c, test.cycles)
+		}
+		p.Close()
+	}
+}
+
+func TestTimingV20(t *testing.T) {
+	p := newCodeCPU(t, []byte{0xF6, 0xE3, 0x8B, 0x07})
+	defer p.Close()
+	p.SetModel(NECV20)
+
+	if c, _ := p.Step(); c != 21 {
+		t.Errorf("MUL BL: got %d cycles but expected 21", c)
+	}
+	if c, _ := p.Step(); c != 11+4 {
+		t.Errorf("MOV AX,[BX]: got %d cycles but expected 15", c)
+	}
+}
@@ -33,6 +33,12 @@ type InterruptController interface {
 	IRQ(n int)
 }
 
+// Coprocessor is implemented by devices that execute the ESC instructions.
+// The operand address is only valid if the instruction has a memory operand.
+type Coprocessor interface {
+	Escape(op, modRegRM byte, ip memory.Pointer, operand memory.Address)
+}
+
 type Processor interface {
 	Debug
 
@@ -41,18 +47,32 @@ type Processor interface {
 	InWord(port uint16) uint16
 	OutWord(port uint16, data uint16)
 
+	NMI()
+
 	ReadByte(addr memory.Pointer) byte
 	WriteByte(addr memory.Pointer, data byte)
 	ReadWord(addr memory.Pointer) uint16
 	WriteWord(addr memory.Pointer, data uint16)
 
+	// InvalidateMemory must be called by devices that change memory without going
+	// through the processor. Cached instructions in the range are decoded again.
+	InvalidateMemory(from, to memory.Pointer)
+
 	GetRegisters() *Registers
 	GetInterruptController() InterruptController
+	GetCoprocessor() Coprocessor
+	GetScheduler() *Scheduler
 	GetMappedMemoryDevice(addr memory.Pointer) memory.Memory
 	GetMappedIODevice(port uint16) memory.IO
 
+	// Memory is mapped in pages of memory.PageSize bytes. A range that does not start
+	// and end on page boundaries is extended to whole pages. InstallMemoryDeviceAt
+	// maps one page for each address and fails unless the addresses are page aligned.
 	InstallMemoryDevice(device memory.Memory, from, to memory.Pointer) error
 	InstallMemoryDeviceAt(device memory.Memory, addr ...memory.Pointer) error
+	UninstallMemoryDevice(from, to memory.Pointer) error
+
 	InstallIODevice(device memory.IO, from, to uint16) error
 	InstallIODeviceAt(device memory.IO, port ...uint16) error
+	UninstallIODevice(from, to uint16) error
 }
@@ -0,0 +1,253 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package processor
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ClockFrequency is the CPU clock of the IBM PC in Hz. All emulated time is derived from it.
+const ClockFrequency = 4772727
+
+// EventFunc is called by the scheduler when an event is due.
+type EventFunc func() error
+
+// Event is a callback scheduled to run at a specific CPU cycle.
+type Event struct {
+	fn     EventFunc
+	at     int64
+	period int64
+	seq    uint64
+	index  int
+	host   bool
+}
+
+type eventQueue []*Event
+
+func (q eventQueue) Len() int {
+	return len(q)
+}
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at == q[j].at {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at < q[j].at
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *eventQueue) Push(x interface{}) {
+	e := x.(*Event)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	n := len(old) - 1
+	e := old[n]
+	old[n] = nil
+	e.index = -1
+	*q = old[:n]
+	return e
+}
+
+// schedulerState and eventState are the layout of the state written by Scheduler.SaveState.
+type (
+	schedulerState struct {
+		Cycles    int64
+		Seq       uint64
+		NumEvents uint32
+	}
+
+	eventState struct {
+		Pending bool
+		At      int64
+		Seq     uint64
+	}
+)
+
+// Scheduler keeps track of the number of executed CPU cycles and runs
+// the events registered by the peripherals when they are due.
+type Scheduler struct {
+	cycles int64
+	seq    uint64
+	queue  eventQueue
+	events []*Event
+}
+
+// Cycles returns the total number of cycles executed.
+func (s *Scheduler) Cycles() int64 {
+	return s.cycles
+}
+
+// Time returns the emulated time based on the number of executed cycles.
+func (s *Scheduler) Time() time.Duration {
+	sec, rem := s.cycles/ClockFrequency, s.cycles%ClockFrequency
+	return time.Duration(sec)*time.Second + time.Duration(rem)*time.Second/ClockFrequency
+}
+
+// After schedules a single call to fn after the given number of cycles.
+func (s *Scheduler) After(delay int64, fn EventFunc) *Event {
+	e := &Event{fn: fn, index: -1}
+	s.events = append(s.events, e)
+	s.Reschedule(e, delay)
+	return e
+}
+
+// Every schedules fn to be called periodically.
+func (s *Scheduler) Every(period int64, fn EventFunc) *Event {
+	e := s.every(period, fn)
+	s.events = append(s.events, e)
+	return e
+}
+
+// HostEvery is like Every but for events that belong to the host rather than the
+// emulated machine, like the frame timer of a video recorder. Host events are not
+// part of the scheduler state.
+func (s *Scheduler) HostEvery(period int64, fn EventFunc) *Event {
+	e := s.every(period, fn)
+	e.host = true
+	return e
+}
+
+func (s *Scheduler) every(period int64, fn EventFunc) *Event {
+	if period <= 0 {
+		panic("invalid event period")
+	}
+	e := &Event{fn: fn, period: period, index: -1}
+	s.Reschedule(e, period)
+	return e
+}
+
+// Reschedule moves the event so it is due after the given number of cycles.
+// It can also be used to restart an event that has been canceled or has already run.
+func (s *Scheduler) Reschedule(e *Event, delay int64) {
+	// Events that are due at the same cycle run in the order they were scheduled.
+	s.seq++
+	e.at, e.seq = s.cycles+delay, s.seq
+	if e.index < 0 {
+		heap.Push(&s.queue, e)
+	} else {
+		heap.Fix(&s.queue, e.index)
+	}
+}
+
+// Cancel removes the event from the scheduler.
+func (s *Scheduler) Cancel(e *Event) {
+	if e.index >= 0 {
+		heap.Remove(&s.queue, e.index)
+	}
+}
+
+// Next returns the number of cycles until the next event is due or -1 if there are no events.
+func (s *Scheduler) Next() int64 {
+	if len(s.queue) == 0 {
+		return -1
+	}
+	return s.queue[0].at - s.cycles
+}
+
+// SaveState writes the cycle counter and when each event of the machine is due.
+func (s *Scheduler) SaveState(w io.Writer) error {
+	states := make([]eventState, len(s.events))
+	for i, e := range s.events {
+		states[i] = eventState{e.index >= 0, e.at, e.seq}
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, schedulerState{s.cycles, s.seq, uint32(len(s.events))}); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, states)
+}
+
+// LoadState restores the state written by SaveState. The same events must have been
+// scheduled, in the same order, as in the scheduler that was saved. Host events keep
+// the number of cycles remaining until they are due.
+func (s *Scheduler) LoadState(r io.Reader) error {
+	var state schedulerState
+	if err := binary.Read(r, binary.LittleEndian, &state); err != nil {
+		return err
+	}
+	if int(state.NumEvents) != len(s.events) {
+		return fmt.Errorf("snapshot has %d events, expected %d", state.NumEvents, len(s.events))
+	}
+
+	states := make([]eventState, state.NumEvents)
+	if err := binary.Read(r, binary.LittleEndian, states); err != nil {
+		return err
+	}
+
+	var queue eventQueue
+	for _, e := range s.queue {
+		if e.host {
+			e.at += state.Cycles - s.cycles
+			queue = append(queue, e)
+		}
+	}
+	for i, e := range s.events {
+		e.index = -1
+		if st := states[i]; st.Pending {
+			e.at, e.seq = st.At, st.Seq
+			queue = append(queue, e)
+		}
+	}
+	for i, e := range queue {
+		e.index = i
+	}
+
+	heap.Init(&queue)
+	s.cycles, s.seq, s.queue = state.Cycles, state.Seq, queue
+	return nil
+}
+
+// Advance adds the cycles to the counter and runs all events that are due, in order.
+func (s *Scheduler) Advance(cycles int) error {
+	s.cycles += int64(cycles)
+	for len(s.queue) > 0 {
+		e := s.queue[0]
+		if e.at > s.cycles {
+			break
+		}
+
+		if e.period > 0 {
+			s.seq++
+			e.at, e.seq = e.at+e.period, s.seq
+			heap.Fix(&s.queue, 0)
+		} else {
+			heap.Pop(&s.queue)
+		}
+
+		if err := e.fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
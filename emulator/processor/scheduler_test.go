@@ -0,0 +1,149 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package processor
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	var s Scheduler
+	var log []int64
+
+	s.Every(10, func() error {
+		log = append(log, s.Cycles())
+		return nil
+	})
+	once := s.After(15, func() error {
+		log = append(log, -s.Cycles())
+		return nil
+	})
+
+	if n := s.Next(); n != 10 {
+		t.Errorf("expected next event in 10 cycles, got %d", n)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := s.Advance(7); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Periodic events that are late run once for every period.
+	if err := s.Advance(25); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []int64{14, -21, 21, 35, 60, 60, 60}
+	if len(log) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, log)
+	}
+	for i, v := range expected {
+		if log[i] != v {
+			t.Fatalf("expected %v, got %v", expected, log)
+		}
+	}
+
+	s.Reschedule(once, 5)
+	s.Cancel(once)
+	if err := s.Advance(5); err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != len(expected) {
+		t.Errorf("expected canceled event to not run, got %v", log)
+	}
+}
+
+func TestSchedulerTime(t *testing.T) {
+	var s Scheduler
+	s.Advance(ClockFrequency * 3 / 2)
+	if d := s.Time(); d < 1499*time.Millisecond || d > 1500*time.Millisecond {
+		t.Errorf("expected 1.5s, got %v", d)
+	}
+}
+
+func TestSchedulerState(t *testing.T) {
+	newScheduler := func() (*Scheduler, *[]int64) {
+		var (
+			s   Scheduler
+			log []int64
+		)
+		s.Every(10, func() error {
+			log = append(log, s.Cycles())
+			return nil
+		})
+		s.After(25, func() error {
+			log = append(log, -s.Cycles())
+			return nil
+		})
+		return &s, &log
+	}
+
+	s, _ := newScheduler()
+	s.Advance(13)
+
+	var state bytes.Buffer
+	if err := s.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restore into a scheduler that is out of phase and has a host event.
+	r, log := newScheduler()
+	r.Advance(27)
+	var host []int64
+	r.HostEvery(4, func() error {
+		host = append(host, r.Cycles())
+		return nil
+	})
+
+	if err := r.LoadState(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	*log = nil
+	for i := 0; i < 17; i++ {
+		if err := r.Advance(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []int64{20, -25, 30}
+	if len(*log) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, *log)
+	}
+	for i, v := range expected {
+		if (*log)[i] != v {
+			t.Fatalf("expected %v, got %v", expected, *log)
+		}
+	}
+	if len(host) != 4 || host[0] != 17 {
+		t.Errorf("expected host event to keep its delay, got %v", host)
+	}
+
+	var empty Scheduler
+	state.Reset()
+	s.SaveState(&state)
+	if err := empty.LoadState(&state); err == nil {
+		t.Error("expected error when the events differ")
+	}
+}
@@ -28,10 +28,12 @@ import (
 
 const Enabled = false
 
-func Initialize(string, int, int)     {}
-func Begin(byte, processor.Registers) {}
-func End(processor.Registers)         {}
-func Discard()                        {}
-func ReadByte(uint32, byte)           {}
-func WriteByte(uint32, byte)          {}
-func Shutdown()                       {}
+type Validator struct{}
+
+func New(string, int, int) *Validator              { return nil }
+func (*Validator) Begin(byte, processor.Registers) {}
+func (*Validator) End(processor.Registers)         {}
+func (*Validator) Discard()                        {}
+func (*Validator) ReadMemory(uint32, byte)         {}
+func (*Validator) WriteMemory(uint32, byte)        {}
+func (*Validator) Shutdown()                       {}
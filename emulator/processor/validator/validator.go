@@ -35,24 +35,27 @@ import (
 
 const Enabled = true
 
-var outputFile string
-
-var (
+// Validator records the registers and memory accesses of every executed instruction.
+// All methods can be called on a nil validator, which does nothing.
+type Validator struct {
 	inScope      bool
 	currentEvent Event
 	outputChan   chan Event
 	quitChan     chan struct{}
-)
+}
 
-func Initialize(output string, queueSize, bufferSize int) {
-	if outputFile = output; output == "" {
-		return
+// New creates a validator that writes to the output file. It returns nil if output is empty.
+func New(output string, queueSize, bufferSize int) *Validator {
+	if output == "" {
+		return nil
 	}
 
-	outputChan = make(chan Event, queueSize)
-	quitChan = make(chan struct{})
+	v := &Validator{
+		outputChan: make(chan Event, queueSize),
+		quitChan:   make(chan struct{}),
+	}
 
-	fp, err := os.Create(outputFile)
+	fp, err := os.Create(output)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -61,11 +64,11 @@ func Initialize(output string, queueSize, bufferSize int) {
 		var buffer bytes.Buffer
 
 		defer fp.Close()
-		defer func() { io.Copy(fp, &buffer); quitChan <- struct{}{} }()
+		defer func() { io.Copy(fp, &buffer); v.quitChan <- struct{}{} }()
 
 		enc := json.NewEncoder(&buffer)
 
-		for ev := range outputChan {
+		for ev := range v.outputChan {
 			if err := enc.Encode(ev); err != nil {
 				log.Print(err)
 				return
@@ -79,64 +82,67 @@ func Initialize(output string, queueSize, bufferSize int) {
 			}
 		}
 	}()
+	return v
 }
 
-func Begin(opcode byte, regs processor.Registers) {
-	if outputFile == "" {
+func (v *Validator) Begin(opcode byte, regs processor.Registers) {
+	if v == nil {
 		return
 	}
 
-	inScope = true
-	currentEvent = EmptyEvent
-	currentEvent.Opcode = opcode
-	currentEvent.Regs[0] = regs
+	v.inScope = true
+	v.currentEvent = EmptyEvent
+	v.currentEvent.Opcode = opcode
+	v.currentEvent.Regs[0] = regs
 
 }
 
-func End(regs processor.Registers) {
-	if !inScope {
+func (v *Validator) End(regs processor.Registers) {
+	if v == nil || !v.inScope {
 		return
 	}
 
-	inScope = false
-	currentEvent.Regs[1] = regs
-	outputChan <- currentEvent
+	v.inScope = false
+	v.currentEvent.Regs[1] = regs
+	v.outputChan <- v.currentEvent
 }
 
-func Discard() {
-	inScope = false
+func (v *Validator) Discard() {
+	if v != nil {
+		v.inScope = false
+	}
 }
 
-func ReadByte(addr uint32, data byte) {
-	if !inScope {
+func (v *Validator) ReadMemory(addr uint32, data byte) {
+	if v == nil || !v.inScope {
 		return
 	}
-	for i, op := range currentEvent.Reads {
+	for i, op := range v.currentEvent.Reads {
 		if op.Addr == math.MaxUint32 {
-			currentEvent.Reads[i] = MemOp{addr, data}
+			v.currentEvent.Reads[i] = MemOp{addr, data}
 			return
 		}
 	}
 	log.Panic("Max reads!")
 }
 
-func WriteByte(addr uint32, data byte) {
-	if !inScope {
+func (v *Validator) WriteMemory(addr uint32, data byte) {
+	if v == nil || !v.inScope {
 		return
 	}
-	for i, op := range currentEvent.Writes {
+	for i, op := range v.currentEvent.Writes {
 		if op.Addr == math.MaxUint32 {
-			currentEvent.Writes[i] = MemOp{addr, data}
+			v.currentEvent.Writes[i] = MemOp{addr, data}
 			return
 		}
 	}
 	log.Panic("Max writes!")
 }
 
-func Shutdown() {
-	if outputFile == "" {
+func (v *Validator) Shutdown() {
+	if v == nil {
 		return
 	}
-	close(outputChan)
-	<-quitChan
+	close(v.outputChan)
+	<-v.quitChan
 }
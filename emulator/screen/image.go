@@ -0,0 +1,39 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package screen
+
+import "image"
+
+// Image wraps RGBA pixels in an image. With aspect correction the image is
+// stretched vertically to 4:3, which is how it looks on a PC monitor.
+func Image(pixels []byte, width, height int, correctAspect bool) *image.RGBA {
+	img := &image.RGBA{Pix: pixels, Stride: width * 4, Rect: image.Rect(0, 0, width, height)}
+	if !correctAspect {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, width*3/4))
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		src := (y * height / dst.Rect.Dy()) * img.Stride
+		copy(dst.Pix[y*dst.Stride:(y+1)*dst.Stride], img.Pix[src:src+img.Stride])
+	}
+	return dst
+}
@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+// Package screen gives a readable view of emulated screens.
+package screen
+
+import (
+	"strings"
+
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+// Text is a copy of the visible text page.
+type Text struct {
+	Width,
+	Height int
+
+	// Page is the offset of the visible page in video memory, in bytes.
+	Page int
+
+	// CursorX and CursorY are -1 if the cursor is hidden or outside the page.
+	CursorX,
+	CursorY int
+
+	// Mem holds Width*Height character and attribute pairs.
+	Mem []byte
+}
+
+// Cell returns the character and attribute at the position.
+func (t *Text) Cell(x, y int) (rune, byte) {
+	offset := (y*t.Width + x) * 2
+	ch := t.Mem[offset]
+	if ch == 0 {
+		return ' ', t.Mem[offset+1]
+	}
+	return platform.CodePage437(ch), t.Mem[offset+1]
+}
+
+// Attributes returns the attributes of a row.
+func (t *Text) Attributes(y int) []byte {
+	attribs := make([]byte, t.Width)
+	for x := range attribs {
+		_, attribs[x] = t.Cell(x, y)
+	}
+	return attribs
+}
+
+// Row returns a row as Unicode text, with trailing spaces removed.
+func (t *Text) Row(y int) string {
+	var sb strings.Builder
+	for x := 0; x < t.Width; x++ {
+		ch, _ := t.Cell(x, y)
+		sb.WriteRune(ch)
+	}
+	return strings.TrimRight(sb.String(), " ")
+}
+
+// Rows returns all rows as Unicode text.
+func (t *Text) Rows() []string {
+	rows := make([]string, t.Height)
+	for y := range rows {
+		rows[y] = t.Row(y)
+	}
+	return rows
+}
+
+// Contains reports whether s is found on any row.
+func (t *Text) Contains(s string) bool {
+	for y := 0; y < t.Height; y++ {
+		if strings.Contains(t.Row(y), s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Text) String() string {
+	return strings.Join(t.Rows(), "\n")
+}
@@ -0,0 +1,54 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package screen
+
+import (
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	txt := &Text{Width: 4, Height: 2, CursorX: -1, CursorY: -1, Mem: make([]byte, 4*2*2)}
+	for i, ch := range []byte{'H', 'i', 0, ' ', 0x01, 0xB0, 'x', 0xFB} {
+		txt.Mem[i*2] = ch
+		txt.Mem[i*2+1] = byte(i)
+	}
+
+	if ch, attrib := txt.Cell(1, 1); ch != '░' || attrib != 5 {
+		t.Errorf("unexpected cell: %c %d", ch, attrib)
+	}
+	if ch, _ := txt.Cell(2, 0); ch != ' ' {
+		t.Errorf("NULL should be a space, got %q", ch)
+	}
+	if a := txt.Attributes(1); len(a) != 4 || a[0] != 4 || a[3] != 7 {
+		t.Errorf("unexpected attributes: %v", a)
+	}
+
+	rows := txt.Rows()
+	if len(rows) != 2 || rows[0] != "Hi" || rows[1] != "☺░x√" {
+		t.Errorf("unexpected rows: %q", rows)
+	}
+	if txt.String() != "Hi\n☺░x√" {
+		t.Errorf("unexpected string: %q", txt.String())
+	}
+	if !txt.Contains("░x") || txt.Contains("Hi☺") {
+		t.Error("Contains is wrong")
+	}
+}
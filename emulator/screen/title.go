@@ -0,0 +1,68 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package screen
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/andreas-jonsson/virtualxt/platform"
+	"github.com/andreas-jonsson/virtualxt/platform/dialog"
+)
+
+// Title shows the speed of the emulated CPU in the window title. Display adapters
+// count the cycles in their scanline events and update the title from the render loop.
+type Title struct {
+	atomicCycleCounter int32
+	ticker             *time.Ticker
+	startTime          time.Time
+}
+
+// NewTitle returns a title that is updated once every second.
+func NewTitle() *Title {
+	return &Title{ticker: time.NewTicker(time.Second), startTime: time.Now()}
+}
+
+// AddCycles counts emulated cycles. It can be called from any goroutine.
+func (t *Title) AddCycles(n int) {
+	atomic.AddInt32(&t.atomicCycleCounter, int32(n))
+}
+
+// Update sets the title of the display if a second has passed since the last
+// update. A hint about the menu is shown during the first ten seconds, until the menu is opened.
+func (t *Title) Update(p platform.Display) {
+	select {
+	case <-t.ticker.C:
+		hlp := " (Press F12 for menu)"
+		if dialog.MainMenuWasOpen() || time.Since(t.startTime) > time.Second*10 {
+			hlp = ""
+		}
+		numCycles := float64(atomic.SwapInt32(&t.atomicCycleCounter, 0))
+		p.SetTitle(fmt.Sprintf("VirtualXT - %.2f MHz%s", numCycles/1000000, hlp))
+	default:
+	}
+}
+
+// Stop stops the updates.
+func (t *Title) Stop() {
+	t.ticker.Stop()
+}
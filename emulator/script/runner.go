@@ -0,0 +1,216 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package script
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/machine"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor"
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+// ErrTimeout is returned when the text of a WaitFor command does not show up in time.
+var ErrTimeout = errors.New("timeout")
+
+// screenPollDelay is how often the screen is checked by WaitFor.
+const screenPollDelay = 10 * time.Millisecond
+
+// toCycles converts whole seconds and the remainder separately so long durations don't overflow.
+func toCycles(d time.Duration) int64 {
+	sec, rem := int64(d/time.Second), int64(d%time.Second)
+	return sec*processor.ClockFrequency + rem*processor.ClockFrequency/int64(time.Second)
+}
+
+// Runner executes a script on a machine. The machine is driven by the caller,
+// who calls Step between machine steps. A runner is not safe for concurrent use.
+type Runner struct {
+	// Create is used to save screenshots. It defaults to os.Create.
+	Create func(name string) (io.WriteCloser, error)
+
+	// CorrectAspect stretches screenshots to a 4:3 aspect ratio.
+	CorrectAspect bool
+
+	m    *machine.Machine
+	cmds []command
+	pc   int
+
+	next     int64
+	deadline int64
+	buttons  byte
+
+	done   bool
+	exited bool
+	code   int
+	err    error
+}
+
+// NewRunner prepares the script to run on the machine.
+func NewRunner(s *Script, m *machine.Machine) *Runner {
+	return &Runner{
+		Create: func(name string) (io.WriteCloser, error) { return os.Create(name) },
+		m:      m,
+		cmds:   s.cmds,
+		next:   m.Cycles(),
+	}
+}
+
+// Run executes the script while stepping the machine as fast as possible.
+// It returns the exit status of the script.
+func (s *Script) Run(m *machine.Machine) (int, error) {
+	r := NewRunner(s, m)
+	for !r.Step() {
+		if _, err := m.Step(toCycles(time.Millisecond)); err != nil {
+			return 1, err
+		}
+	}
+	code, _ := r.Exited()
+	return code, r.Err()
+}
+
+// Done reports if the script has finished, failed or exited.
+func (r *Runner) Done() bool {
+	return r.done
+}
+
+// Err returns the error that stopped the script.
+func (r *Runner) Err() error {
+	return r.err
+}
+
+// Exited returns the status code if the script ended with an exit command.
+// Scripts that fail exit with status 1.
+func (r *Runner) Exited() (int, bool) {
+	if r.err != nil {
+		return 1, true
+	}
+	return r.code, r.exited
+}
+
+// Step runs the commands that are due and returns true when the script is done.
+func (r *Runner) Step() bool {
+	if r.done {
+		return true
+	}
+
+	cycles := r.m.Cycles()
+	if cycles < r.next {
+		return false
+	}
+
+	// Let the machine finish typing before anything else.
+	if r.busy() {
+		return false
+	}
+
+	for r.pc < len(r.cmds) {
+		cmd := &r.cmds[r.pc]
+		wait, err := r.exec(cmd, cycles)
+		if err != nil {
+			if cmd.line > 0 {
+				err = fmt.Errorf("line %d: %w", cmd.line, err)
+			}
+			r.err = err
+			r.done = true
+			return true
+		}
+		if wait {
+			return false
+		}
+
+		r.pc++
+		r.deadline = 0
+		if r.done || r.busy() || r.next > cycles {
+			return r.done
+		}
+	}
+
+	r.done = true
+	return true
+}
+
+// busy reports if the machine has keystrokes or mouse movements left to deliver.
+func (r *Runner) busy() bool {
+	return r.m.Typing() || r.m.MouseMoving()
+}
+
+// exec runs the command and returns true if it has to be executed again later.
+func (r *Runner) exec(cmd *command, cycles int64) (bool, error) {
+	switch cmd.op {
+	case opType:
+		if err := r.m.Paste(cmd.text); err != nil {
+			return false, err
+		}
+	case opKey:
+		keys := append([]platform.Scancode{}, cmd.keys...)
+		for i := len(cmd.keys) - 1; i >= 0; i-- {
+			keys = append(keys, cmd.keys[i]|platform.KeyUpMask)
+		}
+		r.m.TypeKeys(keys...)
+	case opMouseMove:
+		r.m.MoveMouse(r.buttons, cmd.x, cmd.y)
+	case opMouseButton:
+		if cmd.click {
+			r.m.MoveMouse(r.buttons|cmd.buttons, 0, 0)
+			r.m.MoveMouse(r.buttons, 0, 0)
+		} else {
+			if cmd.down {
+				r.buttons |= cmd.buttons
+			} else {
+				r.buttons &^= cmd.buttons
+			}
+			r.m.MoveMouse(r.buttons, 0, 0)
+		}
+	case opWait:
+		r.next = cycles + toCycles(cmd.d)
+	case opWaitFor:
+		if r.deadline == 0 {
+			r.deadline = cycles + toCycles(cmd.d)
+		}
+		if t := r.m.Text(); t != nil && t.Contains(cmd.text) {
+			return false, nil
+		}
+		if cycles >= r.deadline {
+			return false, fmt.Errorf("%w waiting for %q", ErrTimeout, cmd.text)
+		}
+		r.next = cycles + toCycles(screenPollDelay)
+		return true, nil
+	case opScreenshot:
+		fp, err := r.Create(cmd.text)
+		if err != nil {
+			return false, err
+		}
+		err = r.m.Screenshot(fp, r.CorrectAspect)
+		if cerr := fp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return false, err
+		}
+	case opExit:
+		r.code, r.exited, r.done = cmd.code, true, true
+	}
+	return false, nil
+}
@@ -0,0 +1,327 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+// Package script automates a machine with keyboard and mouse input that waits
+// for the screen to reach a known state.
+//
+// Scripts are text files with one command per line. Empty lines and lines that
+// start with # are ignored. Strings are quoted like Go strings.
+//
+//	type "dir\n"                Type text using a US keyboard layout.
+//	key ctrl+alt+del f1         Press keys. Keys joined by + are pressed together.
+//	mouse move 10 -5            Move the mouse relative to its current position.
+//	mouse click [left|right]    Click a mouse button. Left is the default.
+//	mouse down [left|right]     Hold a mouse button.
+//	mouse up [left|right]       Release a mouse button.
+//	wait 500                    Wait for 500 milliseconds of emulated time.
+//	waitfor "C:\\>" [60000]     Wait until the text is on the screen. The timeout is in milliseconds.
+//	screenshot "install.png"    Save the screen to a PNG image.
+//	exit [code]                 Stop the script and exit with the status code.
+//
+// Keystrokes and mouse movements are delivered at the pace the guest reads them,
+// and the next command runs when all of them are delivered. Mouse input is
+// dropped unless a mouse driver is loaded.
+package script
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+// DefaultTimeout is the time WaitFor waits if no timeout is given.
+const DefaultTimeout = time.Minute
+
+type opcode int
+
+const (
+	opType opcode = iota
+	opKey
+	opMouseMove
+	opMouseButton
+	opWait
+	opWaitFor
+	opScreenshot
+	opExit
+)
+
+type command struct {
+	op      opcode
+	line    int
+	text    string
+	keys    []platform.Scancode
+	x, y    int
+	buttons byte
+	down    bool
+	click   bool
+	d       time.Duration
+	code    int
+}
+
+// Script is a list of commands. Scripts are created by Parse or by chaining the command methods.
+type Script struct {
+	cmds []command
+}
+
+// New creates an empty script.
+func New() *Script {
+	return &Script{}
+}
+
+func (s *Script) add(cmd command) *Script {
+	s.cmds = append(s.cmds, cmd)
+	return s
+}
+
+// Type types the text using a US keyboard layout.
+func (s *Script) Type(text string) *Script {
+	return s.add(command{op: opType, text: text})
+}
+
+// Key presses the keys together and releases them in reverse order.
+func (s *Script) Key(keys ...platform.Scancode) *Script {
+	return s.add(command{op: opKey, keys: keys})
+}
+
+// MouseMove moves the mouse relative to its current position.
+func (s *Script) MouseMove(x, y int) *Script {
+	return s.add(command{op: opMouseMove, x: x, y: y})
+}
+
+// MouseClick presses and releases the buttons. Bit 1 is the left button and bit 0 is the right button.
+func (s *Script) MouseClick(buttons byte) *Script {
+	return s.add(command{op: opMouseButton, buttons: buttons, click: true})
+}
+
+// MouseButton holds or releases the buttons.
+func (s *Script) MouseButton(buttons byte, down bool) *Script {
+	return s.add(command{op: opMouseButton, buttons: buttons, down: down})
+}
+
+// Wait waits for the duration of emulated time.
+func (s *Script) Wait(d time.Duration) *Script {
+	return s.add(command{op: opWait, d: d})
+}
+
+// WaitFor waits until the text is on the text screen. The script fails after the timeout.
+func (s *Script) WaitFor(text string, timeout time.Duration) *Script {
+	return s.add(command{op: opWaitFor, text: text, d: timeout})
+}
+
+// Screenshot saves the screen as a PNG image.
+func (s *Script) Screenshot(name string) *Script {
+	return s.add(command{op: opScreenshot, text: name})
+}
+
+// Exit stops the script with the exit status.
+func (s *Script) Exit(code int) *Script {
+	return s.add(command{op: opExit, code: code})
+}
+
+// Parse reads a script in the text format.
+func Parse(r io.Reader) (*Script, error) {
+	s := New()
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		args, err := splitArgs(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(args) == 0 || strings.HasPrefix(args[0], "#") {
+			continue
+		}
+
+		if err := s.parseCommand(args); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		s.cmds[len(s.cmds)-1].line = line
+	}
+	return s, scanner.Err()
+}
+
+func (s *Script) parseCommand(args []string) error {
+	name, args := args[0], args[1:]
+	switch name {
+	case "type":
+		if len(args) != 1 {
+			return fmt.Errorf("type expects one string")
+		}
+		if _, err := platform.Keystrokes(args[0]); err != nil {
+			return err
+		}
+		s.Type(args[0])
+	case "key":
+		if len(args) == 0 {
+			return fmt.Errorf("key expects at least one key")
+		}
+		for _, combo := range args {
+			var keys []platform.Scancode
+			for _, k := range strings.Split(combo, "+") {
+				sc, ok := platform.ParseKey(k)
+				if !ok {
+					return fmt.Errorf("unknown key: %s", k)
+				}
+				keys = append(keys, sc)
+			}
+			s.Key(keys...)
+		}
+	case "mouse":
+		return s.parseMouse(args)
+	case "wait":
+		if len(args) != 1 {
+			return fmt.Errorf("wait expects milliseconds")
+		}
+		d, err := parseMilliseconds(args[0])
+		if err != nil {
+			return err
+		}
+		s.Wait(d)
+	case "waitfor":
+		if len(args) != 1 && len(args) != 2 {
+			return fmt.Errorf("waitfor expects a string and an optional timeout")
+		}
+		timeout := DefaultTimeout
+		if len(args) == 2 {
+			var err error
+			if timeout, err = parseMilliseconds(args[1]); err != nil {
+				return err
+			}
+		}
+		s.WaitFor(args[0], timeout)
+	case "screenshot":
+		if len(args) != 1 {
+			return fmt.Errorf("screenshot expects a file name")
+		}
+		s.Screenshot(args[0])
+	case "exit":
+		code := 0
+		if len(args) > 1 {
+			return fmt.Errorf("exit expects an optional status code")
+		} else if len(args) == 1 {
+			var err error
+			if code, err = strconv.Atoi(args[0]); err != nil {
+				return err
+			}
+		}
+		s.Exit(code)
+	default:
+		return fmt.Errorf("unknown command: %s", name)
+	}
+	return nil
+}
+
+func parseMilliseconds(s string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if ms < 0 || ms > math.MaxInt64/int64(time.Millisecond) {
+		return 0, fmt.Errorf("duration out of range: %s", s)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (s *Script) parseMouse(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("mouse expects move, click, down or up")
+	}
+
+	switch args[0] {
+	case "move":
+		if len(args) != 3 {
+			return fmt.Errorf("mouse move expects x and y")
+		}
+		x, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		y, err := strconv.Atoi(args[2])
+		if err != nil {
+			return err
+		}
+		s.MouseMove(x, y)
+	case "click", "down", "up":
+		var buttons byte = 2
+		if len(args) > 2 {
+			return fmt.Errorf("mouse %s expects an optional button", args[0])
+		} else if len(args) == 2 {
+			switch args[1] {
+			case "left":
+			case "right":
+				buttons = 1
+			default:
+				return fmt.Errorf("unknown mouse button: %s", args[1])
+			}
+		}
+		if args[0] == "click" {
+			s.MouseClick(buttons)
+		} else {
+			s.MouseButton(buttons, args[0] == "down")
+		}
+	default:
+		return fmt.Errorf("unknown mouse command: %s", args[0])
+	}
+	return nil
+}
+
+// splitArgs splits the line at spaces. Quoted strings are unquoted.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return args, nil
+		}
+
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			args = append(args, line[:end])
+			line = line[end:]
+			continue
+		}
+
+		end := 1
+		for ; end < len(line) && line[end] != '"'; end++ {
+			if line[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(line) {
+			return nil, fmt.Errorf("unterminated string")
+		}
+
+		arg, err := strconv.Unquote(line[:end+1])
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		line = line[end+1:]
+	}
+}
@@ -0,0 +1,271 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package script
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreas-jonsson/virtualxt/emulator/machine"
+	"github.com/andreas-jonsson/virtualxt/emulator/processor"
+	"github.com/andreas-jonsson/virtualxt/platform"
+)
+
+type memDisk struct {
+	data []byte
+	pos  int64
+}
+
+func (d *memDisk) Read(p []byte) (int, error) {
+	if d.pos >= int64(len(d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, d.data[d.pos:])
+	d.pos += int64(n)
+	return n, nil
+}
+
+func (d *memDisk) Write(p []byte) (int, error) {
+	n := copy(d.data[d.pos:], p)
+	d.pos += int64(n)
+	return n, nil
+}
+
+func (d *memDisk) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		d.pos = offset
+	case io.SeekCurrent:
+		d.pos += offset
+	case io.SeekEnd:
+		d.pos = int64(len(d.data)) + offset
+	}
+	return d.pos, nil
+}
+
+type closeBuffer struct {
+	bytes.Buffer
+}
+
+func (*closeBuffer) Close() error {
+	return nil
+}
+
+// newEchoMachine boots a floppy that prints @@@ and then echoes all typed characters to the screen.
+func newEchoMachine(t *testing.T) *machine.Machine {
+	bios, err := os.Open("../../bios/vxtbios.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bios.Close()
+
+	vxtx, err := os.Open("../../bios/vxtx.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vxtx.Close()
+
+	floppy := &memDisk{data: make([]byte, 0x168000)}
+	copy(floppy.data, []byte{
+		0xB8, 0x40, 0x0E, // MOV AX,0E40h
+		0xCD, 0x10, // INT 10h
+		0xB8, 0x40, 0x0E, // MOV AX,0E40h
+		0xCD, 0x10, // INT 10h
+		0xB8, 0x40, 0x0E, // MOV AX,0E40h
+		0xCD, 0x10, // INT 10h
+		0xB4, 0x00, // MOV AH,0
+		0xCD, 0x16, // INT 16h
+		0xB4, 0x0E, // MOV AH,0Eh
+		0xCD, 0x10, // INT 10h
+		0xEB, 0xF6, // JMP -10
+	})
+	floppy.data[510], floppy.data[511] = 0x55, 0xAA
+
+	m, errs := machine.New(machine.Config{
+		Platform:      platform.NewHeadless(),
+		BIOS:          bios,
+		BIOSExtension: vxtx,
+		Drives:        map[byte]io.ReadWriteSeeker{0: floppy},
+		Seed:          1,
+		ClearMemory:   true,
+	})
+	for _, err := range errs {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestParse(t *testing.T) {
+	src := `
+# Comment
+type "dir a:\\\n"
+key ctrl+alt+del f1
+mouse move 300 -5
+mouse click right
+mouse down
+mouse up left
+wait 500
+waitfor "C:\\>" 1000
+screenshot "shot 1.png"
+exit 2
+`
+	s, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.cmds) != 11 {
+		t.Fatalf("expected 11 commands, got %d", len(s.cmds))
+	}
+
+	if c := s.cmds[0]; c.op != opType || c.text != "dir a:\\\n" || c.line != 3 {
+		t.Errorf("unexpected type command: %+v", c)
+	}
+	if c := s.cmds[1]; c.op != opKey || len(c.keys) != 3 || c.keys[2] != platform.ScanKPDelete {
+		t.Errorf("unexpected key command: %+v", c)
+	}
+	if c := s.cmds[2]; c.op != opKey || len(c.keys) != 1 || c.keys[0] != platform.ScanF1 {
+		t.Errorf("unexpected key command: %+v", c)
+	}
+	if c := s.cmds[4]; c.op != opMouseButton || !c.click || c.buttons != 1 {
+		t.Errorf("unexpected mouse command: %+v", c)
+	}
+	if c := s.cmds[8]; c.op != opWaitFor || c.text != "C:\\>" || c.d != time.Second {
+		t.Errorf("unexpected waitfor command: %+v", c)
+	}
+	if c := s.cmds[9]; c.op != opScreenshot || c.text != "shot 1.png" {
+		t.Errorf("unexpected screenshot command: %+v", c)
+	}
+	if c := s.cmds[10]; c.op != opExit || c.code != 2 {
+		t.Errorf("unexpected exit command: %+v", c)
+	}
+
+	for _, src := range []string{
+		"jump",
+		"type",
+		`type "unterminated`,
+		`type "€"`,
+		"key ctrl+nope",
+		"mouse click middle",
+		"wait soon",
+		"wait -1",
+		`waitfor "C:\\>" 9223372036855`,
+		"exit 1 2",
+	} {
+		if _, err := Parse(strings.NewReader(src)); err == nil {
+			t.Errorf("expected error for %q", src)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	m := newEchoMachine(t)
+	defer m.Close()
+
+	var shot closeBuffer
+	s := New().
+		WaitFor("@@@", time.Minute).
+		Type("Hello, World!").
+		WaitFor("Hello, World!", 5*time.Second).
+		Screenshot("shot.png").
+		Exit(7).
+		Type("unreachable")
+
+	r := NewRunner(s, m)
+	r.Create = func(name string) (io.WriteCloser, error) {
+		if name != "shot.png" {
+			t.Errorf("unexpected screenshot name: %s", name)
+		}
+		return &shot, nil
+	}
+
+	for !r.Step() {
+		if _, err := m.Step(10000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if code, ok := r.Exited(); !ok || code != 7 {
+		t.Errorf("expected exit status 7, got %d", code)
+	}
+	if _, err := png.Decode(&shot); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPaste(t *testing.T) {
+	m := newEchoMachine(t)
+	defer m.Close()
+
+	// Longer than the 15 characters that fit in the BIOS keyboard buffer.
+	const text = "Pasted text is typed as fast as the guest reads it, café!"
+	s := New().
+		WaitFor("@@@", time.Minute).
+		Type(text).
+		WaitFor(text, 10*time.Second)
+
+	if _, err := s.Run(m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	m := newEchoMachine(t)
+	defer m.Close()
+
+	s, err := Parse(strings.NewReader(`waitfor "never" 50`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := s.Run(m)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	if code != 1 {
+		t.Errorf("expected exit status 1, got %d", code)
+	}
+}
+
+func TestLongTimeout(t *testing.T) {
+	if c := toCycles(time.Hour); c != 3600*processor.ClockFrequency {
+		t.Errorf("expected %d cycles, got %d", 3600*processor.ClockFrequency, c)
+	}
+
+	m := newEchoMachine(t)
+	defer m.Close()
+
+	// Scripted installers can run for much longer than a minute.
+	s, err := Parse(strings.NewReader(`waitfor "@@@" 3600000`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Run(m); err != nil {
+		t.Error(err)
+	}
+}
@@ -20,6 +20,26 @@ freely, subject to the following restrictions:
 
 package platform
 
+// CodePage437 returns the Unicode character for a character in the IBM PC character set.
+func CodePage437(ch byte) rune {
+	return codePage437[ch]
+}
+
+var reverseCodePage437 map[rune]byte
+
+func init() {
+	reverseCodePage437 = make(map[rune]byte, len(codePage437))
+	for i, r := range codePage437 {
+		reverseCodePage437[r] = byte(i)
+	}
+}
+
+// RuneToCodePage437 returns the character in the IBM PC character set for the Unicode character.
+func RuneToCodePage437(r rune) (byte, bool) {
+	ch, ok := reverseCodePage437[r]
+	return ch, ok
+}
+
 var codePage437 = [256]rune{
 	0x0000, // NULL
 	0x263A, // ☺
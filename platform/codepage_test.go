@@ -42,4 +42,15 @@ func TestCodePage(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("Reverse", func(t *testing.T) {
+		for i := 0; i < 256; i++ {
+			if ch, ok := RuneToCodePage437(codePage437[i]); !ok || ch != byte(i) {
+				t.Errorf("RuneToCodePage437(%q) = %d, %v", codePage437[i], ch, ok)
+			}
+		}
+		if _, ok := RuneToCodePage437('€'); ok {
+			t.Error("expected € to be missing from code page 437")
+		}
+	})
 }
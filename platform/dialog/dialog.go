@@ -21,11 +21,11 @@ freely, subject to the following restrictions:
 package dialog
 
 import (
-	"flag"
 	"io"
 	"os"
 	"os/exec"
 	"runtime"
+	"sync"
 	"sync/atomic"
 )
 
@@ -40,39 +40,34 @@ type DiskController interface {
 	Replace(dnum byte, disk io.ReadWriteSeeker) error
 }
 
-var (
-	OpenFileFunc     func(name string, flag int, perm os.FileMode) (File, error)
-	FloppyController DiskController
-)
+var OpenFileFunc func(name string, flag int, perm os.FileMode) (File, error)
 
 var (
 	mainMenuWasOpen,
 	requestRestart,
-	quitFlag int32
+	requestSaveState,
+	requestLoadState,
+	requestScreenshot,
+	quitFlag,
+	exitCode int32
 )
 
 var (
-	defaultFloppyImage = ""
-	defaultHdImage     = "boot/freedos_hd.img"
+	pasteLock    sync.Mutex
+	pasteText    string
+	requestPaste bool
 )
 
-var DriveImages [0x100]struct {
+// DriveImage is a disk image file mounted in one of the drives.
+type DriveImage struct {
 	Name string
 	Fp   File
 }
 
-func init() {
-	if p, ok := os.LookupEnv("VXT_DEFAULT_FLOPPY_IMAGE"); ok {
-		defaultFloppyImage = p
-	}
-	if p, ok := os.LookupEnv("VXT_DEFAULT_HD_IMAGE"); ok {
-		defaultHdImage = p
-	}
-
-	flag.StringVar(&DriveImages[0x0].Name, "a", defaultFloppyImage, "Mount image as floppy A")
-	flag.StringVar(&DriveImages[0x1].Name, "b", "", "Mount image as floppy B")
-	flag.StringVar(&DriveImages[0x80].Name, "c", defaultHdImage, "Mount image as haddrive C")
-	flag.StringVar(&DriveImages[0x81].Name, "d", "", "Mount image as haddrive D")
+// Drives keeps track of the disk images mounted in a machine.
+type Drives struct {
+	Images     [0x100]DriveImage
+	Controller DiskController
 }
 
 func OpenURL(url string) error {
@@ -113,6 +108,50 @@ func RestartRequested() bool {
 	return atomic.SwapInt32(&requestRestart, 0) != 0
 }
 
+// RequestSaveState asks the emulator to save a snapshot of the machine.
+func RequestSaveState() {
+	atomic.StoreInt32(&requestSaveState, 1)
+}
+
+func SaveStateRequested() bool {
+	return atomic.SwapInt32(&requestSaveState, 0) != 0
+}
+
+// RequestLoadState asks the emulator to restore the last saved snapshot.
+func RequestLoadState() {
+	atomic.StoreInt32(&requestLoadState, 1)
+}
+
+func LoadStateRequested() bool {
+	return atomic.SwapInt32(&requestLoadState, 0) != 0
+}
+
+// RequestScreenshot asks the emulator to save the screen as an image.
+func RequestScreenshot() {
+	atomic.StoreInt32(&requestScreenshot, 1)
+}
+
+func ScreenshotRequested() bool {
+	return atomic.SwapInt32(&requestScreenshot, 0) != 0
+}
+
+// RequestPaste asks the emulator to type the text in the guest.
+func RequestPaste(text string) {
+	pasteLock.Lock()
+	pasteText += text
+	requestPaste = true
+	pasteLock.Unlock()
+}
+
+func PasteRequested() (string, bool) {
+	pasteLock.Lock()
+	defer pasteLock.Unlock()
+
+	text, ok := pasteText, requestPaste
+	pasteText, requestPaste = "", false
+	return text, ok
+}
+
 func ShutdownRequested() bool {
 	return atomic.LoadInt32(&quitFlag) != 0
 }
@@ -120,3 +159,13 @@ func ShutdownRequested() bool {
 func Quit() {
 	atomic.StoreInt32(&quitFlag, 1)
 }
+
+// Exit requests a shutdown and sets the exit status of the process.
+func Exit(code int) {
+	atomic.StoreInt32(&exitCode, int32(code))
+	Quit()
+}
+
+func ExitCode() int {
+	return int(atomic.LoadInt32(&exitCode))
+}
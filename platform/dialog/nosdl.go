@@ -27,16 +27,16 @@ import (
 	"sync/atomic"
 )
 
-func MainMenu() error {
+func MainMenu(d *Drives) error {
 	atomic.StoreInt32(&mainMenuWasOpen, 1)
 	return nil
 }
 
-func EjectFloppy() error {
+func EjectFloppy(d *Drives) error {
 	return nil
 }
 
-func MountFloppyImage(file string) error {
+func MountFloppyImage(d *Drives, file string) error {
 	return nil
 }
 
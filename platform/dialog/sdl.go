@@ -56,7 +56,7 @@ func sortButtons(buttons []sdl.MessageBoxButtonData) []sdl.MessageBoxButtonData
 	return buttons
 }
 
-func MainMenu() error {
+func MainMenu(d *Drives) error {
 	atomic.StoreInt32(&mainMenuWasOpen, 1)
 
 	buttons := []sdl.MessageBoxButtonData{
@@ -71,7 +71,7 @@ func MainMenu() error {
 		},
 	}
 
-	if DriveImages[0].Fp != nil || DriveImages[1].Fp != nil {
+	if d != nil && (d.Images[0].Fp != nil || d.Images[1].Fp != nil) {
 		buttons = append(buttons, sdl.MessageBoxButtonData{
 			ButtonID: 2,
 			Text:     "Eject",
@@ -107,7 +107,7 @@ func MainMenu() error {
 			}
 			return OpenURL("config.json")
 		case 2:
-			return EjectFloppy()
+			return EjectFloppy(d)
 		case 1:
 			atomic.StoreInt32(&requestRestart, 1)
 			return nil
@@ -160,7 +160,7 @@ func WindowsInstallNpcap() {
 	}
 }
 
-func EjectFloppy() error {
+func EjectFloppy(d *Drives) error {
 	buttons := []sdl.MessageBoxButtonData{
 		{
 			Flags:    sdl.MESSAGEBOX_BUTTON_ESCAPEKEY_DEFAULT,
@@ -169,14 +169,14 @@ func EjectFloppy() error {
 		},
 	}
 
-	if v := &DriveImages[1]; v.Fp != nil {
+	if v := &d.Images[1]; v.Fp != nil {
 		buttons = append(buttons, sdl.MessageBoxButtonData{
 			ButtonID: 1,
 			Text:     "Drive B",
 		})
 	}
 
-	if v := &DriveImages[0]; v.Fp != nil {
+	if v := &d.Images[0]; v.Fp != nil {
 		buttons = append(buttons, sdl.MessageBoxButtonData{
 			ButtonID: 2,
 			Text:     "Drive A",
@@ -201,21 +201,21 @@ func EjectFloppy() error {
 		}
 
 		drive := byte(2 - id)
-		if _, err := FloppyController.Eject(drive); err != nil {
+		if _, err := d.Controller.Eject(drive); err != nil {
 			ShowErrorMessage(err.Error())
 			return err
 		}
 
-		DriveImages[drive].Fp.Close()
-		DriveImages[drive].Fp = nil
-		DriveImages[drive].Name = ""
+		d.Images[drive].Fp.Close()
+		d.Images[drive].Fp = nil
+		d.Images[drive].Name = ""
 		return nil
 	} else {
 		return err
 	}
 }
 
-func MountFloppyImage(file string) error {
+func MountFloppyImage(d *Drives, file string) error {
 	mbd := sdl.MessageBoxData{
 		Flags:   sdl.MESSAGEBOX_INFORMATION,
 		Title:   "Mount Floppy Image",
@@ -229,7 +229,7 @@ func MountFloppyImage(file string) error {
 			{
 				ButtonID: 1,
 				Text: func() string {
-					if DriveImages[1].Name == "" {
+					if d.Images[1].Name == "" {
 						return "Drive B"
 					}
 					return "Drive B*"
@@ -239,7 +239,7 @@ func MountFloppyImage(file string) error {
 				Flags:    sdl.MESSAGEBOX_BUTTON_RETURNKEY_DEFAULT,
 				ButtonID: 2,
 				Text: func() string {
-					if DriveImages[0].Name == "" {
+					if d.Images[0].Name == "" {
 						return "Drive A"
 					}
 					return "Drive A*"
@@ -254,19 +254,19 @@ func MountFloppyImage(file string) error {
 		}
 
 		driveId := byte(2 - id)
-		oldFp := DriveImages[driveId].Fp
+		oldFp := d.Images[driveId].Fp
 
-		if DriveImages[driveId].Fp, err = OpenFileFunc(file, os.O_RDWR, 0644); err != nil {
-			DriveImages[driveId].Fp = oldFp
+		if d.Images[driveId].Fp, err = OpenFileFunc(file, os.O_RDWR, 0644); err != nil {
+			d.Images[driveId].Fp = oldFp
 			sdl.ShowSimpleMessageBox(sdl.MESSAGEBOX_ERROR, "Error", err.Error(), nil)
 			return err
-		} else if err = FloppyController.Replace(driveId, DriveImages[driveId].Fp); err != nil {
+		} else if err = d.Controller.Replace(driveId, d.Images[driveId].Fp); err != nil {
 			sdl.ShowSimpleMessageBox(sdl.MESSAGEBOX_ERROR, "Error", err.Error(), nil)
 			return err
 		} else if oldFp != nil {
 			oldFp.Close()
 		}
-		DriveImages[driveId].Name = file
+		d.Images[driveId].Name = file
 		return nil
 	} else {
 		return err
@@ -0,0 +1,225 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package platform
+
+import (
+	"flag"
+	"os"
+	"sync"
+
+	"github.com/andreas-jonsson/virtualxt/platform/dialog"
+)
+
+// Headless is a platform without display, audio or host input. The last rendered
+// screen is kept in memory and input is injected with KeyEvent and MouseEvent.
+// It is safe for concurrent use.
+type Headless struct {
+	lock sync.Mutex
+
+	graphics      []byte
+	width, height int
+	background    [3]byte
+
+	text        []byte
+	columns     int
+	mono, blink bool
+	bg, cx, cy  int
+
+	title    string
+	drives   *dialog.Drives
+	displays map[int]*Headless
+
+	mouseHandler    func(byte, int8, int8)
+	keyboardHandler func(Scancode)
+}
+
+// NewHeadless creates a headless platform. Files are accessed through the host file system.
+func NewHeadless() *Headless {
+	return &Headless{cx: -1, cy: -1}
+}
+
+func headlessStart(mainLoop func(Platform)) {
+	// Default to max speed in headless mode.
+	flag.Set("mips", "0")
+	flag.Parse()
+
+	p := NewHeadless()
+	setDialogFileSystem(p)
+	mainLoop(p)
+}
+
+func headlessMode() bool {
+	f := flag.Lookup("headless")
+	return f != nil && f.Value.(flag.Getter).Get().(bool)
+}
+
+func (*Headless) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (*Headless) Create(name string) (File, error) {
+	return os.Create(name)
+}
+
+func (*Headless) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (*Headless) HasAudio() bool {
+	return false
+}
+
+// GetDisplay returns a headless platform that keeps the screen of the display.
+// Display 0 is p itself.
+func (p *Headless) GetDisplay(n int) Display {
+	if n == 0 {
+		return p
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.displays == nil {
+		p.displays = make(map[int]*Headless)
+	}
+	d, ok := p.displays[n]
+	if !ok {
+		d = NewHeadless()
+		p.displays[n] = d
+	}
+	return d
+}
+
+func (p *Headless) RenderGraphics(backBuffer []byte, width, height int, r, g, b byte) {
+	p.lock.Lock()
+	p.graphics = append(p.graphics[:0], backBuffer...)
+	p.width, p.height = width, height
+	p.background = [3]byte{r, g, b}
+	p.lock.Unlock()
+}
+
+func (p *Headless) RenderText(mem []byte, columns int, mono, blink bool, bg, cx, cy int) {
+	p.lock.Lock()
+	p.text = append(p.text[:0], mem...)
+	p.columns, p.mono, p.blink, p.bg, p.cx, p.cy = columns, mono, blink, bg, cx, cy
+	p.lock.Unlock()
+}
+
+func (p *Headless) SetTitle(title string) {
+	p.lock.Lock()
+	p.title = title
+	p.lock.Unlock()
+}
+
+func (*Headless) QueueAudio([]byte) {
+}
+
+func (*Headless) AudioSpec() AudioSpec {
+	return AudioSpec{}
+}
+
+func (*Headless) EnableAudio(bool) {
+}
+
+func (p *Headless) SetKeyboardHandler(h func(Scancode)) {
+	p.lock.Lock()
+	p.keyboardHandler = h
+	p.lock.Unlock()
+}
+
+func (p *Headless) SetMouseHandler(h func(byte, int8, int8)) {
+	p.lock.Lock()
+	p.mouseHandler = h
+	p.lock.Unlock()
+}
+
+func (p *Headless) SetDrives(d *dialog.Drives) {
+	p.lock.Lock()
+	p.drives = d
+	p.lock.Unlock()
+}
+
+// Graphics returns a copy of the last RGBA surface, its size and the background color passed
+// to RenderGraphics. The surface is nil if nothing has been rendered in graphics mode.
+func (p *Headless) Graphics() (surface []byte, width, height int, r, g, b byte) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.graphics != nil {
+		surface = append([]byte(nil), p.graphics...)
+	}
+	return surface, p.width, p.height, p.background[0], p.background[1], p.background[2]
+}
+
+// Text returns a copy of the last character and attribute pairs passed to RenderText.
+// The cursor position is -1 if the cursor is hidden.
+func (p *Headless) Text() (mem []byte, columns int, mono, blink bool, bg, cx, cy int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.text != nil {
+		mem = append([]byte(nil), p.text...)
+	}
+	return mem, p.columns, p.mono, p.blink, p.bg, p.cx, p.cy
+}
+
+// Title returns the last window title set by the emulator.
+func (p *Headless) Title() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.title
+}
+
+// Drives returns the disk images mounted by the emulator, if any.
+func (p *Headless) Drives() *dialog.Drives {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.drives
+}
+
+// KeyEvent sends a scancode to the keyboard handler. Use KeyUpMask for key releases.
+func (p *Headless) KeyEvent(sc Scancode) {
+	p.lock.Lock()
+	h := p.keyboardHandler
+	p.lock.Unlock()
+
+	if h != nil {
+		h(sc)
+	}
+}
+
+// KeyPress sends a key press followed by a key release.
+func (p *Headless) KeyPress(sc Scancode) {
+	p.KeyEvent(sc)
+	p.KeyEvent(sc | KeyUpMask)
+}
+
+// MouseEvent sends the button state and a relative movement to the mouse handler.
+// Bit 1 of buttons is the left button and bit 0 is the right button.
+func (p *Headless) MouseEvent(buttons byte, xrel, yrel int8) {
+	p.lock.Lock()
+	h := p.mouseHandler
+	p.lock.Unlock()
+
+	if h != nil {
+		h(buttons, xrel, yrel)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package platform
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHeadless(t *testing.T) {
+	t.Run("Render", func(t *testing.T) {
+		p := NewHeadless()
+		if surface, _, _, _, _, _ := p.Graphics(); surface != nil {
+			t.Error("expected no surface before rendering")
+		}
+
+		mem := []byte{'A', 0x07, 'B', 0x1F}
+		p.RenderText(mem, 2, true, true, 1, 2, 3)
+		mem[0] = 'X'
+
+		text, columns, mono, blink, bg, cx, cy := p.Text()
+		if !bytes.Equal(text, []byte{'A', 0x07, 'B', 0x1F}) {
+			t.Errorf("text memory was not copied: %v", text)
+		}
+		if columns != 2 || !mono || !blink || bg != 1 || cx != 2 || cy != 3 {
+			t.Errorf("unexpected text state: %d %v %v %d %d %d", columns, mono, blink, bg, cx, cy)
+		}
+
+		surface := []byte{1, 2, 3, 4}
+		p.RenderGraphics(surface, 1, 1, 5, 6, 7)
+		surface[0] = 0
+
+		if s, w, h, r, g, b := p.Graphics(); !bytes.Equal(s, []byte{1, 2, 3, 4}) || w != 1 || h != 1 || r != 5 || g != 6 || b != 7 {
+			t.Errorf("unexpected surface: %v %dx%d %d %d %d", s, w, h, r, g, b)
+		}
+	})
+
+	t.Run("Input", func(t *testing.T) {
+		p := NewHeadless()
+		p.KeyEvent(ScanA) // Dropped since there is no handler.
+
+		var keys []Scancode
+		p.SetKeyboardHandler(func(sc Scancode) { keys = append(keys, sc) })
+		p.KeyPress(ScanA)
+		if len(keys) != 2 || keys[0] != ScanA || keys[1] != ScanA|KeyUpMask {
+			t.Errorf("unexpected scancodes: %v", keys)
+		}
+
+		var buttons byte
+		var xrel, yrel int8
+		p.SetMouseHandler(func(b byte, x, y int8) { buttons, xrel, yrel = b, x, y })
+		p.MouseEvent(2, -4, 8)
+		if buttons != 2 || xrel != -4 || yrel != 8 {
+			t.Errorf("unexpected mouse event: %d %d %d", buttons, xrel, yrel)
+		}
+	})
+
+	t.Run("Displays", func(t *testing.T) {
+		p := NewHeadless()
+		if p.GetDisplay(0) != Display(p) {
+			t.Error("expected display 0 to be the platform")
+		}
+
+		second := p.GetDisplay(1).(*Headless)
+		if p.GetDisplay(1) != Display(second) {
+			t.Error("expected the same display every time")
+		}
+
+		second.RenderText([]byte{'M', 0x07}, 1, true, false, 0, -1, -1)
+		if mem, _, _, _, _, _, _ := p.Text(); mem != nil {
+			t.Error("text was rendered on the wrong display")
+		}
+		if mem, _, mono, _, _, _, _ := second.Text(); !mono || !bytes.Equal(mem, []byte{'M', 0x07}) {
+			t.Errorf("unexpected text on the second display: %v", mem)
+		}
+	})
+}
@@ -28,14 +28,17 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall/js"
 
+	"github.com/andreas-jonsson/virtualxt/platform/dialog"
 	"github.com/spf13/afero"
 )
 
 type jsPlatform struct {
 	canvas, context js.Value
+	width, height   int
 	fileSystem      afero.Fs
 
 	mouseHandler    func(byte, int8, int8)
@@ -76,6 +79,7 @@ func Start(mainLoop func(Platform), configs ...Config) {
 
 	canvas.Call("setAttribute", "width", "640")
 	canvas.Call("setAttribute", "height", "200")
+	jsPlatformInstance.width, jsPlatformInstance.height = 640, 200
 	canvas.Set("imageSmoothingEnabled", false)
 	canvas.Set("oncontextmenu", js.FuncOf(func(js.Value, []js.Value) interface{} { return nil }))
 
@@ -130,9 +134,8 @@ func Start(mainLoop func(Platform), configs ...Config) {
 	canvas.Set("onmouseup", jsMouseHandler)
 	canvas.Set("onmousedown", jsMouseHandler)
 
-	Instance = &jsPlatformInstance
-	setDialogFileSystem(Instance)
-	mainLoop(Instance)
+	setDialogFileSystem(&jsPlatformInstance)
+	mainLoop(&jsPlatformInstance)
 }
 
 func (p *jsPlatform) downloadFile(name string) error {
@@ -186,15 +189,28 @@ func (p *jsPlatform) HasAudio() bool {
 	return false
 }
 
-func (p *jsPlatform) RenderGraphics(backBuffer []byte, r, g, b byte) {
-	img := p.context.Call("getImageData", 0, 0, 640, 200)
+func (p *jsPlatform) GetDisplay(n int) Display {
+	if n == 0 {
+		return p
+	}
+	return nullDisplay{}
+}
+
+func (p *jsPlatform) RenderGraphics(backBuffer []byte, width, height int, r, g, b byte) {
+	if width != p.width || height != p.height {
+		p.width, p.height = width, height
+		p.canvas.Call("setAttribute", "width", strconv.Itoa(width))
+		p.canvas.Call("setAttribute", "height", strconv.Itoa(height))
+	}
+
+	img := p.context.Call("getImageData", 0, 0, width, height)
 	data := img.Get("data")
 
 	js.CopyBytesToJS(data, backBuffer)
 	p.context.Call("putImageData", img, 0, 0)
 }
 
-func (p *jsPlatform) RenderText([]byte, bool, int, int, int) {
+func (p *jsPlatform) RenderText([]byte, int, bool, bool, int, int, int) {
 	panic("not implemented")
 }
 
@@ -220,6 +236,9 @@ func (p *jsPlatform) SetMouseHandler(h func(byte, int8, int8)) {
 	p.mouseHandler = h
 }
 
+func (p *jsPlatform) SetDrives(d *dialog.Drives) {
+}
+
 func toScancode(key string) Scancode {
 	switch strings.ToLower(key) {
 	case "escape":
@@ -0,0 +1,255 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package platform
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KeyForRune returns the key that types the character on a US keyboard and whether shift has to be held.
+func KeyForRune(r rune) (sc Scancode, shift, ok bool) {
+	switch r {
+	case '\n', '\r':
+		return ScanEnter, false, true
+	case '\t':
+		return ScanTab, false, true
+	case '\b':
+		return ScanBackspace, false, true
+	case 0x1B:
+		return ScanEscape, false, true
+	}
+
+	if r < 0x20 || r > 0x7E {
+		return ScanInvalid, false, false
+	}
+	shift = (r >= 'A' && r <= 'Z') || strings.ContainsRune(`~!@#$%^&*()_+{}|:"<>?`, r)
+	return asciiToScancode[r-0x20], shift, true
+}
+
+// keypadDigits are the numeric keypad keys for 0-9.
+var keypadDigits = [10]Scancode{
+	ScanKPInsert,
+	ScanKPEnd,
+	ScanKPDown,
+	ScanKPPagedown,
+	ScanKPLeft,
+	ScanKP5,
+	ScanKPRight,
+	ScanKPHome,
+	ScanKPUp,
+	ScanKPPageup,
+}
+
+// Keystrokes returns the key presses and releases that type the text. Characters
+// that are not on a US keyboard, but are in code page 437, are typed by holding
+// alt and entering the character code on the numeric keypad. Windows line endings
+// are typed as a single enter.
+func Keystrokes(text string) ([]Scancode, error) {
+	var keys []Scancode
+	text = strings.Replace(text, "\r\n", "\n", -1)
+
+	for _, r := range text {
+		if sc, shift, ok := KeyForRune(r); ok {
+			if shift {
+				keys = append(keys, ScanLShift)
+			}
+			keys = append(keys, sc, sc|KeyUpMask)
+			if shift {
+				keys = append(keys, ScanLShift|KeyUpMask)
+			}
+			continue
+		}
+
+		ch, ok := RuneToCodePage437(r)
+		if !ok || ch == 0 {
+			return nil, fmt.Errorf("can't type %q", r)
+		}
+
+		keys = append(keys, ScanAlt)
+		for _, digit := range fmt.Sprint(ch) {
+			sc := keypadDigits[digit-'0']
+			keys = append(keys, sc, sc|KeyUpMask)
+		}
+		keys = append(keys, ScanAlt|KeyUpMask)
+	}
+	return keys, nil
+}
+
+var keyNames = map[string]Scancode{
+	"esc":        ScanEscape,
+	"escape":     ScanEscape,
+	"backspace":  ScanBackspace,
+	"tab":        ScanTab,
+	"enter":      ScanEnter,
+	"return":     ScanEnter,
+	"ctrl":       ScanControl,
+	"control":    ScanControl,
+	"shift":      ScanLShift,
+	"lshift":     ScanLShift,
+	"rshift":     ScanRShift,
+	"alt":        ScanAlt,
+	"space":      ScanSpace,
+	"capslock":   ScanCapslock,
+	"numlock":    ScanNumlock,
+	"scrolllock": ScanScrlock,
+	"print":      ScanPrint,
+	"f1":         ScanF1,
+	"f2":         ScanF2,
+	"f3":         ScanF3,
+	"f4":         ScanF4,
+	"f5":         ScanF5,
+	"f6":         ScanF6,
+	"f7":         ScanF7,
+	"f8":         ScanF8,
+	"f9":         ScanF9,
+	"f10":        ScanF10,
+	"home":       ScanKPHome,
+	"up":         ScanKPUp,
+	"pgup":       ScanKPPageup,
+	"pageup":     ScanKPPageup,
+	"left":       ScanKPLeft,
+	"kp5":        ScanKP5,
+	"right":      ScanKPRight,
+	"end":        ScanKPEnd,
+	"down":       ScanKPDown,
+	"pgdn":       ScanKPPagedown,
+	"pagedown":   ScanKPPagedown,
+	"ins":        ScanKPInsert,
+	"insert":     ScanKPInsert,
+	"del":        ScanKPDelete,
+	"delete":     ScanKPDelete,
+	"kpminus":    ScanKPMinus,
+	"kpplus":     ScanKPPlus,
+}
+
+// ParseKey returns the key with the name, like "ctrl", "f1" or "a". Names are case insensitive.
+// Single characters name the key that types them, so "A" and "a" are the same key.
+func ParseKey(name string) (Scancode, bool) {
+	name = strings.ToLower(name)
+	if sc, ok := keyNames[name]; ok {
+		return sc, true
+	}
+	if r := []rune(name); len(r) == 1 {
+		if sc, _, ok := KeyForRune(r[0]); ok {
+			return sc, true
+		}
+	}
+	return ScanInvalid, false
+}
+
+var asciiToScancode = [96]Scancode{
+	ScanSpace,
+	Scan1,
+	ScanQuote,
+	Scan3,
+	Scan4,
+	Scan5,
+	Scan7,
+	ScanQuote,
+	Scan9,
+	Scan0,
+	Scan8,
+	ScanEqual,
+	ScanComma,
+	ScanMinus,
+	ScanPeriod,
+	ScanSlash,
+	Scan0,
+	Scan1,
+	Scan2,
+	Scan3,
+	Scan4,
+	Scan5,
+	Scan6,
+	Scan7,
+	Scan8,
+	Scan9,
+	ScanSemicolon,
+	ScanSemicolon,
+	ScanComma,
+	ScanEqual,
+	ScanPeriod,
+	ScanSlash,
+	Scan2,
+	ScanA,
+	ScanB,
+	ScanC,
+	ScanD,
+	ScanE,
+	ScanF,
+	ScanG,
+	ScanH,
+	ScanI,
+	ScanJ,
+	ScanK,
+	ScanL,
+	ScanM,
+	ScanN,
+	ScanO,
+	ScanP,
+	ScanQ,
+	ScanR,
+	ScanS,
+	ScanT,
+	ScanU,
+	ScanV,
+	ScanW,
+	ScanX,
+	ScanY,
+	ScanZ,
+	ScanLBracket,
+	ScanBackslash,
+	ScanRBracket,
+	Scan6,
+	ScanMinus,
+	ScanBackquote,
+	ScanA,
+	ScanB,
+	ScanC,
+	ScanD,
+	ScanE,
+	ScanF,
+	ScanG,
+	ScanH,
+	ScanI,
+	ScanJ,
+	ScanK,
+	ScanL,
+	ScanM,
+	ScanN,
+	ScanO,
+	ScanP,
+	ScanQ,
+	ScanR,
+	ScanS,
+	ScanT,
+	ScanU,
+	ScanV,
+	ScanW,
+	ScanX,
+	ScanY,
+	ScanZ,
+	ScanLBracket,
+	ScanBackslash,
+	ScanRBracket,
+	ScanBackquote,
+}
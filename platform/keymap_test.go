@@ -0,0 +1,60 @@
+/*
+Copyright (c) 2019-2020 Andreas T Jonsson
+
+This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not
+   claim that you wrote the original software. If you use this software
+   in a product, an acknowledgment in the product documentation would be
+   appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+   misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package platform
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeystrokes(t *testing.T) {
+	tests := []struct {
+		text string
+		keys []Scancode
+	}{
+		{"a", []Scancode{ScanA, ScanA | KeyUpMask}},
+		{"A!", []Scancode{
+			ScanLShift, ScanA, ScanA | KeyUpMask, ScanLShift | KeyUpMask,
+			ScanLShift, Scan1, Scan1 | KeyUpMask, ScanLShift | KeyUpMask,
+		}},
+		{"\r\n", []Scancode{ScanEnter, ScanEnter | KeyUpMask}},
+		{"é", []Scancode{
+			ScanAlt,
+			ScanKPEnd, ScanKPEnd | KeyUpMask,
+			ScanKPPagedown, ScanKPPagedown | KeyUpMask,
+			ScanKPInsert, ScanKPInsert | KeyUpMask,
+			ScanAlt | KeyUpMask,
+		}},
+	}
+
+	for _, test := range tests {
+		keys, err := Keystrokes(test.text)
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+		} else if !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%q: unexpected keys %v", test.text, keys)
+		}
+	}
+
+	if _, err := Keystrokes("a€"); err == nil {
+		t.Error("expected an error for a character outside code page 437")
+	}
+}
@@ -39,6 +39,10 @@ func ConfigWithFullscreen(p internalPlatform) error {
 }
 
 func Start(mainLoop func(Platform), configs ...Config) {
+	if headlessMode() {
+		headlessStart(mainLoop)
+		return
+	}
 	flag.Set("text", "true")
 	flag.Parse()
 	tcellStart(mainLoop, configs...)
@@ -21,6 +21,7 @@ freely, subject to the following restrictions:
 package platform
 
 import (
+	"flag"
 	"io"
 	"os"
 
@@ -49,21 +50,50 @@ type FileSystem interface {
 	OpenFile(name string, flag int, perm os.FileMode) (File, error)
 }
 
+// Display shows the screen of a video adapter.
+type Display interface {
+	RenderGraphics(backBuffer []byte, width, height int, r, g, b byte)
+	RenderText(mem []byte, columns int, mono, blink bool, bg, cx, cy int)
+	SetTitle(title string)
+}
+
 type Platform interface {
 	FileSystem
+	Display
+
+	// GetDisplay returns the display of the n:th video adapter. Display 0 is the
+	// platform itself. Displays that can't be shown drop everything sent to them.
+	GetDisplay(n int) Display
 
 	HasAudio() bool
-	RenderGraphics(backBuffer []byte, r, g, b byte)
-	RenderText(mem []byte, blink bool, bg, cx, cy int)
-	SetTitle(title string)
 	QueueAudio(soundBuffer []byte)
 	AudioSpec() AudioSpec
 	EnableAudio(b bool)
 	SetKeyboardHandler(h func(Scancode))
 	SetMouseHandler(h func(byte, int8, int8))
+	SetDrives(d *dialog.Drives)
+}
+
+// nullDisplay drops everything sent to it.
+type nullDisplay struct{}
+
+func (nullDisplay) RenderGraphics([]byte, int, int, byte, byte, byte) {
+}
+
+func (nullDisplay) RenderText([]byte, int, bool, bool, int, int, int) {
 }
 
-var Instance Platform
+func (nullDisplay) SetTitle(string) {
+}
+
+// selectedDisplay returns the display chosen with the -display flag. It is
+// used by the platforms that can only show one display.
+func selectedDisplay() int {
+	if f := flag.Lookup("display"); f != nil {
+		return f.Value.(flag.Getter).Get().(int)
+	}
+	return 0
+}
 
 func setDialogFileSystem(fs FileSystem) {
 	dialog.OpenFileFunc = func(name string, flag int, perm os.FileMode) (dialog.File, error) {
@@ -37,6 +37,7 @@ type sdlPlatform struct {
 	quitChan        chan struct{}
 	mouseHandler    func(byte, int8, int8)
 	keyboardHandler func(Scancode)
+	drives          *dialog.Drives
 
 	sdlFlags, sdlWindowFlags uint32
 	windowSizeX, windowSizeY int32
@@ -44,9 +45,10 @@ type sdlPlatform struct {
 	audioSpec     *sdl.AudioSpec
 	audioDeviceID sdl.AudioDeviceID
 
-	window   *sdl.Window
-	renderer *sdl.Renderer
-	texture  *sdl.Texture
+	// The main window is embedded. The secondary window is opened
+	// when a second video adapter asks for a display.
+	sdlDisplay
+	secondary *sdlDisplay
 }
 
 var sdlPlatformInstance sdlPlatform
@@ -125,6 +127,10 @@ func ConfigWithFullscreen(p internalPlatform) error {
 }
 
 func Start(mainLoop func(Platform), configs ...Config) {
+	if headlessMode() {
+		headlessStart(mainLoop)
+		return
+	}
 	if f := flag.Lookup("text"); f != nil && f.Value.(flag.Getter).Get().(bool) {
 		tcellStart(mainLoop, []Config{}...)
 		return
@@ -164,7 +170,6 @@ func Start(mainLoop func(Platform), configs ...Config) {
 			}
 		}()
 
-		Instance = p
 		setDialogFileSystem(p)
 
 		if err := p.initializeVideo(); err != nil {
@@ -175,7 +180,7 @@ func Start(mainLoop func(Platform), configs ...Config) {
 		}
 		mainLoop(p)
 	})
-	os.Exit(0) // Calling Exit is required!
+	os.Exit(dialog.ExitCode()) // Calling Exit is required!
 }
 
 func (*sdlPlatform) Open(name string) (File, error) {
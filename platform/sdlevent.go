@@ -63,6 +63,16 @@ func (p *sdlPlatform) initializeSDLEvents() error {
 							dialog.AskToQuit()
 						case *sdl.KeyboardEvent:
 							p.sdlProcessKey(ev)
+						case *sdl.WindowEvent:
+							// There is no quit event when one of two windows is closed.
+							if ev.Event == sdl.WINDOWEVENT_CLOSE && p.secondary != nil {
+								if p.isSecondaryWindow(ev.WindowID) {
+									p.secondary.window.Hide()
+								} else {
+									sdl.SetRelativeMouseMode(false)
+									dialog.AskToQuit()
+								}
+							}
 						/*
 							case *sdl.WindowEvent:
 								if ev.Event == sdl.WINDOWEVENT_MAXIMIZED {
@@ -104,7 +114,9 @@ func (p *sdlPlatform) initializeSDLEvents() error {
 							}
 						case *sdl.DropEvent:
 							if ev.Type == sdl.DROPFILE {
-								dialog.MountFloppyImage(ev.File)
+								if p.drives != nil {
+									dialog.MountFloppyImage(p.drives, ev.File)
+								}
 							}
 						}
 					}
@@ -125,7 +137,30 @@ func shutdownSDLEvents(p *sdlPlatform) {
 
 func (p *sdlPlatform) sdlProcessKey(ev *sdl.KeyboardEvent) {
 	keyUp := ev.Type == sdl.KEYUP
-	if ev.Keysym.Scancode == sdl.SCANCODE_F11 {
+	shift := ev.Keysym.Mod&sdl.KMOD_SHIFT != 0
+	ctrl := ev.Keysym.Mod&sdl.KMOD_CTRL != 0
+
+	if ev.Keysym.Scancode == sdl.SCANCODE_F11 && ctrl {
+		if keyUp {
+			dialog.RequestScreenshot()
+		}
+	} else if ev.Keysym.Scancode == sdl.SCANCODE_F12 && ctrl {
+		if keyUp {
+			if text, err := sdl.GetClipboardText(); err != nil {
+				log.Print(err)
+			} else {
+				dialog.RequestPaste(text)
+			}
+		}
+	} else if ev.Keysym.Scancode == sdl.SCANCODE_F11 && shift {
+		if keyUp {
+			dialog.RequestSaveState()
+		}
+	} else if ev.Keysym.Scancode == sdl.SCANCODE_F12 && shift {
+		if keyUp {
+			dialog.RequestLoadState()
+		}
+	} else if ev.Keysym.Scancode == sdl.SCANCODE_F11 {
 		if keyUp {
 			if (p.window.GetFlags() & sdl.WINDOW_FULLSCREEN) != 0 {
 				p.window.SetFullscreen(0)
@@ -139,7 +174,7 @@ func (p *sdlPlatform) sdlProcessKey(ev *sdl.KeyboardEvent) {
 		if keyUp {
 			p.window.SetFullscreen(0)
 			sdl.SetRelativeMouseMode(false)
-			dialog.MainMenu()
+			dialog.MainMenu(p.drives)
 		}
 	} else if scan := sdlScanToXTScan(ev.Keysym.Scancode); scan != ScanInvalid && p.keyboardHandler != nil {
 		if keyUp {
@@ -163,6 +198,12 @@ func (p *sdlPlatform) SetMouseHandler(h func(byte, int8, int8)) {
 	})
 }
 
+func (p *sdlPlatform) SetDrives(d *dialog.Drives) {
+	sdl.Do(func() {
+		p.drives = d
+	})
+}
+
 func sdlScanToXTScan(scan sdl.Scancode) Scancode {
 	switch scan {
 	case sdl.SCANCODE_ESCAPE:
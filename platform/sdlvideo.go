@@ -28,6 +28,35 @@ import (
 	"github.com/veandco/go-sdl2/sdl"
 )
 
+// sdlDisplay is a window that shows the screen of a video adapter.
+type sdlDisplay struct {
+	window   *sdl.Window
+	renderer *sdl.Renderer
+	texture  *sdl.Texture
+
+	textureWidth, textureHeight int
+}
+
+// open creates the window. It must be called from the main thread.
+func (d *sdlDisplay) open(w, h int32, flags uint32) error {
+	var err error
+	if d.window, d.renderer, err = sdl.CreateWindowAndRenderer(w, h, flags); err != nil {
+		return err
+	}
+	d.window.SetTitle("VirtualXT")
+	if err = d.resizeTexture(640, 200); err != nil {
+		return err
+	}
+	return d.renderer.SetLogicalSize(640, 480)
+}
+
+// destroy closes the window. It must be called from the main thread.
+func (d *sdlDisplay) destroy() {
+	d.texture.Destroy()
+	d.renderer.Destroy()
+	d.window.Destroy()
+}
+
 func (p *sdlPlatform) initializeVideo() error {
 	var err error
 	sdl.Do(func() {
@@ -37,14 +66,7 @@ func (p *sdlPlatform) initializeVideo() error {
 
 		sdl.SetHint(sdl.HINT_RENDER_SCALE_QUALITY, "0")
 		sdl.SetHint(sdl.HINT_WINDOWS_NO_CLOSE_ON_ALT_F4, "1")
-		if p.window, p.renderer, err = sdl.CreateWindowAndRenderer(p.windowSizeX, p.windowSizeY, p.sdlWindowFlags); err != nil {
-			return
-		}
-		p.window.SetTitle("VirtualXT")
-		if p.texture, err = p.renderer.CreateTexture(sdl.PIXELFORMAT_ABGR8888, sdl.TEXTUREACCESS_STREAMING, 640, 200); err != nil {
-			return
-		}
-		err = p.renderer.SetLogicalSize(640, 480)
+		err = p.open(p.windowSizeX, p.windowSizeY, p.sdlWindowFlags)
 	})
 	if err != nil {
 		return err
@@ -56,35 +78,88 @@ func (p *sdlPlatform) initializeVideo() error {
 
 func shutdownVideo(p *sdlPlatform) {
 	sdl.Do(func() {
-		p.texture.Destroy()
-		p.renderer.Destroy()
-		p.window.Destroy()
+		if p.secondary != nil {
+			p.secondary.destroy()
+		}
+		p.destroy()
 		sdl.QuitSubSystem(sdl.INIT_VIDEO)
 	})
 }
 
-func (p *sdlPlatform) RenderGraphics(backBuffer []byte, r, g, b byte) {
-	if len(backBuffer) != 640*200*4 {
+// GetDisplay opens a second window for display 1. There is no support for more displays.
+func (p *sdlPlatform) GetDisplay(n int) Display {
+	switch n {
+	case 0:
+		return p
+	case 1:
+		var d Display = nullDisplay{}
+		sdl.Do(func() {
+			if p.secondary == nil {
+				secondary := &sdlDisplay{}
+				if err := secondary.open(p.windowSizeX, p.windowSizeY, sdl.WINDOW_RESIZABLE); err != nil {
+					log.Print("Could not open a second window: ", err)
+					return
+				}
+				p.secondary = secondary
+			}
+			d = p.secondary
+		})
+		return d
+	}
+	return nullDisplay{}
+}
+
+// isSecondaryWindow reports if the window is the second window. It must be called from the main thread.
+func (p *sdlPlatform) isSecondaryWindow(id uint32) bool {
+	if p.secondary == nil {
+		return false
+	}
+	secondaryID, err := p.secondary.window.GetID()
+	return err == nil && secondaryID == id
+}
+
+// resizeTexture replaces the texture if it does not have the requested size.
+// It must be called from the main thread.
+func (d *sdlDisplay) resizeTexture(width, height int) error {
+	if d.texture != nil {
+		if d.textureWidth == width && d.textureHeight == height {
+			return nil
+		}
+		d.texture.Destroy()
+	}
+
+	var err error
+	d.texture, err = d.renderer.CreateTexture(sdl.PIXELFORMAT_ABGR8888, sdl.TEXTUREACCESS_STREAMING, int32(width), int32(height))
+	d.textureWidth, d.textureHeight = width, height
+	return err
+}
+
+func (d *sdlDisplay) RenderGraphics(backBuffer []byte, width, height int, r, g, b byte) {
+	if len(backBuffer) != width*height*4 {
 		log.Panic("invalid back buffer size")
 	}
 
 	sdl.Do(func() {
-		p.renderer.SetDrawColor(r, g, b, 0xFF)
-		p.renderer.Clear()
+		if err := d.resizeTexture(width, height); err != nil {
+			log.Panic(err)
+		}
+
+		d.renderer.SetDrawColor(r, g, b, 0xFF)
+		d.renderer.Clear()
 
-		p.texture.Update(nil, backBuffer, 640*4)
-		p.renderer.Copy(p.texture, nil, nil)
+		d.texture.Update(nil, backBuffer, width*4)
+		d.renderer.Copy(d.texture, nil, nil)
 
-		p.renderer.Present()
+		d.renderer.Present()
 	})
 }
 
-func (p *sdlPlatform) RenderText([]byte, bool, int, int, int) {
+func (d *sdlDisplay) RenderText([]byte, int, bool, bool, int, int, int) {
 	panic("not implemented")
 }
 
-func (p *sdlPlatform) SetTitle(title string) {
+func (d *sdlDisplay) SetTitle(title string) {
 	sdl.Do(func() {
-		p.window.SetTitle(title)
+		d.window.SetTitle(title)
 	})
 }
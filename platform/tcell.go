@@ -29,6 +29,7 @@ import (
 	"os"
 	"sync"
 
+	"github.com/andreas-jonsson/virtualxt/platform/dialog"
 	"github.com/gdamore/tcell"
 )
 
@@ -62,8 +63,7 @@ func tcellStart(mainLoop func(Platform), configs ...Config) {
 		log.Fatal(err)
 	}
 
-	Instance = &tcellPlatformInstance
-	setDialogFileSystem(Instance)
+	setDialogFileSystem(&tcellPlatformInstance)
 	s := tcellPlatformInstance.screen
 
 	if err = s.Init(); err != nil {
@@ -78,7 +78,7 @@ func tcellStart(mainLoop func(Platform), configs ...Config) {
 	if err := tcellPlatformInstance.initializeTcellEvents(); err != nil {
 		log.Fatal(err)
 	}
-	mainLoop(Instance)
+	mainLoop(&tcellPlatformInstance)
 }
 
 func (*tcellPlatform) Open(name string) (File, error) {
@@ -97,16 +97,23 @@ func (p *tcellPlatform) HasAudio() bool {
 	return false
 }
 
-func (p *tcellPlatform) RenderGraphics([]byte, byte, byte, byte) {
+func (p *tcellPlatform) GetDisplay(n int) Display {
+	if n == selectedDisplay() {
+		return p
+	}
+	return nullDisplay{}
+}
+
+func (p *tcellPlatform) RenderGraphics([]byte, int, int, byte, byte, byte) {
 	panic("not implemented")
 }
 
-func (p *tcellPlatform) RenderText(mem []byte, blink bool, bg, cx, cy int) {
+func (p *tcellPlatform) RenderText(mem []byte, columns int, mono, blink bool, bg, cx, cy int) {
 	p.Lock()
 	p.buffer.Reset()
 	p.buffer.Write(mem)
 	p.Unlock()
-	p.screen.PostEvent(tcell.NewEventInterrupt(drawEvent{&p.buffer, blink, bg, cx, cy}))
+	p.screen.PostEvent(tcell.NewEventInterrupt(drawEvent{&p.buffer, columns, mono, blink, bg, cx, cy}))
 }
 
 func (p *tcellPlatform) SetTitle(title string) {
@@ -133,3 +140,6 @@ func (p *tcellPlatform) SetMouseHandler(h func(byte, int8, int8)) {
 	p.mouseHandler = h
 	p.Unlock()
 }
+
+func (p *tcellPlatform) SetDrives(d *dialog.Drives) {
+}
@@ -33,9 +33,10 @@ import (
 )
 
 type drawEvent struct {
-	buffer     *bytes.Buffer
-	blink      bool
-	bg, cx, cy int
+	buffer      *bytes.Buffer
+	columns     int
+	mono, blink bool
+	bg, cx, cy  int
 }
 
 var cgaPalette = [16]tcell.Color{
@@ -61,13 +62,27 @@ func (p *tcellPlatform) initializeTcellEvents() error {
 	go func() {
 		currentCX, currentCY := -1, -1
 		currentMX, currentMY := 0, 0
-		currentBG := -1
+		currentBG, currentSize := -1, -1
 		s := p.screen
 
 		for {
 			ev := s.PollEvent()
 			switch ev := ev.(type) {
 			case *tcell.EventKey:
+				if ev.Modifiers()&tcell.ModCtrl != 0 && ev.Key() == tcell.KeyF11 {
+					dialog.RequestScreenshot()
+					break
+				}
+				if ev.Modifiers()&tcell.ModShift != 0 {
+					if ev.Key() == tcell.KeyF11 {
+						dialog.RequestSaveState()
+						break
+					} else if ev.Key() == tcell.KeyF12 {
+						dialog.RequestLoadState()
+						break
+					}
+				}
+
 				if ev.Key() == tcell.KeyF12 {
 					dialog.Quit()
 					go func() {
@@ -101,22 +116,26 @@ func (p *tcellPlatform) initializeTcellEvents() error {
 				if data, ok := ev.Data().(drawEvent); ok {
 					p.Lock()
 
-					if currentBG != data.bg {
-						currentBG = data.bg
-						s.Fill(' ', tcell.StyleDefault.Background(cgaPalette[data.bg&0xF]))
-					}
-
+					// The screen is cleared when the background or the text geometry changes.
 					buf := data.buffer
-					numColumns := 80
-					if buf.Len() < 80*25*2 {
-						numColumns = 40
+					numColumns, numRows := data.columns, 0
+					if numColumns > 0 {
+						numRows = buf.Len() / (numColumns * 2)
+					}
+					if size := numColumns<<16 | numRows; currentBG != data.bg || currentSize != size {
+						currentBG, currentSize = data.bg, size
+						s.Fill(' ', tcell.StyleDefault.Background(cgaPalette[data.bg&0xF]))
 					}
 
 					mem := buf.Bytes()
-					for y := 0; y < 25; y++ {
+					for y := 0; y < numRows; y++ {
 						for x := 0; x < numColumns; x++ {
 							offset := y*numColumns*2 + x*2
-							s.SetCell(x, y, p.createStyleFromAttrib(mem[offset+1], data.blink), codePage437[mem[offset]])
+							style := p.createStyleFromAttrib(mem[offset+1], data.blink)
+							if data.mono {
+								style = p.createMonoStyleFromAttrib(mem[offset+1], data.blink)
+							}
+							s.SetCell(x, y, style, codePage437[mem[offset]])
 						}
 					}
 
@@ -144,6 +163,28 @@ func (p *tcellPlatform) createStyleFromAttrib(attr byte, blink bool) tcell.Style
 	return tcell.StyleDefault.Blink(blink && blinkAttrib).Background(cgaPalette[bgColorIndex]).Foreground(cgaPalette[attr&0xF])
 }
 
+// createMonoStyleFromAttrib maps MDA attributes. Only underline, intensity,
+// reverse video, blink and invisible text exist on a monochrome display.
+func (p *tcellPlatform) createMonoStyleFromAttrib(attr byte, blink bool) tcell.Style {
+	style := tcell.StyleDefault.Background(tcell.ColorBlack).Blink(blink && attr&0x80 != 0)
+	switch attr & 0x77 {
+	case 0x00:
+		return style.Foreground(tcell.ColorBlack)
+	case 0x70:
+		bg := tcell.ColorSilver
+		if attr&0x80 != 0 && !blink {
+			bg = tcell.ColorWhite
+		}
+		return style.Background(bg).Foreground(tcell.ColorBlack)
+	}
+
+	fg := tcell.ColorSilver
+	if attr&8 != 0 {
+		fg = tcell.ColorWhite
+	}
+	return style.Foreground(fg).Bold(attr&8 != 0).Underline(attr&7 == 1)
+}
+
 func (p *tcellPlatform) pushKeyEvent(ev *tcell.EventKey) {
 	deviceEvent := createEventFromTCELL(ev)
 	if deviceEvent == ScanInvalid {
@@ -235,101 +276,3 @@ func createEventFromTCELL(ev *tcell.EventKey) Scancode {
 	}
 	return ScanInvalid
 }
-
-var asciiToScancode = [96]Scancode{
-	ScanSpace,
-	Scan1,
-	ScanQuote,
-	Scan3,
-	Scan4,
-	Scan5,
-	Scan7,
-	ScanQuote,
-	Scan9,
-	Scan0,
-	Scan8,
-	ScanEqual,
-	ScanComma,
-	ScanMinus,
-	ScanPeriod,
-	ScanSlash,
-	Scan0,
-	Scan1,
-	Scan2,
-	Scan3,
-	Scan4,
-	Scan5,
-	Scan6,
-	Scan7,
-	Scan8,
-	Scan9,
-	ScanSemicolon,
-	ScanSemicolon,
-	ScanComma,
-	ScanEqual,
-	ScanPeriod,
-	ScanSlash,
-	Scan2,
-	ScanA,
-	ScanB,
-	ScanC,
-	ScanD,
-	ScanE,
-	ScanF,
-	ScanG,
-	ScanH,
-	ScanI,
-	ScanJ,
-	ScanK,
-	ScanL,
-	ScanM,
-	ScanN,
-	ScanO,
-	ScanP,
-	ScanQ,
-	ScanR,
-	ScanS,
-	ScanT,
-	ScanU,
-	ScanV,
-	ScanW,
-	ScanX,
-	ScanY,
-	ScanZ,
-	ScanLBracket,
-	ScanBackslash,
-	ScanRBracket,
-	Scan6,
-	ScanMinus,
-	ScanBackquote,
-	ScanA,
-	ScanB,
-	ScanC,
-	ScanD,
-	ScanE,
-	ScanF,
-	ScanG,
-	ScanH,
-	ScanI,
-	ScanJ,
-	ScanK,
-	ScanL,
-	ScanM,
-	ScanN,
-	ScanO,
-	ScanP,
-	ScanQ,
-	ScanR,
-	ScanS,
-	ScanT,
-	ScanU,
-	ScanV,
-	ScanW,
-	ScanX,
-	ScanY,
-	ScanZ,
-	ScanLBracket,
-	ScanBackslash,
-	ScanRBracket,
-	ScanBackquote,
-}
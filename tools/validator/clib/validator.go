@@ -29,39 +29,41 @@ import (
 	"github.com/andreas-jonsson/virtualxt/emulator/processor/validator"
 )
 
+var v *validator.Validator
+
 //export Initialize
 func Initialize(output *C.char, queueSize, bufferSize C.int) {
-	validator.Initialize(C.GoString(output), int(queueSize), int(bufferSize))
+	v = validator.New(C.GoString(output), int(queueSize), int(bufferSize))
 }
 
 //export Begin
 func Begin(opcode C.uchar, regs *C.struct_Registers) {
-	validator.Begin(byte(opcode), cToGoRegs(regs))
+	v.Begin(byte(opcode), cToGoRegs(regs))
 }
 
 //export End
 func End(regs *C.struct_Registers) {
-	validator.End(cToGoRegs(regs))
+	v.End(cToGoRegs(regs))
 }
 
 //export Discard
 func Discard() {
-	validator.Discard()
+	v.Discard()
 }
 
 //export ReadByte
 func ReadByte(addr C.uint, data C.uchar) {
-	validator.ReadByte(uint32(addr), byte(data))
+	v.ReadMemory(uint32(addr), byte(data))
 }
 
 //export WriteByte
 func WriteByte(addr C.uint, data C.uchar) {
-	validator.WriteByte(uint32(addr), byte(data))
+	v.WriteMemory(uint32(addr), byte(data))
 }
 
 //export Shutdown
 func Shutdown() {
-	validator.Shutdown()
+	v.Shutdown()
 }
 
 func cToGoRegs(r *C.struct_Registers) processor.Registers {
@@ -52,6 +52,7 @@ func init() {
 	flag.IntVar(&genHdSize, "gen-hd-size", genHdSize, "Set size of the generated harddrive image in megabytes")
 
 	flag.Bool("text", false, "CGA textmode runing in termainal")
+	flag.Bool("headless", false, "Run without display, audio or keyboard input")
 }
 
 func main() {
@@ -79,6 +80,7 @@ func main() {
 
 	printLogo()
 	platform.Start(emulator.Start, configs...)
+	os.Exit(dialog.ExitCode())
 }
 
 func genImage() bool {